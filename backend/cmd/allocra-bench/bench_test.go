@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC)
+
+func TestGenerate_IsDeterministic(t *testing.T) {
+	w := workload{Requests: 200, Rooms: 5, Users: 2, Zipf: 1.5, Overlap: 0.3, Units: 1, Start: start}
+	assert.Equal(t, w.generate(7), w.generate(7))
+	assert.NotEqual(t, w.generate(7), w.generate(8))
+}
+
+func TestGenerate_OnlyTargetedRequestsOverlap(t *testing.T) {
+	for _, ratio := range []float64{0, 0.5} {
+		w := workload{Requests: 500, Rooms: 4, Users: 3, Overlap: ratio, Units: 1, Start: start}
+		reqs := w.generate(1)
+
+		targeted := 0
+		for i, a := range reqs {
+			require.True(t, a.End.After(a.Start))
+			require.True(t, a.User >= 1 && a.User <= 3)
+			if a.Overlaps {
+				targeted++
+				continue
+			}
+			for _, b := range reqs[i+1:] {
+				if !b.Overlaps && a.Room == b.Room {
+					assert.False(t, a.Start.Before(b.End) && b.Start.Before(a.End), "untargeted requests %v and %v overlap", a, b)
+				}
+			}
+		}
+		assert.InDelta(t, ratio*float64(len(reqs)), targeted, 40)
+	}
+}
+
+func TestGenerate_ZipfFavorsTheFirstRooms(t *testing.T) {
+	w := workload{Requests: 1000, Rooms: 10, Users: 1, Zipf: 2, Units: 1, Start: start}
+	counts := make([]int, w.Rooms)
+	for _, r := range w.generate(1) {
+		counts[r.Room]++
+	}
+	assert.Greater(t, counts[0], counts[9]*5)
+}
+
+func TestOverbooked(t *testing.T) {
+	at := func(h int) time.Time { return start.Add(time.Duration(h) * time.Hour) }
+	exclusive := room{Name: "A", Quantity: 1}
+	assert.Empty(t, overbooked(exclusive, []booking{
+		{ID: 1, StartTime: at(9), EndTime: at(10)},
+		{ID: 2, StartTime: at(10), EndTime: at(11)},
+	}), "back to back is fine")
+	assert.Len(t, overbooked(exclusive, []booking{
+		{ID: 1, StartTime: at(9), EndTime: at(12)},
+		{ID: 2, StartTime: at(10), EndTime: at(11)},
+	}), 1)
+
+	pool := room{Name: "P", Quantity: 3}
+	held := []booking{
+		{ID: 1, StartTime: at(9), EndTime: at(12), Quantity: 2},
+		{ID: 2, StartTime: at(10), EndTime: at(11), Quantity: 1},
+	}
+	assert.Empty(t, overbooked(pool, held))
+	assert.Len(t, overbooked(pool, append(held, booking{ID: 3, StartTime: at(11), EndTime: at(13), Quantity: 2})), 1)
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(t, 100*time.Millisecond, percentile(sorted, 100))
+	assert.Zero(t, percentile(nil, 50))
+}
+
+func TestEnsureRooms_RefusesRoomsOfAnotherWorkload(t *testing.T) {
+	// An earlier run left exclusive rooms behind
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/rooms", r.URL.Path)
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(room{ID: 3, Name: "BENCH-02", Type: "pooled", Quantity: 4, Status: "online"})
+			return
+		}
+		json.NewEncoder(w).Encode([]room{{ID: 1, Name: "BENCH-01", Type: "exclusive", Quantity: 1, Status: "online"}})
+	}))
+	defer srv.Close()
+	c := newClient(srv.URL, 1, time.Second)
+
+	rooms, err := c.ensureRooms(context.Background(), "BENCH", "exclusive", 4, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rooms[0].ID)
+
+	_, err = c.ensureRooms(context.Background(), "BENCH", "pooled", 4, 2)
+	assert.ErrorContains(t, err, "room BENCH-01 exists")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// client talks to the Allocra API under test
+type client struct {
+	base string // the API root, such as http://localhost:8080/api
+	http *http.Client
+}
+
+func newClient(target string, workers int, timeout time.Duration) *client {
+	return &client{
+		base: strings.TrimSuffix(target, "/") + "/api",
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: workers},
+		},
+	}
+}
+
+// apiError is a response outside the 2xx range
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.Status, strings.TrimSpace(e.Body))
+}
+
+// do sends body as JSON and decodes a 2xx response into out, when given
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, &apiError{Status: resp.StatusCode, Body: string(data)}
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", method, path, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+type room struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Quantity int    `json:"quantity"`
+	Status   string `json:"status"`
+	GroupID  *int   `json:"group_id"`
+}
+
+type booking struct {
+	ID        int       `json:"id"`
+	RoomID    int       `json:"room_id"`
+	Status    string    `json:"status"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Quantity  int       `json:"quantity"`
+}
+
+// ensureRooms returns the bench rooms, creating those that don't exist yet.
+// A room left by an earlier run is reused only when it is the room this run
+// would create; otherwise the run would measure another workload. Archived
+// rooms are not listed, so their names are simply taken again.
+func (c *client) ensureRooms(ctx context.Context, prefix, roomType string, units, n int) ([]room, error) {
+	var existing []room
+	if _, err := c.do(ctx, http.MethodGet, "/rooms", nil, &existing); err != nil {
+		return nil, fmt.Errorf("failed to list rooms: %w", err)
+	}
+	byName := make(map[string]room, len(existing))
+	for _, r := range existing {
+		byName[r.Name] = r
+	}
+
+	rooms := make([]room, n)
+	for i := range rooms {
+		name := fmt.Sprintf("%s-%02d", prefix, i+1)
+		quantity := 1
+		if roomType == "pooled" {
+			quantity = units
+		}
+		if r, ok := byName[name]; ok {
+			// Outside a group no policy applies, so no approval either
+			if r.Type != roomType || r.Quantity != quantity || r.Status != "online" || r.GroupID != nil {
+				return nil, fmt.Errorf("room %s exists as %s, %s, %d units, group %s, but this run needs an online %s room of %d units outside any group; archive it or pick another -prefix",
+					name, r.Status, r.Type, r.Quantity, groupName(r.GroupID), roomType, quantity)
+			}
+			rooms[i] = r
+			continue
+		}
+		payload := map[string]interface{}{"name": name, "capacity": 4, "type": roomType, "quantity": quantity, "status": "online"}
+		if _, err := c.do(ctx, http.MethodPost, "/rooms", payload, &rooms[i]); err != nil {
+			return nil, fmt.Errorf("failed to create room %s: %w", name, err)
+		}
+	}
+	return rooms, nil
+}
+
+func groupName(id *int) string {
+	if id == nil {
+		return "none"
+	}
+	return strconv.Itoa(*id)
+}
+
+// book submits one allocation request
+func (c *client) book(ctx context.Context, roomID, userID, units int, start, end time.Time) (*booking, error) {
+	payload := map[string]interface{}{
+		"room_id":    roomID,
+		"user_id":    userID,
+		"quantity":   units,
+		"start_time": start,
+		"end_time":   end,
+	}
+	var b booking
+	if _, err := c.do(ctx, http.MethodPost, "/bookings", payload, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// approved pages through the approved bookings of a room
+func (c *client) approved(ctx context.Context, roomID int) ([]booking, error) {
+	q := url.Values{
+		"room_id": {strconv.Itoa(roomID)},
+		"status":  {"approved"},
+		"sort":    {"start_time"},
+		"limit":   {"500"},
+	}
+	var all []booking
+	for {
+		var page struct {
+			Data       []booking `json:"data"`
+			NextCursor string    `json:"next_cursor"`
+		}
+		if _, err := c.do(ctx, http.MethodGet, "/bookings?"+q.Encode(), nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to list bookings of room %d: %w", roomID, err)
+		}
+		all = append(all, page.Data...)
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		q.Set("cursor", page.NextCursor)
+	}
+}
+
+// reset purges every allocation on the server
+func (c *client) reset(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, "/allocations/reset", nil, nil)
+	return err
+}
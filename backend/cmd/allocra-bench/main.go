@@ -0,0 +1,171 @@
+// Command allocra-bench drives an allocation workload against a running
+// Allocra API and reports throughput, latency percentiles and the conflict
+// rate. Afterwards it reads back the approved bookings of the rooms it used
+// and fails when any room is overbooked, the one outcome the engine must never
+// produce however hard it is pushed.
+//
+//	go run ./cmd/allocra-bench -url http://localhost:8080 -requests 2000 -workers 32 -zipf 1.2 -overlap 0.3
+//
+// The workload is generated from -seed, so two runs with the same flags send
+// the same requests. Only their interleaving varies with -workers above 1.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func main() {
+	target := flag.String("url", "http://localhost:8080", "base URL of the API under test")
+	requests := flag.Int("requests", 500, "number of allocation requests to send")
+	workers := flag.Int("workers", 1, "concurrent workers; 1 sends the requests one after another")
+	rooms := flag.Int("rooms", 8, "number of bench rooms to spread the requests over")
+	roomType := flag.String("room-type", "exclusive", "type of the bench rooms: exclusive or pooled")
+	roomUnits := flag.Int("room-units", 4, "units of each pooled bench room")
+	units := flag.Int("units", 1, "units each request takes")
+	users := flag.Int("users", 2, "requests are made by users 1 to this many")
+	zipf := flag.Float64("zipf", 0, "Zipf skew of room popularity, greater than 1; 0 picks rooms uniformly")
+	overlap := flag.Float64("overlap", 0.2, "share of requests aimed at a window already requested on the room")
+	seed := flag.Int64("seed", 1, "seed of the workload")
+	start := flag.String("start", "", "RFC 3339 instant the first windows start at (default: midnight UTC two days from now)")
+	prefix := flag.String("prefix", "BENCH", "name prefix of the bench rooms, which are reused when they match the run")
+	reset := flag.Bool("reset", false, "purge all allocations on the server before the run")
+	check := flag.Bool("verify", true, "verify afterwards that no room is overbooked")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each HTTP request")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	w := workload{
+		Requests: *requests,
+		Rooms:    *rooms,
+		Users:    *users,
+		Zipf:     *zipf,
+		Overlap:  *overlap,
+		Units:    *units,
+		Start:    time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 2),
+	}
+	if *start != "" {
+		t, err := time.Parse(time.RFC3339, *start)
+		if err != nil {
+			log.Fatalf("-start: %v", err)
+		}
+		w.Start = t
+	}
+	if err := w.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *workers < 1 {
+		log.Fatal("-workers must be at least 1")
+	}
+	if *roomType != "exclusive" && *roomType != "pooled" {
+		log.Fatalf("-room-type must be exclusive or pooled, not %q", *roomType)
+	}
+
+	// Ctrl-C stops sending and reports what was sent so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := newClient(*target, *workers, *timeout)
+	if *reset {
+		if err := c.reset(ctx); err != nil {
+			log.Fatalf("failed to reset allocations: %v", err)
+		}
+	}
+	benchRooms, err := c.ensureRooms(ctx, *prefix, *roomType, *roomUnits, w.Rooms)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reqs := w.generate(*seed)
+	log.Printf("Sending %d requests to %s over %d rooms with %d workers (seed %d)", len(reqs), *target, len(benchRooms), *workers, *seed)
+	results, elapsed := run(ctx, c, benchRooms, reqs, w.Units, *workers)
+	stop()
+
+	rep := summarize(results, *workers, elapsed)
+	if *check {
+		// The run may have been interrupted, but verification must still finish
+		found, err := verify(context.Background(), c, benchRooms)
+		if err != nil {
+			log.Fatalf("verification failed: %v", err)
+		}
+		rep.Verified, rep.Overbooked = true, found
+	}
+
+	if *asJSON {
+		if err := rep.writeJSON(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		rep.writeText(os.Stdout)
+	}
+	if len(rep.Overbooked) > 0 || rep.Outcomes["error"] > 0 {
+		os.Exit(1)
+	}
+}
+
+// run sends reqs from workers goroutines and returns the outcome of each one
+// sent, in the order generated, and how long they took altogether
+func run(ctx context.Context, c *client, rooms []room, reqs []request, units, workers int) ([]result, time.Duration) {
+	results := make([]result, len(reqs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	began := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = send(ctx, c, rooms, reqs[j], units)
+			}
+		}()
+	}
+dispatch:
+	for j := range reqs {
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(began)
+
+	sent := results[:0]
+	for _, r := range results {
+		if r.status != "" {
+			sent = append(sent, r)
+		}
+	}
+	return sent, elapsed
+}
+
+// send submits one request and classifies its outcome
+func send(ctx context.Context, c *client, rooms []room, r request, units int) result {
+	began := time.Now()
+	b, err := c.book(ctx, rooms[r.Room].ID, r.User, units, r.Start, r.End)
+	res := result{latency: time.Since(began), overlaps: r.Overlaps}
+
+	var apiErr *apiError
+	switch {
+	case err == nil:
+		res.status = b.Status
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict:
+		res.status = "conflict"
+	default:
+		res.status, res.err = "error", err
+		if apiErr != nil {
+			res.err = fmt.Errorf("HTTP %d", apiErr.Status)
+		}
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// result is the outcome of one request
+type result struct {
+	latency  time.Duration
+	status   string // approved, pending, conflict or error
+	err      error
+	overlaps bool
+}
+
+// report summarizes a run
+type report struct {
+	Requests    int                `json:"requests"`
+	Workers     int                `json:"workers"`
+	Elapsed     time.Duration      `json:"elapsed_ns"`
+	Throughput  float64            `json:"throughput_rps"`
+	Latency     map[string]float64 `json:"latency_ms"`
+	Outcomes    map[string]int     `json:"outcomes"`
+	ConflictPct float64            `json:"conflict_rate"`
+	Targeted    int                `json:"overlapping_requests"`
+	Errors      map[string]int     `json:"errors,omitempty"`
+	Overbooked  []string           `json:"overbooked,omitempty"`
+	Verified    bool               `json:"verified"`
+}
+
+// percentile is the nearest-rank p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func summarize(results []result, workers int, elapsed time.Duration) *report {
+	r := &report{
+		Requests: len(results),
+		Workers:  workers,
+		Elapsed:  elapsed,
+		Outcomes: map[string]int{},
+		Errors:   map[string]int{},
+	}
+	latencies := make([]time.Duration, 0, len(results))
+	for _, res := range results {
+		latencies = append(latencies, res.latency)
+		r.Outcomes[res.status]++
+		if res.err != nil {
+			r.Errors[res.err.Error()]++
+		}
+		if res.overlaps {
+			r.Targeted++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	r.Latency = map[string]float64{
+		"p50": ms(percentile(latencies, 50)),
+		"p90": ms(percentile(latencies, 90)),
+		"p99": ms(percentile(latencies, 99)),
+		"max": ms(percentile(latencies, 100)),
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+	if len(results) > 0 {
+		r.ConflictPct = float64(r.Outcomes["conflict"]) / float64(len(results))
+	}
+	return r
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *report) writeText(w io.Writer) {
+	fmt.Fprintf(w, "requests     %d with %d workers in %s\n", r.Requests, r.Workers, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput   %.1f req/s\n", r.Throughput)
+	fmt.Fprintf(w, "latency      p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms\n",
+		r.Latency["p50"], r.Latency["p90"], r.Latency["p99"], r.Latency["max"])
+	fmt.Fprintf(w, "outcomes     approved %d  pending %d  conflict %d  error %d\n",
+		r.Outcomes["approved"], r.Outcomes["pending"], r.Outcomes["conflict"], r.Outcomes["error"])
+	fmt.Fprintf(w, "conflicts    %.1f%% of requests, %d aimed at taken windows\n", 100*r.ConflictPct, r.Targeted)
+	for msg, n := range r.Errors {
+		fmt.Fprintf(w, "error        %dx %s\n", n, msg)
+	}
+	switch {
+	case !r.Verified:
+		fmt.Fprintln(w, "verification skipped")
+	case len(r.Overbooked) == 0:
+		fmt.Fprintln(w, "verification no approved overlaps")
+	default:
+		for _, o := range r.Overbooked {
+			fmt.Fprintf(w, "OVERBOOKED   %s\n", o)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// overbooked describes every instant at which the approved bookings of r hold
+// more units than it has, checked at every booking start like the server's
+// peak_usage function
+func overbooked(r room, approved []booking) []string {
+	quantity := r.Quantity
+	if quantity < 1 {
+		quantity = 1
+	}
+	sort.Slice(approved, func(i, j int) bool { return approved[i].StartTime.Before(approved[j].StartTime) })
+
+	var found []string
+	for _, b := range approved {
+		units, ids := 0, []int{}
+		for _, o := range approved {
+			if !o.StartTime.After(b.StartTime) && o.EndTime.After(b.StartTime) {
+				units += max(o.Quantity, 1)
+				ids = append(ids, o.ID)
+			}
+		}
+		if units > quantity {
+			found = append(found, fmt.Sprintf("room %s holds %d of %d units at %s (bookings %v)",
+				r.Name, units, quantity, b.StartTime.Format("2006-01-02 15:04"), ids))
+		}
+	}
+	return found
+}
+
+// verify reads back the approved bookings of the bench rooms and lists every
+// overbooking
+func verify(ctx context.Context, c *client, rooms []room) ([]string, error) {
+	var found []string
+	for _, r := range rooms {
+		approved, err := c.approved(ctx, r.ID)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, overbooked(r, approved)...)
+	}
+	return found, nil
+}
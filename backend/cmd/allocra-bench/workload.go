@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// workload describes the allocation requests of a run
+type workload struct {
+	Requests int
+	Rooms    int
+	Users    int
+	Zipf     float64 // skew of room popularity; 0 picks rooms uniformly
+	Overlap  float64 // share of requests aimed at a window already requested
+	Start    time.Time
+	Units    int // units each request takes
+}
+
+func (w workload) validate() error {
+	switch {
+	case w.Requests < 1:
+		return fmt.Errorf("-requests must be at least 1")
+	case w.Rooms < 1:
+		return fmt.Errorf("-rooms must be at least 1")
+	case w.Users < 1:
+		return fmt.Errorf("-users must be at least 1")
+	case w.Zipf != 0 && w.Zipf <= 1:
+		return fmt.Errorf("-zipf must be 0 (uniform) or greater than 1")
+	case w.Overlap < 0 || w.Overlap > 1:
+		return fmt.Errorf("-overlap must be between 0 and 1")
+	case w.Units < 1:
+		return fmt.Errorf("-units must be at least 1")
+	}
+	return nil
+}
+
+// request is one generated allocation; Room indexes the bench rooms
+type request struct {
+	Room       int
+	User       int
+	Start, End time.Time
+	Overlaps   bool // aimed at the window of an earlier request on the room
+}
+
+// overlapShapes are the ways an overlapping request sits against the earlier
+// one it targets, as offsets of its start and end in quarters of that one's length
+var overlapShapes = []struct {
+	name       string
+	start, end int
+}{
+	{"exact", 0, 4},
+	{"tail", -2, 2},
+	{"head", 2, 6},
+	{"enclosure", 1, 3},
+}
+
+// generate draws the requests of w from seed. Requests that don't overlap get
+// windows of their own, one after another on each room, so every conflict of
+// a run comes from the share asked for with Overlap.
+func (w workload) generate(seed int64) []request {
+	rng := rand.New(rand.NewSource(seed))
+	var zipf *rand.Zipf
+	if w.Zipf > 0 && w.Rooms > 1 {
+		zipf = rand.NewZipf(rng, w.Zipf, 1, uint64(w.Rooms-1))
+	}
+
+	next := make([]time.Time, w.Rooms) // the first free instant of each room
+	for i := range next {
+		next[i] = w.Start
+	}
+	fresh := make([][]request, w.Rooms) // the non-overlapping requests of each room
+
+	reqs := make([]request, w.Requests)
+	for i := range reqs {
+		room := rng.Intn(w.Rooms)
+		if zipf != nil {
+			room = int(zipf.Uint64())
+		}
+		r := request{Room: room, User: 1 + rng.Intn(w.Users)}
+
+		if len(fresh[room]) > 0 && rng.Float64() < w.Overlap {
+			target := fresh[room][rng.Intn(len(fresh[room]))]
+			shape := overlapShapes[rng.Intn(len(overlapShapes))]
+			quarter := target.End.Sub(target.Start) / 4
+			r.Start = target.Start.Add(time.Duration(shape.start) * quarter)
+			r.End = target.Start.Add(time.Duration(shape.end) * quarter)
+			r.Overlaps = true
+		} else {
+			// One to three hours, then up to an hour's gap
+			r.Start = next[room]
+			r.End = r.Start.Add(time.Duration(4+rng.Intn(9)) * 15 * time.Minute)
+			next[room] = r.End.Add(time.Duration(rng.Intn(5)) * 15 * time.Minute)
+			fresh[room] = append(fresh[room], r)
+		}
+		reqs[i] = r
+	}
+	return reqs
+}
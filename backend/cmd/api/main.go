@@ -1,161 +1,222 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // rooms and clients name IANA zones; do not depend on the host's zoneinfo
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
-	"github.com/joho/godotenv"
 
+	"github.com/indraprhmbd/allocra/internal/config"
 	"github.com/indraprhmbd/allocra/internal/handlers"
-	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/metrics"
 	"github.com/indraprhmbd/allocra/internal/services"
+	"github.com/indraprhmbd/allocra/internal/tracing"
 )
 
 func main() {
-    // Set default timezone to WIB (Asia/Jakarta)
-    loc, err := time.LoadLocation("Asia/Jakarta")
+    storageFlag := flag.String("storage", "", "storage backend, postgres, sqlite or memory (overrides STORAGE)")
+    flag.Parse()
+    
+    cfg, err := config.Load()
     if err != nil {
-        log.Printf("Warning: failed to load Asia/Jakarta timezone: %v", err)
-        // Fallback or just continue (system might handle it via TZ env)
-    } else {
-        time.Local = loc
-        log.Println("Timezone configured to Asia/Jakarta (WIB)")
+        log.Fatalf("Failed to load configuration: %v", err)
     }
-
-    // Load environment variables
-    if err := godotenv.Load(); err != nil {
-        log.Println("No .env file found")
+    if *storageFlag != "" {
+        cfg.Storage = *storageFlag
+        if err := cfg.Validate(); err != nil {
+            log.Fatalf("Failed to load configuration: %v", err)
+        }
+    }
+    
+    args := flag.Args()
+    if len(args) > 0 && args[0] == "config" {
+        // Print the effective configuration, secrets redacted
+        fmt.Print(cfg)
+        return
+    }
+    if len(args) > 0 && args[0] == "migrate" {
+        migrator, closeDB, err := openMigrator(cfg)
+        if err != nil {
+            log.Fatalf("migrate: %v", err)
+        }
+        err = runMigrateCommand(context.Background(), migrator, args[1:])
+        closeDB()
+        if err != nil {
+            log.Fatalf("migrate: %v", err)
+        }
+        return
     }
     
-    // Database connection
-    dbHost := os.Getenv("DB_HOST")
-    dbPort := os.Getenv("DB_PORT")
-    dbUser := os.Getenv("DB_USER")
-    dbPassword := os.Getenv("DB_PASSWORD")
-    dbName := os.Getenv("DB_NAME")
-    
-    // Default values if not set
-    if dbHost == "" { dbHost = "localhost" }
-    if dbPort == "" { dbPort = "5432" }
-    if dbUser == "" { dbUser = "postgres" }
-    if dbPassword == "" { dbPassword = "password" }
-    if dbName == "" { dbName = "allocra" }
-    
-    db, err := repository.NewDatabase(dbHost, dbPort, dbUser, dbPassword, dbName)
+    if err := serve(cfg); err != nil {
+        log.Fatal(err)
+    }
+}
+
+// serve runs the API until SIGINT or SIGTERM, or until the listener fails.
+// Either way it returns only after the storage is closed and traces are
+// flushed, so its caller may exit on the error.
+func serve(cfg *config.Config) error {
+    // ctx is cancelled on SIGINT or SIGTERM, which starts the graceful shutdown
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    
+    // Tracing (OTEL_TRACES_EXPORTER: otlp, stdout or none)
+    shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+        Exporter: cfg.Tracing.Exporter,
+        ServiceName: cfg.Tracing.ServiceName,
+    })
     if err != nil {
-        log.Fatalf("Failed to connect to database: %v", err)
+        return fmt.Errorf("failed to configure tracing: %w", err)
     }
-    defer db.Close()
+    defer shutdownTracing(context.Background())
     
-    // Run migrations
-    if err := runMigrations(db.DB); err != nil {
-        log.Fatalf("Failed to run migrations: %v", err)
+    // Storage: Postgres or SQLite (migrated on startup), or process memory
+    store, err := openStorage(ctx, cfg)
+    if err != nil {
+        return fmt.Errorf("failed to open %s storage: %w", cfg.Storage, err)
     }
+    defer store.close()
     
     // Wire up dependencies
-    roomRepo := repository.NewRoomRepository(db)
-    bookingRepo := repository.NewBookingRepository(db)
+    roomService := services.NewRoomService(store.rooms)
+    groupService := services.NewGroupService(store.groups)
+    bookingService := services.NewBookingService(store.bookings)
+    
+    idempotencyService := services.NewIdempotencyService(store.idempotency, cfg.Idempotency.TTL)
     
-    roomService := services.NewRoomService(roomRepo)
-    bookingService := services.NewBookingService(bookingRepo)
+    // Background workers stop with ctx and are waited for before the pool closes
+    var workers sync.WaitGroup
+    workers.Add(1)
+    go func() {
+        defer workers.Done()
+        idempotencyService.PurgeLoop(ctx, time.Hour)
+    }()
     
     roomHandler := handlers.NewRoomHandler(roomService)
+    groupHandler := handlers.NewGroupHandler(groupService)
     bookingHandler := handlers.NewBookingHandler(bookingService)
     systemHandler := handlers.NewSystemHandler(bookingService)
+    importHandler := handlers.NewImportHandler(bookingService)
+    healthHandler := handlers.NewHealthHandler(store.checks)
     
     // Initialize Fiber
     app := fiber.New()
     
+    // Probes are registered ahead of the middleware so they are not logged,
+    // counted or traced
+    app.Get("/healthz", healthHandler.Live)
+    app.Get("/readyz", healthHandler.Ready)
+    
     // Middleware
     app.Use(logger.New())
     app.Use(recover.New())
+    app.Use(metrics.Middleware())
+    app.Use(tracing.Middleware())
+    if len(cfg.CORS.AllowedOrigins) > 0 {
+        app.Use(cors.New(cors.Config{
+            AllowOrigins:     strings.Join(cfg.CORS.AllowedOrigins, ","),
+            AllowCredentials: cfg.CORS.AllowCredentials,
+            AllowHeaders:     "Content-Type, If-Match, If-None-Match, Idempotency-Key, " + handlers.TimezoneHeader,
+            ExposeHeaders:    "ETag, Idempotent-Replayed, X-Total-Count",
+        }))
+    }
+    
+    // Prometheus scrape endpoint
+    if cfg.Features.Metrics {
+        app.Get("/metrics", metrics.Handler())
+    }
     
     // Routes
-    api := app.Group("/api")
+    // Times are rendered in the zone the client asks for (?tz= or Time-Zone),
+    // the configured zone otherwise
+    api := app.Group("/api", handlers.RequestTimezone(cfg.Location()))
+    idempotent := handlers.Idempotency(idempotencyService)
     
     // Room routes
     api.Post("/rooms", roomHandler.CreateRoom)
     api.Get("/rooms", roomHandler.GetRooms)
+    api.Get("/rooms/available", roomHandler.FindAvailableRooms)
+    api.Get("/rooms/:id", roomHandler.GetRoom)
     api.Put("/rooms/:id", roomHandler.UpdateRoom)
+    api.Patch("/rooms/:id", roomHandler.PatchRoom)
     api.Delete("/rooms/:id", roomHandler.DeleteRoom)
+    api.Get("/rooms/:id/calendar.ics", bookingHandler.GetRoomCalendar)
+    
+    // Resource group routes (sites, buildings, pools)
+    api.Post("/groups", groupHandler.CreateGroup)
+    api.Get("/groups", groupHandler.GetGroups)
+    api.Get("/groups/:id", groupHandler.GetGroup)
+    api.Patch("/groups/:id", groupHandler.PatchGroup)
+    api.Delete("/groups/:id", groupHandler.DeleteGroup)
+    
+    // User routes
+    api.Get("/users/:id/calendar.ics", bookingHandler.GetUserCalendar)
     
     // Booking routes
+    api.Get("/bookings", bookingHandler.ListBookings)
     api.Get("/bookings/all", bookingHandler.GetAllBookings)
-    api.Post("/bookings", bookingHandler.CreateBooking)
+    api.Get("/bookings/:id", bookingHandler.GetBooking)
+    api.Post("/bookings", idempotent, bookingHandler.CreateBooking)
     api.Patch("/bookings/:id/approve", bookingHandler.ApproveBooking)
     api.Patch("/bookings/:id/reject", bookingHandler.RejectBooking)
     api.Patch("/bookings/:id/force", bookingHandler.ForceAllocate)
     api.Get("/reports/monthly-usage", bookingHandler.GetMonthlyReport)
+    api.Get("/reports/utilization", bookingHandler.GetUtilizationReport)
+    
+    // Bundle routes: several rooms booked over one window, all or nothing
+    api.Post("/bundles", idempotent, bookingHandler.CreateBundle)
+    api.Get("/bundles/:id", bookingHandler.GetBundle)
+    api.Patch("/bundles/:id/approve", bookingHandler.ApproveBundle)
+    api.Patch("/bundles/:id/reject", bookingHandler.RejectBundle)
+    
+    // Import routes
+    if cfg.Features.CalendarImport {
+        api.Post("/import/ics", idempotent, importHandler.ImportICS)
+    }
     
     // System routes
     api.Get("/system/stats", systemHandler.GetStats)
-    api.Post("/allocations/reset", systemHandler.ResetAllocations)
+    if cfg.Features.AllocationReset {
+        api.Post("/allocations/reset", systemHandler.ResetAllocations)
+    }
     
     // Start server
-    port := os.Getenv("PORT")
-    if port == "" {
-        port = "8080"
+    listenErr := make(chan error, 1)
+    go func() {
+        listenErr <- app.Listen(fmt.Sprintf(":%s", cfg.Port))
+    }()
+    
+    var serveErr error
+    select {
+    case err := <-listenErr:
+        // Background workers stop with ctx too
+        serveErr = fmt.Errorf("failed to start server: %w", err)
+        stop()
+    case <-ctx.Done():
     }
     
-    log.Fatal(app.Listen(fmt.Sprintf(":%s", port)))
-}
-
-func runMigrations(db *sql.DB) error {
-    // Create migrations table if it doesn't exist
-    _, err := db.Exec(`CREATE TABLE IF NOT EXISTS _migrations (name TEXT PRIMARY KEY)`)
-    if err != nil {
-        return fmt.Errorf("failed to create migrations table: %w", err)
-    }
-
-    log.Println("Checking for pending migrations...")
-    
-    // Retroactively seed migrations if tables exist but tracking is missing
-    var roomsExist bool
-    db.QueryRow("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'rooms')").Scan(&roomsExist)
-    if roomsExist {
-        db.Exec("INSERT INTO _migrations (name) VALUES ('migrations/001_initial_schema.sql') ON CONFLICT DO NOTHING")
-        db.Exec("INSERT INTO _migrations (name) VALUES ('migrations/002_seed_data.sql') ON CONFLICT DO NOTHING")
-    }
-
-    files := []string{
-        "migrations/001_initial_schema.sql", 
-        "migrations/002_seed_data.sql",
-        "migrations/003_extend_rooms.sql",
-    }
-
-    for _, file := range files {
-        var applied bool
-        err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM _migrations WHERE name = $1)", file).Scan(&applied)
-        if err != nil {
-            return fmt.Errorf("failed to check migration status: %w", err)
-        }
-
-        if applied {
-            continue
-        }
-
-        log.Printf("Applying migration: %s", file)
-        content, err := os.ReadFile(file)
-        if err != nil {
-            return fmt.Errorf("failed to read migration file %s: %w", file, err)
-        }
-        
-        _, err = db.Exec(string(content))
-        if err != nil {
-            return fmt.Errorf("failed to execute migration %s: %w", file, err)
-        }
-
-        _, err = db.Exec("INSERT INTO _migrations (name) VALUES ($1)", file)
-        if err != nil {
-            return fmt.Errorf("failed to record migration %s: %w", file, err)
-        }
-        log.Printf("Successfully applied migration: %s", file)
+    // Stop accepting connections and let in-flight requests, and the
+    // transactions they hold, finish within the shutdown timeout
+    log.Printf("Shutting down, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+    defer cancel()
+    if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+        log.Printf("Server shutdown: %v", err)
     }
-    return nil
+    workers.Wait()
+    log.Println("Server stopped")
+    // The deferred calls close the storage, then flush traces
+    return serveErr
 }
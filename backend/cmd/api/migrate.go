@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/indraprhmbd/allocra/internal/migrate"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// runMigrateCommand serves the migrate subcommand: up applies every pending
+// migration, down rolls back the last steps (default 1), status lists them all
+func runMigrateCommand(ctx context.Context, m *migrate.Migrator, args []string) error {
+    if len(args) == 0 {
+        return errors.New(migrateUsage)
+    }
+    
+    switch args[0] {
+    case "up":
+        applied, err := m.Up(ctx)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("%d migrations applied\n", len(applied))
+        return nil
+        
+    case "down":
+        steps := 1
+        if len(args) > 1 {
+            n, err := strconv.Atoi(args[1])
+            if err != nil || n < 1 {
+                return errors.New("steps must be a positive integer")
+            }
+            steps = n
+        }
+        rolledBack, err := m.Down(ctx, steps)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("%d migrations rolled back\n", len(rolledBack))
+        return nil
+        
+    case "status":
+        statuses, err := m.Status(ctx)
+        if err != nil {
+            return err
+        }
+        w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+        for _, s := range statuses {
+            state, at := "pending", ""
+            if s.AppliedAt != nil {
+                state, at = "applied", s.AppliedAt.UTC().Format("2006-01-02 15:04:05Z")
+            }
+            if s.Legacy {
+                state = "applied (legacy)"
+            }
+            if s.Modified {
+                state = "modified"
+            }
+            fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, state, at)
+        }
+        return w.Flush()
+    }
+    return errors.New(migrateUsage)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/indraprhmbd/allocra/internal/config"
+	"github.com/indraprhmbd/allocra/internal/handlers"
+	"github.com/indraprhmbd/allocra/internal/metrics"
+	"github.com/indraprhmbd/allocra/internal/migrate"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/memory"
+	"github.com/indraprhmbd/allocra/internal/repository/sqlite"
+	"github.com/indraprhmbd/allocra/internal/services"
+	"github.com/indraprhmbd/allocra/migrations"
+	sqlitemigrations "github.com/indraprhmbd/allocra/migrations/sqlite"
+)
+
+// storage is the backend the services run on, with the readiness checks it
+// contributes and how to release it
+type storage struct {
+    bookings    services.BookingStore
+    rooms       services.RoomStore
+    groups      services.GroupStore
+    idempotency services.IdempotencyStore
+    checks      map[string]handlers.ReadinessCheck
+    close       func() error
+}
+
+// openStorage opens the backend cfg.Storage names
+func openStorage(ctx context.Context, cfg *config.Config) (*storage, error) {
+    switch cfg.Storage {
+    case config.StorageMemory:
+        log.Println("Using in-memory storage; all data is lost when the server stops")
+        db := memory.NewDatabase()
+        return &storage{
+            bookings:    memory.NewBookingRepository(db),
+            rooms:       memory.NewRoomRepository(db),
+            groups:      memory.NewGroupRepository(db),
+            idempotency: memory.NewIdempotencyRepository(db),
+            checks:      map[string]handlers.ReadinessCheck{},
+            close:       func() error { return nil },
+        }, nil
+    case config.StorageSQLite:
+        return openSQLite(ctx, cfg)
+    case config.StoragePostgres:
+        return openPostgres(ctx, cfg)
+    }
+    return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+}
+
+// openMigrator opens the database of cfg.Storage for `allocra migrate`
+func openMigrator(cfg *config.Config) (*migrate.Migrator, func() error, error) {
+    switch cfg.Storage {
+    case config.StoragePostgres:
+        db, migrator, err := connectPostgres(cfg)
+        if err != nil {
+            return nil, nil, err
+        }
+        return migrator, db.Close, nil
+    case config.StorageSQLite:
+        db, migrator, err := connectSQLite(cfg)
+        if err != nil {
+            return nil, nil, err
+        }
+        return migrator, db.Close, nil
+    }
+    return nil, nil, fmt.Errorf("the %s storage has no migrations", cfg.Storage)
+}
+
+// migrationsCheck fails readiness while migrations are pending, such as when
+// a newer replica has not finished migrating yet
+func migrationsCheck(migrator *migrate.Migrator) handlers.ReadinessCheck {
+    return func(ctx context.Context) error {
+        pending, err := migrator.Pending(ctx)
+        if err != nil {
+            return err
+        }
+        if pending > 0 {
+            return fmt.Errorf("%d migrations pending", pending)
+        }
+        return nil
+    }
+}
+
+// openPostgres connects to the database and applies pending migrations;
+// replicas starting together wait on each other
+func openPostgres(ctx context.Context, cfg *config.Config) (*storage, error) {
+    db, migrator, err := connectPostgres(cfg)
+    if err != nil {
+        return nil, err
+    }
+    metrics.RegisterDB(db.DB)
+    
+    if _, err := migrator.Up(ctx); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to run migrations: %w", err)
+    }
+    
+    return &storage{
+        bookings:    repository.NewBookingRepository(db),
+        rooms:       repository.NewRoomRepository(db),
+        groups:      repository.NewGroupRepository(db),
+        idempotency: repository.NewIdempotencyRepository(db),
+        checks: map[string]handlers.ReadinessCheck{
+            "database":   db.DB.PingContext,
+            "migrations": migrationsCheck(migrator),
+        },
+        close: db.Close,
+    }, nil
+}
+
+// connectPostgres opens the configured database with the embedded migrations
+func connectPostgres(cfg *config.Config) (*repository.Database, *migrate.Migrator, error) {
+    db, err := repository.NewDatabase(cfg.Database.DSN(), repository.Options{
+        MaxOpenConns:      cfg.Database.MaxOpenConns,
+        MaxIdleConns:      cfg.Database.MaxIdleConns,
+        ConnMaxLifetime:   cfg.Database.ConnMaxLifetime,
+        ConnectAttempts:   cfg.Database.ConnectAttempts,
+        ConnectRetryDelay: cfg.Database.ConnectRetryDelay,
+        Timeouts:          repository.Timeouts(cfg.Timeouts),
+    })
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+    }
+    
+    migrator, err := migrate.New(db.DB, migrations.FS, log.Printf)
+    if err != nil {
+        db.Close()
+        return nil, nil, fmt.Errorf("failed to load migrations: %w", err)
+    }
+    return db, migrator, nil
+}
+
+// openSQLite opens (creating if needed) the database file and applies pending
+// migrations
+func openSQLite(ctx context.Context, cfg *config.Config) (*storage, error) {
+    db, migrator, err := connectSQLite(cfg)
+    if err != nil {
+        return nil, err
+    }
+    metrics.RegisterDB(db.DB)
+    
+    if _, err := migrator.Up(ctx); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to run migrations: %w", err)
+    }
+    log.Printf("Using SQLite storage in %s", cfg.SQLite.Path)
+    
+    return &storage{
+        bookings:    sqlite.NewBookingRepository(db),
+        rooms:       sqlite.NewRoomRepository(db),
+        groups:      sqlite.NewGroupRepository(db),
+        idempotency: sqlite.NewIdempotencyRepository(db),
+        checks: map[string]handlers.ReadinessCheck{
+            "database":   db.DB.PingContext,
+            "migrations": migrationsCheck(migrator),
+        },
+        close: db.Close,
+    }, nil
+}
+
+// connectSQLite opens the configured database file with its embedded migrations
+func connectSQLite(cfg *config.Config) (*sqlite.Database, *migrate.Migrator, error) {
+    db, err := sqlite.Open(cfg.SQLite.Path, sqlite.Options{
+        BusyTimeout: cfg.SQLite.BusyTimeout,
+        Timeouts:    repository.Timeouts(cfg.Timeouts),
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    migrator, err := migrate.NewSQLite(db.DB, sqlitemigrations.FS, log.Printf)
+    if err != nil {
+        db.Close()
+        return nil, nil, fmt.Errorf("failed to load migrations: %w", err)
+    }
+    return db, migrator, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/memory"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+// localDriver replays a scenario on the service layer over a fresh in-memory
+// store, with no server or database involved
+type localDriver struct {
+	bookings *services.BookingService
+	rooms    *services.RoomService
+	groups   *services.GroupService
+}
+
+func newLocalDriver() *localDriver {
+	db := memory.NewDatabase()
+	return &localDriver{
+		bookings: services.NewBookingService(memory.NewBookingRepository(db)),
+		rooms:    services.NewRoomService(memory.NewRoomRepository(db)),
+		groups:   services.NewGroupService(memory.NewGroupRepository(db)),
+	}
+}
+
+func (spec roomSpec) withDefaults() roomSpec {
+	if spec.Type == "" {
+		spec.Type = models.RoomTypeExclusive
+	}
+	if spec.Capacity == 0 {
+		spec.Capacity = 4
+	}
+	if spec.Quantity == 0 {
+		spec.Quantity = 1
+	}
+	return spec
+}
+
+// refusals are the refusals of approve, reject and force that a scenario may
+// expect, by sentinel and by the code the API answers them with
+var refusals = []struct {
+	err     error
+	code    string
+	outcome string
+}{
+	{repository.ErrBookingNotPending, "booking_not_pending", "not_pending"},
+	{repository.ErrApprovalConflict, "approval_conflict", "conflict"},
+	{repository.ErrInsufficientUnits, "insufficient_units", "conflict"},
+	{repository.ErrBookingInBundle, "booking_in_bundle", "conflict"},
+}
+
+// refusalOutcomes maps the API's refusal codes to outcomes
+var refusalOutcomes = func() map[string]string {
+	m := make(map[string]string, len(refusals))
+	for _, r := range refusals {
+		m[r.code] = r.outcome
+	}
+	return m
+}()
+
+// transitionOutcome maps a refusal a scenario may expect to its outcome, or
+// returns ""
+func transitionOutcome(err error) string {
+	for _, r := range refusals {
+		if errors.Is(err, r.err) {
+			return r.outcome
+		}
+	}
+	return ""
+}
+
+func (d *localDriver) group(ctx context.Context, g groupSpec) (int, error) {
+	kind := g.Kind
+	if kind == "" {
+		kind = models.GroupKindSite
+	}
+	approval := g.RequiresApproval
+	created, err := d.groups.CreateGroup(ctx, &models.ResourceGroup{
+		Name:   g.Name,
+		Kind:   kind,
+		Policy: models.Policy{RequiresApproval: &approval, DailyQuotaHours: g.DailyQuotaHours},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+func (d *localDriver) room(ctx context.Context, spec roomSpec, groupID *int) (int, error) {
+	spec = spec.withDefaults()
+	created, err := d.rooms.CreateRoom(ctx, spec.Name, spec.Capacity, spec.Quantity, spec.Type, models.RoomStatusOnline, "UTC", nil, groupID)
+	if err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+func (d *localDriver) book(ctx context.Context, roomID, userID, units int, start, end time.Time) (int, string, error) {
+	b, err := d.bookings.CreateBooking(ctx, &models.CreateBookingRequest{
+		RoomID: roomID, UserID: userID, Quantity: units, StartTime: start, EndTime: end,
+	})
+	var verr *services.ValidationError
+	switch {
+	case err == nil:
+		return b.ID, b.Status, nil
+	case errors.Is(err, repository.ErrBookingConflict):
+		return b.ID, "rejected", nil
+	case errors.Is(err, repository.ErrNoRoomAvailable):
+		return 0, "rejected", nil
+	case errors.Is(err, repository.ErrQuotaExceeded):
+		return 0, "quota", nil
+	case errors.As(err, &verr), errors.Is(err, repository.ErrRoomNotFound), errors.Is(err, repository.ErrInsufficientUnits),
+		errors.Is(err, repository.ErrOutsideOperatingHours):
+		return 0, "invalid", nil
+	}
+	return 0, "", err
+}
+
+func (d *localDriver) transition(ctx context.Context, op string, bookingID int) (string, error) {
+	apply := map[string]func(context.Context, int, int) (*models.Booking, error){
+		"approve": d.bookings.ApproveBooking,
+		"reject":  d.bookings.RejectBooking,
+		"force":   d.bookings.ForceAllocate,
+	}[op]
+	b, err := apply(ctx, bookingID, repository.AnyVersion)
+	if err != nil {
+		if outcome := transitionOutcome(err); outcome != "" {
+			return outcome, nil
+		}
+		return "", err
+	}
+	return b.Status, nil
+}
+
+func (d *localDriver) status(ctx context.Context, bookingID int) (string, error) {
+	b, err := d.bookings.GetBooking(ctx, bookingID)
+	if err != nil {
+		return "", err
+	}
+	return b.Status, nil
+}
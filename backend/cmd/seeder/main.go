@@ -1,219 +1,89 @@
+// Command seeder replays scenario files: declarative rooms, groups and a
+// timeline of allocation operations with the outcome expected of each. It
+// runs them against a live API, or with -local directly against the service
+// layer over an in-memory store, and exits non-zero on any mismatch, so the
+// scenarios double as regression tests. Without arguments it replays the
+// scenarios built into backend/scenarios, which also seeds a dashboard.
+//
+//	go run ./cmd/seeder -url http://localhost:8080 -reset
+//	go run ./cmd/seeder -local scenarios/overlap_shapes.yaml
+//
+// Step times are offsets from the scenario's base instant, never the clock,
+// so a scenario sends the same requests every time it is replayed.
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"math/rand"
-	"net/http"
-	"time"
-)
+	"log"
+	"os"
+	"path/filepath"
 
-const (
-	baseURL = "http://localhost:8080/api"
+	"github.com/indraprhmbd/allocra/scenarios"
 )
 
-type Room struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Capacity int    `json:"capacity"`
-	Type     string `json:"type"`
-	Status   string `json:"status"`
-}
-
-type Booking struct {
-	ID        int       `json:"id"`
-	RoomID    int       `json:"room_id"`
-	UserID    int       `json:"user_id"`
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-}
-
 func main() {
-	fmt.Println("[*] Starting Allocra Engine Seeder...")
-
-	// 1. Fetch existing rooms or create new ones
-	nodes := []string{"NODE-AX-01", "NODE-AX-02", "NODE-AX-03", "NODE-AX-04", "NODE-AX-05"}
-	createdRooms := []Room{}
-
-	// First try to fetch existing
-	existingRes, _ := get("/rooms")
-	var existing []Room
-	json.Unmarshal(existingRes, &existing)
-	
-	roomMap := make(map[string]Room)
-	for _, r := range existing {
-		roomMap[r.Name] = r
-	}
-
-	for _, name := range nodes {
-		if r, ok := roomMap[name]; ok {
-			createdRooms = append(createdRooms, r)
-			fmt.Printf("[~] Reusing %s (ID: %d)\n", name, r.ID)
-			continue
-		}
-
-		room := map[string]interface{}{
-			"name":     name,
-			"capacity": 64 + rand.Intn(128),
-			"type":     []string{"shared", "exclusive"}[rand.Intn(2)],
-			"status":   "online",
+	target := flag.String("url", "http://localhost:8080", "base URL of the API to replay against")
+	local := flag.Bool("local", false, "replay against the service layer in process instead of -url; each scenario gets a fresh in-memory store")
+	reset := flag.Bool("reset", false, "purge all allocations on the server before each scenario, so replays start alike")
+	verbose := flag.Bool("v", false, "log every mismatch as it happens")
+	flag.Parse()
+
+	var list []*scenario
+	if flag.NArg() == 0 {
+		builtin, err := loadScenarios(scenarios.FS)
+		if err != nil {
+			log.Fatal(err)
 		}
-		res, err := post("/rooms", room)
+		list = builtin
+	}
+	for _, path := range flag.Args() {
+		s, err := loadScenario(os.DirFS(filepath.Dir(path)), filepath.Base(path))
 		if err != nil {
-			fmt.Printf("[X] Failed to create room %s: %v\n", name, err)
-			continue
+			log.Fatal(err)
 		}
-		var r Room
-		json.Unmarshal(res, &r)
-		createdRooms = append(createdRooms, r)
-		fmt.Printf("[+] Created %s (ID: %d)\n", name, r.ID)
+		list = append(list, s)
 	}
 
-	if len(createdRooms) == 0 {
-		fmt.Println("[!] No rooms created. Make sure backend is running on :8080")
-		return
+	logf := func(string, ...interface{}) {}
+	if *verbose {
+		logf = log.Printf
 	}
 
-	// 2. Create Valid Bookings (Spread out)
-	fmt.Println("\n Generating valid allocations...")
-	baseTime := time.Now().Truncate(time.Hour).Add(1 * time.Hour) // Start from next hour
-
-	for i := 0; i < 15; i++ {
-		room := createdRooms[rand.Intn(len(createdRooms))]
-		offset := time.Duration(rand.Intn(12)) * time.Hour
-		duration := time.Duration(1+rand.Intn(3)) * time.Hour
-
-		booking := map[string]interface{}{
-			"room_id":    room.ID,
-			"user_id":    1,
-			"start_time": baseTime.Add(offset),
-			"end_time":   baseTime.Add(offset).Add(duration),
-		}
-
-		_, err := post("/bookings", booking)
-		if err != nil {
-			fmt.Printf("[!] Skip valid booking for %s: %v\n", room.Name, err)
+	ctx := context.Background()
+	failed := 0
+	for _, s := range list {
+		var d driver
+		if *local {
+			d = newLocalDriver()
 		} else {
-			fmt.Printf("[+] Allocated %s for %v\n", room.Name, duration)
+			api := newAPIDriver(*target)
+			if *reset {
+				if err := api.reset(ctx); err != nil {
+					log.Fatal(err)
+				}
+			}
+			d = api
 		}
-	}
 
-	// 3. Create Intentioned Conflicts
-	fmt.Println("\n[*] Triggering deterministic conflicts...")
-	if len(createdRooms) > 0 {
-		targetRoom := createdRooms[0]
-		collisionStart := baseTime.Add(24 * time.Hour)
-		collisionEnd := collisionStart.Add(4 * time.Hour)
-
-		// First, a successful one
-		fmt.Printf(" Establishing baseline for %s at %v\n", targetRoom.Name, collisionStart.Format("15:04"))
-		baselineRes, _ := post("/bookings", map[string]interface{}{
-			"room_id":    targetRoom.ID,
-			"user_id":    1,
-			"start_time": collisionStart,
-			"end_time":   collisionEnd,
-		})
-
-		var baseline Booking
-		json.Unmarshal(baselineRes, &baseline)
-
-		// Approve the baseline to trigger real conflicts
-		fmt.Printf("Approving baseline (ID: %d)...\n", baseline.ID)
-		_, err := patch(fmt.Sprintf("/bookings/%d/approve", baseline.ID), nil)
-		if err != nil {
-			fmt.Printf("[X] Failed to approve baseline: %v\n", err)
+		mismatches, err := replay(ctx, d, s, logf)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL  %s (%s): %v\n", s.Name, s.file, err)
+		case len(mismatches) > 0:
+			failed++
+			fmt.Printf("FAIL  %s (%s)\n", s.Name, s.file)
+		default:
+			fmt.Printf("ok    %s (%d steps)\n", s.Name, len(s.Steps))
 		}
-
-		// Now attempt collisions
-		overlaps := []struct {
-			name  string
-			start time.Time
-			end   time.Time
-		}{
-			{"Tail Overlap", collisionStart.Add(-1 * time.Hour), collisionStart.Add(1 * time.Hour)},
-			{"Head Overlap", collisionEnd.Add(-1 * time.Hour), collisionEnd.Add(1 * time.Hour)},
-			{"Full Enclosure", collisionStart.Add(1 * time.Hour), collisionEnd.Add(-1 * time.Hour)},
+		for _, m := range mismatches {
+			fmt.Printf("      %s\n", m)
 		}
-
-		for _, o := range overlaps {
-			fmt.Printf("Attempting conflict [%s]: ", o.name)
-			payload := map[string]interface{}{
-				"room_id":    targetRoom.ID,
-				"user_id":    1,
-				"start_time": o.start,
-				"end_time":   o.end,
-			}
-			_, err := post("/bookings", payload)
-			if err != nil {
-				fmt.Printf("[+] Engine correctly REJECTED: %v\n", err)
-			} else {
-				fmt.Printf("[X] Engine failed to detect conflict!\n")
-			}
-		}
-	}
-
-	fmt.Println("\nSeeding complete. Refresh Allocra Dashboard to see results.")
-}
-
-func post(path string, data interface{}) ([]byte, error) {
-	b, _ := json.Marshal(data)
-	resp, err := http.Post(baseURL+path, "application/json", bytes.NewBuffer(b))
-	if err != nil {
-		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var buf bytes.Buffer
-	buf.ReadFrom(resp.Body)
-
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, buf.String())
-	}
-
-	return buf.Bytes(), nil
-}
-
-func patch(path string, data interface{}) ([]byte, error) {
-	var body *bytes.Buffer
-	if data != nil {
-		b, _ := json.Marshal(data)
-		body = bytes.NewBuffer(b)
-	} else {
-		body = bytes.NewBuffer([]byte("{}"))
+	if failed > 0 {
+		fmt.Printf("%d of %d scenarios failed\n", failed, len(list))
+		os.Exit(1)
 	}
-	req, _ := http.NewRequest(http.MethodPatch, baseURL+path, body)
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var buf bytes.Buffer
-	buf.ReadFrom(resp.Body)
-
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, buf.String())
-	}
-
-	return buf.Bytes(), nil
-}
-
-func get(path string) ([]byte, error) {
-	resp, err := http.Get(baseURL + path)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var buf bytes.Buffer
-	buf.ReadFrom(resp.Body)
-
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, buf.String())
-	}
-
-	return buf.Bytes(), nil
 }
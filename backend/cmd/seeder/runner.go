@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// driver carries out scenario steps against an engine, over HTTP or in
+// process. Failures the scenario may expect come back as outcomes; errors
+// are for everything else.
+type driver interface {
+	group(ctx context.Context, g groupSpec) (int, error)
+	room(ctx context.Context, r roomSpec, groupID *int) (int, error)
+	book(ctx context.Context, roomID, userID, units int, start, end time.Time) (id int, outcome string, err error)
+	transition(ctx context.Context, op string, bookingID int) (outcome string, err error)
+	status(ctx context.Context, bookingID int) (string, error)
+}
+
+// replay runs s on d and returns a description of every step whose outcome
+// differs from the expected one. An error means the scenario could not run on.
+func replay(ctx context.Context, d driver, s *scenario, logf func(format string, args ...interface{})) ([]string, error) {
+	groups := map[string]int{}
+	for _, g := range s.Groups {
+		id, err := d.group(ctx, g)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		groups[g.Name] = id
+	}
+	rooms := map[string]int{}
+	for _, r := range s.Rooms {
+		var groupID *int
+		if r.Group != "" {
+			id := groups[r.Group]
+			groupID = &id
+		}
+		id, err := d.room(ctx, r, groupID)
+		if err != nil {
+			return nil, fmt.Errorf("room %s: %w", r.Name, err)
+		}
+		rooms[r.Name] = id
+	}
+
+	base := s.base()
+	bookings := map[string]int{}
+	preempted := map[string]bool{}
+	var mismatches []string
+	mismatch := func(i int, st step, format string, args ...interface{}) {
+		msg := fmt.Sprintf("step %d (%s): ", i+1, describe(st)) + fmt.Sprintf(format, args...)
+		mismatches = append(mismatches, msg)
+		logf("  MISMATCH %s", msg)
+	}
+
+	for i, st := range s.Steps {
+		switch st.Op {
+		case "book":
+			id, outcome, err := d.book(ctx, rooms[st.Room], st.User, st.Units, base.Add(st.Start), base.Add(st.End))
+			if err != nil {
+				return mismatches, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			if st.ID != "" {
+				if id == 0 {
+					return mismatches, fmt.Errorf("step %d: booking %s was not stored (%s), later steps cannot refer to it", i+1, st.ID, outcome)
+				}
+				bookings[st.ID] = id
+			}
+			if st.Expect != "" && outcome != st.Expect {
+				mismatch(i, st, "got %s, want %s", outcome, st.Expect)
+			}
+
+		case "approve", "reject", "force":
+			// Everything approved before a force and rejected after it was preempted
+			before := map[string]string{}
+			if st.Op == "force" {
+				for name, id := range bookings {
+					status, err := d.status(ctx, id)
+					if err != nil {
+						return mismatches, fmt.Errorf("step %d: %w", i+1, err)
+					}
+					before[name] = status
+				}
+			}
+
+			outcome, err := d.transition(ctx, st.Op, bookings[st.Booking])
+			if err != nil {
+				return mismatches, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			if st.Expect != "" && outcome != st.Expect {
+				mismatch(i, st, "got %s, want %s", outcome, st.Expect)
+			}
+
+			if st.Op == "force" {
+				var got []string
+				for name, was := range before {
+					if name == st.Booking || was != "approved" {
+						continue
+					}
+					status, err := d.status(ctx, bookings[name])
+					if err != nil {
+						return mismatches, fmt.Errorf("step %d: %w", i+1, err)
+					}
+					if status == "rejected" {
+						preempted[name] = true
+						got = append(got, name)
+					}
+				}
+				want := append([]string(nil), st.Preempts...)
+				sort.Strings(got)
+				sort.Strings(want)
+				if st.Preempts != nil && strings.Join(got, ",") != strings.Join(want, ",") {
+					mismatch(i, st, "preempted [%s], want [%s]", strings.Join(got, ", "), strings.Join(want, ", "))
+				}
+			}
+
+		case "check":
+			names := make([]string, 0, len(st.Statuses))
+			for name := range st.Statuses {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				status, err := d.status(ctx, bookings[name])
+				if err != nil {
+					return mismatches, fmt.Errorf("step %d: %w", i+1, err)
+				}
+				if status == "rejected" && preempted[name] {
+					status = "preempted"
+				}
+				want := st.Statuses[name]
+				// A preempted booking is rejected too
+				if status != want && !(want == "rejected" && status == "preempted") {
+					mismatch(i, st, "%s is %s, want %s", name, status, want)
+				}
+			}
+		}
+	}
+	return mismatches, nil
+}
+
+// describe names a step in reports
+func describe(st step) string {
+	switch st.Op {
+	case "book":
+		name := st.ID
+		if name == "" {
+			name = "booking"
+		}
+		return fmt.Sprintf("book %s on %s %s..%s", name, st.Room, st.Start, st.End)
+	case "check":
+		return "check"
+	}
+	return st.Op + " " + st.Booking
+}
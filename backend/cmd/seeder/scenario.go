@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultBase is the instant step times count from when a scenario sets none:
+// a Monday far enough ahead that no scenario books in the past
+var defaultBase = time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC)
+
+// scenario is a declarative allocation script with the outcome expected of
+// every step. Files are YAML, or JSON, which YAML reads as well.
+type scenario struct {
+	Name        string      `yaml:"name"`
+	Description string      `yaml:"description"`
+	Base        *time.Time  `yaml:"base"`
+	Groups      []groupSpec `yaml:"groups"`
+	Rooms       []roomSpec  `yaml:"rooms"`
+	Steps       []step      `yaml:"steps"`
+
+	file string
+}
+
+type groupSpec struct {
+	Name             string   `yaml:"name"`
+	Kind             string   `yaml:"kind"`
+	RequiresApproval bool     `yaml:"requires_approval"`
+	DailyQuotaHours  *float64 `yaml:"daily_quota_hours"`
+}
+
+type roomSpec struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Capacity int    `yaml:"capacity"`
+	Quantity int    `yaml:"quantity"`
+	Group    string `yaml:"group"`
+}
+
+// step is one operation. Times are offsets from the scenario's base.
+type step struct {
+	Op string `yaml:"op"` // book, approve, reject, force or check
+
+	// book
+	ID    string        `yaml:"id"` // names the booking for later steps
+	Room  string        `yaml:"room"`
+	User  int           `yaml:"user"`
+	Start time.Duration `yaml:"start"`
+	End   time.Duration `yaml:"end"`
+	Units int           `yaml:"units"`
+
+	// approve, reject and force
+	Booking string `yaml:"booking"`
+
+	Expect   string   `yaml:"expect"`   // the outcome; see outcomes
+	Preempts []string `yaml:"preempts"` // force: exactly the bookings it rejects
+
+	// check: the current status of each named booking
+	Statuses map[string]string `yaml:"statuses"`
+}
+
+// outcomes are what each operation may be expected to end in
+var outcomes = map[string][]string{
+	// rejected: stored as rejected for a conflict, or no room was free to pick;
+	// quota: past a daily quota; invalid: refused as a bad request
+	"book": {"approved", "pending", "rejected", "quota", "invalid"},
+	// conflict: the booking does not fit, or cannot change alone (bundles);
+	// not_pending: only pending bookings are approved or rejected
+	"approve": {"approved", "conflict", "not_pending"},
+	"reject":  {"rejected", "not_pending"},
+	"force":   {"approved", "conflict"},
+}
+
+// statuses a check may expect; preempted is rejected by a force
+var statuses = []string{"approved", "pending", "rejected", "preempted"}
+
+func (s *scenario) base() time.Time {
+	if s.Base != nil {
+		return *s.Base
+	}
+	return defaultBase
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validate catches mistakes in the file before anything is sent
+func (s *scenario) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	groups := map[string]bool{}
+	for _, g := range s.Groups {
+		if g.Name == "" || groups[g.Name] {
+			return fmt.Errorf("group names must be given and unique, %q is not", g.Name)
+		}
+		groups[g.Name] = true
+	}
+	rooms := map[string]bool{}
+	for _, r := range s.Rooms {
+		if r.Name == "" || rooms[r.Name] {
+			return fmt.Errorf("room names must be given and unique, %q is not", r.Name)
+		}
+		if r.Group != "" && !groups[r.Group] {
+			return fmt.Errorf("room %s: unknown group %q", r.Name, r.Group)
+		}
+		rooms[r.Name] = true
+	}
+
+	bookings := map[string]bool{}
+	known := func(name string) error {
+		if !bookings[name] {
+			return fmt.Errorf("unknown booking %q", name)
+		}
+		return nil
+	}
+	for i, st := range s.Steps {
+		err := func() error {
+			switch st.Op {
+			case "book":
+				if !rooms[st.Room] {
+					return fmt.Errorf("unknown room %q", st.Room)
+				}
+				if st.ID != "" {
+					if bookings[st.ID] {
+						return fmt.Errorf("booking id %q is taken", st.ID)
+					}
+					bookings[st.ID] = true
+				}
+			case "approve", "reject", "force":
+				if err := known(st.Booking); err != nil {
+					return err
+				}
+				for _, p := range st.Preempts {
+					if err := known(p); err != nil {
+						return err
+					}
+				}
+			case "check":
+				if len(st.Statuses) == 0 {
+					return fmt.Errorf("check needs statuses")
+				}
+				for name, status := range st.Statuses {
+					if err := known(name); err != nil {
+						return err
+					}
+					if !contains(statuses, status) {
+						return fmt.Errorf("booking %s: status %q is not one of %v", name, status, statuses)
+					}
+				}
+				return nil
+			default:
+				return fmt.Errorf("unknown op %q", st.Op)
+			}
+			if st.Expect != "" && !contains(outcomes[st.Op], st.Expect) {
+				return fmt.Errorf("%s cannot end in %q, only %v", st.Op, st.Expect, outcomes[st.Op])
+			}
+			if st.Preempts != nil && st.Op != "force" {
+				return fmt.Errorf("only force preempts")
+			}
+			return nil
+		}()
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// parseScenario reads and validates one scenario file
+func parseScenario(name string, data []byte) (*scenario, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var s scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	s.file = name
+	return &s, nil
+}
+
+// scenarioPatterns match the scenario files of a directory
+var scenarioPatterns = []string{"*.yaml", "*.yml", "*.json"}
+
+// loadScenarios reads every scenario file of fsys, in name order
+func loadScenarios(fsys fs.FS) ([]*scenario, error) {
+	var names []string
+	for _, pattern := range scenarioPatterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+	sort.Strings(names)
+
+	list := make([]*scenario, 0, len(names))
+	for _, name := range names {
+		s, err := loadScenario(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// loadScenario reads a scenario from fsys
+func loadScenario(fsys fs.FS, name string) (*scenario, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return parseScenario(name, data)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/scenarios"
+)
+
+// TestScenarios replays every built-in scenario on the service layer, so each
+// one is a regression test of the engine
+func TestScenarios(t *testing.T) {
+	list, err := loadScenarios(scenarios.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+
+	for _, s := range list {
+		s := s
+		t.Run(s.file, func(t *testing.T) {
+			mismatches, err := replay(context.Background(), newLocalDriver(), s, t.Logf)
+			require.NoError(t, err)
+			assert.Empty(t, mismatches)
+		})
+	}
+}
+
+func TestLoadScenarios_YAMLAndJSON(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b.yaml":    {Data: []byte("name: b\nrooms: [{name: R}]\nsteps: [{op: book, room: R, user: 1, start: 9h, end: 10h}]\n")},
+		"a.json":    {Data: []byte(`{"name": "a", "rooms": [{"name": "R"}], "steps": [{"op": "book", "room": "R", "user": 1, "start": "9h", "end": "10h"}]}`)},
+		"notes.txt": {Data: []byte("not a scenario")},
+	}
+	list, err := loadScenarios(fsys)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "a", list[0].Name)
+	assert.Equal(t, "b", list[1].Name)
+}
+
+func TestReplay_ReportsMismatches(t *testing.T) {
+	s, err := parseScenario("inline.yaml", []byte(`
+name: wrong on purpose
+rooms: [{name: R}]
+steps:
+  - {op: book, id: a, room: R, user: 1, start: 9h, end: 10h, expect: approved}
+  - {op: book, id: b, room: R, user: 2, start: 9h, end: 10h, expect: approved}
+  - {op: force, booking: b, expect: approved, preempts: []}
+  - op: check
+    statuses: {a: approved}
+`))
+	require.NoError(t, err)
+
+	mismatches, err := replay(context.Background(), newLocalDriver(), s, t.Logf)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 3)
+	assert.Contains(t, mismatches[0], "got rejected, want approved")
+	assert.Contains(t, mismatches[1], "preempted [a], want []")
+	assert.Contains(t, mismatches[2], "a is preempted, want approved")
+}
+
+func TestParseScenario_Rejects(t *testing.T) {
+	cases := map[string]string{
+		"unknown field":   "name: x\nroom: []",
+		"unknown room":    "name: x\nsteps: [{op: book, room: R, user: 1, start: 1h, end: 2h}]",
+		"unknown group":   "name: x\nrooms: [{name: R, group: G}]",
+		"unknown booking": "name: x\nsteps: [{op: approve, booking: a}]",
+		"unknown op":      "name: x\nsteps: [{op: cancel}]",
+		"bad outcome":     "name: x\nrooms: [{name: R}]\nsteps: [{op: book, room: R, user: 1, start: 1h, end: 2h, expect: preempted}]",
+		"taken id":        "name: x\nrooms: [{name: R}]\nsteps: [{op: book, id: a, room: R}, {op: book, id: a, room: R}]",
+		"missing name":    "rooms: [{name: R}]",
+	}
+	for name, doc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseScenario("bad.yaml", []byte(doc))
+			assert.Error(t, err)
+		})
+	}
+}
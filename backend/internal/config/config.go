@@ -0,0 +1,452 @@
+// Package config loads the server configuration. Values come from, in rising
+// precedence: built-in defaults, an optional YAML file named by CONFIG_FILE, a
+// .env file in the working directory, and the process environment.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration
+type Config struct {
+	Port string `yaml:"port"`
+
+	// ShutdownTimeout is how long in-flight requests get to finish on SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Timezone is the zone responses are rendered in when a request names none
+	Timezone string `yaml:"timezone"`
+
+	// Storage selects the backend the services run on; see the Storage constants
+	Storage string `yaml:"storage"`
+
+	Database    Database    `yaml:"database"`
+	SQLite      SQLite      `yaml:"sqlite"`
+	Timeouts    Timeouts    `yaml:"timeouts"`
+	CORS        CORS        `yaml:"cors"`
+	Features    Features    `yaml:"features"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Idempotency Idempotency `yaml:"idempotency"`
+}
+
+// Storage backends
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite" // a single database file; one server process at a time
+	StorageMemory   = "memory" // process memory, lost on exit; needs no database
+)
+
+// Database describes the Postgres connection and its pool
+type Database struct {
+	// URL, when set, is used instead of the individual connection fields
+	URL      string `yaml:"url"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+
+	// The database is pinged up to ConnectAttempts times at startup
+	ConnectAttempts   int           `yaml:"connect_attempts"`
+	ConnectRetryDelay time.Duration `yaml:"connect_retry_delay"`
+}
+
+// SQLite describes the database file of the sqlite storage
+type SQLite struct {
+	Path string `yaml:"path"` // created, with its schema, if missing
+
+	// BusyTimeout is how long a write waits for another process holding the
+	// file, such as `allocra migrate`
+	BusyTimeout time.Duration `yaml:"busy_timeout"`
+}
+
+// Timeouts bound each kind of database operation
+type Timeouts struct {
+	Query         time.Duration `yaml:"query"`          // single reads and writes
+	ConflictCheck time.Duration `yaml:"conflict_check"` // the overlap check inside an allocation
+	Allocation    time.Duration `yaml:"allocation"`     // allocating or approving under room locks
+	BulkWrite     time.Duration `yaml:"bulk_write"`     // preemption, bundles, archiving, reset
+	Report        time.Duration `yaml:"report"`         // monthly and utilization reports
+	Batch         time.Duration `yaml:"batch"`          // calendar dry runs, idempotency key purges
+	Export        time.Duration `yaml:"export"`         // streamed spreadsheet exports
+}
+
+// CORS is off unless AllowedOrigins is set
+type CORS struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+}
+
+// Features switch optional endpoints on and off
+type Features struct {
+	AllocationReset bool `yaml:"allocation_reset"` // POST /api/allocations/reset (Playground)
+	CalendarImport  bool `yaml:"calendar_import"`  // POST /api/import/ics
+	Metrics         bool `yaml:"metrics"`          // GET /metrics
+}
+
+// Tracing selects the OpenTelemetry exporter
+type Tracing struct {
+	Exporter    string `yaml:"exporter"` // otlp, stdout or none
+	ServiceName string `yaml:"service_name"`
+}
+
+// Idempotency configures stored Idempotency-Key responses
+type Idempotency struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// Default is the configuration used for anything left unset
+func Default() Config {
+	return Config{
+		Port:            "8080",
+		ShutdownTimeout: 20 * time.Second,
+		Timezone:        "UTC",
+		Storage:         StoragePostgres,
+		Database: Database{
+			Host:              "localhost",
+			Port:              "5432",
+			User:              "postgres",
+			Password:          "password",
+			Name:              "allocra",
+			SSLMode:           "disable",
+			MaxOpenConns:      10,
+			MaxIdleConns:      5,
+			ConnMaxLifetime:   time.Hour,
+			ConnectAttempts:   10,
+			ConnectRetryDelay: 2 * time.Second,
+		},
+		SQLite: SQLite{
+			Path:        "allocra.db",
+			BusyTimeout: 5 * time.Second,
+		},
+		Timeouts: Timeouts{
+			Query:         5 * time.Second,
+			ConflictCheck: 3 * time.Second,
+			Allocation:    10 * time.Second,
+			BulkWrite:     15 * time.Second,
+			Report:        10 * time.Second,
+			Batch:         30 * time.Second,
+			Export:        60 * time.Second,
+		},
+		Features: Features{
+			AllocationReset: true,
+			CalendarImport:  true,
+			Metrics:         true,
+		},
+		Tracing:     Tracing{Exporter: "none"},
+		Idempotency: Idempotency{TTL: 24 * time.Hour},
+	}
+}
+
+// Load reads the configuration from CONFIG_FILE, .env and the environment and
+// validates it
+func Load() (*Config, error) {
+	// A missing .env is fine; variables already set are not overridden
+	_ = godotenv.Load()
+	return load(os.Getenv("CONFIG_FILE"), os.LookupEnv)
+}
+
+func load(file string, lookup func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", file, err)
+		}
+	}
+	if err := cfg.applyEnv(lookup); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// applyEnv overrides fields from environment variables
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	e := envReader{lookup: lookup}
+
+	e.str("PORT", &c.Port)
+	e.duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	e.str("TIMEZONE", &c.Timezone)
+	e.str("STORAGE", &c.Storage)
+
+	e.str("DATABASE_URL", &c.Database.URL)
+	e.str("DB_HOST", &c.Database.Host)
+	e.str("DB_PORT", &c.Database.Port)
+	e.str("DB_USER", &c.Database.User)
+	e.str("DB_PASSWORD", &c.Database.Password)
+	e.str("DB_NAME", &c.Database.Name)
+	e.str("DB_SSLMODE", &c.Database.SSLMode)
+	e.integer("DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	e.integer("DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+	e.duration("DB_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime)
+	e.integer("DB_CONNECT_ATTEMPTS", &c.Database.ConnectAttempts)
+	e.duration("DB_CONNECT_RETRY_DELAY", &c.Database.ConnectRetryDelay)
+
+	e.str("SQLITE_PATH", &c.SQLite.Path)
+	e.duration("SQLITE_BUSY_TIMEOUT", &c.SQLite.BusyTimeout)
+
+	e.duration("TIMEOUT_QUERY", &c.Timeouts.Query)
+	e.duration("TIMEOUT_CONFLICT_CHECK", &c.Timeouts.ConflictCheck)
+	e.duration("TIMEOUT_ALLOCATION", &c.Timeouts.Allocation)
+	e.duration("TIMEOUT_BULK_WRITE", &c.Timeouts.BulkWrite)
+	e.duration("TIMEOUT_REPORT", &c.Timeouts.Report)
+	e.duration("TIMEOUT_BATCH", &c.Timeouts.Batch)
+	e.duration("TIMEOUT_EXPORT", &c.Timeouts.Export)
+
+	e.list("CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+	e.boolean("CORS_ALLOW_CREDENTIALS", &c.CORS.AllowCredentials)
+
+	e.boolean("FEATURE_ALLOCATION_RESET", &c.Features.AllocationReset)
+	e.boolean("FEATURE_CALENDAR_IMPORT", &c.Features.CalendarImport)
+	e.boolean("FEATURE_METRICS", &c.Features.Metrics)
+
+	e.str("OTEL_TRACES_EXPORTER", &c.Tracing.Exporter)
+	e.str("OTEL_SERVICE_NAME", &c.Tracing.ServiceName)
+
+	e.duration("IDEMPOTENCY_TTL", &c.Idempotency.TTL)
+
+	return errors.Join(e.errs...)
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		fail("port: %q is not a TCP port", c.Port)
+	}
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout: must be positive")
+	}
+	if c.Timezone == "" || c.Timezone == "Local" {
+		fail("timezone: must be an IANA time zone such as UTC or Europe/Berlin")
+	} else if _, err := time.LoadLocation(c.Timezone); err != nil {
+		fail("timezone: unknown time zone %q", c.Timezone)
+	}
+	switch c.Storage {
+	case StoragePostgres, StorageSQLite, StorageMemory:
+	default:
+		fail("storage: %q is not one of %s, %s, %s", c.Storage, StoragePostgres, StorageSQLite, StorageMemory)
+	}
+	if c.Storage == StorageSQLite {
+		if c.SQLite.Path == "" {
+			fail("sqlite.path: required with sqlite storage")
+		}
+		if c.SQLite.BusyTimeout < 0 {
+			fail("sqlite.busy_timeout: must not be negative")
+		}
+	}
+
+	db := c.Database
+	if db.URL != "" {
+		if u, err := url.Parse(db.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			fail("database.url: must be a postgres:// URL")
+		}
+	} else if db.Host == "" || db.Name == "" || db.User == "" {
+		fail("database: host, user and name are required unless url is set")
+	}
+	switch db.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		fail("database.sslmode: %q is not a Postgres sslmode", db.SSLMode)
+	}
+	if db.MaxOpenConns < 1 {
+		fail("database.max_open_conns: must be at least 1")
+	}
+	if db.MaxIdleConns < 0 || db.MaxIdleConns > db.MaxOpenConns {
+		fail("database.max_idle_conns: must be between 0 and max_open_conns")
+	}
+	if db.ConnMaxLifetime < 0 {
+		fail("database.conn_max_lifetime: must not be negative")
+	}
+	if db.ConnectAttempts < 1 {
+		fail("database.connect_attempts: must be at least 1")
+	}
+
+	for name, d := range map[string]time.Duration{
+		"query":          c.Timeouts.Query,
+		"conflict_check": c.Timeouts.ConflictCheck,
+		"allocation":     c.Timeouts.Allocation,
+		"bulk_write":     c.Timeouts.BulkWrite,
+		"report":         c.Timeouts.Report,
+		"batch":          c.Timeouts.Batch,
+		"export":         c.Timeouts.Export,
+	} {
+		if d <= 0 {
+			fail("timeouts.%s: must be positive", name)
+		}
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" && c.CORS.AllowCredentials {
+			fail("cors.allowed_origins: * cannot be combined with allow_credentials")
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "none", "otlp", "stdout":
+	default:
+		fail("tracing.exporter: must be otlp, stdout or none")
+	}
+	if c.Idempotency.TTL <= 0 {
+		fail("idempotency.ttl: must be positive")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+}
+
+// Location is the parsed Timezone
+func (c *Config) Location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// DSN is the lib/pq connection string. Sessions always run in UTC, so nothing
+// the database derives from a timestamp depends on where it is configured.
+func (d Database) DSN() string {
+	if d.URL != "" {
+		u, err := url.Parse(d.URL)
+		if err != nil {
+			return d.URL
+		}
+		q := u.Query()
+		if q.Get("sslmode") == "" {
+			q.Set("sslmode", d.SSLMode)
+		}
+		q.Set("timezone", "UTC")
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s timezone=UTC",
+		dsnValue(d.Host), dsnValue(d.Port), dsnValue(d.User), dsnValue(d.Password), dsnValue(d.Name), dsnValue(d.SSLMode))
+}
+
+// dsnValue quotes a key/value connection string value when it needs it
+func dsnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+const redacted = "********"
+
+// String renders the configuration as YAML with secrets redacted
+func (c Config) String() string {
+	if c.Database.Password != "" {
+		c.Database.Password = redacted
+	}
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL); err == nil {
+			// lib/pq also takes the password as a query parameter
+			if q := u.Query(); q.Get("password") != "" {
+				q.Set("password", "xxxxx")
+				u.RawQuery = q.Encode()
+			}
+			c.Database.URL = u.Redacted()
+		} else {
+			c.Database.URL = redacted
+		}
+	}
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("<unprintable config: %v>", err)
+	}
+	return string(out)
+}
+
+// envReader applies environment variables to fields, collecting parse errors
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (e *envReader) get(name string) (string, bool) {
+	v, ok := e.lookup(name)
+	if !ok || v == "" {
+		return "", false
+	}
+	return v, true
+}
+
+func (e *envReader) str(name string, dst *string) {
+	if v, ok := e.get(name); ok {
+		*dst = v
+	}
+}
+
+func (e *envReader) integer(name string, dst *int) {
+	if v, ok := e.get(name); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not an integer", name, v))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) duration(name string, dst *time.Duration) {
+	if v, ok := e.get(name); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not a duration such as 5s or 1h", name, v))
+			return
+		}
+		*dst = d
+	}
+}
+
+func (e *envReader) boolean(name string, dst *bool) {
+	if v, ok := e.get(name); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not a boolean", name, v))
+			return
+		}
+		*dst = b
+	}
+}
+
+func (e *envReader) list(name string, dst *[]string) {
+	if v, ok := e.get(name); ok {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load("", env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "8080", cfg.Port)
+	assert.Equal(t, time.UTC, cfg.Location())
+	assert.Equal(t, StoragePostgres, cfg.Storage)
+	assert.Equal(t, 5*time.Second, cfg.Timeouts.Query)
+	assert.Equal(t, 24*time.Hour, cfg.Idempotency.TTL)
+	assert.True(t, cfg.Features.AllocationReset)
+	assert.Empty(t, cfg.CORS.AllowedOrigins)
+	assert.Equal(t, "host=localhost port=5432 user=postgres password=password dbname=allocra sslmode=disable timezone=UTC", cfg.Database.DSN())
+}
+
+func TestLoad_FileThenEnvironment(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "allocra.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+port: "9000"
+timezone: Europe/Berlin
+database:
+  host: db.internal
+  sslmode: require
+  max_open_conns: 40
+timeouts:
+  query: 2s
+features:
+  allocation_reset: false
+`), 0o600))
+
+	cfg, err := load(file, env(map[string]string{
+		"DB_HOST":              "override.internal",
+		"TIMEOUT_EXPORT":       "2m",
+		"CORS_ALLOWED_ORIGINS": "https://a.example, https://b.example",
+		"FEATURE_METRICS":      "false",
+		"PORT":                 "", // empty variables are ignored
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "9000", cfg.Port)
+	assert.Equal(t, "Europe/Berlin", cfg.Location().String())
+	assert.Equal(t, "override.internal", cfg.Database.Host)
+	assert.Equal(t, "require", cfg.Database.SSLMode)
+	assert.Equal(t, 40, cfg.Database.MaxOpenConns)
+	assert.Equal(t, 5, cfg.Database.MaxIdleConns)
+	assert.Equal(t, 2*time.Second, cfg.Timeouts.Query)
+	assert.Equal(t, 2*time.Minute, cfg.Timeouts.Export)
+	assert.Equal(t, 3*time.Second, cfg.Timeouts.ConflictCheck)
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, cfg.CORS.AllowedOrigins)
+	assert.False(t, cfg.Features.AllocationReset)
+	assert.False(t, cfg.Features.Metrics)
+	assert.True(t, cfg.Features.CalendarImport)
+}
+
+func TestLoad_RejectsUnknownFileKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "allocra.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("databse:\n  host: x\n"), 0o600))
+
+	_, err := load(file, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "databse")
+}
+
+func TestLoad_ReportsEveryProblem(t *testing.T) {
+	_, err := load("", env(map[string]string{
+		"PORT":                   "http",
+		"SHUTDOWN_TIMEOUT":       "-1s",
+		"TIMEZONE":               "Local",
+		"STORAGE":                "mongodb",
+		"DB_SSLMODE":             "sometimes",
+		"DB_MAX_IDLE_CONNS":      "50",
+		"TIMEOUT_QUERY":          "0s",
+		"CORS_ALLOWED_ORIGINS":   "*",
+		"CORS_ALLOW_CREDENTIALS": "true",
+	}))
+	require.Error(t, err)
+	for _, want := range []string{"port", "shutdown_timeout", "timezone", "storage", "sslmode", "max_idle_conns", "timeouts.query", "allow_credentials"} {
+		assert.Contains(t, err.Error(), want)
+	}
+
+	_, err = load("", env(map[string]string{"TIMEOUT_REPORT": "ten seconds", "FEATURE_METRICS": "maybe"}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TIMEOUT_REPORT")
+	assert.Contains(t, err.Error(), "FEATURE_METRICS")
+}
+
+func TestLoad_SQLite(t *testing.T) {
+	cfg, err := load("", env(map[string]string{"STORAGE": "sqlite", "SQLITE_PATH": "/var/lib/allocra/allocra.db"}))
+	require.NoError(t, err)
+	assert.Equal(t, StorageSQLite, cfg.Storage)
+	assert.Equal(t, "/var/lib/allocra/allocra.db", cfg.SQLite.Path)
+	assert.Equal(t, 5*time.Second, cfg.SQLite.BusyTimeout)
+
+	file := filepath.Join(t.TempDir(), "allocra.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("storage: sqlite\nsqlite:\n  path: \"\"\n"), 0o600))
+	_, err = load(file, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sqlite.path")
+}
+
+func TestDSN(t *testing.T) {
+	db := Default().Database
+	db.Password = "it's secret"
+	assert.Contains(t, db.DSN(), `password='it\'s secret'`)
+
+	db.URL = "postgres://app:pw@db:5432/allocra?sslmode=verify-full"
+	assert.Equal(t, "postgres://app:pw@db:5432/allocra?sslmode=verify-full&timezone=UTC", db.DSN())
+}
+
+func TestString_RedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = "hunter2"
+	out := cfg.String()
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, "password: '********'")
+	assert.Contains(t, out, "query: 5s")
+	assert.Equal(t, "hunter2", cfg.Database.Password)
+
+	cfg.Database.URL = "postgres://app:hunter2@db/allocra"
+	out = cfg.String()
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, "url: postgres://app:xxxxx@db/allocra")
+
+	cfg.Database.URL = "postgres://db/allocra?password=hunter2&sslmode=require"
+	out = cfg.String()
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, "url: postgres://db/allocra?password=xxxxx&sslmode=require")
+}
@@ -0,0 +1,92 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+const icsTimeLayout = "20060102T150405Z"
+
+// CalendarWriter streams bookings as an RFC 5545 VCALENDAR feed
+type CalendarWriter struct {
+	w     *bufio.Writer
+	host  string
+	stamp time.Time
+	err   error
+}
+
+// NewCalendarWriter writes the calendar preamble. host scopes event UIDs so
+// they stay stable across feed refreshes, which calendar clients rely on.
+func NewCalendarWriter(w io.Writer, name, host string) *CalendarWriter {
+	cw := &CalendarWriter{w: bufio.NewWriter(w), host: host, stamp: time.Now().UTC()}
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line("PRODID:-//Allocra//Allocation Engine//EN")
+	cw.line("CALSCALE:GREGORIAN")
+	cw.line("METHOD:PUBLISH")
+	cw.line("X-WR-CALNAME:" + escapeText(name))
+	return cw
+}
+
+// WriteBooking emits one VEVENT
+func (cw *CalendarWriter) WriteBooking(b *models.Booking) error {
+	summary := fmt.Sprintf("Allocation #%d", b.ID)
+	location := fmt.Sprintf("Room %d", b.RoomID)
+	if b.RoomName != "" {
+		summary = b.RoomName + " allocation"
+		location = b.RoomName
+	}
+
+	cw.line("BEGIN:VEVENT")
+	cw.line(fmt.Sprintf("UID:booking-%d@%s", b.ID, cw.host))
+	cw.line("DTSTAMP:" + cw.stamp.Format(icsTimeLayout))
+	cw.line("DTSTART:" + b.StartTime.UTC().Format(icsTimeLayout))
+	cw.line("DTEND:" + b.EndTime.UTC().Format(icsTimeLayout))
+	cw.line("SUMMARY:" + escapeText(summary))
+	cw.line("LOCATION:" + escapeText(location))
+	cw.line(fmt.Sprintf("DESCRIPTION:Booked by user %d", b.UserID))
+	cw.line("STATUS:CONFIRMED")
+	cw.line("END:VEVENT")
+	return cw.err
+}
+
+// Close terminates the calendar and flushes the output
+func (cw *CalendarWriter) Close() error {
+	cw.line("END:VCALENDAR")
+	if cw.err != nil {
+		return cw.err
+	}
+	return cw.w.Flush()
+}
+
+// line writes a content line, folding it at 75 octets as RFC 5545 requires
+func (cw *CalendarWriter) line(s string) {
+	if cw.err != nil {
+		return
+	}
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		// Never split a multi-byte UTF-8 sequence
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		if _, cw.err = cw.w.WriteString(s[:cut] + "\r\n "); cw.err != nil {
+			return
+		}
+		s = s[cut:]
+		limit = 74 // continuation lines start with a space
+	}
+	_, cw.err = cw.w.WriteString(s + "\r\n")
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
@@ -0,0 +1,71 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+func TestCalendarWriter_EscapesAndFolds(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewCalendarWriter(&buf, "Room; A", "allocra.test")
+
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, cw.WriteBooking(&models.Booking{
+		ID:        42,
+		RoomID:    1,
+		UserID:    7,
+		StartTime: start,
+		EndTime:   start.Add(time.Hour),
+		RoomName:  "Conference Hall, " + strings.Repeat("x", 100),
+	}))
+	require.NoError(t, cw.Close())
+
+	out := buf.String()
+	assert.Contains(t, out, "X-WR-CALNAME:Room\\; A\r\n")
+	assert.Contains(t, out, "UID:booking-42@allocra.test\r\n")
+	assert.Contains(t, out, "DTSTART:20260301T090000Z\r\n")
+	assert.Contains(t, out, "LOCATION:Conference Hall\\, ")
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+
+	for _, line := range strings.Split(out, "\r\n") {
+		assert.LessOrEqual(t, len(line), 75, "content lines must be folded: %q", line)
+	}
+}
+
+func TestTableWriter_CSV(t *testing.T) {
+	var buf bytes.Buffer
+	tw, err := NewTableWriter(FormatCSV, &buf, "bookings")
+	require.NoError(t, err)
+
+	require.NoError(t, tw.WriteHeader([]string{"id", "room_name", "hours", "start"}))
+	require.NoError(t, tw.WriteRow([]any{1, "Room, A", 1.5, time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}))
+	require.NoError(t, tw.Close())
+
+	assert.Equal(t, "id,room_name,hours,start\n1,\"Room, A\",1.5,2026-03-01T09:00:00Z\n", buf.String())
+}
+
+func TestTableWriter_XLSX(t *testing.T) {
+	var buf bytes.Buffer
+	tw, err := NewTableWriter(FormatXLSX, &buf, "bookings")
+	require.NoError(t, err)
+
+	require.NoError(t, tw.WriteHeader([]string{"id", "room_name"}))
+	require.NoError(t, tw.WriteRow([]any{1, "Room A"}))
+	require.NoError(t, tw.Close())
+
+	f, err := excelize.OpenReader(&buf)
+	require.NoError(t, err)
+	defer f.Close()
+
+	rows, err := f.GetRows("bookings")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"id", "room_name"}, {"1", "Room A"}}, rows)
+}
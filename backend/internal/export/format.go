@@ -0,0 +1,63 @@
+package export
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Format is an output representation selectable by ?format= or the Accept header
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatCSV  Format = "csv"
+	FormatXLSX Format = "xlsx"
+	FormatICS  Format = "ics"
+)
+
+const (
+	MIMECSV  = "text/csv; charset=utf-8"
+	MIMEXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	MIMEICS  = "text/calendar; charset=utf-8"
+)
+
+// ContentType returns the MIME type sent with f
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return MIMECSV
+	case FormatXLSX:
+		return MIMEXLSX
+	case FormatICS:
+		return MIMEICS
+	}
+	return fiber.MIMEApplicationJSONCharsetUTF8
+}
+
+// Negotiate picks the response format. An explicit ?format= wins; otherwise the
+// Accept header is matched against CSV and XLSX, falling back to JSON.
+// ok is false when ?format= names something unsupported.
+func Negotiate(c *fiber.Ctx) (format Format, ok bool) {
+	if q := strings.ToLower(c.Query("format")); q != "" {
+		switch Format(q) {
+		case FormatJSON, FormatCSV, FormatXLSX:
+			return Format(q), true
+		}
+		return "", false
+	}
+
+	switch c.Accepts(fiber.MIMEApplicationJSON, "text/csv", MIMEXLSX) {
+	case "text/csv":
+		return FormatCSV, true
+	case MIMEXLSX:
+		return FormatXLSX, true
+	}
+	return FormatJSON, true
+}
+
+// Attach sets the headers for a file download of the given base name
+func Attach(c *fiber.Ctx, f Format, name string) {
+	c.Set(fiber.HeaderContentType, f.ContentType())
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+name+"."+string(f)+`"`)
+}
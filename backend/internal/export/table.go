@@ -0,0 +1,120 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// TableWriter streams a tabular report one row at a time
+type TableWriter interface {
+	WriteHeader(columns []string) error
+	WriteRow(values []any) error
+	// Close flushes buffered output; the writer must not be used afterwards
+	Close() error
+}
+
+// NewTableWriter returns a writer for a tabular format (CSV or XLSX)
+func NewTableWriter(format Format, w io.Writer, sheet string) (TableWriter, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w, sheet)
+	}
+	return nil, fmt.Errorf("format %q is not tabular", format)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) WriteHeader(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvWriter) WriteRow(values []any) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatCell(v)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func formatCell(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case int:
+		return strconv.Itoa(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// xlsxWriter uses excelize's stream writer, which spills rows to a temp file
+// instead of holding the sheet in memory; the workbook is assembled on Close
+type xlsxWriter struct {
+	out    io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func newXLSXWriter(w io.Writer, sheet string) (*xlsxWriter, error) {
+	f := excelize.NewFile()
+	if sheet != "" && sheet != "Sheet1" {
+		if err := f.SetSheetName("Sheet1", sheet); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else {
+		sheet = "Sheet1"
+	}
+
+	stream, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open xlsx stream: %w", err)
+	}
+	return &xlsxWriter{out: w, file: f, stream: stream}, nil
+}
+
+func (x *xlsxWriter) WriteHeader(columns []string) error {
+	values := make([]any, len(columns))
+	for i, c := range columns {
+		values[i] = c
+	}
+	return x.WriteRow(values)
+}
+
+func (x *xlsxWriter) WriteRow(values []any) error {
+	x.row++
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	return x.stream.SetRow(cell, values)
+}
+
+func (x *xlsxWriter) Close() error {
+	defer x.file.Close()
+	if err := x.stream.Flush(); err != nil {
+		return fmt.Errorf("failed to flush xlsx stream: %w", err)
+	}
+	return x.file.Write(x.out)
+}
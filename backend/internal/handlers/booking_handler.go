@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/export"
 	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
 	"github.com/indraprhmbd/allocra/internal/services"
 )
 
@@ -24,22 +30,49 @@ func (h *BookingHandler) CreateBooking(c *fiber.Ctx) error {
         })
     }
     
-    booking, err := h.bookingService.CreateBooking(c.Context(), &req)
+    booking, err := h.bookingService.CreateBooking(c.UserContext(), &req)
     if err != nil {
-        if err.Error() == "booking conflict detected" {
-            return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-                "error": err.Error(),
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        if errors.Is(err, repository.ErrNoRoomAvailable) || errors.Is(err, repository.ErrQuotaExceeded) {
+            return refused(c, fiber.StatusConflict, err)
+        }
+        if errors.Is(err, repository.ErrRoomNotFound) {
+            return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+                Error:  "validation failed",
+                Fields: map[string]string{"room_id": "does not exist or is archived"},
+            })
+        }
+        if errors.Is(err, repository.ErrInsufficientUnits) {
+            return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+                Error:  "validation failed",
+                Fields: map[string]string{"quantity": err.Error()},
+            })
+        }
+        if errors.Is(err, repository.ErrOutsideOperatingHours) {
+            return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+                Error:  "validation failed",
+                Fields: map[string]string{"start_time": err.Error()},
             })
         }
+        if errors.Is(err, repository.ErrBookingConflict) {
+            // The request is stored as rejected; clients get it to refer to
+            resp := errorResponse{Error: err.Error(), Code: "booking_conflict"}
+            if booking != nil {
+                resp.Booking = localize(c, booking)
+            }
+            return c.Status(fiber.StatusConflict).JSON(resp)
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
     
-    return c.Status(fiber.StatusCreated).JSON(booking)
+    return c.Status(fiber.StatusCreated).JSON(localize(c, booking))
 }
 
-func (h *BookingHandler) ApproveBooking(c *fiber.Ctx) error {
+func (h *BookingHandler) GetBooking(c *fiber.Ctx) error {
     id, err := strconv.Atoi(c.Params("id"))
     if err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -47,17 +80,37 @@ func (h *BookingHandler) ApproveBooking(c *fiber.Ctx) error {
         })
     }
     
-    err = h.bookingService.ApproveBooking(c.Context(), id)
+    booking, err := h.bookingService.GetBooking(c.UserContext(), id)
     if err != nil {
+        if errors.Is(err, repository.ErrBookingNotFound) {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+                "error": err.Error(),
+            })
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
     
-    return c.SendStatus(fiber.StatusOK)
+    return sendConditional(c, entityTag(c, "booking", booking.ID, booking.Version), booking.UpdatedAt, booking)
+}
+
+func (h *BookingHandler) ApproveBooking(c *fiber.Ctx) error {
+    return h.transition(c, h.bookingService.ApproveBooking)
 }
 
 func (h *BookingHandler) RejectBooking(c *fiber.Ctx) error {
+    return h.transition(c, h.bookingService.RejectBooking)
+}
+
+// transitionRequest is the optional body of the status PATCH endpoints
+type transitionRequest struct {
+    Version *int `json:"version"`
+}
+
+// transition runs a status change conditioned on If-Match or the body's
+// version and responds with the updated booking and its new ETag
+func (h *BookingHandler) transition(c *fiber.Ctx, apply func(context.Context, int, int) (*models.Booking, error)) error {
     id, err := strconv.Atoi(c.Params("id"))
     if err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -65,46 +118,168 @@ func (h *BookingHandler) RejectBooking(c *fiber.Ctx) error {
         })
     }
     
-    err = h.bookingService.RejectBooking(c.Context(), id)
+    var req transitionRequest
+    if len(c.Body()) > 0 {
+        if err := c.BodyParser(&req); err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "invalid request body",
+            })
+        }
+    }
+    
+    version, err := expectedVersion(c, "booking", id, req.Version)
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        return c.Status(preconditionStatus(err)).JSON(fiber.Map{"error": err.Error()})
+    }
+    
+    booking, err := apply(c.UserContext(), id, version)
     if err != nil {
+        if status := preconditionStatus(err); status != 0 {
+            return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+        }
+        if errors.Is(err, repository.ErrBookingNotFound) {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+                "error": err.Error(),
+            })
+        }
+        if errors.Is(err, repository.ErrBookingInBundle) || errors.Is(err, repository.ErrInsufficientUnits) ||
+            errors.Is(err, repository.ErrBookingNotPending) || errors.Is(err, repository.ErrApprovalConflict) {
+            return refused(c, fiber.StatusConflict, err)
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
     
-    return c.SendStatus(fiber.StatusOK)
+    c.Set(fiber.HeaderETag, entityTag(c, "booking", booking.ID, booking.Version))
+    return c.JSON(localize(c, booking))
 }
 
+var bookingColumns = []string{"id", "room_id", "room_name", "user_id", "start_time", "end_time", "status", "created_at"}
+
 func (h *BookingHandler) GetAllBookings(c *fiber.Ctx) error {
-    bookings, err := h.bookingService.GetAllBookings(c.Context())
+    format, ok := export.Negotiate(c)
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unsupported format: use json, csv or xlsx"})
+    }
+    if format != export.FormatJSON {
+        return streamTable(c, format, "bookings", bookingColumns, func(ctx context.Context, emit func(...any) error) error {
+            return h.bookingService.StreamBookings(ctx, models.BookingFilter{}, func(b *models.Booking) error {
+                return emit(b.ID, b.RoomID, b.RoomName, b.UserID, b.StartTime, b.EndTime, b.Status, b.CreatedAt)
+            })
+        })
+    }
+    
+    bookings, err := h.bookingService.GetAllBookings(c.UserContext())
     if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
-    return c.JSON(bookings)
+    return c.JSON(localize(c, bookings))
 }
 
-func (h *BookingHandler) GetBookingsByRoom(c *fiber.Ctx) error {
-    roomID, err := strconv.Atoi(c.Query("room_id"))
+// ListBookings serves GET /api/bookings: filtered, sorted, keyset-paginated.
+//
+// Filters: room_id, user_id, status, from/to (bookings overlapping the window),
+// created_from/created_to. Sorting: sort=start_time|created_at|id, order=asc|desc.
+// Paging: limit (default 50, max 500) and cursor (next_cursor of the previous page).
+// The total number of matches is returned in X-Total-Count.
+func (h *BookingHandler) ListBookings(c *fiber.Ctx) error {
+    q := models.BookingListQuery{
+        Sort:   c.Query("sort", "start_time"),
+        Desc:   c.Query("order", "desc") != "asc",
+        Cursor: c.Query("cursor"),
+    }
+    q.Filter.Status = c.Query("status")
+    
+    var err error
+    if q.Limit, err = queryInt(c, "limit"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.Filter.RoomID, err = queryIntPtr(c, "room_id"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.Filter.UserID, err = queryIntPtr(c, "user_id"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.Filter.From, err = queryTime(c, "from"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.Filter.To, err = queryTime(c, "to"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.Filter.CreatedAfter, err = queryTime(c, "created_from"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.Filter.CreatedBefore, err = queryTime(c, "created_to"); err != nil {
+        return badRequest(c, err)
+    }
+    
+    page, err := h.bookingService.ListBookings(c.UserContext(), q)
     if err != nil {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-            "error": "invalid room ID",
+        if errors.Is(err, services.ErrInvalidListQuery) {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": err.Error(),
+        })
+    }
+    
+    c.Set("X-Total-Count", strconv.Itoa(page.Total))
+    return c.JSON(localize(c, page))
+}
+
+// GetMonthlyReport serves /api/reports/monthly-usage per room, or rolled up to
+// the groups of one hierarchy level with ?level=site|building|pool. The month is
+// the one under way in the request's zone.
+func (h *BookingHandler) GetMonthlyReport(c *fiber.Ctx) error {
+    format, ok := export.Negotiate(c)
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unsupported format: use json, csv or xlsx"})
+    }
+    if level := c.Query("level", "room"); level != "room" {
+        return h.getGroupMonthlyReport(c, format, level)
+    }
+    loc := requestLocation(c)
+    if format != export.FormatJSON {
+        columns := []string{"room_id", "room_name", "total_bookings", "total_hours"}
+        return streamTable(c, format, "monthly-usage", columns, func(ctx context.Context, emit func(...any) error) error {
+            return h.bookingService.StreamMonthlyReport(ctx, loc, func(r *models.MonthlyUsageReport) error {
+                return emit(r.RoomID, r.RoomName, r.TotalBookings, r.TotalHours)
+            })
         })
     }
     
-    bookings, err := h.bookingService.GetBookingsByRoom(c.Context(), roomID)
+    report, err := h.bookingService.GetMonthlyReport(c.UserContext(), loc)
     if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
     
-    return c.JSON(bookings)
+    return c.JSON(report)
 }
 
-func (h *BookingHandler) GetMonthlyReport(c *fiber.Ctx) error {
-    report, err := h.bookingService.GetMonthlyReport(c.Context())
+func (h *BookingHandler) getGroupMonthlyReport(c *fiber.Ctx, format export.Format, level string) error {
+    // Checked up front: once a table streams, errors can only be logged
+    if err := services.ValidateReportLevel(level); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+    }
+    loc := requestLocation(c)
+    if format != export.FormatJSON {
+        columns := []string{"group_id", "group_name", "kind", "rooms", "total_bookings", "total_hours"}
+        return streamTable(c, format, "monthly-usage-"+level, columns, func(ctx context.Context, emit func(...any) error) error {
+            return h.bookingService.StreamGroupMonthlyReport(ctx, level, loc, func(r *models.GroupUsageReport) error {
+                return emit(r.GroupID, r.GroupName, r.Kind, r.Rooms, r.TotalBookings, r.TotalHours)
+            })
+        })
+    }
+    
+    report, err := h.bookingService.GetGroupMonthlyReport(c.UserContext(), level, loc)
     if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
@@ -114,13 +289,83 @@ func (h *BookingHandler) GetMonthlyReport(c *fiber.Ctx) error {
     return c.JSON(report)
 }
 
-func (h *BookingHandler) ForceAllocate(c *fiber.Ctx) error {
-    id, _ := strconv.Atoi(c.Params("id"))
-    err := h.bookingService.ForceAllocate(c.Context(), id)
+// GetUtilizationReport serves /api/reports/utilization. from/to are RFC 3339 and
+// default to the last seven days; buckets follow the request zone's calendar.
+func (h *BookingHandler) GetUtilizationReport(c *fiber.Ctx) error {
+    q := models.UtilizationQuery{
+        To:          time.Now(),
+        Granularity: c.Query("granularity", "day"),
+        Type:        c.Query("type"),
+        Location:    requestLocation(c),
+    }
+    q.From = q.To.AddDate(0, 0, -7)
+    
+    if v := c.Query("from"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid from: expected RFC 3339 timestamp"})
+        }
+        q.From = t
+    }
+    if v := c.Query("to"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid to: expected RFC 3339 timestamp"})
+        }
+        q.To = t
+    }
+    if v := c.Query("room_id"); v != "" {
+        roomID, err := strconv.Atoi(v)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room ID"})
+        }
+        q.RoomID = &roomID
+    }
+    groupID, err := queryIntPtr(c, "group_id")
+    if err != nil {
+        return badRequest(c, err)
+    }
+    q.GroupID = groupID
+    
+    report, err := h.bookingService.GetUtilizationReport(c.UserContext(), q)
     if err != nil {
+        if errors.Is(err, services.ErrInvalidReportQuery) {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
-    return c.SendStatus(fiber.StatusOK)
+    
+    return c.JSON(localize(c, report))
+}
+
+// GetRoomCalendar serves an iCalendar feed of a room's approved bookings
+func (h *BookingHandler) GetRoomCalendar(c *fiber.Ctx) error {
+    roomID, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room ID"})
+    }
+    
+    filter := models.BookingFilter{RoomID: &roomID, Status: "approved"}
+    return streamCalendar(c, fmt.Sprintf("Allocra room %d", roomID), func(ctx context.Context, cw *export.CalendarWriter) error {
+        return h.bookingService.StreamBookings(ctx, filter, cw.WriteBooking)
+    })
+}
+
+// GetUserCalendar serves an iCalendar feed of a user's approved bookings
+func (h *BookingHandler) GetUserCalendar(c *fiber.Ctx) error {
+    userID, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user ID"})
+    }
+    
+    filter := models.BookingFilter{UserID: &userID, Status: "approved"}
+    return streamCalendar(c, fmt.Sprintf("Allocra user %d", userID), func(ctx context.Context, cw *export.CalendarWriter) error {
+        return h.bookingService.StreamBookings(ctx, filter, cw.WriteBooking)
+    })
+}
+
+func (h *BookingHandler) ForceAllocate(c *fiber.Ctx) error {
+    return h.transition(c, h.bookingService.ForceAllocate)
 }
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository/memory"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+func TestCreateBooking_ConflictReturnsTheStoredBooking(t *testing.T) {
+	db := memory.NewDatabase()
+	room, err := services.NewRoomService(memory.NewRoomRepository(db)).
+		CreateRoom(context.Background(), "Lab", 4, 1, models.RoomTypeExclusive, models.RoomStatusOnline, "UTC", nil, nil)
+	require.NoError(t, err)
+
+	h := NewBookingHandler(services.NewBookingService(memory.NewBookingRepository(db)))
+	app := fiber.New()
+	app.Post("/bookings", h.CreateBooking)
+	app.Patch("/bookings/:id/approve", h.ApproveBooking)
+
+	type reply struct {
+		ID      int    `json:"id"`
+		Status  string `json:"status"`
+		Error   string `json:"error"`
+		Code    string `json:"code"`
+		Booking *struct {
+			ID     int    `json:"id"`
+			Status string `json:"status"`
+		} `json:"booking"`
+	}
+	send := func(method, path, body string) (int, reply) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		var r reply
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+		return resp.StatusCode, r
+	}
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+	body := fmt.Sprintf(`{"room_id":%d,"user_id":%%d,"start_time":%q,"end_time":%q}`,
+		room.ID, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+
+	status, first := send("POST", "/bookings", fmt.Sprintf(body, 1))
+	require.Equal(t, fiber.StatusCreated, status)
+	assert.Equal(t, "approved", first.Status)
+
+	status, second := send("POST", "/bookings", fmt.Sprintf(body, 2))
+	require.Equal(t, fiber.StatusConflict, status)
+	assert.Equal(t, "booking_conflict", second.Code)
+	require.NotNil(t, second.Booking)
+	assert.Equal(t, "rejected", second.Booking.Status)
+	assert.NotEqual(t, first.ID, second.Booking.ID)
+
+	status, again := send("PATCH", fmt.Sprintf("/bookings/%d/approve", second.Booking.ID), "")
+	assert.Equal(t, fiber.StatusConflict, status)
+	assert.Equal(t, "booking_not_pending", again.Code)
+}
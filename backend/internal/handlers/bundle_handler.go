@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+// CreateBundle books several rooms over one window, all or nothing. When any
+// member conflicts the rejected bundle is returned with 409, listing the
+// conflicting rooms.
+func (h *BookingHandler) CreateBundle(c *fiber.Ctx) error {
+    var req models.CreateBundleRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "invalid request body",
+        })
+    }
+    
+    bundle, err := h.bookingService.CreateBundle(c.UserContext(), &req)
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        if errors.Is(err, repository.ErrBookingConflict) {
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+                "error":  err.Error(),
+                "bundle": localize(c, bundle),
+            })
+        }
+        if errors.Is(err, repository.ErrNoRoomAvailable) || errors.Is(err, repository.ErrQuotaExceeded) {
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+                "error": err.Error(),
+            })
+        }
+        if errors.Is(err, repository.ErrRoomNotFound) {
+            return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+                Error:  "validation failed",
+                Fields: map[string]string{"members": err.Error() + " (missing or archived)"},
+            })
+        }
+        if errors.Is(err, repository.ErrInsufficientUnits) {
+            return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+                Error:  "validation failed",
+                Fields: map[string]string{"members": err.Error()},
+            })
+        }
+        if errors.Is(err, repository.ErrOutsideOperatingHours) {
+            return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+                Error:  "validation failed",
+                Fields: map[string]string{"members": err.Error()},
+            })
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": err.Error(),
+        })
+    }
+    
+    c.Set(fiber.HeaderETag, entityTag(c, "bundle", bundle.ID, bundle.Version))
+    return c.Status(fiber.StatusCreated).JSON(localize(c, bundle))
+}
+
+func (h *BookingHandler) GetBundle(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "invalid bundle ID",
+        })
+    }
+    
+    bundle, err := h.bookingService.GetBundle(c.UserContext(), id)
+    if err != nil {
+        if errors.Is(err, repository.ErrBundleNotFound) {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+                "error": err.Error(),
+            })
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": err.Error(),
+        })
+    }
+    
+    return sendConditional(c, entityTag(c, "bundle", bundle.ID, bundle.Version), bundle.UpdatedAt, bundle)
+}
+
+func (h *BookingHandler) ApproveBundle(c *fiber.Ctx) error {
+    return h.transitionBundle(c, h.bookingService.ApproveBundle)
+}
+
+func (h *BookingHandler) RejectBundle(c *fiber.Ctx) error {
+    return h.transitionBundle(c, h.bookingService.RejectBundle)
+}
+
+// transitionBundle is transition for bundles: the whole bundle changes status
+func (h *BookingHandler) transitionBundle(c *fiber.Ctx, apply func(context.Context, int, int) (*models.Bundle, error)) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "invalid bundle ID",
+        })
+    }
+    
+    var req transitionRequest
+    if len(c.Body()) > 0 {
+        if err := c.BodyParser(&req); err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "error": "invalid request body",
+            })
+        }
+    }
+    
+    version, err := expectedVersion(c, "bundle", id, req.Version)
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        return c.Status(preconditionStatus(err)).JSON(fiber.Map{"error": err.Error()})
+    }
+    
+    bundle, err := apply(c.UserContext(), id, version)
+    if err != nil {
+        if status := preconditionStatus(err); status != 0 {
+            return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+        }
+        if errors.Is(err, repository.ErrBundleNotFound) {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+                "error": err.Error(),
+            })
+        }
+        if errors.Is(err, repository.ErrBundleNotPending) {
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+                "error": err.Error(),
+            })
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "error": err.Error(),
+        })
+    }
+    
+    c.Set(fiber.HeaderETag, entityTag(c, "bundle", bundle.ID, bundle.Version))
+    return c.JSON(localize(c, bundle))
+}
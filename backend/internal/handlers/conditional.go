@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+// errVersionRequired is answered with 428 so clients cannot skip the
+// optimistic concurrency check by accident
+var errVersionRequired = errors.New("precondition required: send If-Match with the resource ETag or a version field")
+
+// entityTag builds a strong validator from the resource identity and its row
+// version. The version is bumped by a trigger on every UPDATE, so the tag
+// changes whenever the representation may have. Times are rendered in the
+// request's zone, so outside UTC the zone is part of the tag as well
+// ("room-7-v3-Asia/Jakarta"): each zone's rendering is its own representation.
+func entityTag(c *fiber.Ctx, kind string, id, version int) string {
+    tag := fmt.Sprintf(`%s-%d-v%d`, kind, id, version)
+    if zone := requestLocation(c).String(); zone != "UTC" {
+        tag += "-" + zone
+    }
+    return `"` + tag + `"`
+}
+
+// sendConditional writes ETag and Last-Modified for a single resource and
+// answers 304 when the client's cached copy is still current. If-None-Match
+// takes precedence over If-Modified-Since (RFC 9110 section 13.2.2).
+func sendConditional(c *fiber.Ctx, etag string, updatedAt time.Time, body interface{}) error {
+    c.Set(fiber.HeaderETag, etag)
+    c.Set(fiber.HeaderLastModified, updatedAt.UTC().Format(http.TimeFormat))
+    
+    if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" {
+        if etagMatches(inm, etag) {
+            return c.SendStatus(fiber.StatusNotModified)
+        }
+    } else if ims := c.Get(fiber.HeaderIfModifiedSince); ims != "" {
+        // HTTP dates have second precision
+        if t, err := http.ParseTime(ims); err == nil && !updatedAt.Truncate(time.Second).After(t) {
+            return c.SendStatus(fiber.StatusNotModified)
+        }
+    }
+    
+    return c.JSON(localize(c, body))
+}
+
+// etagMatches applies the weak comparison used for If-None-Match
+func etagMatches(header, etag string) bool {
+    want := strings.TrimPrefix(etag, "W/")
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+            return true
+        }
+    }
+    return false
+}
+
+// expectedVersion resolves the version an update is conditioned on. If-Match
+// wins over a version field in the body; "If-Match: *" yields AnyVersion.
+// An If-Match that names no version of this resource can never match, so it
+// is reported as stale rather than malformed.
+func expectedVersion(c *fiber.Ctx, kind string, id int, bodyVersion *int) (int, error) {
+    header := c.Get(fiber.HeaderIfMatch)
+    if header == "" {
+        if bodyVersion == nil {
+            return 0, errVersionRequired
+        }
+        if *bodyVersion <= 0 {
+            verr := &services.ValidationError{}
+            verr.Add("version", "must be a positive integer")
+            return 0, verr
+        }
+        return *bodyVersion, nil
+    }
+    
+    prefix := fmt.Sprintf(`"%s-%d-v`, kind, id)
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" {
+            return repository.AnyVersion, nil
+        }
+        // If-Match uses strong comparison, so weak tags never match
+        if !strings.HasPrefix(candidate, prefix) || !strings.HasSuffix(candidate, `"`) {
+            continue
+        }
+        // The version is the same in every zone's tag
+        version := strings.TrimSuffix(strings.TrimPrefix(candidate, prefix), `"`)
+        if i := strings.IndexByte(version, '-'); i >= 0 {
+            version = version[:i]
+        }
+        if v, err := strconv.Atoi(version); err == nil && v > 0 {
+            return v, nil
+        }
+    }
+    return 0, repository.ErrStaleVersion
+}
+
+// preconditionStatus maps version check failures to their status code,
+// or returns 0 for any other error
+func preconditionStatus(err error) int {
+    switch {
+    case errors.Is(err, errVersionRequired):
+        return fiber.StatusPreconditionRequired
+    case errors.Is(err, repository.ErrStaleVersion):
+        return fiber.StatusPreconditionFailed
+    }
+    return 0
+}
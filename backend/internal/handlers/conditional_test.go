@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+func TestSendConditional(t *testing.T) {
+	updatedAt := time.Date(2026, 3, 1, 9, 0, 0, 500, time.UTC)
+	const etag = `"room-7-v3"`
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return sendConditional(c, entityTag(c, "room", 7, 3), updatedAt, fiber.Map{"id": 7})
+	})
+
+	cases := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"no validators", "", "", fiber.StatusOK},
+		{"matching etag", "If-None-Match", etag, fiber.StatusNotModified},
+		{"weak form of etag", "If-None-Match", "W/" + etag, fiber.StatusNotModified},
+		{"etag in list", "If-None-Match", `"other", ` + etag, fiber.StatusNotModified},
+		{"wildcard", "If-None-Match", "*", fiber.StatusNotModified},
+		{"stale etag", "If-None-Match", `"room-7-v2"`, fiber.StatusOK},
+		{"not modified since", "If-Modified-Since", updatedAt.Format(http.TimeFormat), fiber.StatusNotModified},
+		{"modified since", "If-Modified-Since", updatedAt.Add(-time.Minute).Format(http.TimeFormat), fiber.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, resp.StatusCode)
+			assert.Equal(t, etag, resp.Header.Get("ETag"))
+			assert.Equal(t, "Sun, 01 Mar 2026 09:00:00 GMT", resp.Header.Get("Last-Modified"))
+		})
+	}
+}
+
+func TestSendConditional_TagPerZone(t *testing.T) {
+	updatedAt := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	app := fiber.New()
+	app.Use(RequestTimezone(time.UTC))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return sendConditional(c, entityTag(c, "room", 7, 3), updatedAt, fiber.Map{"updated_at": updatedAt})
+	})
+
+	get := func(path, ifNoneMatch string) *http.Response {
+		req := httptest.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	utc := get("/", "").Header.Get("ETag")
+	assert.Equal(t, `"room-7-v3"`, utc)
+
+	// The UTC rendering is not current for a client asking for another zone
+	resp := get("/?tz=Asia/Jakarta", utc)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	jakarta := resp.Header.Get("ETag")
+	assert.Equal(t, `"room-7-v3-Asia/Jakarta"`, jakarta)
+
+	assert.Equal(t, fiber.StatusNotModified, get("/?tz=Asia/Jakarta", jakarta).StatusCode)
+	assert.Equal(t, fiber.StatusOK, get("/", jakarta).StatusCode)
+}
+
+func TestExpectedVersion(t *testing.T) {
+	four := 4
+	cases := []struct {
+		name    string
+		ifMatch string
+		body    *int
+		want    int
+		wantErr error
+	}{
+		{"if-match", `"room-7-v3"`, nil, 3, nil},
+		{"if-match wins over body", `"room-7-v3"`, &four, 3, nil},
+		{"if-match list", `"room-8-v1", "room-7-v5"`, nil, 5, nil},
+		{"tag of another zone", `"room-7-v3-Asia/Jakarta"`, nil, 3, nil},
+		{"wildcard", "*", nil, repository.AnyVersion, nil},
+		{"body version", "", &four, 4, nil},
+		{"missing", "", nil, 0, errVersionRequired},
+		{"weak tag never matches", `W/"room-7-v3"`, nil, 0, repository.ErrStaleVersion},
+		{"other resource", `"booking-7-v3"`, nil, 0, repository.ErrStaleVersion},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got int
+			var gotErr error
+			app := fiber.New()
+			app.Put("/", func(c *fiber.Ctx) error {
+				got, gotErr = expectedVersion(c, "room", 7, tc.body)
+				return nil
+			})
+			req := httptest.NewRequest("PUT", "/", nil)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			_, err := app.Test(req)
+			require.NoError(t, err)
+
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, gotErr, tc.wantErr)
+				return
+			}
+			require.NoError(t, gotErr)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+// errorResponse is the envelope of every error reply. Fields is only set when
+// validation failed and maps JSON field names to what is wrong with them.
+// Code names a refusal clients may act on and stays stable, unlike Error.
+// Booking is the booking a refused request was still stored as.
+type errorResponse struct {
+    Error   string            `json:"error"`
+    Code    string            `json:"code,omitempty"`
+    Fields  map[string]string `json:"fields,omitempty"`
+    Booking interface{}       `json:"booking,omitempty"`
+}
+
+// errorCodes are the stable codes of the allocation refusals
+var errorCodes = []struct {
+    err  error
+    code string
+}{
+    {repository.ErrBookingConflict, "booking_conflict"},
+    {repository.ErrApprovalConflict, "approval_conflict"},
+    {repository.ErrBookingNotPending, "booking_not_pending"},
+    {repository.ErrQuotaExceeded, "quota_exceeded"},
+    {repository.ErrNoRoomAvailable, "no_room_available"},
+    {repository.ErrInsufficientUnits, "insufficient_units"},
+    {repository.ErrBookingInBundle, "booking_in_bundle"},
+}
+
+// refused answers status with err's message and stable code
+func refused(c *fiber.Ctx, status int, err error) error {
+    resp := errorResponse{Error: err.Error()}
+    for _, e := range errorCodes {
+        if errors.Is(err, e.err) {
+            resp.Code = e.code
+            break
+        }
+    }
+    return c.Status(status).JSON(resp)
+}
+
+// validationFailed answers 400 with per-field errors if err is a
+// *services.ValidationError; ok is false for any other error
+func validationFailed(c *fiber.Ctx, err error) (bool, error) {
+    var verr *services.ValidationError
+    if !errors.As(err, &verr) {
+        return false, nil
+    }
+    return true, c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+        Error:  "validation failed",
+        Fields: verr.Fields,
+    })
+}
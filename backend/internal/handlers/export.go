@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/export"
+)
+
+// streamTable sends a CSV/XLSX download whose rows are produced by walk while the
+// response is being written. Headers are committed before the first row, so a
+// failure mid-stream can only be logged and truncates the file. Times are
+// written in the request's zone.
+func streamTable(c *fiber.Ctx, format export.Format, name string, columns []string, walk func(ctx context.Context, emit func(values ...any) error) error) error {
+    ctx := c.UserContext()
+    loc := requestLocation(c)
+    export.Attach(c, format, name)
+    
+    c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+        tw, err := export.NewTableWriter(format, w, name)
+        if err != nil {
+            log.Printf("export %s: %v", name, err)
+            return
+        }
+        
+        err = tw.WriteHeader(columns)
+        if err == nil {
+            err = walk(ctx, func(values ...any) error {
+                localizeRow(values, loc)
+                return tw.WriteRow(values)
+            })
+        }
+        if closeErr := tw.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            log.Printf("export %s aborted: %v", name, err)
+        }
+    })
+    return nil
+}
+
+// streamCalendar sends an iCalendar feed produced by walk
+func streamCalendar(c *fiber.Ctx, calendarName string, walk func(ctx context.Context, cw *export.CalendarWriter) error) error {
+    ctx := c.UserContext()
+    host := c.Hostname()
+    c.Set(fiber.HeaderContentType, export.FormatICS.ContentType())
+    
+    c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+        cw := export.NewCalendarWriter(w, calendarName, host)
+        err := walk(ctx, cw)
+        if closeErr := cw.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            log.Printf("calendar feed %q aborted: %v", calendarName, err)
+        }
+    })
+    return nil
+}
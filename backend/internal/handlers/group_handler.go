@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+type GroupHandler struct {
+    groupService *services.GroupService
+}
+
+func NewGroupHandler(groupService *services.GroupService) *GroupHandler {
+    return &GroupHandler{groupService: groupService}
+}
+
+type CreateGroupRequest struct {
+    Name     string        `json:"name"`
+    Kind     string        `json:"kind"`
+    ParentID *int          `json:"parent_id,omitempty"`
+    Policy   models.Policy `json:"policy"`
+}
+
+func (h *GroupHandler) CreateGroup(c *fiber.Ctx) error {
+    var req CreateGroupRequest
+    if err := c.BodyParser(&req); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+    }
+    
+    group, err := h.groupService.CreateGroup(c.UserContext(), &models.ResourceGroup{
+        Name:     req.Name,
+        Kind:     req.Kind,
+        ParentID: req.ParentID,
+        Policy:   req.Policy,
+    })
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    
+    c.Set(fiber.HeaderETag, entityTag(c, "group", group.ID, group.Version))
+    return c.Status(fiber.StatusCreated).JSON(localize(c, group))
+}
+
+// GetGroups lists resource groups with their rolled-up capacity; ?kind= keeps
+// one level of the hierarchy
+func (h *GroupHandler) GetGroups(c *fiber.Ctx) error {
+    groups, err := h.groupService.ListGroups(c.UserContext(), c.Query("kind"))
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    return c.JSON(localize(c, groups))
+}
+
+func (h *GroupHandler) GetGroup(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid group ID"})
+    }
+    
+    group, err := h.groupService.GetGroup(c.UserContext(), id)
+    if err != nil {
+        if errors.Is(err, repository.ErrGroupNotFound) {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    
+    return sendConditional(c, entityTag(c, "group", group.ID, group.Version), group.UpdatedAt, group)
+}
+
+// PatchGroup applies a JSON Merge Patch to name, parent_id and policy. A null
+// parent_id makes the group a root; null policy members, or a null policy,
+// revert to what the parent sets. The kind of a group cannot be changed.
+func (h *GroupHandler) PatchGroup(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid group ID"})
+    }
+    
+    switch mediaType(c) {
+    case fiber.MIMEApplicationJSON, "application/merge-patch+json":
+    default:
+        return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+            "error": "use Content-Type application/merge-patch+json",
+        })
+    }
+    
+    patch, bodyVersion, err := decodeGroupPatch(c.Body())
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        return badRequest(c, err)
+    }
+    
+    version, err := expectedVersion(c, "group", id, bodyVersion)
+    if err != nil {
+        return h.updateFailed(c, err)
+    }
+    
+    group, err := h.groupService.PatchGroup(c.UserContext(), id, version, *patch)
+    if err != nil {
+        return h.updateFailed(c, err)
+    }
+    
+    c.Set(fiber.HeaderETag, entityTag(c, "group", group.ID, group.Version))
+    return c.JSON(localize(c, group))
+}
+
+func (h *GroupHandler) updateFailed(c *fiber.Ctx, err error) error {
+    if ok, resp := validationFailed(c, err); ok {
+        return resp
+    }
+    if status := preconditionStatus(err); status != 0 {
+        return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+    }
+    if errors.Is(err, repository.ErrGroupNotFound) {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+    }
+    return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
+// DeleteGroup removes an empty group; 409 while it has child groups or active rooms
+func (h *GroupHandler) DeleteGroup(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid group ID"})
+    }
+    
+    if err := h.groupService.DeleteGroup(c.UserContext(), id); err != nil {
+        switch {
+        case errors.Is(err, repository.ErrGroupNotFound):
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+        case errors.Is(err, repository.ErrGroupNotEmpty):
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    return c.SendStatus(fiber.StatusNoContent)
+}
+
+// groupPatchMembers are the members a group merge patch may carry
+var groupPatchMembers = map[string]bool{
+    "name": true, "kind": true, "parent_id": true, "policy": true, "version": true,
+}
+
+// decodeGroupPatch parses a group merge patch document, reporting problems per
+// field; members of the policy are reported as policy.<member>
+func decodeGroupPatch(body []byte) (*models.GroupPatch, *int, error) {
+    var doc map[string]json.RawMessage
+    if err := json.Unmarshal(body, &doc); err != nil || doc == nil {
+        return nil, nil, errors.New("merge patch must be a JSON object")
+    }
+    
+    verr := &services.ValidationError{}
+    for member := range doc {
+        if !groupPatchMembers[member] {
+            verr.Add(member, "is not a group field")
+        }
+    }
+    if _, ok := doc["kind"]; ok {
+        verr.Add("kind", "cannot be changed")
+    }
+    
+    var patch models.GroupPatch
+    var version *int
+    decode := func(member string, raw json.RawMessage, dst interface{}, message string) {
+        if err := json.Unmarshal(raw, dst); err != nil {
+            verr.Add(member, message)
+        }
+    }
+    if raw, ok := doc["name"]; ok {
+        if string(raw) == "null" {
+            verr.Add("name", "cannot be removed")
+        } else {
+            decode("name", raw, &patch.Name, "must be a string")
+        }
+    }
+    if raw, ok := doc["parent_id"]; ok {
+        patch.ClearParent = string(raw) == "null"
+        decode("parent_id", raw, &patch.ParentID, "must be an integer")
+    }
+    if raw, ok := doc["version"]; ok {
+        decode("version", raw, &version, "must be an integer")
+    }
+    
+    // policy is itself merged: null reverts all of it to the parent's
+    if raw, ok := doc["policy"]; ok {
+        var policy map[string]json.RawMessage
+        if string(raw) == "null" {
+            patch.InheritHours, patch.InheritApproval, patch.InheritQuota = true, true, true
+        } else if err := json.Unmarshal(raw, &policy); err != nil {
+            verr.Add("policy", "must be an object")
+        }
+        for member, raw := range policy {
+            field := "policy." + member
+            null := string(raw) == "null"
+            switch member {
+            case "opens_at":
+                decode(field, raw, &patch.OpensAt, "must be a string")
+                patch.InheritHours = patch.InheritHours || null
+            case "closes_at":
+                decode(field, raw, &patch.ClosesAt, "must be a string")
+                patch.InheritHours = patch.InheritHours || null
+            case "requires_approval":
+                decode(field, raw, &patch.RequiresApproval, "must be a boolean")
+                patch.InheritApproval = null
+            case "daily_quota_hours":
+                decode(field, raw, &patch.DailyQuotaHours, "must be a number")
+                patch.InheritQuota = null
+            default:
+                verr.Add(field, "is not a policy field")
+            }
+        }
+        if patch.InheritHours && (patch.OpensAt != nil || patch.ClosesAt != nil) {
+            verr.Add("policy.opens_at", "opens_at and closes_at must both be null to inherit operating hours")
+        }
+    }
+    
+    if err := verr.Err(); err != nil {
+        return nil, nil, err
+    }
+    return &patch, version, nil
+}
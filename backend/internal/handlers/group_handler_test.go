@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+func TestDecodeGroupPatch(t *testing.T) {
+	patch, version, err := decodeGroupPatch([]byte(`{"parent_id":null,"policy":{"requires_approval":true,"daily_quota_hours":null},"version":2}`))
+	require.NoError(t, err)
+	assert.True(t, patch.ClearParent)
+	assert.True(t, *patch.RequiresApproval)
+	assert.False(t, patch.InheritApproval)
+	assert.True(t, patch.InheritQuota)
+	assert.False(t, patch.InheritHours)
+	assert.Equal(t, 2, *version)
+
+	patch, _, err = decodeGroupPatch([]byte(`{"policy":null}`))
+	require.NoError(t, err)
+	assert.True(t, patch.InheritHours && patch.InheritApproval && patch.InheritQuota)
+
+	_, _, err = decodeGroupPatch([]byte(`{"kind":"site","name":null,"policy":{"quota":1,"requires_approval":"yes"}}`))
+	var verr *services.ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, map[string]string{
+		"kind":                     "cannot be changed",
+		"name":                     "cannot be removed",
+		"policy.quota":             "is not a policy field",
+		"policy.requires_approval": "must be a boolean",
+	}, verr.Fields)
+}
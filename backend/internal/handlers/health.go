@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readinessTimeout bounds each readiness check so a hung dependency fails the
+// probe instead of stalling it
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck reports why a dependency cannot serve traffic, nil if it can
+type ReadinessCheck func(ctx context.Context) error
+
+// HealthHandler answers the liveness and readiness probes of orchestrators
+type HealthHandler struct {
+    checks map[string]ReadinessCheck
+}
+
+func NewHealthHandler(checks map[string]ReadinessCheck) *HealthHandler {
+    return &HealthHandler{checks: checks}
+}
+
+// Live answers 200 while the process can serve requests at all
+func (h *HealthHandler) Live(c *fiber.Ctx) error {
+    return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// Ready runs every check and answers 200 if all pass, 503 naming the failures otherwise
+func (h *HealthHandler) Ready(c *fiber.Ctx) error {
+    names := make([]string, 0, len(h.checks))
+    for name := range h.checks {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    
+    results := make(map[string]string, len(names))
+    ready := true
+    for _, name := range names {
+        ctx, cancel := context.WithTimeout(c.UserContext(), readinessTimeout)
+        err := h.checks[name](ctx)
+        cancel()
+        if err != nil {
+            results[name] = err.Error()
+            ready = false
+        } else {
+            results[name] = "ok"
+        }
+    }
+    
+    if !ready {
+        return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "unavailable", "checks": results})
+    }
+    return c.JSON(fiber.Map{"status": "ok", "checks": results})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	var dbErr error
+	h := NewHealthHandler(map[string]ReadinessCheck{
+		"database":   func(ctx context.Context) error { return dbErr },
+		"migrations": func(ctx context.Context) error { return nil },
+	})
+	app := fiber.New()
+	app.Get("/healthz", h.Live)
+	app.Get("/readyz", h.Ready)
+
+	get := func(target string) (int, string) {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil))
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("/readyz")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.JSONEq(t, `{"status":"ok","checks":{"database":"ok","migrations":"ok"}}`, body)
+
+	dbErr = errors.New("connection refused")
+	status, body = get("/readyz")
+	assert.Equal(t, fiber.StatusServiceUnavailable, status)
+	assert.JSONEq(t, `{"status":"unavailable","checks":{"database":"connection refused","migrations":"ok"}}`, body)
+
+	// Liveness does not depend on the database
+	status, _ = get("/healthz")
+	assert.Equal(t, fiber.StatusOK, status)
+}
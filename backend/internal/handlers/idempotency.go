@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+const (
+    headerIdempotencyKey     = "Idempotency-Key"
+    headerIdempotentReplayed = "Idempotent-Replayed"
+    maxIdempotencyKeyLength  = 255
+)
+
+// Idempotency makes a route safe to retry. Requests carrying an Idempotency-Key
+// are fingerprinted (method, URL and body, or the uploaded file); the first one
+// is processed and its response stored, replays get that response back with
+// Idempotent-Replayed: true, and reusing the key for a different request is
+// rejected with 422.
+// Server errors are not stored, so the client can retry them with the same key.
+// Register it on individual routes: the scope of a key is the route template.
+func Idempotency(svc *services.IdempotencyService) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        key := c.Get(headerIdempotencyKey)
+        if key == "" {
+            return c.Next()
+        }
+        if len(key) > maxIdempotencyKeyLength {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Idempotency-Key must be at most 255 characters"})
+        }
+
+        ctx := c.UserContext()
+        scope := c.Method() + " " + c.Route().Path
+        fingerprint := requestFingerprint(c)
+
+        stored, err := svc.Begin(ctx, scope, key, fingerprint)
+        switch {
+        case errors.Is(err, services.ErrIdempotencyKeyReused):
+            return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+        case errors.Is(err, services.ErrIdempotencyInProgress):
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+        case err != nil:
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+        case stored != nil:
+            c.Set(headerIdempotentReplayed, "true")
+            c.Set(fiber.HeaderContentType, stored.ContentType)
+            return c.Status(stored.StatusCode).Send(stored.Body)
+        }
+
+        if err := c.Next(); err != nil {
+            abortIdempotency(c, svc, scope, key, fingerprint)
+            return err
+        }
+
+        status := c.Response().StatusCode()
+        if status >= fiber.StatusInternalServerError {
+            abortIdempotency(c, svc, scope, key, fingerprint)
+            return nil
+        }
+
+        rec := &models.IdempotencyRecord{
+            Fingerprint: fingerprint,
+            StatusCode:  status,
+            ContentType: string(c.Response().Header.ContentType()),
+            Body:        append([]byte(nil), c.Response().Body()...),
+        }
+        if err := svc.Complete(ctx, scope, key, rec); err != nil {
+            log.Printf("Failed to store response for idempotency key %q: %v", key, err)
+        }
+        return nil
+    }
+}
+
+func abortIdempotency(c *fiber.Ctx, svc *services.IdempotencyService, scope, key, fingerprint string) {
+    if err := svc.Abort(c.UserContext(), scope, key, fingerprint); err != nil {
+        log.Printf("Failed to release idempotency key %q: %v", key, err)
+    }
+}
+
+// requestFingerprint identifies the request a key was first used with. A
+// multipart body is reduced to its uploaded file: clients draw a new boundary
+// on every retry, so the raw bytes of an honest retry differ.
+func requestFingerprint(c *fiber.Ctx) string {
+    h := sha256.New()
+    h.Write([]byte(c.Method()))
+    h.Write([]byte{0})
+    h.Write(c.Request().URI().RequestURI())
+    h.Write([]byte{0})
+    if !writeUpload(h, c) {
+        h.Write(c.Body())
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeUpload writes the content of the multipart "file" field to w and
+// reports whether the request carried one
+func writeUpload(w io.Writer, c *fiber.Ctx) bool {
+    if !strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEMultipartForm) {
+        return false
+    }
+    file, err := c.FormFile("file")
+    if err != nil {
+        return false
+    }
+    f, err := file.Open()
+    if err != nil {
+        return false
+    }
+    defer f.Close()
+    _, err = io.Copy(w, f)
+    return err == nil
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/memory"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+func TestIdempotency(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	svc := services.NewIdempotencyService(repository.NewIdempotencyRepository(&repository.Database{DB: db}), 0)
+
+	calls := 0
+	app := fiber.New()
+	app.Post("/api/bookings", Idempotency(svc), func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": calls})
+	})
+
+	const body = `{"room_id":1}`
+	sum := sha256.Sum256([]byte("POST\x00/api/bookings\x00" + body))
+	fingerprint := hex.EncodeToString(sum[:])
+	reserve := regexp.QuoteMeta(`INSERT INTO idempotency_keys`)
+	lookup := regexp.QuoteMeta(`SELECT fingerprint`)
+	storedRow := func(fp string, status int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"fingerprint", "status_code", "content_type", "response"}).
+			AddRow(fp, status, "application/json", []byte(`{"id":1}`))
+	}
+
+	send := func(body string) (int, string, string) {
+		req := httptest.NewRequest("POST", "/api/bookings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "retry-1")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(out), resp.Header.Get("Idempotent-Replayed")
+	}
+
+	// First request is processed and its response stored
+	mock.ExpectQuery(reserve).
+		WithArgs("POST /api/bookings", "retry-1", fingerprint, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys`)).
+		WithArgs("POST /api/bookings", "retry-1", fingerprint, fiber.StatusCreated, "application/json", []byte(`{"id":1}`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	status, out, replayed := send(body)
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.Equal(t, `{"id":1}`, out)
+	assert.Empty(t, replayed)
+
+	// A retry replays the stored response without reaching the handler
+	mock.ExpectQuery(reserve).WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+	mock.ExpectQuery(lookup).WillReturnRows(storedRow(fingerprint, fiber.StatusCreated))
+	status, out, replayed = send(body)
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.Equal(t, `{"id":1}`, out)
+	assert.Equal(t, "true", replayed)
+
+	// The same key with a different body is rejected
+	mock.ExpectQuery(reserve).WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+	mock.ExpectQuery(lookup).WillReturnRows(storedRow(fingerprint, fiber.StatusCreated))
+	status, _, _ = send(`{"room_id":2}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+
+	// A retry racing the original request is told to wait
+	mock.ExpectQuery(reserve).WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+	mock.ExpectQuery(lookup).WillReturnRows(storedRow(fingerprint, 0))
+	status, _, _ = send(body)
+	assert.Equal(t, fiber.StatusConflict, status)
+
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIdempotency_MultipartRetryWithNewBoundary(t *testing.T) {
+	svc := services.NewIdempotencyService(memory.NewIdempotencyRepository(memory.NewDatabase()), 0)
+
+	calls := 0
+	app := fiber.New()
+	app.Post("/api/import/ics", Idempotency(svc), func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"run": calls})
+	})
+
+	// Each upload gets a fresh random boundary, as clients do on every retry
+	upload := func(content, query string) (int, string) {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		part, err := w.CreateFormFile("file", "cal.ics")
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		require.NoError(t, w.Close())
+
+		req := httptest.NewRequest("POST", "/api/import/ics?room_id=1"+query, &buf)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		req.Header.Set("Idempotency-Key", "import-1")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode, resp.Header.Get("Idempotent-Replayed")
+	}
+
+	status, replayed := upload("BEGIN:VCALENDAR", "")
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.Empty(t, replayed)
+
+	status, replayed = upload("BEGIN:VCALENDAR", "")
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.Equal(t, "true", replayed)
+
+	// Another file, or other query parameters, is another request
+	status, _ = upload("BEGIN:VCALENDAR\r\nX", "")
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+	status, _ = upload("BEGIN:VCALENDAR", "&dry_run=true")
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+type ImportHandler struct {
+    bookingService *services.BookingService
+}
+
+func NewImportHandler(bookingService *services.BookingService) *ImportHandler {
+    return &ImportHandler{bookingService: bookingService}
+}
+
+// ImportICS accepts a calendar either as the raw request body (text/calendar)
+// or as a multipart upload in the "file" field.
+//
+// Query: room_id (required), user_id (default 1, the seeded admin),
+// dry_run=true to only report conflicts, until=RFC 3339 expansion horizon
+// for open-ended recurrences (default one year ahead). Times without a zone
+// in the calendar are read in the request's zone.
+func (h *ImportHandler) ImportICS(c *fiber.Ctx) error {
+    roomID, err := strconv.Atoi(c.Query("room_id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room ID"})
+    }
+    
+    req := models.ImportRequest{
+        RoomID:   roomID,
+        UserID:   c.QueryInt("user_id", 1),
+        DryRun:   c.QueryBool("dry_run", false),
+        Horizon:  time.Now().AddDate(1, 0, 0),
+        Location: requestLocation(c),
+    }
+    if v := c.Query("until"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid until: expected RFC 3339 timestamp"})
+        }
+        req.Horizon = t
+    }
+    
+    var body io.Reader = bytes.NewReader(c.Body())
+    if file, err := c.FormFile("file"); err == nil {
+        f, err := file.Open()
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unreadable upload"})
+        }
+        defer f.Close()
+        body = f
+    }
+    
+    report, err := h.bookingService.ImportCalendar(c.UserContext(), req, body)
+    if err != nil {
+        if errors.Is(err, services.ErrInvalidCalendar) {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    
+    status := fiber.StatusOK
+    if !req.DryRun && report.Approved+report.Pending+report.Conflicts > 0 {
+        status = fiber.StatusCreated
+    }
+    return c.Status(status).JSON(localize(c, report))
+}
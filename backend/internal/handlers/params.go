@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// queryInt parses an optional integer query parameter; absent means 0
+func queryInt(c *fiber.Ctx, key string) (int, error) {
+    v := c.Query(key)
+    if v == "" {
+        return 0, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s: expected an integer", key)
+    }
+    return n, nil
+}
+
+// queryIntPtr parses an optional integer query parameter; absent means nil
+func queryIntPtr(c *fiber.Ctx, key string) (*int, error) {
+    if c.Query(key) == "" {
+        return nil, nil
+    }
+    n, err := queryInt(c, key)
+    if err != nil {
+        return nil, err
+    }
+    return &n, nil
+}
+
+// queryTime parses an optional RFC 3339 query parameter; absent means nil
+func queryTime(c *fiber.Ctx, key string) (*time.Time, error) {
+    v := c.Query(key)
+    if v == "" {
+        return nil, nil
+    }
+    t, err := time.Parse(time.RFC3339, v)
+    if err != nil {
+        return nil, fmt.Errorf("invalid %s: expected RFC 3339 timestamp", key)
+    }
+    return &t, nil
+}
+
+func badRequest(c *fiber.Ctx, err error) error {
+    return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+}
+
+// mediaType returns the request Content-Type without parameters
+func mediaType(c *fiber.Ctx) string {
+    ct := strings.ToLower(c.Get(fiber.HeaderContentType))
+    if i := strings.IndexByte(ct, ';'); i >= 0 {
+        ct = ct[:i]
+    }
+    return strings.TrimSpace(ct)
+}
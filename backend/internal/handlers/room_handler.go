@@ -1,10 +1,13 @@
 package handlers
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
 	"github.com/indraprhmbd/allocra/internal/services"
 )
 
@@ -19,8 +22,14 @@ func NewRoomHandler(roomService *services.RoomService) *RoomHandler {
 type CreateRoomRequest struct {
     Name     string `json:"name"`
     Capacity int    `json:"capacity"`
+    Quantity int    `json:"quantity,omitempty"` // units of a pooled room
     Type     string `json:"type"`
     Status   string `json:"status"`
+    Timezone string `json:"timezone,omitempty"` // IANA zone of the operating hours, UTC when omitted
+    Version  *int   `json:"version,omitempty"` // required on update unless If-Match is sent
+    
+    Labels  map[string]string `json:"labels,omitempty"`
+    GroupID *int              `json:"group_id,omitempty"`
 }
 
 func (h *RoomHandler) CreateRoom(c *fiber.Ctx) error {
@@ -31,26 +40,101 @@ func (h *RoomHandler) CreateRoom(c *fiber.Ctx) error {
         })
     }
     
-    room, err := h.roomService.CreateRoom(c.Context(), req.Name, req.Capacity, req.Type, req.Status)
+    room, err := h.roomService.CreateRoom(c.UserContext(), req.Name, req.Capacity, req.Quantity, req.Type, req.Status, req.Timezone, req.Labels, req.GroupID)
     if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
     
-    return c.Status(fiber.StatusCreated).JSON(room)
+    return c.Status(fiber.StatusCreated).JSON(localize(c, room))
 }
 
+// GetRooms lists active rooms; ?selector= narrows them by label
+// (e.g. zone=a,gpu!=true,tier in (gold,silver),!deprecated) and ?group_id=
+// to the rooms anywhere below a resource group
 func (h *RoomHandler) GetRooms(c *fiber.Ctx) error {
-    rooms, err := h.roomService.GetAllRooms(c.Context())
+    groupID, err := queryIntPtr(c, "group_id")
+    if err != nil {
+        return badRequest(c, err)
+    }
+    
+    rooms, err := h.roomService.GetAllRooms(c.UserContext(), c.Query("selector"), groupID)
     if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": err.Error(),
         })
     }
-    return c.JSON(rooms)
+    return c.JSON(localize(c, rooms))
+}
+
+// FindAvailableRooms serves GET /api/rooms/available: online rooms keeping
+// quantity units (default 1) free throughout start..end (RFC 3339), smallest
+// capacity first, with their free_units. Optional: quantity, min_capacity,
+// type, group_id and a label selector.
+func (h *RoomHandler) FindAvailableRooms(c *fiber.Ctx) error {
+    q := models.AvailabilityQuery{Type: c.Query("type")}
+    
+    var err error
+    if q.MinCapacity, err = queryInt(c, "min_capacity"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.Quantity, err = queryInt(c, "quantity"); err != nil {
+        return badRequest(c, err)
+    }
+    if q.GroupID, err = queryIntPtr(c, "group_id"); err != nil {
+        return badRequest(c, err)
+    }
+    start, err := queryTime(c, "start")
+    if err != nil {
+        return badRequest(c, err)
+    }
+    end, err := queryTime(c, "end")
+    if err != nil {
+        return badRequest(c, err)
+    }
+    if start != nil {
+        q.Start = *start
+    }
+    if end != nil {
+        q.End = *end
+    }
+    
+    rooms, err := h.roomService.FindAvailableRooms(c.UserContext(), q, c.Query("selector"))
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    return c.JSON(localize(c, rooms))
 }
 
+func (h *RoomHandler) GetRoom(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room ID"})
+    }
+    
+    room, err := h.roomService.GetRoom(c.UserContext(), id)
+    if err != nil {
+        if errors.Is(err, repository.ErrRoomNotFound) {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    
+    return sendConditional(c, entityTag(c, "room", room.ID, room.Version), room.UpdatedAt, room)
+}
+
+// UpdateRoom replaces name, capacity, type and status (all required) and
+// quantity (1 when omitted); the time zone is kept when omitted
 func (h *RoomHandler) UpdateRoom(c *fiber.Ctx) error {
     id, err := strconv.Atoi(c.Params("id"))
     if err != nil {
@@ -62,26 +146,176 @@ func (h *RoomHandler) UpdateRoom(c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
     }
     
-    if req.Name == "" || req.Capacity <= 0 {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name and capacity (>0) are required"})
+    version, err := expectedVersion(c, "room", id, req.Version)
+    if err != nil {
+        return h.updateFailed(c, err)
     }
     
-    err = h.roomService.UpdateRoom(c.Context(), id, req.Name, req.Capacity, req.Type, req.Status)
+    room, err := h.roomService.UpdateRoom(c.UserContext(), id, version, req.Name, req.Capacity, req.Quantity, req.Type, req.Status, req.Timezone, req.Labels, req.GroupID)
     if err != nil {
-        if err.Error() == fmt.Sprintf("room not found with id: %d", id) {
-             return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+        return h.updateFailed(c, err)
+    }
+    
+    c.Set(fiber.HeaderETag, entityTag(c, "room", room.ID, room.Version))
+    return c.Status(fiber.StatusOK).JSON(localize(c, room))
+}
+
+// PatchRoom applies a JSON Merge Patch (RFC 7396). Members that are absent are
+// left unchanged. Null removes labels, the group, or the room's own operating
+// hours (it then inherits its group's); other fields are required, so null is
+// rejected. A "version" member is the precondition, like on PUT, not a patched field.
+func (h *RoomHandler) PatchRoom(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room ID"})
+    }
+    
+    switch mediaType(c) {
+    case fiber.MIMEApplicationJSON, "application/merge-patch+json":
+    default:
+        return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+            "error": "use Content-Type application/merge-patch+json",
+        })
+    }
+    
+    patch, bodyVersion, err := decodeRoomPatch(c.Body())
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
         }
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+        return badRequest(c, err)
+    }
+    
+    version, err := expectedVersion(c, "room", id, bodyVersion)
+    if err != nil {
+        return h.updateFailed(c, err)
     }
     
-    return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "room updated successfully"})
+    room, err := h.roomService.PatchRoom(c.UserContext(), id, version, *patch)
+    if err != nil {
+        return h.updateFailed(c, err)
+    }
+    
+    c.Set(fiber.HeaderETag, entityTag(c, "room", room.ID, room.Version))
+    return c.JSON(localize(c, room))
 }
 
+func (h *RoomHandler) updateFailed(c *fiber.Ctx, err error) error {
+    if ok, resp := validationFailed(c, err); ok {
+        return resp
+    }
+    if status := preconditionStatus(err); status != 0 {
+        return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+    }
+    if errors.Is(err, repository.ErrRoomNotFound) {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+    }
+    return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
+// DeleteRoom archives the room; its bookings stay for history and reports.
+// Query: strategy=reject|reassign for approved bookings that have not ended.
 func (h *RoomHandler) DeleteRoom(c *fiber.Ctx) error {
-    id, _ := strconv.Atoi(c.Params("id"))
-    err := h.roomService.DeleteRoom(c.Context(), id)
+    id, err := strconv.Atoi(c.Params("id"))
     if err != nil {
-        return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room ID"})
+    }
+    
+    result, err := h.roomService.ArchiveRoom(c.UserContext(), id, c.Query("strategy"))
+    if err != nil {
+        if ok, resp := validationFailed(c, err); ok {
+            return resp
+        }
+        switch {
+        case errors.Is(err, repository.ErrRoomNotFound):
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+        case errors.Is(err, repository.ErrRoomHasUpcomingBookings), errors.Is(err, repository.ErrNoReassignmentTarget):
+            return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+        }
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+    }
+    return c.JSON(localize(c, result))
+}
+
+// roomPatchMembers are the members a room merge patch may carry
+var roomPatchMembers = map[string]bool{
+    "name": true, "capacity": true, "quantity": true, "type": true, "status": true,
+    "timezone": true, "opens_at": true, "closes_at": true, "labels": true, "group_id": true, "version": true,
+}
+
+// roomPatchNullable are the members for which null means remove
+var roomPatchNullable = map[string]bool{
+    "opens_at": true, "closes_at": true, "labels": true, "group_id": true,
+}
+
+// decodeRoomPatch parses a merge patch document, reporting unknown members,
+// nulls and wrongly typed values per field
+func decodeRoomPatch(body []byte) (*models.RoomPatch, *int, error) {
+    var doc map[string]json.RawMessage
+    if err := json.Unmarshal(body, &doc); err != nil || doc == nil {
+        return nil, nil, errors.New("merge patch must be a JSON object")
+    }
+    
+    verr := &services.ValidationError{}
+    for member, raw := range doc {
+        if !roomPatchMembers[member] {
+            verr.Add(member, "is not a room field")
+        } else if string(raw) == "null" && !roomPatchNullable[member] {
+            verr.Add(member, "cannot be removed")
+        }
+    }
+    
+    var patch models.RoomPatch
+    var version *int
+    decodeString := func(member string, dst **string) {
+        if raw, ok := doc[member]; ok && verr.Fields[member] == "" {
+            if err := json.Unmarshal(raw, dst); err != nil {
+                verr.Add(member, "must be a string")
+            }
+        }
+    }
+    decodeInt := func(member string, dst **int) {
+        if raw, ok := doc[member]; ok && verr.Fields[member] == "" {
+            if err := json.Unmarshal(raw, dst); err != nil {
+                verr.Add(member, "must be an integer")
+            }
+        }
+    }
+    decodeString("name", &patch.Name)
+    decodeInt("capacity", &patch.Capacity)
+    decodeInt("quantity", &patch.Quantity)
+    decodeString("type", &patch.Type)
+    decodeString("status", &patch.Status)
+    decodeString("timezone", &patch.Timezone)
+    decodeString("opens_at", &patch.OpensAt)
+    decodeString("closes_at", &patch.ClosesAt)
+    decodeInt("group_id", &patch.GroupID)
+    decodeInt("version", &version)
+    
+    // Operating hours are inherited as a pair, so one null bound needs the other
+    opens, closes := string(doc["opens_at"]), string(doc["closes_at"])
+    if opens == "null" || closes == "null" {
+        patch.InheritHours = true
+        if patch.OpensAt != nil {
+            verr.Add("opens_at", "must be null too to inherit operating hours")
+        }
+        if patch.ClosesAt != nil {
+            verr.Add("closes_at", "must be null too to inherit operating hours")
+        }
+    }
+    patch.ClearGroup = string(doc["group_id"]) == "null"
+    
+    // labels is itself merged: null clears it, null members remove single labels
+    if raw, ok := doc["labels"]; ok {
+        if string(raw) == "null" {
+            patch.ClearLabels = true
+        } else if err := json.Unmarshal(raw, &patch.Labels); err != nil {
+            verr.Add("labels", "must be an object of string values")
+        }
+    }
+    
+    if err := verr.Err(); err != nil {
+        return nil, nil, err
     }
-    return c.SendStatus(200)
+    return &patch, version, nil
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+func TestDecodeRoomPatch(t *testing.T) {
+	patch, version, err := decodeRoomPatch([]byte(`{"status":"maintenance","closes_at":"18:00","version":3}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch.Name)
+	assert.Nil(t, patch.Capacity)
+	assert.Equal(t, "maintenance", *patch.Status)
+	assert.Equal(t, "18:00", *patch.ClosesAt)
+	assert.Equal(t, 3, *version)
+
+	_, _, err = decodeRoomPatch([]byte(`{"name":null,"capacity":"ten","colour":"red","type":1}`))
+	var verr *services.ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, map[string]string{
+		"name":     "cannot be removed",
+		"capacity": "must be an integer",
+		"colour":   "is not a room field",
+		"type":     "must be a string",
+	}, verr.Fields)
+
+	_, _, err = decodeRoomPatch([]byte(`[1]`))
+	assert.EqualError(t, err, "merge patch must be a JSON object")
+}
+
+func TestDecodeRoomPatch_NullsInheritHoursAndDetachGroup(t *testing.T) {
+	patch, _, err := decodeRoomPatch([]byte(`{"opens_at":null,"closes_at":null,"group_id":null}`))
+	require.NoError(t, err)
+	assert.True(t, patch.InheritHours)
+	assert.True(t, patch.ClearGroup)
+	assert.Nil(t, patch.GroupID)
+
+	patch, _, err = decodeRoomPatch([]byte(`{"group_id":4}`))
+	require.NoError(t, err)
+	assert.False(t, patch.ClearGroup)
+	assert.Equal(t, 4, *patch.GroupID)
+
+	_, _, err = decodeRoomPatch([]byte(`{"opens_at":null,"closes_at":"18:00"}`))
+	var verr *services.ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, map[string]string{"closes_at": "must be null too to inherit operating hours"}, verr.Fields)
+}
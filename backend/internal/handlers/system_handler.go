@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/indraprhmbd/allocra/internal/services"
 
 	"github.com/gofiber/fiber/v2"
@@ -15,14 +17,15 @@ func NewSystemHandler(bookingService *services.BookingService) *SystemHandler {
 }
 
 func (h *SystemHandler) GetStats(c *fiber.Ctx) error {
-    stats, err := h.bookingService.GetSystemStats(c.Context())
+    stats, err := h.bookingService.GetSystemStats(c.UserContext())
     if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
     }
     
     return c.JSON(fiber.Map{
         "status": "nominal",
-        "uptime": "99.9%",
+        "uptime": stats.Uptime.Truncate(time.Second).String(),
+        "uptime_seconds": int64(stats.Uptime.Seconds()),
         "version": "1.0.0",
         "total_bookings": stats.TotalBookings,
         "active_bookings": stats.ActiveBookings,
@@ -30,11 +33,12 @@ func (h *SystemHandler) GetStats(c *fiber.Ctx) error {
         "utilization": stats.Utilization,
         "cpu_usage": stats.CPUUsage,
         "memory_usage": stats.MemoryUsage,
+        "allocation_attempts": stats.AllocationAttempts,
     })
 }
 
 func (h *SystemHandler) ResetAllocations(c *fiber.Ctx) error {
-    if err := h.bookingService.ResetAllocations(c.Context()); err != nil {
+    if err := h.bookingService.ResetAllocations(c.UserContext()); err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
     }
     return c.JSON(fiber.Map{"message": "All allocations have been reset"})
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+// TimezoneHeader names the zone a client wants times rendered in, e.g.
+// "Time-Zone: Europe/Berlin"; the tz query parameter takes precedence
+const TimezoneHeader = "Time-Zone"
+
+type locationKey struct{}
+
+// RequestTimezone resolves the zone of each request from ?tz= or the Time-Zone
+// header. Responses are rendered in that zone, fallback when neither is given;
+// an unknown zone is a 400.
+func RequestTimezone(fallback *time.Location) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        c.Vary(TimezoneHeader)
+        
+        name := c.Query("tz", c.Get(TimezoneHeader))
+        if name == "" {
+            c.Locals(locationKey{}, fallback)
+            return c.Next()
+        }
+        loc, err := services.LoadTimezone(name)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+                Error:  "invalid time zone",
+                Fields: map[string]string{"tz": err.Error()},
+            })
+        }
+        c.Locals(locationKey{}, loc)
+        return c.Next()
+    }
+}
+
+// requestLocation is the zone resolved by RequestTimezone
+func requestLocation(c *fiber.Ctx) *time.Location {
+    if loc, ok := c.Locals(locationKey{}).(*time.Location); ok {
+        return loc
+    }
+    return time.UTC
+}
+
+// localize moves every time in body to the request's zone and returns body.
+// The instants are unchanged; only the offset they are written with differs.
+func localize(c *fiber.Ctx, body interface{}) interface{} {
+    if body != nil {
+        localizeValue(reflect.ValueOf(&body).Elem(), requestLocation(c))
+    }
+    return body
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// localizeValue rewrites the times reachable from v in place. Values held by
+// interfaces and maps are not addressable, so those are copied, rewritten and
+// stored back.
+func localizeValue(v reflect.Value, loc *time.Location) {
+    switch v.Kind() {
+    case reflect.Ptr:
+        if !v.IsNil() {
+            localizeValue(v.Elem(), loc)
+        }
+    case reflect.Interface:
+        if v.IsNil() || !v.CanSet() {
+            return
+        }
+        elem := v.Elem()
+        if elem.Kind() == reflect.Ptr {
+            localizeValue(elem, loc)
+            return
+        }
+        cp := reflect.New(elem.Type()).Elem()
+        cp.Set(elem)
+        localizeValue(cp, loc)
+        v.Set(cp)
+    case reflect.Struct:
+        if v.Type() == timeType {
+            if v.CanSet() {
+                v.Set(reflect.ValueOf(v.Interface().(time.Time).In(loc)))
+            }
+            return
+        }
+        for i := 0; i < v.NumField(); i++ {
+            if v.Type().Field(i).IsExported() {
+                localizeValue(v.Field(i), loc)
+            }
+        }
+    case reflect.Slice, reflect.Array:
+        for i := 0; i < v.Len(); i++ {
+            localizeValue(v.Index(i), loc)
+        }
+    case reflect.Map:
+        iter := v.MapRange()
+        for iter.Next() {
+            cp := reflect.New(v.Type().Elem()).Elem()
+            cp.Set(iter.Value())
+            localizeValue(cp, loc)
+            v.SetMapIndex(iter.Key(), cp)
+        }
+    }
+}
+
+// localizeRow moves the times of an export row to loc
+func localizeRow(values []any, loc *time.Location) {
+    for i, value := range values {
+        switch t := value.(type) {
+        case time.Time:
+            values[i] = t.In(loc)
+        case *time.Time:
+            if t != nil {
+                values[i] = t.In(loc)
+            }
+        }
+    }
+}
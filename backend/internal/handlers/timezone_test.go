@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+func TestRequestTimezone(t *testing.T) {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	archived := start.Add(time.Hour)
+
+	app := fiber.New()
+	app.Use(RequestTimezone(time.UTC))
+	app.Get("/", func(c *fiber.Ctx) error {
+		booking := &models.Booking{ID: 1, StartTime: start, EndTime: start.Add(time.Hour)}
+		return c.JSON(localize(c, fiber.Map{
+			"booking":  booking,
+			"rooms":    []models.Room{{ID: 2, CreatedAt: start}},
+			"archived": &archived,
+		}))
+	})
+
+	get := func(target, zone string) (int, map[string]json.RawMessage, string) {
+		req := httptest.NewRequest(fiber.MethodGet, target, nil)
+		if zone != "" {
+			req.Header.Set(TimezoneHeader, zone)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		var doc map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(body, &doc))
+		return resp.StatusCode, doc, resp.Header.Get(fiber.HeaderVary)
+	}
+
+	status, doc, vary := get("/", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Contains(t, vary, TimezoneHeader)
+	assert.JSONEq(t, `"2026-03-01T10:00:00Z"`, string(doc["archived"]))
+
+	_, doc, _ = get("/", "Asia/Tokyo")
+	assert.Contains(t, string(doc["booking"]), `"start_time":"2026-03-01T18:00:00+09:00"`)
+	assert.Contains(t, string(doc["rooms"]), `"created_at":"2026-03-01T18:00:00+09:00"`)
+	assert.JSONEq(t, `"2026-03-01T19:00:00+09:00"`, string(doc["archived"]))
+
+	// The query parameter wins over the header
+	_, doc, _ = get("/?tz=America/New_York", "Asia/Tokyo")
+	assert.Contains(t, string(doc["booking"]), `"start_time":"2026-03-01T04:00:00-05:00"`)
+
+	status, doc, _ = get("/?tz=Mars/Olympus_Mons", "")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	assert.Contains(t, string(doc["fields"]), `"tz"`)
+
+	status, _, _ = get("/", "Local")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	// Without a zone from the client, the configured default applies
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	app2 := fiber.New()
+	app2.Use(RequestTimezone(tokyo))
+	app2.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(localize(c, fiber.Map{"archived": &archived}))
+	})
+	resp, err := app2.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"archived":"2026-03-01T19:00:00+09:00"}`, string(body))
+}
+
+func TestLocalizeRow(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	var missing *time.Time
+
+	row := []any{7, "Lab", start, &start, missing}
+	localizeRow(row, tokyo)
+	assert.Equal(t, tokyo, row[2].(time.Time).Location())
+	assert.Equal(t, tokyo, row[3].(time.Time).Location())
+	assert.True(t, row[2].(time.Time).Equal(start))
+	assert.Nil(t, row[4].(*time.Time))
+}
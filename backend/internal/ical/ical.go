@@ -0,0 +1,253 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// ErrTooManyOccurrences is returned when expansion exceeds the caller's limit
+var ErrTooManyOccurrences = errors.New("calendar expands to too many occurrences")
+
+// Occurrence is one concrete instance of a VEVENT after recurrence expansion
+type Occurrence struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Expand parses an iCalendar stream and returns every occurrence that starts
+// before horizon, sorted by start time. Recurring events are expanded through
+// RRULE/RDATE minus EXDATE; instances overridden by a RECURRENCE-ID component
+// are replaced by that component. Cancelled events are dropped. Floating times,
+// those without a TZID or UTC designator, are read in floating (UTC when nil).
+func Expand(r io.Reader, horizon time.Time, limit int, floating *time.Location) ([]Occurrence, error) {
+	if floating == nil {
+		floating = time.UTC
+	}
+	cal, err := ics.ParseCalendar(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar: %w", err)
+	}
+
+	events := cal.Events()
+
+	// Overrides detach single instances from their series
+	overridden := make(map[string][]time.Time)
+	for _, e := range events {
+		if rid := e.GetProperty(ics.ComponentPropertyRecurrenceId); rid != nil {
+			t, err := parseTime(rid.Value, tzid(rid, floating))
+			if err != nil {
+				return nil, fmt.Errorf("event %s: invalid RECURRENCE-ID: %w", e.Id(), err)
+			}
+			overridden[e.Id()] = append(overridden[e.Id()], t)
+		}
+	}
+
+	var out []Occurrence
+	for _, e := range events {
+		if status := e.GetProperty(ics.ComponentPropertyStatus); status != nil && strings.EqualFold(status.Value, "CANCELLED") {
+			continue
+		}
+
+		// The budget bounds the expansion itself, so a dense rule such as
+		// FREQ=SECONDLY fails early instead of filling memory first
+		occ, err := expandEvent(e, horizon, overridden[e.Id()], floating, limit-len(out))
+		if err != nil {
+			return nil, fmt.Errorf("event %s: %w", e.Id(), err)
+		}
+		out = append(out, occ...)
+		if len(out) > limit {
+			return nil, fmt.Errorf("%w (limit %d)", ErrTooManyOccurrences, limit)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out, nil
+}
+
+// expandEvent returns the occurrences of e that start before horizon. It stops
+// after budget+1 of them, which is enough for the caller to see the limit is
+// exceeded.
+func expandEvent(e *ics.VEvent, horizon time.Time, exceptions []time.Time, floating *time.Location, budget int) ([]Occurrence, error) {
+	dtstart := e.GetProperty(ics.ComponentPropertyDtStart)
+	if dtstart == nil {
+		return nil, errors.New("missing DTSTART")
+	}
+	loc := tzid(dtstart, floating)
+	start, err := parseTime(dtstart.Value, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+
+	length, err := eventLength(e, start, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := ""
+	if p := e.GetProperty(ics.ComponentPropertySummary); p != nil {
+		summary = p.Value
+	}
+	newOccurrence := func(s time.Time) Occurrence {
+		return Occurrence{UID: e.Id(), Summary: summary, Start: s, End: s.Add(length)}
+	}
+
+	rule := e.GetProperty(ics.ComponentPropertyRrule)
+	rdates := e.GetProperties(ics.ComponentPropertyRdate)
+	isOverride := e.GetProperty(ics.ComponentPropertyRecurrenceId) != nil
+	if isOverride || (rule == nil && len(rdates) == 0) {
+		if !start.Before(horizon) {
+			return nil, nil
+		}
+		return []Occurrence{newOccurrence(start)}, nil
+	}
+
+	set := &rrule.Set{}
+	set.DTStart(start)
+	if rule != nil {
+		opt, err := rrule.StrToROptionInLocation(rule.Value, start.Location())
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE: %w", err)
+		}
+		opt.Dtstart = start
+		rr, err := rrule.NewRRule(*opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE: %w", err)
+		}
+		set.RRule(rr)
+	} else {
+		set.RDate(start)
+	}
+	for _, p := range rdates {
+		times, err := parseTimeList(p.Value, tzid(p, floating))
+		if err != nil {
+			return nil, fmt.Errorf("invalid RDATE: %w", err)
+		}
+		for _, t := range times {
+			set.RDate(t)
+		}
+	}
+	for _, p := range e.GetProperties(ics.ComponentPropertyExdate) {
+		times, err := parseTimeList(p.Value, tzid(p, floating))
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXDATE: %w", err)
+		}
+		for _, t := range times {
+			set.ExDate(t)
+		}
+	}
+	for _, t := range exceptions {
+		set.ExDate(t)
+	}
+
+	var out []Occurrence
+	next := set.Iterator()
+	for s, ok := next(); ok && s.Before(horizon) && len(out) <= budget; s, ok = next() {
+		out = append(out, newOccurrence(s))
+	}
+	return out, nil
+}
+
+// eventLength derives the event duration from DTEND or DURATION. All-day events
+// without either last one day, as RFC 5545 specifies.
+func eventLength(e *ics.VEvent, start time.Time, loc *time.Location) (time.Duration, error) {
+	if p := e.GetProperty(ics.ComponentPropertyDtEnd); p != nil {
+		end, err := parseTime(p.Value, tzid(p, loc))
+		if err != nil {
+			return 0, fmt.Errorf("invalid DTEND: %w", err)
+		}
+		if !end.After(start) {
+			return 0, errors.New("DTEND must be after DTSTART")
+		}
+		return end.Sub(start), nil
+	}
+	if p := e.GetProperty(ics.ComponentPropertyDuration); p != nil {
+		d, err := parseDuration(p.Value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid DURATION: %w", err)
+		}
+		if d <= 0 {
+			return 0, errors.New("DURATION must be positive")
+		}
+		return d, nil
+	}
+	if len(e.GetProperty(ics.ComponentPropertyDtStart).Value) == len("20060102") {
+		return 24 * time.Hour, nil
+	}
+	return 0, errors.New("missing DTEND or DURATION")
+}
+
+// tzid is the zone named by the TZID parameter of p, or fallback when there is
+// none or it is unknown
+func tzid(p *ics.IANAProperty, fallback *time.Location) *time.Location {
+	values, ok := p.ICalParameters["TZID"]
+	if !ok || len(values) == 0 {
+		return fallback
+	}
+	loc, err := time.LoadLocation(values[0])
+	if err != nil {
+		return fallback
+	}
+	return loc
+}
+
+// parseTime accepts DATE, floating DATE-TIME and UTC DATE-TIME values.
+// Floating times are read in loc.
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case len(value) == len("20060102"):
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+func parseTimeList(value string, loc *time.Location) ([]time.Time, error) {
+	var out []time.Time
+	for _, v := range strings.Split(value, ",") {
+		t, err := parseTime(strings.TrimSpace(v), loc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration parses an RFC 5545 DURATION value such as PT1H30M or P1DT2H
+func parseDuration(value string) (time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(value)
+	if m == nil || value == "P" || value == "PT" {
+		return 0, fmt.Errorf("malformed duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * unit
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
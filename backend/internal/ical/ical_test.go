@@ -0,0 +1,107 @@
+package ical
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func calendar(events ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+		strings.Join(events, "") +
+		"END:VCALENDAR\r\n"
+}
+
+func TestExpand_RecurringWithExceptions(t *testing.T) {
+	cal := calendar(
+		"BEGIN:VEVENT\r\nUID:standup\r\nSUMMARY:Standup\r\n"+
+			"DTSTART:20300107T090000Z\r\nDURATION:PT30M\r\n"+
+			"RRULE:FREQ=DAILY;COUNT=5\r\n"+
+			"EXDATE:20300108T090000Z\r\nEND:VEVENT\r\n",
+		// Moves the third instance an hour later
+		"BEGIN:VEVENT\r\nUID:standup\r\nSUMMARY:Standup (moved)\r\n"+
+			"RECURRENCE-ID:20300109T090000Z\r\n"+
+			"DTSTART:20300109T100000Z\r\nDTEND:20300109T103000Z\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nUID:cancelled\r\nSTATUS:CANCELLED\r\n"+
+			"DTSTART:20300107T120000Z\r\nDTEND:20300107T130000Z\r\nEND:VEVENT\r\n",
+	)
+
+	occ, err := Expand(strings.NewReader(cal), time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), 100, time.UTC)
+	require.NoError(t, err)
+
+	var starts []string
+	for _, o := range occ {
+		starts = append(starts, o.Start.UTC().Format("01-02 15:04"))
+		assert.Equal(t, 30*time.Minute, o.End.Sub(o.Start))
+		assert.Equal(t, "standup", o.UID)
+	}
+	assert.Equal(t, []string{"01-07 09:00", "01-09 10:00", "01-10 09:00", "01-11 09:00"}, starts)
+	assert.Equal(t, "Standup (moved)", occ[1].Summary)
+}
+
+func TestExpand_OpenEndedRuleStopsAtHorizon(t *testing.T) {
+	cal := calendar("BEGIN:VEVENT\r\nUID:weekly\r\n" +
+		"DTSTART;TZID=Asia/Jakarta:20300107T090000\r\nDTEND;TZID=Asia/Jakarta:20300107T110000\r\n" +
+		"RRULE:FREQ=WEEKLY\r\nEND:VEVENT\r\n")
+
+	horizon := time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC)
+	occ, err := Expand(strings.NewReader(cal), horizon, 100, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, occ, 4)
+	assert.Equal(t, time.Date(2030, 1, 7, 2, 0, 0, 0, time.UTC), occ[0].Start.UTC())
+
+	_, err = Expand(strings.NewReader(cal), horizon, 3, time.UTC)
+	assert.True(t, errors.Is(err, ErrTooManyOccurrences))
+}
+
+func TestExpand_DenseRuleStopsAtTheLimit(t *testing.T) {
+	// Unbounded, this would expand to some 31 million occurrences
+	cal := calendar("BEGIN:VEVENT\r\nUID:flood\r\n" +
+		"DTSTART:20300101T000000Z\r\nDURATION:PT1S\r\n" +
+		"RRULE:FREQ=SECONDLY\r\nEND:VEVENT\r\n")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Expand(strings.NewReader(cal), time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), 1000, time.UTC)
+	runtime.ReadMemStats(&after)
+
+	assert.True(t, errors.Is(err, ErrTooManyOccurrences))
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(16<<20), "expansion should stop at the limit")
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"PT1H30M": 90 * time.Minute,
+		"P1DT2H":  26 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+		"PT45S":   45 * time.Second,
+	}
+	for in, want := range cases {
+		got, err := parseDuration(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, bad := range []string{"", "P", "PT", "1H", "PT1X"} {
+		_, err := parseDuration(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestExpand_FloatingTimesUseTheGivenZone(t *testing.T) {
+	cal := calendar("BEGIN:VEVENT\r\nUID:review\r\n" +
+		"DTSTART:20300107T090000\r\nDTEND;TZID=Asia/Tokyo:20300107T180000\r\nEND:VEVENT\r\n")
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	occ, err := Expand(strings.NewReader(cal), time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), 100, berlin)
+	require.NoError(t, err)
+	require.Len(t, occ, 1)
+	assert.Equal(t, time.Date(2030, 1, 7, 8, 0, 0, 0, time.UTC), occ[0].Start.UTC())
+	assert.Equal(t, time.Hour, occ[0].End.Sub(occ[0].Start))
+}
@@ -0,0 +1,237 @@
+// Package labels implements free-form room labels and Kubernetes-style label
+// selectors such as "zone=a,gpu!=true,tier in (gold,silver),!deprecated".
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Operator is the comparison of a single selector requirement
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+const (
+	maxKeyLength   = 63
+	maxValueLength = 63
+	maxLabels      = 64
+)
+
+var (
+	keyPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	valuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+)
+
+// Requirement is one comma-separated term of a selector
+type Requirement struct {
+	Key    string
+	Op     Operator
+	Values []string // one value for Equals/NotEquals, a set for In/NotIn, none otherwise
+}
+
+// Selector is a conjunction of requirements; the empty selector matches everything
+type Selector []Requirement
+
+// Matches reports whether a label set satisfies every requirement. As in
+// Kubernetes, != and notin also match objects that lack the key.
+func (s Selector) Matches(set map[string]string) bool {
+	for _, r := range s {
+		v, ok := set[r.Key]
+		switch r.Op {
+		case Equals, In:
+			if !ok || !contains(r.Values, v) {
+				return false
+			}
+		case NotEquals, NotIn:
+			if ok && contains(r.Values, v) {
+				return false
+			}
+		case Exists:
+			if !ok {
+				return false
+			}
+		case DoesNotExist:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String renders the selector in canonical form
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		switch r.Op {
+		case Exists:
+			parts[i] = r.Key
+		case DoesNotExist:
+			parts[i] = "!" + r.Key
+		case In, NotIn:
+			parts[i] = fmt.Sprintf("%s %s (%s)", r.Key, r.Op, strings.Join(r.Values, ","))
+		default:
+			parts[i] = r.Key + string(r.Op) + r.Values[0]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse reads a selector. Accepted terms are key=value (or ==), key!=value,
+// key in (v1,v2), key notin (v1,v2), key and !key.
+func Parse(s string) (Selector, error) {
+	terms, err := splitTerms(s)
+	if err != nil {
+		return nil, err
+	}
+
+	sel := make(Selector, 0, len(terms))
+	for _, term := range terms {
+		r, err := parseTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Validate checks a label set before it is stored
+func Validate(set map[string]string) error {
+	if len(set) > maxLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := validateKey(k); err != nil {
+			return err
+		}
+		if err := validateValue(set[k]); err != nil {
+			return fmt.Errorf("label %q: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// splitTerms splits on commas that are not inside a value set
+func splitTerms(s string) ([]string, error) {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("nested parentheses in selector")
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in selector")
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in selector")
+	}
+	terms = append(terms, s[start:])
+
+	if len(terms) == 1 && strings.TrimSpace(terms[0]) == "" {
+		return nil, nil
+	}
+	for i, t := range terms {
+		terms[i] = strings.TrimSpace(t)
+		if terms[i] == "" {
+			return nil, fmt.Errorf("empty term in selector")
+		}
+	}
+	return terms, nil
+}
+
+func parseTerm(term string) (Requirement, error) {
+	if strings.HasPrefix(term, "!") && !strings.ContainsAny(term, "=()") {
+		key := strings.TrimSpace(term[1:])
+		return Requirement{Key: key, Op: DoesNotExist}, validateKey(key)
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(term, op); i >= 0 {
+			key := strings.TrimSpace(term[:i])
+			value := strings.TrimSpace(term[i+len(op):])
+			r := Requirement{Key: key, Op: Equals, Values: []string{value}}
+			if op == "!=" {
+				r.Op = NotEquals
+			}
+			if err := validateKey(key); err != nil {
+				return r, err
+			}
+			return r, validateValue(value)
+		}
+	}
+
+	if open := strings.Index(term, "("); open >= 0 {
+		if !strings.HasSuffix(term, ")") {
+			return Requirement{}, fmt.Errorf("invalid selector term %q", term)
+		}
+		fields := strings.Fields(term[:open])
+		if len(fields) != 2 || (fields[1] != string(In) && fields[1] != string(NotIn)) {
+			return Requirement{}, fmt.Errorf("invalid selector term %q: expected key in (...) or key notin (...)", term)
+		}
+		r := Requirement{Key: fields[0], Op: Operator(fields[1])}
+		if err := validateKey(r.Key); err != nil {
+			return r, err
+		}
+		for _, v := range strings.Split(term[open+1:len(term)-1], ",") {
+			v = strings.TrimSpace(v)
+			if err := validateValue(v); err != nil {
+				return r, err
+			}
+			r.Values = append(r.Values, v)
+		}
+		return r, nil
+	}
+
+	return Requirement{Key: term, Op: Exists}, validateKey(term)
+}
+
+func validateKey(k string) error {
+	if len(k) == 0 || len(k) > maxKeyLength || !keyPattern.MatchString(k) {
+		return fmt.Errorf("invalid label key %q: up to %d alphanumerics, '-', '_', '.' or '/', starting and ending alphanumeric", k, maxKeyLength)
+	}
+	return nil
+}
+
+func validateValue(v string) error {
+	if len(v) > maxValueLength || !valuePattern.MatchString(v) {
+		return fmt.Errorf("invalid label value %q: up to %d alphanumerics, '-', '_' or '.', starting and ending alphanumeric", v, maxValueLength)
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
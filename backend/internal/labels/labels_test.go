@@ -0,0 +1,63 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	sel, err := Parse(" zone = a, gpu!=true,tier in (gold, silver),rack notin (r1),ssd,!deprecated,os==linux")
+	require.NoError(t, err)
+	assert.Equal(t, Selector{
+		{Key: "zone", Op: Equals, Values: []string{"a"}},
+		{Key: "gpu", Op: NotEquals, Values: []string{"true"}},
+		{Key: "tier", Op: In, Values: []string{"gold", "silver"}},
+		{Key: "rack", Op: NotIn, Values: []string{"r1"}},
+		{Key: "ssd", Op: Exists},
+		{Key: "deprecated", Op: DoesNotExist},
+		{Key: "os", Op: Equals, Values: []string{"linux"}},
+	}, sel)
+	assert.Equal(t, "zone=a,gpu!=true,tier in (gold,silver),rack notin (r1),ssd,!deprecated,os=linux", sel.String())
+
+	empty, err := Parse("  ")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	for _, bad := range []string{"zone=a,", "tier in (a", "tier among (a)", "=a", "zone=a b", "tier in ((a))", "-zone"} {
+		_, err := Parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	set := map[string]string{"zone": "a", "tier": "gold", "ssd": ""}
+
+	cases := map[string]bool{
+		"":                      true,
+		"zone=a":                true,
+		"zone=b":                false,
+		"gpu!=true":             true, // absent keys satisfy !=
+		"zone!=a":               false,
+		"tier in (gold,silver)": true,
+		"tier notin (gold)":     false,
+		"rack notin (r1)":       true,
+		"ssd":                   true,
+		"gpu":                   false,
+		"!gpu":                  true,
+		"zone=a,!ssd":           false,
+	}
+	for selector, want := range cases {
+		sel, err := Parse(selector)
+		require.NoError(t, err, selector)
+		assert.Equal(t, want, sel.Matches(set), selector)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(map[string]string{"zone": "a", "hw/class": "gpu-large", "spare": ""}))
+	assert.Error(t, Validate(map[string]string{"": "a"}))
+	assert.Error(t, Validate(map[string]string{"zone": "a b"}))
+	assert.Error(t, Validate(map[string]string{"-zone": "a"}))
+}
@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const namespace = "allocra"
+
+// Allocation outcomes used as the "outcome" label of AllocationAttempts
+const (
+	OutcomeApproved = "approved"
+	OutcomePending  = "pending" // allowed, but the room's group requires approval
+	OutcomeConflict = "conflict"
+	OutcomeQuota    = "quota_exceeded"
+	OutcomeInvalid  = "invalid"
+	OutcomeError    = "error"
+)
+
+var startTime = time.Now()
+
+// Registry holds every Allocra collector; /metrics and /api/system/stats both read from it
+var Registry = prometheus.NewRegistry()
+
+var (
+	AllocationAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "allocation_attempts_total",
+		Help:      "Allocation requests processed by the engine, by outcome.",
+	}, []string{"outcome"})
+
+	ConflictCheckDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "conflict_check_duration_seconds",
+		Help:      "Latency of the overlap query run inside allocation transactions.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+
+	RoomLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "room_lock_wait_seconds",
+		Help:      "Time spent waiting for the room row lock (SELECT ... FOR UPDATE).",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	})
+
+	TransactionRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transaction_retries_total",
+		Help:      "Transactions retried after a serialization failure or deadlock, by operation.",
+	}, []string{"operation"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	uptime = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "process_uptime_seconds",
+		Help:      "Seconds since the API process started.",
+	}, func() float64 { return time.Since(startTime).Seconds() })
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		AllocationAttempts,
+		ConflictCheckDuration,
+		RoomLockWait,
+		TransactionRetries,
+		HTTPRequestDuration,
+		uptime,
+	)
+}
+
+// RegisterDB exposes connection pool statistics from sql.DB.Stats()
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware records request durations labelled by the matched route template,
+// so /api/bookings/42/approve and /api/bookings/43/approve share one series
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			} else {
+				status = fiber.StatusInternalServerError
+			}
+		}
+
+		HTTPRequestDuration.WithLabelValues(
+			c.Method(),
+			c.Route().Path,
+			strconv.Itoa(status),
+		).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// Snapshot is a point-in-time summary of the registry for the dashboard
+type Snapshot struct {
+	Uptime             time.Duration
+	CPUUsage           float64 // percent of one core since the previous snapshot
+	MemoryUsage        float64 // heap in use as percent of memory obtained from the OS
+	AllocationAttempts map[string]float64
+}
+
+var cpuSample struct {
+	sync.Mutex
+	seconds float64
+	at      time.Time
+}
+
+// Collect gathers the registry and derives dashboard figures from it
+func Collect() (*Snapshot, error) {
+	families, err := Registry.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, f := range families {
+		byName[f.GetName()] = f
+	}
+
+	snap := &Snapshot{
+		Uptime:             time.Since(startTime),
+		AllocationAttempts: make(map[string]float64),
+	}
+
+	if f, ok := byName["allocra_allocation_attempts_total"]; ok {
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "outcome" {
+					snap.AllocationAttempts[l.GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	alloc := singleValue(byName["go_memstats_alloc_bytes"])
+	sys := singleValue(byName["go_memstats_sys_bytes"])
+	if sys > 0 {
+		snap.MemoryUsage = alloc / sys * 100
+	}
+
+	if f, ok := byName["process_cpu_seconds_total"]; ok {
+		now := time.Now()
+		cpu := singleValue(f)
+
+		cpuSample.Lock()
+		prevSeconds, prevAt := cpuSample.seconds, cpuSample.at
+		if prevAt.IsZero() {
+			prevAt = startTime
+		}
+		cpuSample.seconds, cpuSample.at = cpu, now
+		cpuSample.Unlock()
+
+		if wall := now.Sub(prevAt).Seconds(); wall > 0 {
+			snap.CPUUsage = (cpu - prevSeconds) / wall * 100
+		}
+	}
+
+	return snap, nil
+}
+
+func singleValue(f *dto.MetricFamily) float64 {
+	if f == nil || len(f.GetMetric()) == 0 {
+		return 0
+	}
+	m := f.GetMetric()[0]
+	switch {
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	case m.Untyped != nil:
+		return m.GetUntyped().GetValue()
+	}
+	return 0
+}
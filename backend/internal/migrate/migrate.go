@@ -0,0 +1,474 @@
+// Package migrate applies versioned schema migrations. A migration is a pair of
+// NNN_name.up.sql and NNN_name.down.sql scripts; each runs in its own
+// transaction and is recorded in schema_migrations with the checksum of its up
+// script, so an applied migration that is edited afterwards is detected.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockKey is the Postgres advisory lock held while migrating, so replicas that
+// start together apply each migration once
+const lockKey = 0x616c6c6f63726d // "allocrm"
+
+// dialect covers what differs between the databases a Migrator can drive
+type dialect struct {
+	// lock serializes migrators on conn and returns how to release it
+	lock func(ctx context.Context, conn *sql.Conn) (unlock func(), err error)
+	// appliedAt is the column type of schema_migrations.applied_at
+	appliedAt string
+	// legacy reports whether conn may hold the _migrations table of earlier releases
+	legacy bool
+	// exists is a query reporting whether the table named $1 exists
+	exists string
+}
+
+var postgres = dialect{
+	lock: func(ctx context.Context, conn *sql.Conn) (func(), error) {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return nil, err
+		}
+		return func() { conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey) }, nil
+	},
+	appliedAt: "TIMESTAMPTZ NOT NULL DEFAULT NOW()",
+	legacy:    true,
+	exists:    `SELECT to_regclass($1) IS NOT NULL`,
+}
+
+// sqlite has no advisory locks. A single node rarely runs two migrators at
+// once, and if it does, the loser's insert into schema_migrations fails and
+// its transaction, DDL included, rolls back.
+var sqlite = dialect{
+	lock:      func(context.Context, *sql.Conn) (func(), error) { return func() {}, nil },
+	appliedAt: "TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP",
+	exists:    `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = $1)`,
+}
+
+// ErrChecksumMismatch is returned when an applied migration's up script no
+// longer matches what was applied
+var ErrChecksumMismatch = errors.New("migration changed after it was applied")
+
+// ErrUnknownVersion is returned when the database has a migration applied that
+// this build does not ship, i.e. it was migrated by a newer release
+var ErrUnknownVersion = errors.New("database has a migration applied that this build does not know")
+
+// Migration is one schema version
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // hex SHA-256 of Up
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+// Status reports whether a migration has been applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	Modified  bool // applied with a different up script
+	Legacy    bool // recorded only in _migrations, with no time; the next Up adopts it
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load discovers the migrations in the root of fsys, ordered by version. Every
+// version needs an up script; a missing down script makes it irreversible.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		match := fileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 001_name.up.sql or 001_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migration %s has no up script", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies migrations to a Postgres or SQLite database
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+	logf       func(format string, args ...interface{})
+}
+
+// New prepares a migrator for the migrations in fsys on a Postgres database;
+// logf (may be nil) receives a line per migration applied or rolled back
+func New(db *sql.DB, fsys fs.FS, logf func(format string, args ...interface{})) (*Migrator, error) {
+	return newMigrator(db, postgres, fsys, logf)
+}
+
+// NewSQLite is New for a SQLite database
+func NewSQLite(db *sql.DB, fsys fs.FS, logf func(format string, args ...interface{})) (*Migrator, error) {
+	return newMigrator(db, sqlite, fsys, logf)
+}
+
+func newMigrator(db *sql.DB, d dialect, fsys fs.FS, logf func(format string, args ...interface{})) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations, logf: logf}, nil
+}
+
+// Up applies every pending migration in version order and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var ran []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, done map[int]applied) error {
+		if err := m.verify(done); err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			m.logf("Applying migration %s", mig)
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+					mig.Version, mig.Name, mig.Checksum)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %s: %w", mig, err)
+			}
+			ran = append(ran, mig)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, done map[int]applied) error {
+		if err := m.verify(done); err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if strings.TrimSpace(mig.Down) == "" {
+				return fmt.Errorf("migration %s cannot be rolled back: it has no down script", mig)
+			}
+			m.logf("Rolling back migration %s", mig)
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to roll back migration %s: %w", mig, err)
+			}
+			rolledBack = append(rolledBack, mig)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status lists every known migration with when it was applied. It only reads
+// and takes no lock: before the first Up there is no schema_migrations yet,
+// and migrations that only the legacy _migrations table records are reported
+// as such until Up adopts them.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	done, err := m.readApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	legacy := make(map[int]bool)
+	if m.dialect.legacy {
+		exists, err := m.exists(ctx, "_migrations")
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			names, err := legacyNames(ctx, m.db)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if mig, ok := m.byLegacyName(name); ok {
+					legacy[mig.Version] = true
+				}
+			}
+		}
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if r, ok := done[mig.Version]; ok {
+			s.AppliedAt = &r.appliedAt
+			s.Modified = r.checksum != mig.Checksum
+		} else {
+			s.Legacy = legacy[mig.Version]
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Pending counts the migrations not applied yet, without taking the lock
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	done, err := m.readApplied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, mig := range m.migrations {
+		if _, ok := done[mig.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// readApplied reads the applied migrations without the lock, finding none when
+// schema_migrations has not been created yet
+func (m *Migrator) readApplied(ctx context.Context) (map[int]applied, error) {
+	exists, err := m.exists(ctx, "schema_migrations")
+	if err != nil || !exists {
+		return map[int]applied{}, err
+	}
+	return appliedMigrations(ctx, m.db)
+}
+
+// exists reports whether table exists
+func (m *Migrator) exists(ctx context.Context, table string) (bool, error) {
+	var exists bool
+	if err := m.db.QueryRowContext(ctx, m.dialect.exists, table).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to look for %s: %w", table, err)
+	}
+	return exists, nil
+}
+
+// verify refuses to go on when applied migrations were edited or are unknown
+func (m *Migrator) verify(done map[int]applied) error {
+	known := make(map[int]bool, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		if r, ok := done[mig.Version]; ok && r.checksum != mig.Checksum {
+			return fmt.Errorf("%w: %s", ErrChecksumMismatch, mig)
+		}
+	}
+	for version := range done {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d", ErrUnknownVersion, version)
+		}
+	}
+	return nil
+}
+
+// locked runs fn on one connection holding the migration lock, after
+// making sure schema_migrations exists and, on Postgres, adopting the legacy
+// _migrations bookkeeping. fn gets the applied migrations by version.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, done map[int]applied) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := m.dialect.lock(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+	defer unlock()
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at `+m.dialect.appliedAt+`
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	if m.dialect.legacy {
+		if err := m.adoptLegacy(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	done, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, done)
+}
+
+// adoptLegacy carries over the migrations recorded by file path in the
+// _migrations table of earlier releases, then drops that table
+func (m *Migrator) adoptLegacy(ctx context.Context, conn *sql.Conn) error {
+	var legacy bool
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass('_migrations') IS NOT NULL`).Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("failed to look for legacy migrations: %w", err)
+	}
+	if !legacy {
+		return nil
+	}
+
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		names, err := legacyNames(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			mig, ok := m.byLegacyName(name)
+			if !ok {
+				return fmt.Errorf("legacy migration %s is not known to this build", name)
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+				ON CONFLICT (version) DO NOTHING`,
+				mig.Version, mig.Name, mig.Checksum)
+			if err != nil {
+				return fmt.Errorf("failed to adopt legacy migration %s: %w", name, err)
+			}
+		}
+		m.logf("Adopted %d migrations from _migrations", len(names))
+		_, err = tx.ExecContext(ctx, `DROP TABLE _migrations`)
+		return err
+	})
+}
+
+// legacyNames reads the file paths the _migrations table records
+func legacyNames(ctx context.Context, q queryer) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT name FROM _migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// byLegacyName finds the migration a legacy path such as
+// migrations/004_operating_hours.sql refers to
+func (m *Migrator) byLegacyName(name string) (Migration, bool) {
+	base := strings.TrimSuffix(path.Base(name), ".sql")
+	for _, mig := range m.migrations {
+		if mig.String() == base {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// applied is a row of schema_migrations
+type applied struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func appliedMigrations(ctx context.Context, q queryer) (map[int]applied, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[int]applied)
+	for rows.Next() {
+		var version int
+		var r applied
+		if err := rows.Scan(&version, &r.checksum, &r.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		done[version] = r
+	}
+	return done, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
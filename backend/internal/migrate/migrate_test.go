@@ -0,0 +1,187 @@
+package migrate
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/migrations"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoad(t *testing.T) {
+	ms, err := Load(fstest.MapFS{
+		"002_labels.up.sql":   file("ALTER TABLE rooms ADD COLUMN labels JSONB;"),
+		"002_labels.down.sql": file("ALTER TABLE rooms DROP COLUMN labels;"),
+		"001_init.up.sql":     file("CREATE TABLE rooms (id SERIAL);"),
+		"README.md":           file("not a migration"),
+	})
+	require.NoError(t, err)
+	require.Len(t, ms, 2)
+	assert.Equal(t, "001_init", ms[0].String())
+	assert.Empty(t, ms[0].Down)
+	assert.Equal(t, "002_labels", ms[1].String())
+	assert.Equal(t, "ALTER TABLE rooms DROP COLUMN labels;", ms[1].Down)
+	assert.Len(t, ms[1].Checksum, 64)
+
+	cases := map[string]fstest.MapFS{
+		"duplicate version": {"003_a.up.sql": file(""), "003_b.up.sql": file("")},
+		"down without up":   {"004_a.down.sql": file("")},
+		"bad name":          {"5-init.sql": file("")},
+	}
+	for name, fsys := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEmbeddedMigrationsAreReversible(t *testing.T) {
+	ms, err := Load(migrations.FS)
+	require.NoError(t, err)
+	for i, m := range ms {
+		assert.Equal(t, i+1, m.Version, "versions are consecutive")
+		assert.NotEmpty(t, m.Down, "%s has no down script", m)
+	}
+}
+
+// expectLocked expects the lock, bookkeeping setup and read of applied
+// migrations that every locked operation starts with
+func expectLocked(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass('_migrations') IS NOT NULL`)).
+		WillReturnRows(sqlmock.NewRows([]string{"legacy"}).AddRow(false))
+	mock.ExpectQuery(`SELECT version, checksum, applied_at FROM schema_migrations`).WillReturnRows(applied)
+}
+
+func TestUp_AppliesPendingInOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := New(db, fstest.MapFS{
+		"001_init.up.sql":   file("CREATE TABLE rooms (id SERIAL);"),
+		"002_labels.up.sql": file("ALTER TABLE rooms ADD COLUMN labels JSONB;"),
+		"003_index.up.sql":  file("CREATE INDEX idx_rooms_labels ON rooms USING GIN (labels);"),
+	}, nil)
+	require.NoError(t, err)
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).
+		AddRow(1, m.migrations[0].Checksum, time.Now()))
+	for _, mig := range m.migrations[1:] {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(mig.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO schema_migrations`).
+			WithArgs(mig.Version, mig.Name, mig.Checksum).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := m.Up(context.Background())
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	assert.Equal(t, 2, applied[0].Version)
+	assert.Equal(t, 3, applied[1].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_RefusesEditedMigrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := New(db, fstest.MapFS{
+		"001_init.up.sql": file("CREATE TABLE rooms (id SERIAL, name TEXT);"),
+	}, nil)
+	require.NoError(t, err)
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).
+		AddRow(1, "checksum-of-the-original", time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err = m.Up(context.Background())
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDown_RollsBackNewestFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := New(db, fstest.MapFS{
+		"001_init.up.sql":     file("CREATE TABLE rooms (id SERIAL);"),
+		"001_init.down.sql":   file("DROP TABLE rooms;"),
+		"002_labels.up.sql":   file("ALTER TABLE rooms ADD COLUMN labels JSONB;"),
+		"002_labels.down.sql": file("ALTER TABLE rooms DROP COLUMN labels;"),
+	}, nil)
+	require.NoError(t, err)
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).
+		AddRow(1, m.migrations[0].Checksum, time.Now()).
+		AddRow(2, m.migrations[1].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE rooms DROP COLUMN labels;")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rolledBack, err := m.Down(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 1)
+	assert.Equal(t, 2, rolledBack[0].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStatus_OnlyReads(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := New(db, fstest.MapFS{
+		"001_init.up.sql":   file("CREATE TABLE rooms (id SERIAL);"),
+		"002_labels.up.sql": file("ALTER TABLE rooms ADD COLUMN labels JSONB;"),
+	}, nil)
+	require.NoError(t, err)
+
+	// A database of an earlier release: no schema_migrations, one legacy row.
+	// Any write, or the lock, would be an unexpected statement.
+	exists := regexp.QuoteMeta(`SELECT to_regclass($1) IS NOT NULL`)
+	mock.ExpectQuery(exists).WithArgs("schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(exists).WithArgs("_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT name FROM _migrations`)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("migrations/001_init.sql"))
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Legacy)
+	assert.Nil(t, statuses[0].AppliedAt)
+	assert.False(t, statuses[1].Legacy)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestByLegacyName(t *testing.T) {
+	m := &Migrator{migrations: []Migration{{Version: 3, Name: "extend_rooms"}, {Version: 4, Name: "operating_hours"}}}
+
+	mig, ok := m.byLegacyName("migrations/004_operating_hours.sql")
+	require.True(t, ok)
+	assert.Equal(t, 4, mig.Version)
+
+	_, ok = m.byLegacyName("migrations/003_extra_data.sql")
+	assert.False(t, ok)
+}
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
+)
 
 type User struct {
     ID        int       `json:"id"`
@@ -11,12 +15,160 @@ type User struct {
 }
 
 type Room struct {
-    ID        int       `json:"id"`
-    Name      string    `json:"name"`
-    Capacity  int       `json:"capacity"`
-    Type      string    `json:"type"`   // "shared" or "exclusive"
-    Status    string    `json:"status"` // "online", "maintenance", "offline"
-    CreatedAt time.Time `json:"created_at"`
+    ID        int               `json:"id"`
+    Name      string            `json:"name"`
+    Capacity  int               `json:"capacity"`
+    Type      string            `json:"type"`      // "shared", "exclusive" or "pooled"
+    Quantity  int               `json:"quantity"`  // units a pooled room holds, 1 otherwise
+    Status    string            `json:"status"`    // "online", "maintenance", "offline"
+    OpensAt   string            `json:"opens_at"`  // effective daily operating window, "HH:MM:SS"
+    ClosesAt  string            `json:"closes_at"`
+    Timezone  string            `json:"timezone"`  // IANA zone the operating hours and quota days are read in
+    Labels    map[string]string `json:"labels"`    // free-form, matched by label selectors
+    
+    // GroupID places the room in the resource hierarchy. Without hours of its
+    // own (HoursInherited) the room uses its group's, or the whole day.
+    GroupID          *int     `json:"group_id"`
+    HoursInherited   bool     `json:"hours_inherited"`
+    RequiresApproval bool     `json:"requires_approval"` // inherited from the group
+    DailyQuotaHours  *float64 `json:"daily_quota_hours"` // inherited from the group
+    
+    CreatedAt time.Time         `json:"created_at"`
+    UpdatedAt time.Time         `json:"updated_at"`
+    Version   int               `json:"version"`
+    
+    // FreeUnits is how many units stay free over a searched window; set by
+    // availability searches only
+    FreeUnits *int `json:"free_units,omitempty"`
+}
+
+// Room types and statuses, mirrored by CHECK constraints on the rooms table
+const (
+    RoomTypeShared    = "shared"
+    RoomTypeExclusive = "exclusive"
+    RoomTypePooled    = "pooled" // fungible units booked by count
+    
+    RoomStatusOnline      = "online"
+    RoomStatusMaintenance = "maintenance"
+    RoomStatusOffline     = "offline"
+)
+
+var (
+    RoomTypes    = []string{RoomTypeShared, RoomTypeExclusive, RoomTypePooled}
+    RoomStatuses = []string{RoomStatusOnline, RoomStatusMaintenance, RoomStatusOffline}
+)
+
+// Strategies for the upcoming approved bookings of a room being archived
+const (
+    ArchiveStrategyReject   = "reject"
+    ArchiveStrategyReassign = "reassign"
+)
+
+// ArchiveResult reports what archiving a room did to its upcoming bookings
+type ArchiveResult struct {
+    RoomID     int            `json:"room_id"`
+    ArchivedAt time.Time      `json:"archived_at"`
+    Rejected   []int          `json:"rejected_booking_ids"`
+    Reassigned []Reassignment `json:"reassigned"`
+}
+
+// Reassignment records a booking moved to another room
+type Reassignment struct {
+    BookingID int `json:"booking_id"`
+    RoomID    int `json:"room_id"`
+}
+
+// RoomPatch is a JSON Merge Patch of a room; nil fields are left unchanged
+type RoomPatch struct {
+    Name     *string
+    Capacity *int
+    Quantity *int
+    Type     *string
+    Status   *string
+    OpensAt  *string
+    ClosesAt *string
+    Timezone *string
+    
+    // InheritHours (opens_at or closes_at set to null) drops the room's own
+    // hours in favour of its group's
+    InheritHours bool
+    
+    // GroupID moves the room to another group; ClearGroup detaches it
+    GroupID    *int
+    ClearGroup bool
+    
+    // Labels is merged key by key: a nil value removes the label.
+    // ClearLabels (the whole member set to null) removes all labels first.
+    Labels      map[string]*string
+    ClearLabels bool
+}
+
+// AvailabilityQuery searches for rooms free over [Start, End)
+type AvailabilityQuery struct {
+    Start       time.Time
+    End         time.Time
+    Quantity    int // units that must stay free throughout; 1 when zero
+    MinCapacity int
+    Type        string
+    Selector    labels.Selector
+    GroupID     *int // rooms anywhere below this group
+}
+
+// RoomFilter narrows room listings; zero values match everything
+type RoomFilter struct {
+    Selector labels.Selector
+    GroupID  *int // rooms anywhere below this group
+}
+
+// Resource group kinds, from the top of the hierarchy down. A group's parent
+// must be of an earlier kind, which also keeps the hierarchy free of cycles.
+const (
+    GroupKindSite     = "site"
+    GroupKindBuilding = "building"
+    GroupKindPool     = "pool"
+)
+
+var GroupKinds = []string{GroupKindSite, GroupKindBuilding, GroupKindPool}
+
+// Policy holds the allocation rules groups hand down to their rooms. On a
+// group, nil fields are inherited from the parent; an effective nil means
+// unrestricted (open all day, no approval, no quota).
+type Policy struct {
+    OpensAt          *string  `json:"opens_at"`
+    ClosesAt         *string  `json:"closes_at"`
+    RequiresApproval *bool    `json:"requires_approval"`
+    DailyQuotaHours  *float64 `json:"daily_quota_hours"` // per user per day, across the subtree of the group that sets it
+}
+
+// ResourceGroup is a site, building or pool with capacity rolled up over its subtree
+type ResourceGroup struct {
+    ID             int       `json:"id"`
+    Name           string    `json:"name"`
+    Kind           string    `json:"kind"`
+    ParentID       *int      `json:"parent_id"`
+    Policy         Policy    `json:"policy"`           // as set on this group
+    Effective      Policy    `json:"effective_policy"` // after inheritance
+    RoomCount      int       `json:"room_count"`       // active rooms in the subtree
+    TotalCapacity  int       `json:"total_capacity"`
+    OnlineCapacity int       `json:"online_capacity"`
+    CreatedAt      time.Time `json:"created_at"`
+    UpdatedAt      time.Time `json:"updated_at"`
+    Version        int       `json:"version"`
+}
+
+// GroupPatch is a JSON Merge Patch of a group; nil fields are left unchanged.
+// Null policy members revert to inheritance, a null parent makes a root group.
+type GroupPatch struct {
+    Name             *string
+    ParentID         *int
+    ClearParent      bool
+    OpensAt          *string
+    ClosesAt         *string
+    InheritHours     bool
+    RequiresApproval *bool
+    InheritApproval  bool
+    DailyQuotaHours  *float64
+    InheritQuota     bool
 }
 
 type Booking struct {
@@ -27,6 +179,75 @@ type Booking struct {
     EndTime   time.Time `json:"end_time"`
     Status    string    `json:"status"` // "pending", "approved", "rejected"
     CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    Version   int       `json:"version"`
+    Quantity  int       `json:"quantity"`            // units taken of a pooled room, 1 otherwise
+    BundleID  *int      `json:"bundle_id,omitempty"` // set on members of a multi-resource bundle
+    RoomName  string    `json:"room_name,omitempty"` // populated by joined queries only
+}
+
+// Bundle is a multi-resource allocation: one booking per member room over the
+// same window, all approved, pending or rejected together
+type Bundle struct {
+    ID        int       `json:"id"`
+    UserID    int       `json:"user_id"`
+    StartTime time.Time `json:"start_time"`
+    EndTime   time.Time `json:"end_time"`
+    Status    string    `json:"status"` // "pending", "approved", "rejected"
+    Bookings  []Booking `json:"bookings"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    Version   int       `json:"version"`
+    
+    // ConflictingRoomIDs lists the members that made a new bundle fail
+    ConflictingRoomIDs []int `json:"conflicting_room_ids,omitempty"`
+}
+
+// BundleMember names one resource of a bundle: a room, or like an auto-selected
+// booking a selector, minimum capacity and/or group to pick a free room from
+type BundleMember struct {
+    RoomID       int    `json:"room_id,omitempty"`
+    Quantity     int    `json:"quantity,omitempty"`
+    RoomSelector string `json:"room_selector,omitempty"`
+    MinCapacity  int    `json:"min_capacity,omitempty"`
+    GroupID      *int   `json:"group_id,omitempty"`
+    
+    Selector labels.Selector `json:"-"` // parsed RoomSelector, set by the service
+}
+
+// CreateBundleRequest represents the bundle creation payload
+type CreateBundleRequest struct {
+    UserID    int            `json:"user_id"`
+    StartTime time.Time      `json:"start_time"`
+    EndTime   time.Time      `json:"end_time"`
+    Members   []BundleMember `json:"members"`
+}
+
+// BookingFilter narrows booking queries; zero values match everything
+type BookingFilter struct {
+    RoomID        *int
+    UserID        *int
+    Status        string
+    From          *time.Time // bookings overlapping [From, To)
+    To            *time.Time
+    CreatedAfter  *time.Time // created_at >= CreatedAfter
+    CreatedBefore *time.Time // created_at < CreatedBefore
+}
+
+// BookingListQuery selects one page of a keyset-paginated booking listing
+type BookingListQuery struct {
+    Filter BookingFilter
+    Sort   string // "start_time", "created_at" or "id"
+    Desc   bool
+    Limit  int
+    Cursor string // opaque next_cursor of the previous page
+}
+
+// BookingPage is one page of a booking listing
+type BookingPage struct {
+    Data       []Booking `json:"data"`
+    NextCursor string    `json:"next_cursor,omitempty"`
+    Total      int       `json:"-"` // sent as X-Total-Count
 }
 
 // CreateBookingRequest represents the booking creation payload
@@ -35,6 +256,15 @@ type CreateBookingRequest struct {
     UserID    int       `json:"user_id"`
     StartTime time.Time `json:"start_time"`
     EndTime   time.Time `json:"end_time"`
+    Quantity  int       `json:"quantity,omitempty"` // units of a pooled room; 1 when omitted
+    
+    // Without room_id the engine picks the smallest free online room with at
+    // least MinCapacity whose labels match RoomSelector, below GroupID if set
+    RoomSelector string `json:"room_selector,omitempty"`
+    MinCapacity  int    `json:"min_capacity,omitempty"`
+    GroupID      *int   `json:"group_id,omitempty"` // any room in this group's subtree
+    
+    Selector labels.Selector `json:"-"` // parsed RoomSelector, set by the service
 }
 
 // MonthlyUsageReport represents aggregated room usage
@@ -44,3 +274,81 @@ type MonthlyUsageReport struct {
     TotalBookings int     `json:"total_bookings"`
     TotalHours    float64 `json:"total_hours"`
 }
+
+// GroupUsageReport rolls monthly usage up to the groups of one hierarchy level
+type GroupUsageReport struct {
+    GroupID       int     `json:"group_id"`
+    GroupName     string  `json:"group_name"`
+    Kind          string  `json:"kind"`
+    Rooms         int     `json:"rooms"` // rooms with approved bookings
+    TotalBookings int     `json:"total_bookings"`
+    TotalHours    float64 `json:"total_hours"`
+}
+
+// UtilizationQuery selects the window and scope of a utilization report
+type UtilizationQuery struct {
+    From        time.Time
+    To          time.Time
+    Granularity string // "hour", "day" or "week"
+    RoomID      *int
+    GroupID     *int // rooms anywhere below this group
+    Type        string
+    Location    *time.Location // zone whose wall clock the buckets follow; UTC when nil
+}
+
+// UtilizationBucket reports booked vs. available hours for one time slice
+type UtilizationBucket struct {
+    BucketStart     time.Time `json:"bucket_start"`
+    BucketEnd       time.Time `json:"bucket_end"`
+    AvailableHours  float64   `json:"available_hours"`
+    BookedHours     float64   `json:"booked_hours"`
+    Utilization     float64   `json:"utilization"` // percent of available hours
+    PeakConcurrency int       `json:"peak_concurrency"`
+    Requests        int       `json:"requests"`
+    Conflicts       int       `json:"conflicts"`
+    ConflictRate    float64   `json:"conflict_rate"` // percent of requests rejected
+}
+
+// ImportRequest describes an iCalendar import into one room
+type ImportRequest struct {
+    RoomID  int
+    UserID  int
+    DryRun  bool
+    Horizon time.Time // recurring events are expanded up to this instant
+    
+    // Location is the zone of floating calendar times (no TZID and not UTC)
+    Location *time.Location
+}
+
+// ImportedEvent is the outcome for one expanded calendar occurrence
+type ImportedEvent struct {
+    UID       string    `json:"uid"`
+    Summary   string    `json:"summary,omitempty"`
+    StartTime time.Time `json:"start_time"`
+    EndTime   time.Time `json:"end_time"`
+    Status    string    `json:"status"` // "approved", "pending", "conflict", "skipped" or "error"
+    BookingID int       `json:"booking_id,omitempty"`
+    Reason    string    `json:"reason,omitempty"`
+}
+
+// ImportReport summarises an iCalendar import; with DryRun nothing was persisted
+type ImportReport struct {
+    RoomID    int             `json:"room_id"`
+    DryRun    bool            `json:"dry_run"`
+    Total     int             `json:"total"`
+    Approved  int             `json:"approved"`
+    Pending   int             `json:"pending"`
+    Conflicts int             `json:"conflicts"`
+    Skipped   int             `json:"skipped"`
+    Errors    int             `json:"errors"`
+    Events    []ImportedEvent `json:"events"`
+}
+
+// IdempotencyRecord is the stored outcome of a request made with an Idempotency-Key.
+// StatusCode is 0 while the original request is still being processed.
+type IdempotencyRecord struct {
+    Fingerprint string
+    StatusCode  int
+    ContentType string
+    Body        []byte
+}
@@ -0,0 +1,527 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+// AllocationTx is one transaction of a SQL backend, reduced to the reads and
+// writes the allocation rules are made of. The rules themselves are written
+// once, in Allocation; a backend only says how to lock, read and write in its
+// dialect. A backend that serializes whole transactions may lock nothing.
+type AllocationTx interface {
+    // PickRoom chooses a free room matching an auto-selected request, never
+    // one in exclude, and locks it; ErrNoRoomAvailable when there is none
+    PickRoom(ctx context.Context, req *models.CreateBookingRequest, exclude []int) (int, error)
+    // LockRoom serializes all allocation decisions on an active room;
+    // ErrRoomNotFound when there is no such room
+    LockRoom(ctx context.Context, roomID int) error
+    // RoomPolicy reads the effective policy of a locked room
+    RoomPolicy(ctx context.Context, roomID int) (*AllocationPolicy, error)
+    // Conflicts reports whether units more units of a room can't be held over
+    // [start, end) next to its approved bookings
+    Conflicts(ctx context.Context, roomID int, start, end time.Time, units int) (bool, error)
+    // QuotaUsage sums the hours of the user's approved and pending bookings
+    // starting in [from, to) in the subtree of groupID, and serializes the
+    // user's allocations until the transaction ends
+    QuotaUsage(ctx context.Context, userID int, groupID int64, from, to time.Time) (float64, error)
+    // Location loads a room's time zone
+    Location(name string) (*time.Location, error)
+    // InsertBooking stores a booking, in a bundle when bundleID is set
+    InsertBooking(ctx context.Context, roomID int, req *models.CreateBookingRequest, status string, units int, bundleID *int) (*models.Booking, error)
+    // LockBooking reads a booking for a status change; ErrBookingNotFound
+    // when there is no such booking
+    LockBooking(ctx context.Context, bookingID int) (*models.Booking, error)
+    // SetBookingStatus writes the new status of a locked booking and returns it
+    SetBookingStatus(ctx context.Context, bookingID int, status string) (*models.Booking, error)
+    // RejectNewest rejects the most recently created approved booking of
+    // b's room overlapping b, other than b, and returns its id; ok is false
+    // when there is none left
+    RejectNewest(ctx context.Context, b *models.Booking) (id int, ok bool, err error)
+    // RejectBundlesOf rejects every bundle one of bookingIDs belongs to,
+    // with the rest of its bookings
+    RejectBundlesOf(ctx context.Context, bookingIDs []int) error
+    // InsertBundle stores a bundle without its members
+    InsertBundle(ctx context.Context, req *models.CreateBundleRequest, status string) (*models.Bundle, error)
+    // LockBundle reads a bundle for a status change, with the room and
+    // units of every member; ErrBundleNotFound when there is no such bundle
+    LockBundle(ctx context.Context, id int) (*models.Bundle, error)
+    // SetBundleStatus moves a locked bundle and all its bookings to status
+    SetBundleStatus(ctx context.Context, id int, status string) error
+}
+
+// AllocationPolicy is what the allocation engine enforces on a room: the
+// units it holds, its effective operating hours and its effective group policy
+type AllocationPolicy struct {
+    Quantity         int
+    RequiresApproval bool
+    QuotaHours       sql.NullFloat64
+    QuotaGroupID     sql.NullInt64 // the group whose subtree the quota counts
+    Timezone         string        // the room's zone, in which hours and quota days run
+    OpensAt          string        // "00:00" to "24:00" when neither room nor group sets hours
+    ClosesAt         string
+}
+
+// Allocation applies the allocation rules inside one transaction of a SQL
+// backend. Its methods return failures only: a booking or bundle that was
+// stored as rejected comes back without an error, and the backend reports
+// ErrBookingConflict once the transaction has committed.
+type Allocation struct {
+    tx AllocationTx
+}
+
+func NewAllocation(tx AllocationTx) *Allocation {
+    return &Allocation{tx: tx}
+}
+
+// Create allocates a booking: it picks a room when none is named, locks it and
+// books the request there
+func (a *Allocation) Create(ctx context.Context, req *models.CreateBookingRequest) (*models.Booking, error) {
+    roomID := req.RoomID
+    if roomID == 0 {
+        var err error
+        if roomID, err = a.pickRoom(ctx, req, nil); err != nil {
+            return nil, err
+        }
+    }
+
+    // Lock room to prevent race conditions (double bookings)
+    if err := a.tx.LockRoom(ctx, roomID); err != nil {
+        return nil, err
+    }
+    return a.book(ctx, roomID, req)
+}
+
+// book checks the units, the operating hours, the conflicts and the quota of
+// req in a locked room, and stores the booking as approved, pending or (on a
+// conflict) rejected
+func (a *Allocation) book(ctx context.Context, roomID int, req *models.CreateBookingRequest) (*models.Booking, error) {
+    policy, err := a.tx.RoomPolicy(ctx, roomID)
+    if err != nil {
+        return nil, err
+    }
+    units := alloc.Units(req.Quantity)
+    if units > policy.Quantity {
+        return nil, fmt.Errorf("%w: room %d holds %d", ErrInsufficientUnits, roomID, policy.Quantity)
+    }
+    if err := a.checkHours(roomID, policy, req.StartTime, req.EndTime); err != nil {
+        return nil, err
+    }
+
+    hasConflict, err := a.tx.Conflicts(ctx, roomID, req.StartTime, req.EndTime, units)
+    if err != nil {
+        return nil, err
+    }
+
+    status := "approved"
+    switch {
+    case hasConflict:
+        status = "rejected"
+    case policy.RequiresApproval:
+        status = "pending"
+    }
+    if !hasConflict {
+        if err := a.checkQuota(ctx, roomID, policy, req); err != nil {
+            return nil, err
+        }
+    }
+    return a.tx.InsertBooking(ctx, roomID, req, status, units, nil)
+}
+
+// open reports whether the room with policy p is open over [start, end)
+func (a *Allocation) open(roomID int, p *AllocationPolicy, start, end time.Time) (bool, error) {
+    loc, err := a.tx.Location(p.Timezone)
+    if err != nil {
+        return false, fmt.Errorf("invalid time zone of room %d: %w", roomID, err)
+    }
+    return alloc.Open(start, end, loc, p.OpensAt, p.ClosesAt), nil
+}
+
+// checkHours fails with ErrOutsideOperatingHours unless [start, end) lies
+// within one day's operating hours of the room
+func (a *Allocation) checkHours(roomID int, p *AllocationPolicy, start, end time.Time) error {
+    open, err := a.open(roomID, p, start, end)
+    if err != nil {
+        return err
+    }
+    if !open {
+        return fmt.Errorf("%w: room %d is open %s to %s (%s)",
+            ErrOutsideOperatingHours, roomID, alloc.Clock(p.OpensAt), alloc.Clock(p.ClosesAt), p.Timezone)
+    }
+    return nil
+}
+
+// pickRoom picks a room for req, as AllocationTx.PickRoom, that is also open
+// over its window. Operating hours are on each room's own clock, which not
+// every dialect can read, so rooms a backend picks while closed are passed
+// over here, up to maxRoomPicks of them.
+func (a *Allocation) pickRoom(ctx context.Context, req *models.CreateBookingRequest, exclude []int) (int, error) {
+    passed := append([]int{}, exclude...)
+    for attempt := 0; attempt < maxRoomPicks; attempt++ {
+        roomID, err := a.tx.PickRoom(ctx, req, passed)
+        if err != nil {
+            return 0, err
+        }
+        policy, err := a.tx.RoomPolicy(ctx, roomID)
+        if err != nil {
+            return 0, err
+        }
+        open, err := a.open(roomID, policy, req.StartTime, req.EndTime)
+        if err != nil {
+            return 0, err
+        }
+        if open {
+            return roomID, nil
+        }
+        passed = append(passed, roomID)
+    }
+    return 0, ErrNoRoomAvailable
+}
+
+// checkQuota fails with ErrQuotaExceeded when the user's approved and pending
+// hours starting on the booking's day (midnight to midnight in the room's zone),
+// in the subtree the quota covers, plus the new booking exceed the quota
+func (a *Allocation) checkQuota(ctx context.Context, roomID int, p *AllocationPolicy, req *models.CreateBookingRequest) error {
+    if !p.QuotaHours.Valid {
+        return nil
+    }
+
+    loc, err := a.tx.Location(p.Timezone)
+    if err != nil {
+        return fmt.Errorf("invalid time zone of room %d: %w", roomID, err)
+    }
+    local := req.StartTime.In(loc)
+    dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+    used, err := a.tx.QuotaUsage(ctx, req.UserID, p.QuotaGroupID.Int64, dayStart, dayStart.AddDate(0, 0, 1))
+    if err != nil {
+        return err
+    }
+    if used+req.EndTime.Sub(req.StartTime).Hours() > p.QuotaHours.Float64 {
+        return fmt.Errorf("%w: %.2f of %.2f hours already booked that day in group %d",
+            ErrQuotaExceeded, used, p.QuotaHours.Float64, p.QuotaGroupID.Int64)
+    }
+    return nil
+}
+
+// SimulatedBooking is what Simulate decided for one request: the status its
+// booking would be stored with, or the refusal that Create would return
+type SimulatedBooking struct {
+    Status string // "approved", "pending" or "rejected"; empty when Err is set
+    Err    error
+}
+
+// refusals are the errors with which the rules turn a single request down.
+// None of them leaves the transaction unusable, so a batch carries on past them.
+var refusals = []error{ErrRoomNotFound, ErrInsufficientUnits, ErrOutsideOperatingHours, ErrQuotaExceeded}
+
+func isRefusal(err error) bool {
+    for _, r := range refusals {
+        if errors.Is(err, r) {
+            return true
+        }
+    }
+    return false
+}
+
+// Simulate books reqs, which name their rooms, one after the other by the
+// same rules as Create, so each sees the requests before it; the caller rolls
+// the transaction back. Refusals are reported per request, any other failure
+// ends the batch.
+func (a *Allocation) Simulate(ctx context.Context, reqs []models.CreateBookingRequest) ([]SimulatedBooking, error) {
+    // Lock every room up front, in id order, so concurrent batches can't deadlock
+    roomIDs := make([]int, 0, len(reqs))
+    seen := make(map[int]bool)
+    for _, req := range reqs {
+        if !seen[req.RoomID] {
+            seen[req.RoomID] = true
+            roomIDs = append(roomIDs, req.RoomID)
+        }
+    }
+    sort.Ints(roomIDs)
+    unusable := make(map[int]error)
+    for _, id := range roomIDs {
+        if err := a.tx.LockRoom(ctx, id); err != nil {
+            if !isRefusal(err) {
+                return nil, err
+            }
+            unusable[id] = err
+        }
+    }
+
+    outcomes := make([]SimulatedBooking, len(reqs))
+    for i := range reqs {
+        req := &reqs[i]
+        if err := unusable[req.RoomID]; err != nil {
+            outcomes[i].Err = err
+            continue
+        }
+        booking, err := a.book(ctx, req.RoomID, req)
+        switch {
+        case err == nil:
+            outcomes[i].Status = booking.Status
+        case isRefusal(err):
+            outcomes[i].Err = err
+        default:
+            return nil, err
+        }
+    }
+    return outcomes, nil
+}
+
+// lockBooking locks a booking and enforces the caller's expected version.
+// Members of a bundle are refused: they change status with their bundle.
+func (a *Allocation) lockBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    booking, err := a.tx.LockBooking(ctx, bookingID)
+    if err != nil {
+        return nil, err
+    }
+    if version != AnyVersion && booking.Version != version {
+        return nil, fmt.Errorf("booking %d: %w", bookingID, ErrStaleVersion)
+    }
+    if booking.BundleID != nil {
+        return nil, fmt.Errorf("booking %d: %w %d", bookingID, ErrBookingInBundle, *booking.BundleID)
+    }
+    return booking, nil
+}
+
+// Approve approves a pending booking after re-checking its room under the lock
+func (a *Allocation) Approve(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    booking, err := a.lockBooking(ctx, bookingID, version)
+    if err != nil {
+        return nil, err
+    }
+
+    if booking.Status != "pending" {
+        return nil, ErrBookingNotPending
+    }
+
+    if err := a.tx.LockRoom(ctx, booking.RoomID); err != nil {
+        return nil, err
+    }
+
+    // Re-check conflict before approval
+    hasConflict, err := a.tx.Conflicts(ctx, booking.RoomID, booking.StartTime, booking.EndTime, booking.Quantity)
+    if err != nil {
+        return nil, err
+    }
+    if hasConflict {
+        return nil, ErrApprovalConflict
+    }
+
+    return a.tx.SetBookingStatus(ctx, bookingID, "approved")
+}
+
+// Reject rejects a pending booking
+func (a *Allocation) Reject(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    booking, err := a.lockBooking(ctx, bookingID, version)
+    if err != nil {
+        return nil, err
+    }
+    if booking.Status != "pending" {
+        return nil, ErrBookingNotPending
+    }
+
+    return a.tx.SetBookingStatus(ctx, bookingID, "rejected")
+}
+
+// Preempt rejects approved bookings overlapping the given one, most recently
+// created first, until it fits, and approves it. On a room that is not pooled
+// that rejects every overlapping booking.
+func (a *Allocation) Preempt(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    b, err := a.lockBooking(ctx, bookingID, version)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := a.tx.LockRoom(ctx, b.RoomID); err != nil {
+        return nil, err
+    }
+
+    // An approved booking already holds its units and has nothing to preempt
+    preempted := []int{}
+    for b.Status != "approved" {
+        hasConflict, err := a.tx.Conflicts(ctx, b.RoomID, b.StartTime, b.EndTime, b.Quantity)
+        if err != nil {
+            return nil, err
+        }
+        if !hasConflict {
+            break
+        }
+
+        id, ok, err := a.tx.RejectNewest(ctx, b)
+        if err != nil {
+            return nil, err
+        }
+        if !ok {
+            // Nothing left to preempt: the booking wants more units than the room holds
+            return nil, fmt.Errorf("%w: booking %d", ErrInsufficientUnits, bookingID)
+        }
+        preempted = append(preempted, id)
+    }
+    if err := a.tx.RejectBundlesOf(ctx, preempted); err != nil {
+        return nil, err
+    }
+
+    return a.tx.SetBookingStatus(ctx, bookingID, "approved")
+}
+
+// memberRequest is the booking request a bundle member amounts to
+func memberRequest(req *models.CreateBundleRequest, m models.BundleMember) *models.CreateBookingRequest {
+    return &models.CreateBookingRequest{
+        RoomID:       m.RoomID,
+        Quantity:     m.Quantity,
+        UserID:       req.UserID,
+        StartTime:    req.StartTime,
+        EndTime:      req.EndTime,
+        RoomSelector: m.RoomSelector,
+        MinCapacity:  m.MinCapacity,
+        GroupID:      m.GroupID,
+        Selector:     m.Selector,
+    }
+}
+
+// CreateBundle allocates one room per member over the bundle's window. Named
+// rooms are locked in id order, then the remaining members pick free rooms not
+// already in the bundle. If any member conflicts, the bundle and all its
+// bookings are stored as rejected; otherwise they are all approved, or all
+// pending when any member room requires approval.
+func (a *Allocation) CreateBundle(ctx context.Context, req *models.CreateBundleRequest) (*models.Bundle, error) {
+    // Lock named rooms up front, in id order, so concurrent bundles can't deadlock
+    roomIDs := make([]int, len(req.Members))
+    named := []int{}
+    for i, m := range req.Members {
+        if m.RoomID != 0 {
+            roomIDs[i] = m.RoomID
+            named = append(named, m.RoomID)
+        }
+    }
+    sort.Ints(named)
+    for _, id := range named {
+        if err := a.tx.LockRoom(ctx, id); err != nil {
+            return nil, err
+        }
+    }
+
+    // Picking skips locked rooms rather than waiting, so picking after the
+    // named locks can't deadlock either
+    taken := append([]int{}, named...)
+    for i, m := range req.Members {
+        if m.RoomID != 0 {
+            continue
+        }
+        id, err := a.pickRoom(ctx, memberRequest(req, m), taken)
+        if err != nil {
+            return nil, fmt.Errorf("bundle member %d: %w", i, err)
+        }
+        roomIDs[i] = id
+        taken = append(taken, id)
+    }
+
+    status := "approved"
+    var conflicting []int
+    policies := make([]*AllocationPolicy, len(roomIDs))
+    for i, id := range roomIDs {
+        var err error
+        if policies[i], err = a.tx.RoomPolicy(ctx, id); err != nil {
+            return nil, err
+        }
+        units := alloc.Units(req.Members[i].Quantity)
+        if units > policies[i].Quantity {
+            return nil, fmt.Errorf("bundle member %d: %w: room %d holds %d", i, ErrInsufficientUnits, id, policies[i].Quantity)
+        }
+        if err := a.checkHours(id, policies[i], req.StartTime, req.EndTime); err != nil {
+            return nil, fmt.Errorf("bundle member %d: %w", i, err)
+        }
+        hasConflict, err := a.tx.Conflicts(ctx, id, req.StartTime, req.EndTime, units)
+        if err != nil {
+            return nil, err
+        }
+        if hasConflict {
+            conflicting = append(conflicting, id)
+            status = "rejected"
+        }
+        if policies[i].RequiresApproval && status == "approved" {
+            status = "pending"
+        }
+    }
+
+    bundle, err := a.tx.InsertBundle(ctx, req, status)
+    if err != nil {
+        return nil, err
+    }
+    bundle.ConflictingRoomIDs = conflicting
+
+    // Members are inserted one by one so each quota check counts the
+    // members before it
+    bundle.Bookings = make([]models.Booking, len(roomIDs))
+    for i, id := range roomIDs {
+        member := memberRequest(req, req.Members[i])
+        if status != "rejected" {
+            if err := a.checkQuota(ctx, id, policies[i], member); err != nil {
+                return nil, err
+            }
+        }
+        booking, err := a.tx.InsertBooking(ctx, id, member, status, alloc.Units(member.Quantity), &bundle.ID)
+        if err != nil {
+            return nil, err
+        }
+        bundle.Bookings[i] = *booking
+    }
+    return bundle, nil
+}
+
+// lockPendingBundle locks a pending bundle at version (or AnyVersion) and
+// returns its members in room id order
+func (a *Allocation) lockPendingBundle(ctx context.Context, id, version int) (*models.Bundle, []models.Booking, error) {
+    bundle, err := a.tx.LockBundle(ctx, id)
+    if err != nil {
+        return nil, nil, err
+    }
+    if version != AnyVersion && bundle.Version != version {
+        return nil, nil, fmt.Errorf("booking bundle %d: %w", id, ErrStaleVersion)
+    }
+    if bundle.Status != "pending" {
+        return nil, nil, fmt.Errorf("booking bundle %d: %w", id, ErrBundleNotPending)
+    }
+    members := append([]models.Booking{}, bundle.Bookings...)
+    sort.Slice(members, func(i, j int) bool { return members[i].RoomID < members[j].RoomID })
+    return bundle, members, nil
+}
+
+// ApproveBundle approves a pending bundle after re-checking every member room
+// under its lock; a conflict on any member leaves the whole bundle pending
+func (a *Allocation) ApproveBundle(ctx context.Context, id, version int) error {
+    bundle, members, err := a.lockPendingBundle(ctx, id, version)
+    if err != nil {
+        return err
+    }
+    for _, m := range members {
+        if err := a.tx.LockRoom(ctx, m.RoomID); err != nil {
+            return err
+        }
+        hasConflict, err := a.tx.Conflicts(ctx, m.RoomID, bundle.StartTime, bundle.EndTime, m.Quantity)
+        if err != nil {
+            return err
+        }
+        if hasConflict {
+            return fmt.Errorf("conflict detected on room %d, cannot approve", m.RoomID)
+        }
+    }
+    return a.tx.SetBundleStatus(ctx, id, "approved")
+}
+
+// RejectBundle rejects a pending bundle with all its bookings
+func (a *Allocation) RejectBundle(ctx context.Context, id, version int) error {
+    if _, _, err := a.lockPendingBundle(ctx, id, version); err != nil {
+        return err
+    }
+    return a.tx.SetBundleStatus(ctx, id, "rejected")
+}
@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+var (
+    // ErrBundleNotFound is returned when no bundle has the requested id
+    ErrBundleNotFound = errors.New("booking bundle not found")
+    // ErrBundleNotPending is returned when approving or rejecting a decided bundle
+    ErrBundleNotPending = errors.New("booking bundle is not pending")
+    // ErrBookingInBundle refuses status changes to a single member of a bundle
+    ErrBookingInBundle = errors.New("booking is a member of bundle")
+)
+
+const bundleColumns = `id, user_id, start_time, end_time, status, created_at, updated_at, version`
+
+func scanBundle(row rowScanner, b *models.Bundle) error {
+    return row.Scan(
+        &b.ID,
+        &b.UserID,
+        &b.StartTime,
+        &b.EndTime,
+        &b.Status,
+        &b.CreatedAt,
+        &b.UpdatedAt,
+        &b.Version,
+    )
+}
+
+// CreateBundle allocates one room per member over the bundle's window in a
+// single transaction, as Allocation.CreateBundle describes. A rejected bundle
+// is returned with ErrBookingConflict.
+func (r *BookingRepository) CreateBundle(ctx context.Context, req *models.CreateBundleRequest) (*models.Bundle, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
+    defer cancel()
+    
+    var bundle *models.Bundle
+    err := r.allocate(ctx, "create_bundle", func(a *Allocation) (err error) {
+        bundle, err = a.CreateBundle(ctx, req)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    if bundle.Status == "rejected" {
+        return bundle, ErrBookingConflict
+    }
+    return bundle, nil
+}
+
+// InsertBundle stores a bundle without its members
+func (t *allocTx) InsertBundle(ctx context.Context, req *models.CreateBundleRequest, status string) (*models.Bundle, error) {
+    query := `
+        INSERT INTO booking_bundles (user_id, start_time, end_time, status)
+        VALUES ($1, $2, $3, $4)
+        RETURNING ` + bundleColumns
+    ctx, span := startSpan(ctx, "booking_bundles.insert", query)
+    var bundle models.Bundle
+    err := scanBundle(t.tx.QueryRowContext(ctx, query, req.UserID, req.StartTime, req.EndTime, status), &bundle)
+    endSpan(span, err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to insert booking bundle: %w", err)
+    }
+    return &bundle, nil
+}
+
+// GetBundle fetches a bundle with its member bookings
+func (r *BookingRepository) GetBundle(ctx context.Context, id int) (*models.Bundle, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    query := `SELECT ` + bundleColumns + ` FROM booking_bundles WHERE id = $1`
+    getCtx, span := startSpan(ctx, "booking_bundles.get", query)
+    var bundle models.Bundle
+    err := scanBundle(r.db.DB.QueryRowContext(getCtx, query, id), &bundle)
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w with id: %d", ErrBundleNotFound, id)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch booking bundle: %w", err)
+    }
+    
+    query = `SELECT ` + bookingColumns + ` FROM bookings b WHERE b.bundle_id = $1 ORDER BY b.id`
+    listCtx, span := startSpan(ctx, "bookings.list_bundle", query)
+    rows, err := r.db.DB.QueryContext(listCtx, query, id)
+    if err != nil {
+        endSpan(span, err)
+        return nil, fmt.Errorf("failed to fetch bundle bookings: %w", err)
+    }
+    defer span.End()
+    defer rows.Close()
+    
+    bundle.Bookings = []models.Booking{}
+    for rows.Next() {
+        var b models.Booking
+        if err := scanBooking(rows, &b); err != nil {
+            return nil, fmt.Errorf("failed to scan booking: %w", err)
+        }
+        bundle.Bookings = append(bundle.Bookings, b)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return &bundle, nil
+}
+
+// LockBundle locks a bundle and reads the room and units of its members
+func (t *allocTx) LockBundle(ctx context.Context, id int) (*models.Bundle, error) {
+    query := `SELECT ` + bundleColumns + ` FROM booking_bundles WHERE id = $1 FOR UPDATE`
+    lockCtx, span := startSpan(ctx, "booking_bundles.lock", query)
+    var bundle models.Bundle
+    err := scanBundle(t.tx.QueryRowContext(lockCtx, query, id), &bundle)
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w with id: %d", ErrBundleNotFound, id)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to lock booking bundle: %w", err)
+    }
+    
+    query = `SELECT room_id, quantity FROM bookings WHERE bundle_id = $1 ORDER BY room_id`
+    roomsCtx, span := startSpan(ctx, "bookings.bundle_rooms", query)
+    rows, err := t.tx.QueryContext(roomsCtx, query, id)
+    if err != nil {
+        endSpan(span, err)
+        return nil, fmt.Errorf("failed to read bundle rooms: %w", err)
+    }
+    defer span.End()
+    defer rows.Close()
+    
+    bundle.Bookings = []models.Booking{}
+    for rows.Next() {
+        var m models.Booking
+        if err := rows.Scan(&m.RoomID, &m.Quantity); err != nil {
+            return nil, err
+        }
+        bundle.Bookings = append(bundle.Bookings, m)
+    }
+    return &bundle, rows.Err()
+}
+
+// SetBundleStatus moves a locked bundle and all its bookings to status
+func (t *allocTx) SetBundleStatus(ctx context.Context, id int, status string) error {
+    for _, query := range []string{
+        `UPDATE bookings SET status = $2 WHERE bundle_id = $1`,
+        `UPDATE booking_bundles SET status = $2 WHERE id = $1`,
+    } {
+        execCtx, span := startSpan(ctx, "booking_bundles.set_status", query)
+        _, err := t.tx.ExecContext(execCtx, query, id, status)
+        endSpan(span, err)
+        if err != nil {
+            return fmt.Errorf("failed to mark booking bundle %s: %w", status, err)
+        }
+    }
+    return nil
+}
+
+// ApproveBundle approves a pending bundle after re-checking every member room
+// under its lock; a conflict on any member leaves the whole bundle pending
+func (r *BookingRepository) ApproveBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Allocation)
+    defer cancel()
+    
+    err := r.allocate(ctx, "approve_bundle", func(a *Allocation) error {
+        return a.ApproveBundle(ctx, id, version)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return r.GetBundle(ctx, id)
+}
+
+// RejectBundle rejects a pending bundle with all its bookings
+func (r *BookingRepository) RejectBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    err := r.allocate(ctx, "reject_bundle", func(a *Allocation) error {
+        return a.RejectBundle(ctx, id, version)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return r.GetBundle(ctx, id)
+}
+
+// rejectBundlesOf rejects every bundle one of bookingIDs belongs to, with the
+// rest of its bookings, so that no bundle is ever left partly allocated
+func rejectBundlesOf(ctx context.Context, tx *sql.Tx, bookingIDs []int) error {
+    if len(bookingIDs) == 0 {
+        return nil
+    }
+    
+    query := `
+        WITH bundles AS (
+            UPDATE booking_bundles SET status = 'rejected'
+            WHERE status <> 'rejected'
+              AND id IN (SELECT bundle_id FROM bookings WHERE id = ANY($1::int[]))
+            RETURNING id
+        )
+        UPDATE bookings SET status = 'rejected'
+        WHERE status <> 'rejected' AND bundle_id IN (SELECT id FROM bundles)
+    `
+    ctx, span := startSpan(ctx, "booking_bundles.cascade_reject", query)
+    _, err := tx.ExecContext(ctx, query, pq.Array(bookingIDs))
+    endSpan(span, err)
+    if err != nil {
+        return fmt.Errorf("failed to reject bundles: %w", err)
+    }
+    return nil
+}
+
+// collectIDs reads a single integer column from every row of a query
+func collectIDs(rows *sql.Rows, err error) ([]int, error) {
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    ids := []int{}
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
@@ -3,11 +3,18 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"runtime"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+
+	"github.com/indraprhmbd/allocra/internal/metrics"
 	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
 )
 
 type BookingRepository struct {
@@ -18,193 +25,440 @@ func NewBookingRepository(db *Database) *BookingRepository {
     return &BookingRepository{db: db}
 }
 
-// CheckConflict detects time range overlap for approved bookings
-// Conflict exists when: existing.start_time < new_end AND existing.end_time > new_start
-func (r *BookingRepository) CheckConflict(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time) (bool, error) {
-    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+// ErrBookingNotFound is returned when no booking has the requested id
+var ErrBookingNotFound = errors.New("booking not found")
+
+// bookingColumns is the select list read by scanBooking, over the alias b
+const bookingColumns = `b.id, b.room_id, b.user_id, b.start_time, b.end_time, b.status, b.created_at, b.updated_at, b.version, b.quantity, b.bundle_id`
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// scanBooking reads bookingColumns, followed by any extra selected columns
+func scanBooking(row rowScanner, b *models.Booking, extra ...interface{}) error {
+    var bundleID sql.NullInt64
+    dest := []interface{}{
+        &b.ID,
+        &b.RoomID,
+        &b.UserID,
+        &b.StartTime,
+        &b.EndTime,
+        &b.Status,
+        &b.CreatedAt,
+        &b.UpdatedAt,
+        &b.Version,
+        &b.Quantity,
+        &bundleID,
+    }
+    if err := row.Scan(append(dest, extra...)...); err != nil {
+        return err
+    }
+    b.BundleID = nil
+    if bundleID.Valid {
+        id := int(bundleID.Int64)
+        b.BundleID = &id
+    }
+    return nil
+}
+
+// GetByID fetches a single booking with its room name
+func (r *BookingRepository) GetByID(ctx context.Context, id int) (*models.Booking, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
     defer cancel()
     
-    // This query uses the composite index idx_bookings_room_time
     query := `
-        SELECT 1
-        FROM bookings
-        WHERE room_id = $1
-          AND status = 'approved'
-          AND start_time < $3
-          AND end_time > $2
-        LIMIT 1
+        SELECT ` + bookingColumns + `, r.name
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+        WHERE b.id = $1
     `
     
-    var exists int
-    err := tx.QueryRowContext(ctx, query, roomID, start, end).Scan(&exists)
-    
+    ctx, span := startSpan(ctx, "bookings.get", query)
+    var booking models.Booking
+    err := scanBooking(r.db.DB.QueryRowContext(ctx, query, id), &booking, &booking.RoomName)
+    endSpan(span, err)
     if err == sql.ErrNoRows {
-        return false, nil // No conflict
+        return nil, fmt.Errorf("%w with id: %d", ErrBookingNotFound, id)
     }
     if err != nil {
-        return false, fmt.Errorf("conflict check failed: %w", err)
+        return nil, fmt.Errorf("failed to fetch booking: %w", err)
     }
     
-    return true, nil // Conflict exists
+    return &booking, nil
 }
 
-// CreateWithTransaction creates a booking within a transaction
-// Transaction boundary: conflict check + insert must be atomic
-func (r *BookingRepository) CreateWithTransaction(ctx context.Context, req *models.CreateBookingRequest) (*models.Booking, error) {
-    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+// ErrBookingConflict is returned alongside the persisted (rejected) booking
+// when the requested window overlaps an approved allocation
+var ErrBookingConflict = errors.New("booking conflict detected")
+
+// ErrBookingNotPending is returned when approving or rejecting a booking that
+// has already been decided
+var ErrBookingNotPending = errors.New("booking is not pending")
+
+// ErrApprovalConflict is returned when a pending booking no longer fits next
+// to the room's approved bookings
+var ErrApprovalConflict = errors.New("conflict detected, cannot approve")
+
+// CheckConflict detects whether quantity more units of a room can't be held
+// over [start, end) next to its approved bookings.
+// Conflict exists when: peak units held during the window + quantity > room quantity.
+// Rooms that are not pooled hold one unit, so for them any overlap conflicts:
+// existing.start_time < new_end AND existing.end_time > new_start
+func (r *BookingRepository) CheckConflict(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time, quantity int) (bool, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.ConflictCheck)
     defer cancel()
     
-    tx, err := r.db.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+    // peak_usage scans the window through the composite index idx_bookings_room_time
+    query := `SELECT peak_usage(id, $2, $3) + $4 > quantity FROM rooms WHERE id = $1`
+    
+    ctx, span := startSpan(ctx, "bookings.conflict_check", query)
+    began := time.Now()
+    var conflict bool
+    err := tx.QueryRowContext(ctx, query, roomID, start, end, alloc.Units(quantity)).Scan(&conflict)
+    metrics.ConflictCheckDuration.Observe(time.Since(began).Seconds())
+    endSpan(span, err)
+    
+    if err == sql.ErrNoRows {
+        return false, nil // No such room, nothing to conflict with
+    }
     if err != nil {
-        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+        return false, fmt.Errorf("conflict check failed: %w", err)
     }
-    defer tx.Rollback()
+    
+    return conflict, nil
+}
+
+// ErrInsufficientUnits is returned when a booking asks for more units than its
+// room holds in total, which no amount of waiting would free
+var ErrInsufficientUnits = errors.New("room does not hold that many units")
+
+// ErrOutsideOperatingHours is returned when a booking does not fit within one
+// day's operating hours of its room, on the room's clock
+var ErrOutsideOperatingHours = errors.New("outside the operating hours of the room")
+
+// allocTx is the AllocationTx of a Postgres transaction. Allocation decisions
+// on a room are serialized by the FOR UPDATE lock on its row.
+type allocTx struct {
+    r  *BookingRepository
+    tx *sql.Tx
+}
 
-    // Lock room to prevent race conditions (double bookings)
-    if _, err := tx.ExecContext(ctx, "SELECT id FROM rooms WHERE id = $1 FOR UPDATE", req.RoomID); err != nil {
-        return nil, fmt.Errorf("failed to lock room: %w", err)
+// allocate runs fn on the allocation rules inside a transaction
+func (r *BookingRepository) allocate(ctx context.Context, operation string, fn func(a *Allocation) error) error {
+    return r.db.runInTx(ctx, operation, func(tx *sql.Tx) error {
+        return fn(NewAllocation(&allocTx{r: r, tx: tx}))
+    })
+}
+
+func (t *allocTx) Conflicts(ctx context.Context, roomID int, start, end time.Time, units int) (bool, error) {
+    return t.r.CheckConflict(ctx, t.tx, roomID, start, end, units)
+}
+
+func (t *allocTx) Location(name string) (*time.Location, error) {
+    return t.r.db.zones.Load(name)
+}
+
+// LockRoom takes the row lock that serializes all allocation decisions on a room
+func (t *allocTx) LockRoom(ctx context.Context, roomID int) error {
+    query := "SELECT archived_at IS NULL FROM rooms WHERE id = $1 FOR UPDATE"
+    ctx, span := startSpan(ctx, "rooms.lock", query)
+    began := time.Now()
+    var active bool
+    err := t.tx.QueryRowContext(ctx, query, roomID).Scan(&active)
+    metrics.RoomLockWait.Observe(time.Since(began).Seconds())
+    endSpan(span, err)
+    if err == sql.ErrNoRows || (err == nil && !active) {
+        return fmt.Errorf("%w with id: %d", ErrRoomNotFound, roomID)
+    }
+    if err != nil {
+        return fmt.Errorf("failed to lock room: %w", err)
+    }
+    return nil
+}
+
+func (t *allocTx) InsertBooking(ctx context.Context, roomID int, req *models.CreateBookingRequest, status string, units int, bundleID *int) (*models.Booking, error) {
+    query := `
+        INSERT INTO bookings AS b (room_id, user_id, start_time, end_time, status, quantity)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING ` + bookingColumns
+    args := []interface{}{roomID, req.UserID, req.StartTime, req.EndTime, status, units}
+    if bundleID != nil {
+        query = `
+            INSERT INTO bookings AS b (room_id, user_id, start_time, end_time, status, quantity, bundle_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            RETURNING ` + bookingColumns
+        args = append(args, *bundleID)
     }
     
-    hasConflict, err := r.CheckConflict(ctx, tx, req.RoomID, req.StartTime, req.EndTime)
+    ctx, span := startSpan(ctx, "bookings.insert", query)
+    var booking models.Booking
+    err := scanBooking(t.tx.QueryRowContext(ctx, query, args...), &booking)
+    endSpan(span, err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to insert booking: %w", err)
+    }
+    return &booking, nil
+}
+
+// CreateWithTransaction creates a booking within a transaction
+// Transaction boundary: conflict check + insert must be atomic
+func (r *BookingRepository) CreateWithTransaction(ctx context.Context, req *models.CreateBookingRequest) (*models.Booking, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Allocation)
+    defer cancel()
+    
+    var booking *models.Booking
+    err := r.allocate(ctx, "create_booking", func(a *Allocation) (err error) {
+        booking, err = a.Create(ctx, req)
+        return err
+    })
     if err != nil {
         return nil, err
     }
     
-    status := "approved"
-    if hasConflict {
-        status = "rejected"
+    if booking.Status == "rejected" {
+        return booking, ErrBookingConflict
     }
+    
+    return booking, nil
+}
+
+// ErrQuotaExceeded is returned when a booking would take its user past the
+// daily quota a resource group sets for its subtree
+var ErrQuotaExceeded = errors.New("daily booking quota exceeded")
+
+// RoomPolicy reads the effective policy of a locked room
+func (t *allocTx) RoomPolicy(ctx context.Context, roomID int) (*AllocationPolicy, error) {
     query := `
-        INSERT INTO bookings (room_id, user_id, start_time, end_time, status)
-        VALUES ($1, $2, $3, $4, $5)
-        RETURNING id, room_id, user_id, start_time, end_time, status, created_at
+        SELECT r.quantity, COALESCE(gp.requires_approval, FALSE), gp.daily_quota_hours, gp.quota_group_id, r.timezone,
+               COALESCE(r.opens_at, gp.opens_at, '00:00'), COALESCE(r.closes_at, gp.closes_at, '24:00')
+        FROM rooms r
+        LEFT JOIN group_policies gp ON gp.group_id = r.group_id
+        WHERE r.id = $1
     `
-    
-    var booking models.Booking
-    err = tx.QueryRowContext(ctx, query,
-        req.RoomID,
-        req.UserID,
-        req.StartTime,
-        req.EndTime,
-        status,
-    ).Scan(
-        &booking.ID,
-        &booking.RoomID,
-        &booking.UserID,
-        &booking.StartTime,
-        &booking.EndTime,
-        &booking.Status,
-        &booking.CreatedAt,
-    )
-    
+    ctx, span := startSpan(ctx, "rooms.policy", query)
+    var p AllocationPolicy
+    err := t.tx.QueryRowContext(ctx, query, roomID).Scan(&p.Quantity, &p.RequiresApproval, &p.QuotaHours, &p.QuotaGroupID, &p.Timezone, &p.OpensAt, &p.ClosesAt)
+    endSpan(span, err)
     if err != nil {
-        return nil, fmt.Errorf("failed to insert booking: %w", err)
+        return nil, fmt.Errorf("failed to read room policy: %w", err)
     }
-    
-    if err = tx.Commit(); err != nil {
-        return nil, fmt.Errorf("failed to commit transaction: %w", err)
+    return &p, nil
+}
+
+// QuotaUsage sums the user's booked hours in the quota's subtree
+func (t *allocTx) QuotaUsage(ctx context.Context, userID int, groupID int64, from, to time.Time) (float64, error) {
+    // The room lock doesn't cover the user's bookings in sibling rooms, so
+    // their allocations are serialized on the user row instead
+    query := `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`
+    lockCtx, span := startSpan(ctx, "users.lock", query)
+    var one int
+    err := t.tx.QueryRowContext(lockCtx, query, userID).Scan(&one)
+    endSpan(span, err)
+    if err != nil && err != sql.ErrNoRows {
+        return 0, fmt.Errorf("failed to lock user: %w", err)
     }
     
-    if hasConflict {
-        return &booking, fmt.Errorf("booking conflict detected") 
+    query = `
+        SELECT COALESCE(SUM(EXTRACT(EPOCH FROM (b.end_time - b.start_time))), 0) / 3600
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+        WHERE b.user_id = $1
+          AND b.status IN ('approved', 'pending')
+          AND b.start_time >= $2 AND b.start_time < $3
+          AND r.group_id IN (SELECT descendant_id FROM group_closure WHERE ancestor_id = $4)
+    `
+    sumCtx, span := startSpan(ctx, "bookings.quota_usage", query)
+    var used float64
+    err = t.tx.QueryRowContext(sumCtx, query, userID, from, to, groupID).Scan(&used)
+    endSpan(span, err)
+    if err != nil {
+        return 0, fmt.Errorf("failed to read quota usage: %w", err)
     }
+    return used, nil
+}
+
+// maxRoomPicks bounds how many candidates auto selection tries when rooms
+// that looked free turn out to be taken or closed once locked
+const maxRoomPicks = 5
+
+// ErrNoRoomAvailable is returned when auto selection finds no free matching room
+var ErrNoRoomAvailable = errors.New("no matching room is free for the requested time")
+
+// PickRoom chooses the smallest online room matching the request's selector,
+// capacity and group that is open and has enough free units, and locks it.
+// Rooms locked by concurrent allocations are skipped rather than waited for,
+// and every pick is re-checked under the lock. Rooms in exclude are never picked.
+func (t *allocTx) PickRoom(ctx context.Context, req *models.CreateBookingRequest, exclude []int) (int, error) {
+    units := alloc.Units(req.Quantity)
+    args := []interface{}{req.StartTime, req.EndTime, req.MinCapacity, pq.Array([]int{}), req.GroupID, units}
+    where, args := selectorClause(req.Selector, "rooms.labels", args)
+    // Open is read on the room's wall clock as alloc.Open reads it, so that
+    // closed rooms are never locked
+    query := `
+        SELECT rooms.id FROM rooms
+        LEFT JOIN group_policies gp ON gp.group_id = rooms.group_id
+        CROSS JOIN LATERAL (
+            SELECT COALESCE(rooms.opens_at, gp.opens_at, '00:00') AS opens_at,
+                   COALESCE(rooms.closes_at, gp.closes_at, '24:00') AS closes_at,
+                   $1::timestamptz AT TIME ZONE rooms.timezone AS start_at,
+                   $2::timestamptz AT TIME ZONE rooms.timezone AS end_at
+        ) h
+        WHERE rooms.archived_at IS NULL
+          AND rooms.status = 'online'
+          AND rooms.capacity >= $3
+          AND NOT (rooms.id = ANY($4::int[]))
+          AND ` + inGroup("rooms.group_id", 5) + `
+          AND ` + where + `
+          AND ((h.opens_at = '00:00' AND h.closes_at = '24:00')
+               OR (h.start_at::time >= h.opens_at AND h.end_at <= h.start_at::date + h.closes_at))
+          AND rooms.quantity - peak_usage(rooms.id, $1, $2) >= $6
+        ORDER BY rooms.capacity, rooms.id
+        LIMIT 1
+        FOR UPDATE OF rooms SKIP LOCKED
+    `
     
-    return &booking, nil
+    tried := append([]int{}, exclude...) // a nil slice would encode as NULL and match nothing
+    for attempt := 0; attempt < maxRoomPicks; attempt++ {
+        args[3] = pq.Array(tried)
+        pickCtx, span := startSpan(ctx, "rooms.pick", query)
+        var roomID int
+        err := t.tx.QueryRowContext(pickCtx, query, args...).Scan(&roomID)
+        endSpan(span, err)
+        if err == sql.ErrNoRows {
+            return 0, ErrNoRoomAvailable
+        }
+        if err != nil {
+            return 0, fmt.Errorf("failed to pick a room: %w", err)
+        }
+        
+        taken, err := t.Conflicts(ctx, roomID, req.StartTime, req.EndTime, units)
+        if err != nil {
+            return 0, err
+        }
+        if !taken {
+            return roomID, nil
+        }
+        tried = append(tried, roomID)
+    }
+    return 0, ErrNoRoomAvailable
 }
 
-// ApproveBooking updates booking status to 'approved' with conflict re-check
-func (r *BookingRepository) ApproveBooking(ctx context.Context, bookingID int) error {
-    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+// SimulateBatch books reqs by the rules of CreateWithTransaction, in order,
+// inside a single transaction that is always rolled back; see
+// Allocation.Simulate for the outcomes.
+func (r *BookingRepository) SimulateBatch(ctx context.Context, reqs []models.CreateBookingRequest) (outcomes []SimulatedBooking, err error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Batch)
     defer cancel()
     
+    ctx, span := startSpan(ctx, "tx.simulate_batch", "")
+    defer func() { endSpan(span, err) }()
+    
     tx, err := r.db.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
     if err != nil {
-        return fmt.Errorf("failed to begin transaction: %w", err)
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
     }
     defer tx.Rollback()
     
+    return NewAllocation(&allocTx{r: r, tx: tx}).Simulate(ctx, reqs)
+}
+
+// LockBooking reads a booking FOR UPDATE
+func (t *allocTx) LockBooking(ctx context.Context, bookingID int) (*models.Booking, error) {
+    query := `SELECT room_id, start_time, end_time, status, version, quantity, bundle_id FROM bookings WHERE id = $1 FOR UPDATE`
+    ctx, span := startSpan(ctx, "bookings.lock", query)
     var booking models.Booking
-    query := `SELECT room_id, start_time, end_time, status FROM bookings WHERE id = $1 FOR UPDATE`
-    err = tx.QueryRowContext(ctx, query, bookingID).Scan(
+    var bundleID sql.NullInt64
+    err := t.tx.QueryRowContext(ctx, query, bookingID).Scan(
         &booking.RoomID,
         &booking.StartTime,
         &booking.EndTime,
         &booking.Status,
+        &booking.Version,
+        &booking.Quantity,
+        &bundleID,
     )
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w with id: %d", ErrBookingNotFound, bookingID)
+    }
     if err != nil {
-        return fmt.Errorf("failed to fetch booking: %w", err)
+        return nil, fmt.Errorf("failed to fetch booking: %w", err)
     }
-    
-    if booking.Status != "pending" {
-        return fmt.Errorf("booking is not pending")
+    booking.ID = bookingID
+    if bundleID.Valid {
+        id := int(bundleID.Int64)
+        booking.BundleID = &id
     }
-    
-    // Re-check conflict before approval
-    hasConflict, err := r.CheckConflict(ctx, tx, booking.RoomID, booking.StartTime, booking.EndTime)
+    return &booking, nil
+}
+
+// SetBookingStatus writes the new status of a locked booking and returns the row
+func (t *allocTx) SetBookingStatus(ctx context.Context, bookingID int, status string) (*models.Booking, error) {
+    query := `UPDATE bookings AS b SET status = $2 WHERE b.id = $1 RETURNING ` + bookingColumns
+    ctx, span := startSpan(ctx, "bookings.set_status", query)
+    var booking models.Booking
+    err := scanBooking(t.tx.QueryRowContext(ctx, query, bookingID, status), &booking)
+    endSpan(span, err)
     if err != nil {
-        return err
-    }
-    if hasConflict {
-        return fmt.Errorf("conflict detected, cannot approve")
+        return nil, fmt.Errorf("failed to mark booking %s: %w", status, err)
     }
+    return &booking, nil
+}
+
+// ApproveBooking updates booking status to 'approved' with conflict re-check
+func (r *BookingRepository) ApproveBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Allocation)
+    defer cancel()
     
-    updateQuery := `UPDATE bookings SET status = 'approved' WHERE id = $1`
-    _, err = tx.ExecContext(ctx, updateQuery, bookingID)
+    var approved *models.Booking
+    err := r.allocate(ctx, "approve_booking", func(a *Allocation) (err error) {
+        approved, err = a.Approve(ctx, bookingID, version)
+        return err
+    })
     if err != nil {
-        return fmt.Errorf("failed to approve booking: %w", err)
+        return nil, err
     }
-    
-    return tx.Commit()
+    return approved, nil
 }
 
 // RejectBooking updates booking status to 'rejected'
-func (r *BookingRepository) RejectBooking(ctx context.Context, bookingID int) error {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+func (r *BookingRepository) RejectBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
     defer cancel()
     
-    query := `UPDATE bookings SET status = 'rejected' WHERE id = $1 AND status = 'pending'`
-    result, err := r.db.DB.ExecContext(ctx, query, bookingID)
+    var rejected *models.Booking
+    err := r.allocate(ctx, "reject_booking", func(a *Allocation) (err error) {
+        rejected, err = a.Reject(ctx, bookingID, version)
+        return err
+    })
     if err != nil {
-        return fmt.Errorf("failed to reject booking: %w", err)
-    }
-    
-    rows, _ := result.RowsAffected()
-    if rows == 0 {
-        return fmt.Errorf("booking not found or not pending")
+        return nil, err
     }
-    
-    return nil
+    return rejected, nil
 }
 
 // GetAll fetches all bookings across all rooms
 func (r *BookingRepository) GetAll(ctx context.Context) ([]models.Booking, error) {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
     defer cancel()
     
-    query := `
-        SELECT id, room_id, user_id, start_time, end_time, status, created_at
-        FROM bookings
-        ORDER BY start_time DESC
-    `
+    query := `SELECT ` + bookingColumns + ` FROM bookings b ORDER BY b.start_time DESC`
     
+    ctx, span := startSpan(ctx, "bookings.list", query)
     rows, err := r.db.DB.QueryContext(ctx, query)
     if err != nil {
+        endSpan(span, err)
         return nil, fmt.Errorf("failed to fetch all bookings: %w", err)
     }
+    defer span.End()
     defer rows.Close()
     
     var bookings []models.Booking
     for rows.Next() {
         var booking models.Booking
-        if err := rows.Scan(
-            &booking.ID,
-            &booking.RoomID,
-            &booking.UserID,
-            &booking.StartTime,
-            &booking.EndTime,
-            &booking.Status,
-            &booking.CreatedAt,
-        ); err != nil {
+        if err := scanBooking(rows, &booking); err != nil {
             return nil, fmt.Errorf("failed to scan booking: %w", err)
         }
         bookings = append(bookings, booking)
@@ -213,132 +467,572 @@ func (r *BookingRepository) GetAll(ctx context.Context) ([]models.Booking, error
     return bookings, rows.Err()
 }
 
-// GetByRoomID fetches bookings for a specific room
-func (r *BookingRepository) GetByRoomID(ctx context.Context, roomID int) ([]models.Booking, error) {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// GetMonthlyUsage aggregates approved bookings starting in [from, to)
+func (r *BookingRepository) GetMonthlyUsage(ctx context.Context, from, to time.Time) ([]models.MonthlyUsageReport, error) {
+    var reports []models.MonthlyUsageReport
+    err := r.StreamMonthlyUsage(ctx, from, to, func(report *models.MonthlyUsageReport) error {
+        reports = append(reports, *report)
+        return nil
+    })
+    return reports, err
+}
+
+// StreamMonthlyUsage hands each row of the monthly usage report to fn as it is scanned
+func (r *BookingRepository) StreamMonthlyUsage(ctx context.Context, from, to time.Time, fn func(*models.MonthlyUsageReport) error) error {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Report)
     defer cancel()
     
     query := `
-        SELECT id, room_id, user_id, start_time, end_time, status, created_at
-        FROM bookings
-        WHERE room_id = $1
-        ORDER BY start_time DESC
+        SELECT 
+            b.room_id,
+            r.name as room_name,
+            COUNT(*) as total_bookings,
+            SUM(EXTRACT(EPOCH FROM (b.end_time - b.start_time))/3600) as total_hours
+        FROM bookings b
+        JOIN rooms r ON b.room_id = r.id
+        WHERE b.status = 'approved'
+          AND b.start_time >= $1 AND b.start_time < $2
+        GROUP BY b.room_id, r.name
+        ORDER BY total_hours DESC
     `
     
-    rows, err := r.db.DB.QueryContext(ctx, query, roomID)
+    ctx, span := startSpan(ctx, "bookings.monthly_usage", query)
+    rows, err := r.db.DB.QueryContext(ctx, query, from, to)
     if err != nil {
-        return nil, fmt.Errorf("failed to fetch bookings: %w", err)
+        endSpan(span, err)
+        return fmt.Errorf("failed to fetch monthly usage: %w", err)
     }
+    defer span.End()
     defer rows.Close()
     
-    var bookings []models.Booking
     for rows.Next() {
-        var booking models.Booking
+        var report models.MonthlyUsageReport
         if err := rows.Scan(
-            &booking.ID,
-            &booking.RoomID,
-            &booking.UserID,
-            &booking.StartTime,
-            &booking.EndTime,
-            &booking.Status,
-            &booking.CreatedAt,
+            &report.RoomID,
+            &report.RoomName,
+            &report.TotalBookings,
+            &report.TotalHours,
         ); err != nil {
-            return nil, fmt.Errorf("failed to scan booking: %w", err)
+            return fmt.Errorf("failed to scan usage report: %w", err)
+        }
+        if err := fn(&report); err != nil {
+            return err
         }
-        bookings = append(bookings, booking)
     }
     
-    return bookings, rows.Err()
+    return rows.Err()
+}
+
+// GetGroupMonthlyUsage rolls approved bookings starting in [from, to) up to the
+// groups of kind; rooms with no ancestor of that kind are left out
+func (r *BookingRepository) GetGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time) ([]models.GroupUsageReport, error) {
+    var reports []models.GroupUsageReport
+    err := r.StreamGroupMonthlyUsage(ctx, kind, from, to, func(report *models.GroupUsageReport) error {
+        reports = append(reports, *report)
+        return nil
+    })
+    return reports, err
 }
 
-// GetMonthlyUsage aggregates approved bookings for current month
-func (r *BookingRepository) GetMonthlyUsage(ctx context.Context) ([]models.MonthlyUsageReport, error) {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// StreamGroupMonthlyUsage hands each row of the rolled-up monthly usage report to fn
+func (r *BookingRepository) StreamGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time, fn func(*models.GroupUsageReport) error) error {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Report)
     defer cancel()
     
     query := `
-        SELECT 
-            b.room_id,
-            r.name as room_name,
-            COUNT(*) as total_bookings,
-            SUM(EXTRACT(EPOCH FROM (b.end_time - b.start_time))/3600) as total_hours
+        SELECT
+            g.id,
+            g.name,
+            g.kind,
+            COUNT(DISTINCT b.room_id) AS rooms,
+            COUNT(*) AS total_bookings,
+            SUM(EXTRACT(EPOCH FROM (b.end_time - b.start_time))/3600) AS total_hours
         FROM bookings b
-        JOIN rooms r ON b.room_id = r.id
+        JOIN rooms r ON r.id = b.room_id
+        JOIN group_closure c ON c.descendant_id = r.group_id
+        JOIN resource_groups g ON g.id = c.ancestor_id AND g.kind = $1
         WHERE b.status = 'approved'
-          AND date_trunc('month', b.start_time) = date_trunc('month', NOW())
-        GROUP BY b.room_id, r.name
+          AND b.start_time >= $2 AND b.start_time < $3
+        GROUP BY g.id, g.name, g.kind
         ORDER BY total_hours DESC
     `
     
-    rows, err := r.db.DB.QueryContext(ctx, query)
+    ctx, span := startSpan(ctx, "bookings.group_monthly_usage", query)
+    rows, err := r.db.DB.QueryContext(ctx, query, kind, from, to)
     if err != nil {
-        return nil, fmt.Errorf("failed to fetch monthly usage: %w", err)
+        endSpan(span, err)
+        return fmt.Errorf("failed to fetch monthly usage: %w", err)
     }
+    defer span.End()
     defer rows.Close()
     
-    var reports []models.MonthlyUsageReport
     for rows.Next() {
-        var report models.MonthlyUsageReport
+        var report models.GroupUsageReport
         if err := rows.Scan(
-            &report.RoomID,
-            &report.RoomName,
+            &report.GroupID,
+            &report.GroupName,
+            &report.Kind,
+            &report.Rooms,
             &report.TotalBookings,
             &report.TotalHours,
         ); err != nil {
-            return nil, fmt.Errorf("failed to scan usage report: %w", err)
+            return fmt.Errorf("failed to scan usage report: %w", err)
+        }
+        if err := fn(&report); err != nil {
+            return err
         }
-        reports = append(reports, report)
     }
     
-    return reports, rows.Err()
+    return rows.Err()
 }
 
-// PreemptBooking cancels existing approved bookings that conflict and approves the new one
-func (r *BookingRepository) PreemptBooking(ctx context.Context, bookingID int) error {
-    ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+// StreamBookings walks bookings matching f (newest first) and hands each row to
+// fn as it is scanned, so exports never hold the full result set in memory
+func (r *BookingRepository) StreamBookings(ctx context.Context, f models.BookingFilter, fn func(*models.Booking) error) error {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Export)
     defer cancel()
     
-    tx, err := r.db.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+    where, args := bookingFilterClause(f)
+    query := `
+        SELECT ` + bookingColumns + `, r.name
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+    ` + where + `
+        ORDER BY b.start_time DESC
+    `
+    
+    ctx, span := startSpan(ctx, "bookings.stream", query)
+    rows, err := r.db.DB.QueryContext(ctx, query, args...)
     if err != nil {
-        return err
+        endSpan(span, err)
+        return fmt.Errorf("failed to stream bookings: %w", err)
     }
-    defer tx.Rollback()
+    defer span.End()
+    defer rows.Close()
     
-    var b models.Booking
-    err = tx.QueryRowContext(ctx, "SELECT room_id, start_time, end_time FROM bookings WHERE id = $1 FOR UPDATE", bookingID).Scan(
-        &b.RoomID, &b.StartTime, &b.EndTime,
-    )
+    for rows.Next() {
+        var booking models.Booking
+        if err := scanBooking(rows, &booking, &booking.RoomName); err != nil {
+            return fmt.Errorf("failed to scan booking: %w", err)
+        }
+        if err := fn(&booking); err != nil {
+            return err
+        }
+    }
+    
+    return rows.Err()
+}
+
+// ErrInvalidCursor is returned when a pagination cursor is malformed or was
+// issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// bookingSortColumns whitelists the sortable columns; every key is paired with
+// b.id as a tie-breaker so the keyset is unique
+var bookingSortColumns = map[string]string{
+    "start_time": "b.start_time",
+    "created_at": "b.created_at",
+    "id":         "b.id",
+}
+
+// IsBookingSortKey reports whether List can order by key
+func IsBookingSortKey(key string) bool {
+    _, ok := bookingSortColumns[key]
+    return ok
+}
+
+// BookingCursor is the keyset position after the last row of a page. Every
+// storage backend issues the same opaque cursors.
+type BookingCursor struct {
+    Sort  string    `json:"s"`
+    Desc  bool      `json:"d"`
+    Value time.Time `json:"v,omitempty"`
+    ID    int       `json:"id"`
+}
+
+func encodeBookingCursor(c BookingCursor) string {
+    raw, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeBookingCursor(s string) (*BookingCursor, error) {
+    raw, err := base64.RawURLEncoding.DecodeString(s)
     if err != nil {
-        return err
+        return nil, ErrInvalidCursor
+    }
+    var c BookingCursor
+    if err := json.Unmarshal(raw, &c); err != nil {
+        return nil, ErrInvalidCursor
+    }
+    return &c, nil
+}
+
+// ParseBookingCursor decodes q.Cursor, returning nil for the first page and
+// ErrInvalidCursor if it was issued for another sort order
+func ParseBookingCursor(q models.BookingListQuery) (*BookingCursor, error) {
+    if q.Cursor == "" {
+        return nil, nil
+    }
+    cur, err := decodeBookingCursor(q.Cursor)
+    if err != nil {
+        return nil, err
+    }
+    if cur.Sort != q.Sort || cur.Desc != q.Desc {
+        return nil, fmt.Errorf("%w: cursor was issued for a different sort order", ErrInvalidCursor)
+    }
+    return cur, nil
+}
+
+// NextBookingCursor returns the cursor of the page that follows last
+func NextBookingCursor(q models.BookingListQuery, last *models.Booking) string {
+    next := BookingCursor{Sort: q.Sort, Desc: q.Desc, ID: last.ID}
+    switch q.Sort {
+    case "start_time":
+        next.Value = last.StartTime
+    case "created_at":
+        next.Value = last.CreatedAt
+    }
+    return encodeBookingCursor(next)
+}
+
+// List returns one page of bookings matching q.Filter using keyset pagination,
+// together with the total number of matches. Pages stay stable while new
+// bookings are inserted, unlike OFFSET paging.
+func (r *BookingRepository) List(ctx context.Context, q models.BookingListQuery) (*models.BookingPage, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    column := bookingSortColumns[q.Sort]
+    where, args := bookingFilterClause(q.Filter)
+    
+    var total int
+    countQuery := `SELECT COUNT(*) FROM bookings b` + where
+    countCtx, span := startSpan(ctx, "bookings.count", countQuery)
+    err := r.db.DB.QueryRowContext(countCtx, countQuery, args...).Scan(&total)
+    endSpan(span, err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to count bookings: %w", err)
+    }
+    
+    op, dir := ">", "ASC"
+    if q.Desc {
+        op, dir = "<", "DESC"
+    }
+    
+    cur, err := ParseBookingCursor(q)
+    if err != nil {
+        return nil, err
+    }
+    if cur != nil {
+        var keyset string
+        if column == "b.id" {
+            args = append(args, cur.ID)
+            keyset = fmt.Sprintf("b.id %s $%d", op, len(args))
+        } else {
+            args = append(args, cur.Value, cur.ID)
+            keyset = fmt.Sprintf("(%s, b.id) %s ($%d, $%d)", column, op, len(args)-1, len(args))
+        }
+        if where == "" {
+            where = " WHERE " + keyset
+        } else {
+            where += " AND " + keyset
+        }
+    }
+    
+    args = append(args, q.Limit+1)
+    query := `
+        SELECT ` + bookingColumns + `, r.name
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+    ` + where + fmt.Sprintf(`
+        ORDER BY %s %s, b.id %s
+        LIMIT $%d
+    `, column, dir, dir, len(args))
+    
+    ctx, span = startSpan(ctx, "bookings.list", query)
+    rows, err := r.db.DB.QueryContext(ctx, query, args...)
+    if err != nil {
+        endSpan(span, err)
+        return nil, fmt.Errorf("failed to list bookings: %w", err)
+    }
+    defer span.End()
+    defer rows.Close()
+    
+    page := &models.BookingPage{Data: []models.Booking{}, Total: total}
+    for rows.Next() {
+        var booking models.Booking
+        if err := scanBooking(rows, &booking, &booking.RoomName); err != nil {
+            return nil, fmt.Errorf("failed to scan booking: %w", err)
+        }
+        page.Data = append(page.Data, booking)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    
+    if len(page.Data) > q.Limit {
+        page.Data = page.Data[:q.Limit]
+        page.NextCursor = NextBookingCursor(q, &page.Data[len(page.Data)-1])
+    }
+    
+    return page, nil
+}
+
+// bookingFilterClause renders f as a WHERE clause over the alias b
+func bookingFilterClause(f models.BookingFilter) (string, []interface{}) {
+    var conds []string
+    var args []interface{}
+    add := func(cond string, v interface{}) {
+        args = append(args, v)
+        conds = append(conds, fmt.Sprintf(cond, len(args)))
+    }
+    
+    if f.RoomID != nil {
+        add("b.room_id = $%d", *f.RoomID)
+    }
+    if f.UserID != nil {
+        add("b.user_id = $%d", *f.UserID)
+    }
+    if f.Status != "" {
+        add("b.status = $%d", f.Status)
+    }
+    if f.To != nil {
+        add("b.start_time < $%d", *f.To)
+    }
+    if f.From != nil {
+        add("b.end_time > $%d", *f.From)
+    }
+    if f.CreatedAfter != nil {
+        add("b.created_at >= $%d", *f.CreatedAfter)
+    }
+    if f.CreatedBefore != nil {
+        add("b.created_at < $%d", *f.CreatedBefore)
+    }
+    
+    if len(conds) == 0 {
+        return "", nil
+    }
+    return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// utilizationSteps maps report granularities to their bucket width
+var utilizationSteps = map[string]string{
+    "hour": "1 hour",
+    "day":  "1 day",
+    "week": "1 week",
+}
+
+// GetUtilization computes booked hours over available hours for each bucket of q.
+// Available hours come from the operating windows of online rooms, each on its
+// own zone's clock; bookings are clipped to the bucket, the window and the
+// requested range before summing.
+func (r *BookingRepository) GetUtilization(ctx context.Context, q models.UtilizationQuery) ([]models.UtilizationBucket, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Report)
+    defer cancel()
+    
+    step, ok := utilizationSteps[q.Granularity]
+    if !ok {
+        return nil, fmt.Errorf("unsupported granularity: %s", q.Granularity)
     }
     
-    rejectQuery := `
+    query := `
+        WITH buckets AS (
+            -- Buckets are cut on the report zone's wall clock, so a day bucket
+            -- runs midnight to midnight there, DST days included
+            SELECT b AT TIME ZONE $8 AS bucket_start, (b + $3::interval) AT TIME ZONE $8 AS bucket_end
+            FROM generate_series(
+                date_trunc($4, $1::timestamptz AT TIME ZONE $8),
+                ($2::timestamptz AT TIME ZONE $8) - interval '1 microsecond',
+                $3::interval) AS b
+        ),
+        scoped_rooms AS (
+            SELECT r.id, r.status, r.timezone,
+                   COALESCE(r.opens_at, gp.opens_at, '00:00') AS opens_at,
+                   COALESCE(r.closes_at, gp.closes_at, '24:00') AS closes_at
+            FROM rooms r
+            LEFT JOIN group_policies gp ON gp.group_id = r.group_id
+            WHERE ($5::int IS NULL OR r.id = $5)
+              AND ($6::text IS NULL OR r.type = $6)
+              AND ` + inGroup("r.group_id", 7) + `
+        ),
+        windows AS (
+            -- Operating hours are read on each room's own wall clock
+            SELECT r.id AS room_id,
+                   GREATEST((d + r.opens_at) AT TIME ZONE r.timezone, $1::timestamptz) AS win_start,
+                   LEAST((d + r.closes_at) AT TIME ZONE r.timezone, $2::timestamptz) AS win_end
+            FROM scoped_rooms r
+            CROSS JOIN LATERAL generate_series(
+                date_trunc('day', $1::timestamptz AT TIME ZONE r.timezone),
+                $2::timestamptz AT TIME ZONE r.timezone,
+                interval '1 day') AS d
+            WHERE r.status = 'online'
+              AND (d + r.closes_at) AT TIME ZONE r.timezone > $1::timestamptz
+              AND (d + r.opens_at) AT TIME ZONE r.timezone < $2::timestamptz
+        ),
+        available AS (
+            SELECT bk.bucket_start,
+                   SUM(EXTRACT(EPOCH FROM (LEAST(bk.bucket_end, w.win_end) - GREATEST(bk.bucket_start, w.win_start)))) / 3600 AS hours
+            FROM buckets bk
+            JOIN windows w ON w.win_start < bk.bucket_end AND w.win_end > bk.bucket_start
+            GROUP BY bk.bucket_start
+        ),
+        booked AS (
+            SELECT bk.bucket_start,
+                   SUM(EXTRACT(EPOCH FROM (
+                       LEAST(bk.bucket_end, w.win_end, b.end_time) - GREATEST(bk.bucket_start, w.win_start, b.start_time)
+                   ))) / 3600 AS hours
+            FROM buckets bk
+            JOIN windows w ON w.win_start < bk.bucket_end AND w.win_end > bk.bucket_start
+            JOIN bookings b ON b.room_id = w.room_id
+                           AND b.status = 'approved'
+                           AND b.start_time < LEAST(bk.bucket_end, w.win_end)
+                           AND b.end_time > GREATEST(bk.bucket_start, w.win_start)
+            GROUP BY bk.bucket_start
+        ),
+        requests AS (
+            SELECT bk.bucket_start,
+                   COUNT(*) AS total,
+                   COUNT(*) FILTER (WHERE b.status = 'rejected') AS rejected
+            FROM buckets bk
+            JOIN bookings b ON b.start_time >= bk.bucket_start AND b.start_time < bk.bucket_end
+            JOIN scoped_rooms r ON r.id = b.room_id
+            GROUP BY bk.bucket_start
+        ),
+        peaks AS (
+            -- Sampled at booking starts, as in peak_usage
+            SELECT bk.bucket_start, MAX(c.active) AS peak
+            FROM buckets bk
+            JOIN bookings s ON s.status = 'approved'
+                           AND s.start_time < bk.bucket_end
+                           AND s.end_time > bk.bucket_start
+            JOIN scoped_rooms sr ON sr.id = s.room_id
+            CROSS JOIN LATERAL (
+                SELECT COUNT(*) AS active
+                FROM bookings o
+                JOIN scoped_rooms orr ON orr.id = o.room_id
+                WHERE o.status = 'approved'
+                  AND o.start_time <= GREATEST(s.start_time, bk.bucket_start)
+                  AND o.end_time > GREATEST(s.start_time, bk.bucket_start)
+            ) c
+            GROUP BY bk.bucket_start
+        )
+        SELECT bk.bucket_start,
+               bk.bucket_end,
+               COALESCE(a.hours, 0),
+               COALESCE(bo.hours, 0),
+               COALESCE(p.peak, 0),
+               COALESCE(rq.total, 0),
+               COALESCE(rq.rejected, 0)
+        FROM buckets bk
+        LEFT JOIN available a ON a.bucket_start = bk.bucket_start
+        LEFT JOIN booked bo ON bo.bucket_start = bk.bucket_start
+        LEFT JOIN peaks p ON p.bucket_start = bk.bucket_start
+        LEFT JOIN requests rq ON rq.bucket_start = bk.bucket_start
+        ORDER BY bk.bucket_start
+    `
+    
+    roomType := sql.NullString{String: q.Type, Valid: q.Type != ""}
+    zone := "UTC"
+    if q.Location != nil {
+        zone = q.Location.String()
+    }
+    
+    ctx, span := startSpan(ctx, "bookings.utilization", query)
+    rows, err := r.db.DB.QueryContext(ctx, query, q.From, q.To, step, q.Granularity, q.RoomID, roomType, q.GroupID, zone)
+    if err != nil {
+        endSpan(span, err)
+        return nil, fmt.Errorf("failed to fetch utilization: %w", err)
+    }
+    defer span.End()
+    defer rows.Close()
+    
+    var buckets []models.UtilizationBucket
+    for rows.Next() {
+        var b models.UtilizationBucket
+        if err := rows.Scan(
+            &b.BucketStart,
+            &b.BucketEnd,
+            &b.AvailableHours,
+            &b.BookedHours,
+            &b.PeakConcurrency,
+            &b.Requests,
+            &b.Conflicts,
+        ); err != nil {
+            return nil, fmt.Errorf("failed to scan utilization bucket: %w", err)
+        }
+        if b.AvailableHours > 0 {
+            b.Utilization = b.BookedHours / b.AvailableHours * 100
+        }
+        if b.Requests > 0 {
+            b.ConflictRate = float64(b.Conflicts) / float64(b.Requests) * 100
+        }
+        buckets = append(buckets, b)
+    }
+    
+    return buckets, rows.Err()
+}
+
+// RejectNewest rejects the most recently created approved booking overlapping b
+func (t *allocTx) RejectNewest(ctx context.Context, b *models.Booking) (int, bool, error) {
+    query := `
         UPDATE bookings 
         SET status = 'rejected' 
-        WHERE room_id = $1 
-          AND status = 'approved' 
-          AND start_time < $3 
-          AND end_time > $2
+        WHERE id = (
+            SELECT id FROM bookings
+            WHERE room_id = $1 
+              AND status = 'approved' 
+              AND start_time < $3 
+              AND end_time > $2
+              AND id <> $4
+            ORDER BY created_at DESC, id DESC
+            LIMIT 1
+        )
+        RETURNING id
     `
-    _, err = tx.ExecContext(ctx, rejectQuery, b.RoomID, b.StartTime, b.EndTime)
+    ctx, span := startSpan(ctx, "bookings.preempt_conflicts", query)
+    var id int
+    err := t.tx.QueryRowContext(ctx, query, b.RoomID, b.StartTime, b.EndTime, b.ID).Scan(&id)
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    }
     if err != nil {
-        return err
+        return 0, false, err
     }
+    return id, true, nil
+}
+
+func (t *allocTx) RejectBundlesOf(ctx context.Context, bookingIDs []int) error {
+    return rejectBundlesOf(ctx, t.tx, bookingIDs)
+}
+
+// PreemptBooking rejects approved bookings overlapping the given one, most
+// recently created first, until it fits, and approves it. On a room that is not
+// pooled that rejects every overlapping booking.
+func (r *BookingRepository) PreemptBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
+    defer cancel()
     
-    _, err = tx.ExecContext(ctx, "UPDATE bookings SET status = 'approved' WHERE id = $1", bookingID)
-    if err != nil {
+    var approved *models.Booking
+    err := r.allocate(ctx, "preempt_booking", func(a *Allocation) (err error) {
+        approved, err = a.Preempt(ctx, bookingID, version)
         return err
+    })
+    if err != nil {
+        return nil, err
     }
-    
-    return tx.Commit()
+    return approved, nil
 }
 
 // DeleteAll clears all bookings from the database
 func (r *BookingRepository) DeleteAll(ctx context.Context) error {
-    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
     defer cancel()
 
-    _, err := r.db.DB.ExecContext(ctx, "TRUNCATE TABLE bookings RESTART IDENTITY CASCADE")
+    query := "TRUNCATE TABLE bookings, booking_bundles RESTART IDENTITY CASCADE"
+    ctx, span := startSpan(ctx, "bookings.truncate", query)
+    _, err := r.db.DB.ExecContext(ctx, query)
+    endSpan(span, err)
     if err != nil {
         return fmt.Errorf("failed to truncate bookings: %w", err)
     }
@@ -351,15 +1045,16 @@ type SystemStats struct {
 	ActiveBookings int     `json:"active_bookings"`
 	Conflicts      int     `json:"conflicts"`
 	Utilization    float64 `json:"utilization"`
-	CPUUsage       float64 `json:"cpu_usage"`
-	MemoryUsage    float64 `json:"memory_usage"`
 }
 
-// GetSystemStats aggregates real-time dashboard data
+// GetSystemStats aggregates booking counters for the dashboard
 func (r *BookingRepository) GetSystemStats(ctx context.Context) (*SystemStats, error) {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
     defer cancel()
     
+    ctx, span := startSpan(ctx, "bookings.stats", "")
+    defer span.End()
+    
     var stats SystemStats
     
     err := r.db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM bookings").Scan(&stats.TotalBookings)
@@ -377,34 +1072,20 @@ func (r *BookingRepository) GetSystemStats(ctx context.Context) (*SystemStats, e
         return nil, err
     }
     
-	var totalRooms int
-	err = r.db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM rooms").Scan(&totalRooms)
-	if err != nil {
-		totalRooms = 1
-	}
-	if totalRooms > 0 {
-		stats.Utilization = float64(stats.ActiveBookings) / float64(totalRooms) * 100
-		if stats.Utilization > 100 {
-			stats.Utilization = 100
-		}
-	}
-
-	// Real-time Hardware Metrics
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	// Memory usage in % (Allocated / Total System obtained)
-	if m.Sys > 0 {
-		stats.MemoryUsage = float64(m.Alloc) / float64(m.Sys) * 100
-	}
-	
-	// Simulated but dynamic CPU Load based on Goroutines and active tasks
-	// to ensure it feels "truly dynamic" without external libs
-	baseLoad := stats.Utilization * 0.7
-	goroutineLoad := float64(runtime.NumGoroutine()) / 20.0 * 30.0
-	stats.CPUUsage = baseLoad + goroutineLoad
-	if stats.CPUUsage > 99.9 {
-		stats.CPUUsage = 99.9
-	}
+    // Utilization is today's booked hours over today's available hours
+    now := time.Now()
+    dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    today, err := r.GetUtilization(ctx, models.UtilizationQuery{
+        From:        dayStart,
+        To:          dayStart.AddDate(0, 0, 1),
+        Granularity: "day",
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(today) > 0 {
+        stats.Utilization = today[0].Utilization
+    }
 
 	return &stats, nil
 }
@@ -0,0 +1,53 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/migrate"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/storetest"
+	"github.com/indraprhmbd/allocra/migrations"
+)
+
+// openTestDatabase migrates the Postgres database in TEST_DATABASE_URL and
+// returns a function that empties it and opens the stores. Never point it at a
+// database whose data you want to keep.
+func openTestDatabase(t *testing.T) func(t *testing.T) storetest.Stores {
+    dsn := os.Getenv("TEST_DATABASE_URL")
+    if dsn == "" {
+        t.Skip("TEST_DATABASE_URL is not set")
+    }
+    db, err := repository.NewDatabase(dsn, repository.Options{MaxOpenConns: 20, MaxIdleConns: 20, ConnectAttempts: 1})
+    require.NoError(t, err)
+    t.Cleanup(func() { db.Close() })
+    
+    migrator, err := migrate.New(db.DB, migrations.FS, t.Logf)
+    require.NoError(t, err)
+    _, err = migrator.Up(context.Background())
+    require.NoError(t, err)
+    
+    return func(t *testing.T) storetest.Stores {
+        _, err := db.DB.Exec(`TRUNCATE TABLE bookings, booking_bundles, rooms, resource_groups, idempotency_keys RESTART IDENTITY CASCADE`)
+        require.NoError(t, err)
+        return storetest.Stores{
+            Bookings:    repository.NewBookingRepository(db),
+            Rooms:       repository.NewRoomRepository(db),
+            Groups:      repository.NewGroupRepository(db),
+            Idempotency: repository.NewIdempotencyRepository(db),
+        }
+    }
+}
+
+// TestConformance runs the storage conformance suite against Postgres
+func TestConformance(t *testing.T) {
+    storetest.Run(t, openTestDatabase(t))
+}
+
+// TestModel checks Postgres against the reference model
+func TestModel(t *testing.T) {
+    storetest.Model(t, openTestDatabase(t))
+}
@@ -1,48 +1,160 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/indraprhmbd/allocra/internal/metrics"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
 )
 
+// maxTxAttempts bounds how often a transaction is replayed after a
+// serialization failure or deadlock before the error is surfaced
+const maxTxAttempts = 3
+
+// AnyVersion skips the optimistic concurrency check of an update (If-Match: *)
+const AnyVersion = 0
+
+// ErrStaleVersion is returned when an update names a version that is no longer current
+var ErrStaleVersion = errors.New("resource has been modified since the given version")
+
 type Database struct {
     DB *sql.DB
+    
+    // Timeouts bound each kind of operation; a zero timeout leaves the
+    // caller's context as the only limit
+    Timeouts Timeouts
+    
+    zones alloc.Zones
 }
 
-// NewDatabase creates a new database connection with production settings
-func NewDatabase(host, port, user, password, dbname string) (*Database, error) {
-    psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-        host, port, user, password, dbname)
-    
-    db, err := sql.Open("postgres", psqlInfo)
+// Timeouts bound the database operations of the repositories by kind
+type Timeouts struct {
+    Query         time.Duration // single reads and writes
+    ConflictCheck time.Duration // the overlap check inside an allocation
+    Allocation    time.Duration // allocating or approving under room locks
+    BulkWrite     time.Duration // preemption, bundles, archiving, reset
+    Report        time.Duration // monthly and utilization reports
+    Batch         time.Duration // calendar dry runs, idempotency key purges
+    Export        time.Duration // streamed spreadsheet exports
+}
+
+// Options configures the connection pool and how long startup waits for the database
+type Options struct {
+    MaxOpenConns      int
+    MaxIdleConns      int
+    ConnMaxLifetime   time.Duration
+    ConnectAttempts   int
+    ConnectRetryDelay time.Duration
+    Timeouts          Timeouts
+}
+
+// NewDatabase opens a pool on dsn and waits until the database answers
+func NewDatabase(dsn string, opts Options) (*Database, error) {
+    db, err := sql.Open("postgres", dsn)
     if err != nil {
         return nil, fmt.Errorf("failed to open database: %w", err)
     }
     
-    // Production connection pool settings for 1GB VPS
-    db.SetMaxOpenConns(10)          // Limit concurrent connections
-    db.SetMaxIdleConns(5)            // Keep some idle connections
-    db.SetConnMaxLifetime(time.Hour) // Recycle connections hourly
+    db.SetMaxOpenConns(opts.MaxOpenConns)
+    db.SetMaxIdleConns(opts.MaxIdleConns)
+    db.SetConnMaxLifetime(opts.ConnMaxLifetime)
     
     // Verify connection with retries
     var lastErr error
-    for i := 0; i < 10; i++ {
+    for i := 0; i < opts.ConnectAttempts; i++ {
         if err = db.Ping(); err == nil {
             log.Println("Database connection established")
-            return &Database{DB: db}, nil
+            return &Database{DB: db, Timeouts: opts.Timeouts}, nil
         }
         lastErr = err
-        log.Printf("Attempt %d: Failed to ping database: %v. Retrying in 2s...", i+1, err)
-        time.Sleep(2 * time.Second)
+        log.Printf("Attempt %d: Failed to ping database: %v. Retrying in %s...", i+1, err, opts.ConnectRetryDelay)
+        time.Sleep(opts.ConnectRetryDelay)
     }
+    db.Close()
     
     return nil, fmt.Errorf("failed to connect to database after retries: %w", lastErr)
 }
 
+// withTimeout derives the context of one operation; d <= 0 means no timeout
+func (d *Database) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+    if timeout <= 0 {
+        return context.WithCancel(ctx)
+    }
+    return context.WithTimeout(ctx, timeout)
+}
+
 func (d *Database) Close() error {
     return d.DB.Close()
 }
+
+// runInTx executes fn inside a READ COMMITTED transaction, retrying the whole
+// unit when Postgres aborts it with a serialization failure or deadlock.
+// fn must be safe to replay: it should only derive state from the transaction.
+func (d *Database) runInTx(ctx context.Context, operation string, fn func(tx *sql.Tx) error) (err error) {
+    ctx, span := startSpan(ctx, "tx."+operation, "")
+    defer func() { endSpan(span, err) }()
+
+    for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+        if attempt > 1 {
+            metrics.TransactionRetries.WithLabelValues(operation).Inc()
+            span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt)))
+        }
+
+        err = d.execTx(ctx, fn)
+        if err == nil || !isRetryable(err) {
+            return err
+        }
+    }
+    return err
+}
+
+func (d *Database) execTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+    tx, err := d.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    if err := fn(tx); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit transaction: %w", err)
+    }
+    return nil
+}
+
+// isRetryable reports whether err is a transient Postgres concurrency abort
+func isRetryable(err error) bool {
+    var pqErr *pq.Error
+    if !errors.As(err, &pqErr) {
+        return false
+    }
+    switch pqErr.Code {
+    case "40001", "40P01": // serialization_failure, deadlock_detected
+        return true
+    }
+    return false
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+    var pqErr *pq.Error
+    return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isForeignKeyViolation reports whether err is a Postgres foreign key violation
+func isForeignKeyViolation(err error) bool {
+    var pqErr *pq.Error
+    return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+type GroupRepository struct {
+    db *Database
+}
+
+func NewGroupRepository(db *Database) *GroupRepository {
+    return &GroupRepository{db: db}
+}
+
+var (
+    // ErrGroupNotFound is returned when no resource group has the requested id
+    ErrGroupNotFound = errors.New("resource group not found")
+    // ErrParentGroupNotFound is returned when a group is placed under a missing parent
+    ErrParentGroupNotFound = errors.New("parent resource group not found")
+    // ErrGroupNameTaken is returned when another group already uses the name
+    ErrGroupNameTaken = errors.New("resource group name already in use")
+    // ErrGroupNotEmpty blocks deleting a group that still has children or active rooms
+    ErrGroupNotEmpty = errors.New("resource group still has child groups or active rooms")
+)
+
+// groupSelect reads a group with its effective policy and the capacity of the
+// active rooms in its subtree, in the order scanGroup expects
+const groupSelect = `
+    SELECT g.id, g.name, g.kind, g.parent_id,
+           g.opens_at, g.closes_at, g.requires_approval, g.daily_quota_hours,
+           gp.opens_at, gp.closes_at, gp.requires_approval, gp.daily_quota_hours,
+           COALESCE(agg.room_count, 0), COALESCE(agg.total_capacity, 0), COALESCE(agg.online_capacity, 0),
+           g.created_at, g.updated_at, g.version
+    FROM resource_groups g
+    JOIN group_policies gp ON gp.group_id = g.id
+    LEFT JOIN LATERAL (
+        SELECT COUNT(*) AS room_count,
+               SUM(r.capacity) AS total_capacity,
+               SUM(r.capacity) FILTER (WHERE r.status = 'online') AS online_capacity
+        FROM group_closure c
+        JOIN rooms r ON r.group_id = c.descendant_id AND r.archived_at IS NULL
+        WHERE c.ancestor_id = g.id
+    ) agg ON TRUE`
+
+// nullPolicy is a Policy as read from nullable columns
+type nullPolicy struct {
+    opensAt, closesAt sql.NullString
+    requiresApproval  sql.NullBool
+    dailyQuotaHours   sql.NullFloat64
+}
+
+func (p *nullPolicy) policy() models.Policy {
+    var out models.Policy
+    if p.opensAt.Valid {
+        out.OpensAt, out.ClosesAt = &p.opensAt.String, &p.closesAt.String
+    }
+    if p.requiresApproval.Valid {
+        out.RequiresApproval = &p.requiresApproval.Bool
+    }
+    if p.dailyQuotaHours.Valid {
+        out.DailyQuotaHours = &p.dailyQuotaHours.Float64
+    }
+    return out
+}
+
+func scanGroup(row rowScanner, g *models.ResourceGroup) error {
+    var parentID sql.NullInt64
+    var own, effective nullPolicy
+    err := row.Scan(
+        &g.ID,
+        &g.Name,
+        &g.Kind,
+        &parentID,
+        &own.opensAt,
+        &own.closesAt,
+        &own.requiresApproval,
+        &own.dailyQuotaHours,
+        &effective.opensAt,
+        &effective.closesAt,
+        &effective.requiresApproval,
+        &effective.dailyQuotaHours,
+        &g.RoomCount,
+        &g.TotalCapacity,
+        &g.OnlineCapacity,
+        &g.CreatedAt,
+        &g.UpdatedAt,
+        &g.Version,
+    )
+    if err != nil {
+        return err
+    }
+    g.ParentID = nil
+    if parentID.Valid {
+        id := int(parentID.Int64)
+        g.ParentID = &id
+    }
+    g.Policy, g.Effective = own.policy(), effective.policy()
+    return nil
+}
+
+// groupWriteFailed maps the constraint violations of a group insert or update
+func groupWriteFailed(err error, g *models.ResourceGroup, what string) error {
+    switch {
+    case isUniqueViolation(err):
+        return ErrGroupNameTaken
+    case isForeignKeyViolation(err) && g.ParentID != nil:
+        return fmt.Errorf("%w with id: %d", ErrParentGroupNotFound, *g.ParentID)
+    }
+    return fmt.Errorf("%s: %w", what, err)
+}
+
+// Create inserts a group. The caller checks that the parent is of an earlier kind.
+func (r *GroupRepository) Create(ctx context.Context, g *models.ResourceGroup) (*models.ResourceGroup, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    query := `
+        INSERT INTO resource_groups (name, kind, parent_id, opens_at, closes_at, requires_approval, daily_quota_hours)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id
+    `
+    insertCtx, span := startSpan(ctx, "resource_groups.insert", query)
+    var id int
+    err := r.db.DB.QueryRowContext(insertCtx, query,
+        g.Name,
+        g.Kind,
+        g.ParentID,
+        g.Policy.OpensAt,
+        g.Policy.ClosesAt,
+        g.Policy.RequiresApproval,
+        g.Policy.DailyQuotaHours,
+    ).Scan(&id)
+    endSpan(span, err)
+    if err != nil {
+        return nil, groupWriteFailed(err, g, "failed to create resource group")
+    }
+    
+    return r.GetByID(ctx, id)
+}
+
+// GetByID fetches a single group
+func (r *GroupRepository) GetByID(ctx context.Context, id int) (*models.ResourceGroup, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    query := groupSelect + ` WHERE g.id = $1`
+    
+    ctx, span := startSpan(ctx, "resource_groups.get", query)
+    var g models.ResourceGroup
+    err := scanGroup(r.db.DB.QueryRowContext(ctx, query, id), &g)
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w with id: %d", ErrGroupNotFound, id)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch resource group: %w", err)
+    }
+    
+    return &g, nil
+}
+
+// List returns the groups of one kind, or all groups when kind is empty
+func (r *GroupRepository) List(ctx context.Context, kind string) ([]models.ResourceGroup, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    query := groupSelect + ` WHERE ($1::text IS NULL OR g.kind = $1) ORDER BY g.name`
+    
+    ctx, span := startSpan(ctx, "resource_groups.list", query)
+    rows, err := r.db.DB.QueryContext(ctx, query, sql.NullString{String: kind, Valid: kind != ""})
+    if err != nil {
+        endSpan(span, err)
+        return nil, fmt.Errorf("failed to fetch resource groups: %w", err)
+    }
+    defer span.End()
+    defer rows.Close()
+    
+    groups := []models.ResourceGroup{}
+    for rows.Next() {
+        var g models.ResourceGroup
+        if err := scanGroup(rows, &g); err != nil {
+            return nil, fmt.Errorf("failed to scan resource group: %w", err)
+        }
+        groups = append(groups, g)
+    }
+    
+    return groups, rows.Err()
+}
+
+// Update writes name, parent and policy of g only if the row is still at
+// version (or version is AnyVersion). The kind of a group never changes.
+func (r *GroupRepository) Update(ctx context.Context, id, version int, g *models.ResourceGroup) (*models.ResourceGroup, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    query := `
+        UPDATE resource_groups
+        SET name = $1, parent_id = $2, opens_at = $3, closes_at = $4, requires_approval = $5, daily_quota_hours = $6
+        WHERE id = $7 AND ($8 = 0 OR version = $8)
+    `
+    updateCtx, span := startSpan(ctx, "resource_groups.update", query)
+    result, err := r.db.DB.ExecContext(updateCtx, query,
+        g.Name,
+        g.ParentID,
+        g.Policy.OpensAt,
+        g.Policy.ClosesAt,
+        g.Policy.RequiresApproval,
+        g.Policy.DailyQuotaHours,
+        id,
+        version,
+    )
+    endSpan(span, err)
+    if err != nil {
+        return nil, groupWriteFailed(err, g, "failed to update resource group")
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return nil, err
+    } else if n == 0 {
+        // Either gone or moved on; GetByID tells which
+        if _, err := r.GetByID(ctx, id); err != nil {
+            return nil, err
+        }
+        return nil, fmt.Errorf("resource group %d: %w", id, ErrStaleVersion)
+    }
+    
+    return r.GetByID(ctx, id)
+}
+
+// Delete removes an empty group. Archived rooms still pointing at it are
+// detached; active rooms and child groups must be moved away first.
+func (r *GroupRepository) Delete(ctx context.Context, id int) error {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    return r.db.runInTx(ctx, "delete_resource_group", func(tx *sql.Tx) error {
+        query := `
+            SELECT EXISTS (SELECT 1 FROM resource_groups WHERE parent_id = $1)
+                OR EXISTS (SELECT 1 FROM rooms WHERE group_id = $1 AND archived_at IS NULL)
+            FROM resource_groups WHERE id = $1
+            FOR UPDATE
+        `
+        lockCtx, span := startSpan(ctx, "resource_groups.lock", query)
+        var inUse bool
+        err := tx.QueryRowContext(lockCtx, query, id).Scan(&inUse)
+        endSpan(span, err)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w with id: %d", ErrGroupNotFound, id)
+        }
+        if err != nil {
+            return fmt.Errorf("failed to lock resource group: %w", err)
+        }
+        if inUse {
+            return ErrGroupNotEmpty
+        }
+        
+        for _, query := range []string{
+            `UPDATE rooms SET group_id = NULL WHERE group_id = $1`,
+            `DELETE FROM resource_groups WHERE id = $1`,
+        } {
+            execCtx, span := startSpan(ctx, "resource_groups.delete", query)
+            _, err := tx.ExecContext(execCtx, query, id)
+            endSpan(span, err)
+            if err != nil {
+                return fmt.Errorf("failed to delete resource group: %w", err)
+            }
+        }
+        return nil
+    })
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+type IdempotencyRepository struct {
+    db *Database
+}
+
+func NewIdempotencyRepository(db *Database) *IdempotencyRepository {
+    return &IdempotencyRepository{db: db}
+}
+
+// Reserve claims (scope, key) for lease. It returns nil when the caller now owns
+// the key and must process the request, or the live record already holding it.
+// Expired records, including leases of requests that never completed, are taken over.
+func (r *IdempotencyRepository) Reserve(ctx context.Context, scope, key, fingerprint string, lease time.Duration) (*models.IdempotencyRecord, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+
+    query := `
+        INSERT INTO idempotency_keys (scope, key, fingerprint, expires_at)
+        VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 millisecond')
+        ON CONFLICT (scope, key) DO UPDATE
+        SET fingerprint = EXCLUDED.fingerprint,
+            status_code = NULL,
+            content_type = NULL,
+            response = NULL,
+            created_at = NOW(),
+            expires_at = EXCLUDED.expires_at
+        WHERE idempotency_keys.expires_at < NOW()
+        RETURNING 1
+    `
+    reserveCtx, span := startSpan(ctx, "idempotency.reserve", query)
+    var reserved int
+    err := r.db.DB.QueryRowContext(reserveCtx, query, scope, key, fingerprint, lease.Milliseconds()).Scan(&reserved)
+    endSpan(span, err)
+    if err == nil {
+        return nil, nil
+    }
+    if err != sql.ErrNoRows {
+        return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+    }
+
+    query = `
+        SELECT fingerprint, COALESCE(status_code, 0), COALESCE(content_type, ''), response
+        FROM idempotency_keys
+        WHERE scope = $1 AND key = $2
+    `
+    getCtx, span := startSpan(ctx, "idempotency.get", query)
+    var rec models.IdempotencyRecord
+    err = r.db.DB.QueryRowContext(getCtx, query, scope, key).Scan(
+        &rec.Fingerprint,
+        &rec.StatusCode,
+        &rec.ContentType,
+        &rec.Body,
+    )
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        // Purged between the two statements; claim it again
+        return r.Reserve(ctx, scope, key, fingerprint, lease)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+    }
+
+    return &rec, nil
+}
+
+// Complete stores the response of a reserved key and keeps it for ttl
+func (r *IdempotencyRepository) Complete(ctx context.Context, scope, key string, rec *models.IdempotencyRecord, ttl time.Duration) error {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+
+    query := `
+        UPDATE idempotency_keys
+        SET status_code = $4, content_type = $5, response = $6,
+            expires_at = NOW() + $7 * INTERVAL '1 millisecond'
+        WHERE scope = $1 AND key = $2 AND fingerprint = $3 AND status_code IS NULL
+    `
+    ctx, span := startSpan(ctx, "idempotency.complete", query)
+    _, err := r.db.DB.ExecContext(ctx, query, scope, key, rec.Fingerprint, rec.StatusCode, rec.ContentType, rec.Body, ttl.Milliseconds())
+    endSpan(span, err)
+    if err != nil {
+        return fmt.Errorf("failed to store idempotent response: %w", err)
+    }
+    return nil
+}
+
+// Release drops an in-flight reservation so the client may retry with the same key
+func (r *IdempotencyRepository) Release(ctx context.Context, scope, key, fingerprint string) error {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+
+    query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND fingerprint = $3 AND status_code IS NULL`
+    ctx, span := startSpan(ctx, "idempotency.release", query)
+    _, err := r.db.DB.ExecContext(ctx, query, scope, key, fingerprint)
+    endSpan(span, err)
+    if err != nil {
+        return fmt.Errorf("failed to release idempotency key: %w", err)
+    }
+    return nil
+}
+
+// PurgeExpired deletes records past their TTL and returns how many were removed
+func (r *IdempotencyRepository) PurgeExpired(ctx context.Context) (int64, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Batch)
+    defer cancel()
+
+    query := `DELETE FROM idempotency_keys WHERE expires_at < NOW()`
+    ctx, span := startSpan(ctx, "idempotency.purge", query)
+    result, err := r.db.DB.ExecContext(ctx, query)
+    endSpan(span, err)
+    if err != nil {
+        return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+    }
+    return result.RowsAffected()
+}
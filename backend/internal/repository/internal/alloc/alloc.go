@@ -0,0 +1,73 @@
+// Package alloc holds the small rules every storage backend applies the same
+// way when it allocates: how many units a request takes, how times of day are
+// written and read, when a room is open and how room zones are loaded.
+package alloc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Units is the number of units a request for quantity takes; zero means one
+func Units(quantity int) int {
+	if quantity < 1 {
+		return 1
+	}
+	return quantity
+}
+
+// Clock normalizes a time of day to "HH:MM:SS", the way Postgres prints TIME
+// values, so every backend reports operating hours alike
+func Clock(v string) string {
+	if len(v) == len("15:04") {
+		return v + ":00"
+	}
+	return v
+}
+
+// Offset is the time since midnight a time of day such as "09:30:00" names
+func Offset(v string) time.Duration {
+	var h, m, s int
+	fmt.Sscanf(v, "%d:%d:%d", &h, &m, &s)
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+}
+
+// Open reports whether [start, end) lies within the operating hours opens to
+// closes of a single day on loc's wall clock. Hours of 00:00 to 24:00 mean the
+// room never closes, so only then may a booking run past midnight.
+func Open(start, end time.Time, loc *time.Location, opens, closes string) bool {
+	from, to := Offset(opens), Offset(closes)
+	if from == 0 && to == 24*time.Hour {
+		return true
+	}
+	// Compared as wall clock readings, so that DST shifts move the window
+	// along with the clock
+	s, e := wallClock(start, loc), wallClock(end, loc)
+	day := time.Date(s.Year(), s.Month(), s.Day(), 0, 0, 0, 0, time.UTC)
+	return !s.Before(day.Add(from)) && !e.After(day.Add(to))
+}
+
+// wallClock is the reading of t on loc's clock, as a time in UTC
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	l := t.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+// Zones loads IANA zones once each. The zero value is ready to use.
+type Zones struct {
+	m sync.Map // zone name -> *time.Location
+}
+
+// Load returns the zone called name
+func (z *Zones) Load(name string) (*time.Location, error) {
+	if loc, ok := z.m.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	z.m.Store(name, loc)
+	return loc, nil
+}
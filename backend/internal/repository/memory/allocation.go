@@ -0,0 +1,207 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+// allocTx is the repository.AllocationTx of a write. It locks nothing: the
+// exclusive lock the write holds already serializes every allocation. Rows
+// are handed out as copies, so the rules never change them behind tx's back.
+type allocTx struct {
+	tx *tx
+}
+
+// allocate runs fn on the allocation rules inside a write
+func (r *BookingRepository) allocate(ctx context.Context, fn func(a *repository.Allocation) error) error {
+	return r.db.write(ctx, func(tx *tx) error {
+		return fn(repository.NewAllocation(&allocTx{tx: tx}))
+	})
+}
+
+// PickRoom chooses the smallest online room matching the request's selector,
+// capacity and group that is open and has enough free units over the
+// request's window. Rooms in exclude are never picked.
+func (t *allocTx) PickRoom(ctx context.Context, req *models.CreateBookingRequest, exclude []int) (int, error) {
+	units := alloc.Units(req.Quantity)
+	var picked *room
+	for _, rm := range t.tx.rooms {
+		if rm.archivedAt != nil || rm.status != models.RoomStatusOnline || rm.capacity < req.MinCapacity ||
+			!t.tx.inGroup(rm.groupID, req.GroupID) || !req.Selector.Matches(rm.labels) || contains(exclude, rm.id) {
+			continue
+		}
+		if picked != nil && rm.capacity >= picked.capacity {
+			continue
+		}
+		open, err := t.tx.open(rm, req.StartTime, req.EndTime)
+		if err != nil {
+			return 0, err
+		}
+		if open && rm.quantity-t.tx.peakUsage(rm.id, req.StartTime, req.EndTime) >= units {
+			picked = rm
+		}
+	}
+	if picked == nil {
+		return 0, repository.ErrNoRoomAvailable
+	}
+	return picked.id, nil
+}
+
+func contains(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// LockRoom fails unless the room exists and is active
+func (t *allocTx) LockRoom(ctx context.Context, roomID int) error {
+	if t.tx.activeRoom(roomID) == nil {
+		return fmt.Errorf("%w with id: %d", repository.ErrRoomNotFound, roomID)
+	}
+	return nil
+}
+
+// RoomPolicy reads the units, effective hours and group policy of a room
+func (t *allocTx) RoomPolicy(ctx context.Context, roomID int) (*repository.AllocationPolicy, error) {
+	rm := t.tx.room(roomID)
+	if rm == nil {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrRoomNotFound, roomID)
+	}
+	view := t.tx.roomView(rm)
+	p := &repository.AllocationPolicy{
+		Quantity:         rm.quantity,
+		RequiresApproval: view.RequiresApproval,
+		Timezone:         rm.timezone,
+		OpensAt:          view.OpensAt,
+		ClosesAt:         view.ClosesAt,
+	}
+	if gp := t.tx.effectivePolicy(rm.groupID); gp.DailyQuotaHours != nil {
+		p.QuotaHours = sql.NullFloat64{Float64: *gp.DailyQuotaHours, Valid: true}
+		p.QuotaGroupID = sql.NullInt64{Int64: int64(gp.quotaGroupID), Valid: true}
+	}
+	return p, nil
+}
+
+func (t *allocTx) Conflicts(ctx context.Context, roomID int, start, end time.Time, units int) (bool, error) {
+	return t.tx.conflicts(t.tx.room(roomID), start, end, units), nil
+}
+
+// QuotaUsage sums the hours of the user's approved and pending bookings
+// starting in [from, to) in the subtree of groupID
+func (t *allocTx) QuotaUsage(ctx context.Context, userID int, groupID int64, from, to time.Time) (float64, error) {
+	ancestor := int(groupID)
+	used := 0.0
+	for _, b := range t.tx.bookings {
+		if b.UserID != userID || (b.Status != "approved" && b.Status != "pending") ||
+			b.StartTime.Before(from) || !b.StartTime.Before(to) ||
+			!t.tx.inGroup(t.tx.room(b.RoomID).groupID, &ancestor) {
+			continue
+		}
+		used += b.EndTime.Sub(b.StartTime).Hours()
+	}
+	return used, nil
+}
+
+func (t *allocTx) Location(name string) (*time.Location, error) {
+	return t.tx.zones.Load(name)
+}
+
+// InsertBooking stores a booking, as a member of its bundle when bundleID is set
+func (t *allocTx) InsertBooking(ctx context.Context, roomID int, req *models.CreateBookingRequest, status string, units int, bundleID *int) (*models.Booking, error) {
+	row := t.tx.insertBooking(models.Booking{
+		RoomID:    roomID,
+		UserID:    req.UserID,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		Status:    status,
+		Quantity:  units,
+		BundleID:  copyInt(bundleID),
+	})
+	if bundleID != nil {
+		b := t.tx.bundle(*bundleID)
+		members := b.members
+		b.members = append(b.members, row)
+		t.tx.onRollback(func() { b.members = members })
+	}
+	view := t.tx.bookingView(row, false)
+	return &view, nil
+}
+
+func (t *allocTx) LockBooking(ctx context.Context, bookingID int) (*models.Booking, error) {
+	b := t.tx.booking(bookingID)
+	if b == nil {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrBookingNotFound, bookingID)
+	}
+	view := t.tx.bookingView(b, false)
+	return &view, nil
+}
+
+func (t *allocTx) SetBookingStatus(ctx context.Context, bookingID int, status string) (*models.Booking, error) {
+	b := t.tx.booking(bookingID)
+	t.tx.setBookingStatus(b, status)
+	view := t.tx.bookingView(b, false)
+	return &view, nil
+}
+
+// RejectNewest rejects the most recently created approved booking overlapping b
+func (t *allocTx) RejectNewest(ctx context.Context, b *models.Booking) (int, bool, error) {
+	var victim *models.Booking
+	for _, o := range t.tx.byRoom[b.RoomID] {
+		if o.ID == b.ID || o.Status != "approved" || !o.StartTime.Before(b.EndTime) || !o.EndTime.After(b.StartTime) {
+			continue
+		}
+		if victim == nil || o.CreatedAt.After(victim.CreatedAt) ||
+			(o.CreatedAt.Equal(victim.CreatedAt) && o.ID > victim.ID) {
+			victim = o
+		}
+	}
+	if victim == nil {
+		return 0, false, nil
+	}
+	t.tx.setBookingStatus(victim, "rejected")
+	return victim.ID, true, nil
+}
+
+func (t *allocTx) RejectBundlesOf(ctx context.Context, bookingIDs []int) error {
+	t.tx.rejectBundlesOf(bookingIDs)
+	return nil
+}
+
+// InsertBundle stores a bundle without its members
+func (t *allocTx) InsertBundle(ctx context.Context, req *models.CreateBundleRequest, status string) (*models.Bundle, error) {
+	row := &bundle{Bundle: models.Bundle{
+		ID:        len(t.tx.bundles) + 1,
+		UserID:    req.UserID,
+		StartTime: instant(req.StartTime),
+		EndTime:   instant(req.EndTime),
+		Status:    status,
+		CreatedAt: t.tx.now,
+		UpdatedAt: t.tx.now,
+		Version:   1,
+	}}
+	t.tx.bundles = append(t.tx.bundles, row)
+	t.tx.onRollback(func() { t.tx.bundles = t.tx.bundles[:len(t.tx.bundles)-1] })
+	return t.tx.bundleView(row), nil
+}
+
+func (t *allocTx) LockBundle(ctx context.Context, id int) (*models.Bundle, error) {
+	b := t.tx.bundle(id)
+	if b == nil {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrBundleNotFound, id)
+	}
+	return t.tx.bundleView(b), nil
+}
+
+func (t *allocTx) SetBundleStatus(ctx context.Context, id int, status string) error {
+	t.tx.setBundleStatus(t.tx.bundle(id), status)
+	return nil
+}
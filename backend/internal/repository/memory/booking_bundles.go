@@ -0,0 +1,137 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+type bundle struct {
+	models.Bundle                   // without Bookings or ConflictingRoomIDs
+	members       []*models.Booking // in id order
+}
+
+func (db *Database) bundle(id int) *bundle {
+	if id < 1 || id > len(db.bundles) {
+		return nil
+	}
+	return db.bundles[id-1]
+}
+
+// bundleView copies a bundle with its member bookings
+func (db *Database) bundleView(b *bundle) *models.Bundle {
+	view := b.Bundle
+	view.Bookings = make([]models.Booking, len(b.members))
+	for i, m := range b.members {
+		view.Bookings[i] = db.bookingView(m, false)
+	}
+	return &view
+}
+
+// CreateBundle allocates one room per member over the bundle's window, all or
+// nothing, as repository.Allocation.CreateBundle describes. A rejected bundle
+// is returned with ErrBookingConflict.
+func (r *BookingRepository) CreateBundle(ctx context.Context, req *models.CreateBundleRequest) (*models.Bundle, error) {
+	var created *models.Bundle
+	err := r.allocate(ctx, func(a *repository.Allocation) (err error) {
+		created, err = a.CreateBundle(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created.Status == "rejected" {
+		return created, repository.ErrBookingConflict
+	}
+	return created, nil
+}
+
+// GetBundle fetches a bundle with its member bookings
+func (r *BookingRepository) GetBundle(ctx context.Context, id int) (*models.Bundle, error) {
+	var found *models.Bundle
+	err := r.db.read(ctx, func() error {
+		b := r.db.bundle(id)
+		if b == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrBundleNotFound, id)
+		}
+		found = r.db.bundleView(b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// setBundleStatus moves a bundle and all its bookings to status
+func (tx *tx) setBundleStatus(b *bundle, status string) {
+	for _, m := range b.members {
+		tx.setBookingStatus(m, status)
+	}
+	old := *b
+	tx.onRollback(func() { *b = old })
+	b.Status = status
+	b.Version++
+	b.UpdatedAt = tx.now
+}
+
+// ApproveBundle approves a pending bundle after re-checking every member room;
+// a conflict on any member leaves the whole bundle pending
+func (r *BookingRepository) ApproveBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+	var approved *models.Bundle
+	err := r.allocate(ctx, func(a *repository.Allocation) error {
+		if err := a.ApproveBundle(ctx, id, version); err != nil {
+			return err
+		}
+		approved = r.db.bundleView(r.db.bundle(id))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approved, nil
+}
+
+// RejectBundle rejects a pending bundle with all its bookings
+func (r *BookingRepository) RejectBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+	var rejected *models.Bundle
+	err := r.allocate(ctx, func(a *repository.Allocation) error {
+		if err := a.RejectBundle(ctx, id, version); err != nil {
+			return err
+		}
+		rejected = r.db.bundleView(r.db.bundle(id))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejected, nil
+}
+
+// rejectBundlesOf rejects every bundle one of bookingIDs belongs to, with the
+// rest of its bookings, so that no bundle is ever left partly allocated
+func (tx *tx) rejectBundlesOf(bookingIDs []int) {
+	for _, id := range bookingIDs {
+		b := tx.booking(id)
+		if b.BundleID == nil {
+			continue
+		}
+		bundle := tx.bundle(*b.BundleID)
+		if bundle.Status == "rejected" {
+			continue
+		}
+		for _, m := range bundle.members {
+			if m.Status != "rejected" {
+				tx.setBookingStatus(m, "rejected")
+			}
+		}
+		old := *bundle
+		tx.onRollback(func() { *bundle = old })
+		bundle.Status = "rejected"
+		bundle.Version++
+		bundle.UpdatedAt = tx.now
+	}
+}
@@ -0,0 +1,405 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+type BookingRepository struct {
+	db *Database
+}
+
+func NewBookingRepository(db *Database) *BookingRepository {
+	return &BookingRepository{db: db}
+}
+
+// booking returns the booking with id
+func (db *Database) booking(id int) *models.Booking {
+	if id < 1 || id > len(db.bookings) {
+		return nil
+	}
+	return db.bookings[id-1]
+}
+
+// bookingView copies a booking, with its room name when withRoom is set
+func (db *Database) bookingView(b *models.Booking, withRoom bool) models.Booking {
+	view := *b
+	view.BundleID = copyInt(b.BundleID)
+	if withRoom {
+		view.RoomName = db.room(b.RoomID).name
+	}
+	return view
+}
+
+// peakUsage is the most units of a room that approved bookings hold at any
+// instant of [from, to), sampled as the peak_usage function of migration 014
+// explains
+func (db *Database) peakUsage(roomID int, from, to time.Time) int {
+	var overlapping []*models.Booking
+	for _, b := range db.byRoom[roomID] {
+		if b.Status == "approved" && b.StartTime.Before(to) && b.EndTime.After(from) {
+			overlapping = append(overlapping, b)
+		}
+	}
+	usage := func(at time.Time) int {
+		units := 0
+		for _, b := range overlapping {
+			if !b.StartTime.After(at) && b.EndTime.After(at) {
+				units += b.Quantity
+			}
+		}
+		return units
+	}
+
+	peak := usage(from)
+	for _, b := range overlapping {
+		if b.StartTime.After(from) {
+			if u := usage(b.StartTime); u > peak {
+				peak = u
+			}
+		}
+	}
+	return peak
+}
+
+// conflicts reports whether units more of rm can't be held over [start, end)
+// next to its approved bookings
+func (db *Database) conflicts(rm *room, start, end time.Time, units int) bool {
+	return db.peakUsage(rm.id, start, end)+units > rm.quantity
+}
+
+// open reports whether rm is open over [start, end), by its effective hours
+func (db *Database) open(rm *room, start, end time.Time) (bool, error) {
+	loc, err := db.zones.Load(rm.timezone)
+	if err != nil {
+		return false, fmt.Errorf("invalid time zone of room %d: %w", rm.id, err)
+	}
+	view := db.roomView(rm)
+	return alloc.Open(start, end, loc, view.OpensAt, view.ClosesAt), nil
+}
+
+// insertBooking stores b as a new booking at version 1
+func (tx *tx) insertBooking(b models.Booking) *models.Booking {
+	row := &b
+	row.ID = len(tx.bookings) + 1
+	row.StartTime, row.EndTime = instant(row.StartTime), instant(row.EndTime)
+	row.CreatedAt, row.UpdatedAt = tx.now, tx.now
+	row.Version = 1
+	row.RoomName = ""
+
+	tx.bookings = append(tx.bookings, row)
+	tx.byRoom[row.RoomID] = append(tx.byRoom[row.RoomID], row)
+	tx.onRollback(func() {
+		tx.bookings = tx.bookings[:len(tx.bookings)-1]
+		tx.byRoom[row.RoomID] = tx.byRoom[row.RoomID][:len(tx.byRoom[row.RoomID])-1]
+	})
+	return row
+}
+
+// touchBooking saves b for rollback and stamps it as updated, as the triggers do
+func (tx *tx) touchBooking(b *models.Booking) {
+	old := *b
+	tx.onRollback(func() { *b = old })
+	b.Version++
+	b.UpdatedAt = tx.now
+}
+
+func (tx *tx) setBookingStatus(b *models.Booking, status string) {
+	tx.touchBooking(b)
+	b.Status = status
+}
+
+// moveBooking reassigns b to another room
+func (tx *tx) moveBooking(b *models.Booking, roomID int) {
+	from, to := tx.byRoom[b.RoomID], tx.byRoom[roomID]
+	kept := make([]*models.Booking, 0, len(from))
+	for _, o := range from {
+		if o != b {
+			kept = append(kept, o)
+		}
+	}
+	fromID := b.RoomID
+	tx.byRoom[fromID] = kept
+	tx.byRoom[roomID] = append(to, b)
+	tx.onRollback(func() {
+		tx.byRoom[fromID] = from
+		tx.byRoom[roomID] = to
+	})
+
+	tx.touchBooking(b)
+	b.RoomID = roomID
+}
+
+// CreateWithTransaction allocates a booking atomically by the rules of
+// repository.Allocation: conflicting requests are stored as rejected and
+// returned with ErrBookingConflict
+func (r *BookingRepository) CreateWithTransaction(ctx context.Context, req *models.CreateBookingRequest) (*models.Booking, error) {
+	var booking *models.Booking
+	err := r.allocate(ctx, func(a *repository.Allocation) (err error) {
+		booking, err = a.Create(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if booking.Status == "rejected" {
+		return booking, repository.ErrBookingConflict
+	}
+	return booking, nil
+}
+
+// SimulateBatch books reqs by the rules of CreateWithTransaction, in order,
+// in a write that is always undone; see repository.Allocation.Simulate for
+// the outcomes.
+func (r *BookingRepository) SimulateBatch(ctx context.Context, reqs []models.CreateBookingRequest) ([]repository.SimulatedBooking, error) {
+	var outcomes []repository.SimulatedBooking
+	err := r.db.simulate(ctx, func(tx *tx) (err error) {
+		outcomes, err = repository.NewAllocation(&allocTx{tx: tx}).Simulate(ctx, reqs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
+
+// ApproveBooking updates booking status to 'approved' with conflict re-check
+func (r *BookingRepository) ApproveBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+	var approved *models.Booking
+	err := r.allocate(ctx, func(a *repository.Allocation) (err error) {
+		approved, err = a.Approve(ctx, bookingID, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approved, nil
+}
+
+// RejectBooking updates booking status to 'rejected'
+func (r *BookingRepository) RejectBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+	var rejected *models.Booking
+	err := r.allocate(ctx, func(a *repository.Allocation) (err error) {
+		rejected, err = a.Reject(ctx, bookingID, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejected, nil
+}
+
+// PreemptBooking rejects approved bookings overlapping the given one, most
+// recently created first, until it fits, and approves it
+func (r *BookingRepository) PreemptBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+	var approved *models.Booking
+	err := r.allocate(ctx, func(a *repository.Allocation) (err error) {
+		approved, err = a.Preempt(ctx, bookingID, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approved, nil
+}
+
+// GetByID fetches a single booking with its room name
+func (r *BookingRepository) GetByID(ctx context.Context, id int) (*models.Booking, error) {
+	var found models.Booking
+	err := r.db.read(ctx, func() error {
+		b := r.db.booking(id)
+		if b == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrBookingNotFound, id)
+		}
+		found = r.db.bookingView(b, true)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &found, nil
+}
+
+// GetAll fetches all bookings across all rooms, latest start first
+func (r *BookingRepository) GetAll(ctx context.Context) ([]models.Booking, error) {
+	var bookings []models.Booking
+	err := r.db.read(ctx, func() error {
+		for _, b := range r.db.bookings {
+			bookings = append(bookings, r.db.bookingView(b, false))
+		}
+		return nil
+	})
+	sort.SliceStable(bookings, func(i, j int) bool { return bookings[i].StartTime.After(bookings[j].StartTime) })
+	return bookings, err
+}
+
+// matchesFilter applies a BookingFilter the way bookingFilterClause does
+func matchesFilter(b *models.Booking, f models.BookingFilter) bool {
+	switch {
+	case f.RoomID != nil && b.RoomID != *f.RoomID,
+		f.UserID != nil && b.UserID != *f.UserID,
+		f.Status != "" && b.Status != f.Status,
+		f.To != nil && !b.StartTime.Before(*f.To),
+		f.From != nil && !b.EndTime.After(*f.From),
+		f.CreatedAfter != nil && b.CreatedAt.Before(*f.CreatedAfter),
+		f.CreatedBefore != nil && !b.CreatedAt.Before(*f.CreatedBefore):
+		return false
+	}
+	return true
+}
+
+// sortKey is the value a listing orders by, ahead of the id tie-breaker
+func sortKey(b *models.Booking, sort string) time.Time {
+	switch sort {
+	case "start_time":
+		return b.StartTime
+	case "created_at":
+		return b.CreatedAt
+	}
+	return time.Time{}
+}
+
+// List returns one page of bookings matching q.Filter using the same keyset
+// cursors as the Postgres repository, together with the total number of matches
+func (r *BookingRepository) List(ctx context.Context, q models.BookingListQuery) (*models.BookingPage, error) {
+	if !repository.IsBookingSortKey(q.Sort) {
+		return nil, fmt.Errorf("unsupported sort key: %s", q.Sort)
+	}
+	cur, err := repository.ParseBookingCursor(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// before reports whether a sorts ahead of b in ascending order
+	before := func(a, b *models.Booking) bool {
+		ka, kb := sortKey(a, q.Sort), sortKey(b, q.Sort)
+		if !ka.Equal(kb) {
+			return ka.Before(kb)
+		}
+		return a.ID < b.ID
+	}
+	var after *models.Booking
+	if cur != nil {
+		after = &models.Booking{ID: cur.ID, StartTime: cur.Value, CreatedAt: cur.Value}
+	}
+
+	page := &models.BookingPage{Data: []models.Booking{}}
+	var matches []*models.Booking
+	err = r.db.read(ctx, func() error {
+		for _, b := range r.db.bookings {
+			if !matchesFilter(b, q.Filter) {
+				continue
+			}
+			page.Total++
+			if after != nil && (q.Desc && !before(b, after) || !q.Desc && !before(after, b)) {
+				continue
+			}
+			matches = append(matches, b)
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			if q.Desc {
+				return before(matches[j], matches[i])
+			}
+			return before(matches[i], matches[j])
+		})
+		if len(matches) > q.Limit+1 {
+			matches = matches[:q.Limit+1]
+		}
+		for _, b := range matches {
+			page.Data = append(page.Data, r.db.bookingView(b, true))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(page.Data) > q.Limit {
+		page.Data = page.Data[:q.Limit]
+		page.NextCursor = repository.NextBookingCursor(q, &page.Data[len(page.Data)-1])
+	}
+	return page, nil
+}
+
+// StreamBookings hands the bookings matching f to fn, latest start first. The
+// rows are copied under the lock and handed out after it is released.
+func (r *BookingRepository) StreamBookings(ctx context.Context, f models.BookingFilter, fn func(*models.Booking) error) error {
+	var bookings []models.Booking
+	err := r.db.read(ctx, func() error {
+		for _, b := range r.db.bookings {
+			if matchesFilter(b, f) {
+				bookings = append(bookings, r.db.bookingView(b, true))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(bookings, func(i, j int) bool { return bookings[i].StartTime.After(bookings[j].StartTime) })
+	for i := range bookings {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&bookings[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAll clears all bookings and bundles and restarts their ids
+func (r *BookingRepository) DeleteAll(ctx context.Context) error {
+	return r.db.write(ctx, func(tx *tx) error {
+		bookings, byRoom, bundles := tx.bookings, tx.byRoom, tx.bundles
+		tx.onRollback(func() {
+			tx.bookings, tx.byRoom, tx.bundles = bookings, byRoom, bundles
+		})
+		tx.bookings, tx.byRoom, tx.bundles = nil, map[int][]*models.Booking{}, nil
+		return nil
+	})
+}
+
+// GetSystemStats aggregates booking counters for the dashboard
+func (r *BookingRepository) GetSystemStats(ctx context.Context) (*repository.SystemStats, error) {
+	var stats repository.SystemStats
+	err := r.db.read(ctx, func() error {
+		stats.TotalBookings = len(r.db.bookings)
+		for _, b := range r.db.bookings {
+			switch b.Status {
+			case "approved":
+				stats.ActiveBookings++
+			case "rejected":
+				stats.Conflicts++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Utilization is today's booked hours over today's available hours
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	today, err := r.GetUtilization(ctx, models.UtilizationQuery{
+		From:        dayStart,
+		To:          dayStart.AddDate(0, 0, 1),
+		Granularity: "day",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(today) > 0 {
+		stats.Utilization = today[0].Utilization
+	}
+	return &stats, nil
+}
@@ -0,0 +1,134 @@
+// Package memory keeps the whole allocation state in process memory, behind
+// the same store interfaces as the Postgres repositories. It follows their
+// semantics (sentinel errors, row versions, cursors) and allocates by the very
+// same rules, repository.Allocation, so the service runs without a database
+// for development, demos and tests; nothing survives a restart.
+//
+// A single lock serializes every write, which is stricter than the row locks
+// of Postgres: allocations never interleave at all. A write that fails is
+// undone as a whole, like a rolled back transaction.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+// Database holds the rows of every table. Rooms, bookings and bundles are
+// stored by id-1, as their ids are never reused; groups can be deleted.
+type Database struct {
+	mu sync.RWMutex
+
+	rooms       []*room
+	groups      map[int]*group
+	lastGroupID int
+	bookings    []*models.Booking
+	byRoom      map[int][]*models.Booking // bookings of each room, in insertion order
+	bundles     []*bundle
+	keys        map[idempotencyKey]*idempotencyRecord
+
+	zones alloc.Zones
+}
+
+// NewDatabase returns an empty database
+func NewDatabase() *Database {
+	return &Database{
+		groups: map[int]*group{},
+		byRoom: map[int][]*models.Booking{},
+		keys:   map[idempotencyKey]*idempotencyRecord{},
+	}
+}
+
+// tx is one write under the exclusive lock. Every change registers how to
+// undo it; the changes of a failed write are undone newest first.
+type tx struct {
+	*Database
+	now  time.Time // transaction time, as NOW() in Postgres
+	undo []func()
+}
+
+func (tx *tx) onRollback(fn func()) {
+	tx.undo = append(tx.undo, fn)
+}
+
+func (tx *tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
+
+// read runs fn under the shared lock
+func (db *Database) read(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return fn()
+}
+
+// write runs fn under the exclusive lock and undoes its changes if it fails
+func (db *Database) write(ctx context.Context, fn func(tx *tx) error) error {
+	return db.run(ctx, true, fn)
+}
+
+// simulate runs fn like write but always undoes its changes
+func (db *Database) simulate(ctx context.Context, fn func(tx *tx) error) error {
+	return db.run(ctx, false, fn)
+}
+
+func (db *Database) run(ctx context.Context, commit bool, fn func(tx *tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx := &tx{Database: db, now: instant(time.Now())}
+	err := fn(tx)
+	if err != nil || !commit {
+		tx.rollback()
+	}
+	return err
+}
+
+// instant stores t as a TIMESTAMPTZ column would: in UTC, to the microsecond
+func instant(t time.Time) time.Time {
+	return t.UTC().Round(time.Microsecond)
+}
+
+func copyInt(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyString(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyBool(p *bool) *bool {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyFloat(p *float64) *float64 {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
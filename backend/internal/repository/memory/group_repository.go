@@ -0,0 +1,273 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+type group struct {
+	id                   int
+	name, kind           string
+	parentID             *int
+	policy               models.Policy // as set on the group, hours normalized
+	createdAt, updatedAt time.Time
+	version              int
+}
+
+// effectivePolicy is the policy of a group after inheritance: each setting
+// comes from the nearest group on the path to the root that sets it
+type effectivePolicy struct {
+	models.Policy
+	quotaGroupID int // the group whose subtree the daily quota counts
+}
+
+func (db *Database) effectivePolicy(groupID *int) effectivePolicy {
+	var p effectivePolicy
+	for id := groupID; id != nil; {
+		g := db.groups[*id]
+		if g == nil {
+			break
+		}
+		if p.OpensAt == nil && g.policy.OpensAt != nil {
+			p.OpensAt, p.ClosesAt = g.policy.OpensAt, g.policy.ClosesAt
+		}
+		if p.RequiresApproval == nil && g.policy.RequiresApproval != nil {
+			p.RequiresApproval = g.policy.RequiresApproval
+		}
+		if p.DailyQuotaHours == nil && g.policy.DailyQuotaHours != nil {
+			p.DailyQuotaHours, p.quotaGroupID = g.policy.DailyQuotaHours, g.id
+		}
+		id = g.parentID
+	}
+	return p
+}
+
+// inGroup reports whether groupID lies in the subtree of ancestor; a nil
+// ancestor matches everything
+func (db *Database) inGroup(groupID, ancestor *int) bool {
+	if ancestor == nil {
+		return true
+	}
+	for id := groupID; id != nil; {
+		if *id == *ancestor {
+			return true
+		}
+		g := db.groups[*id]
+		if g == nil {
+			return false
+		}
+		id = g.parentID
+	}
+	return false
+}
+
+// storedPolicy copies p as the columns would hold it: hours as TIME, the
+// quota as NUMERIC(6, 2)
+func storedPolicy(p models.Policy) models.Policy {
+	out := models.Policy{RequiresApproval: copyBool(p.RequiresApproval)}
+	if p.OpensAt != nil && p.ClosesAt != nil {
+		opens, closes := alloc.Clock(*p.OpensAt), alloc.Clock(*p.ClosesAt)
+		out.OpensAt, out.ClosesAt = &opens, &closes
+	}
+	if p.DailyQuotaHours != nil {
+		quota := math.Round(*p.DailyQuotaHours*100) / 100
+		out.DailyQuotaHours = &quota
+	}
+	return out
+}
+
+func copyPolicy(p models.Policy) models.Policy {
+	return models.Policy{
+		OpensAt:          copyString(p.OpensAt),
+		ClosesAt:         copyString(p.ClosesAt),
+		RequiresApproval: copyBool(p.RequiresApproval),
+		DailyQuotaHours:  copyFloat(p.DailyQuotaHours),
+	}
+}
+
+type GroupRepository struct {
+	db *Database
+}
+
+func NewGroupRepository(db *Database) *GroupRepository {
+	return &GroupRepository{db: db}
+}
+
+// view renders a group with its effective policy and the capacity of the
+// active rooms in its subtree
+func (db *Database) groupView(g *group) *models.ResourceGroup {
+	view := &models.ResourceGroup{
+		ID:        g.id,
+		Name:      g.name,
+		Kind:      g.kind,
+		ParentID:  copyInt(g.parentID),
+		Policy:    copyPolicy(g.policy),
+		Effective: copyPolicy(db.effectivePolicy(&g.id).Policy),
+		CreatedAt: g.createdAt,
+		UpdatedAt: g.updatedAt,
+		Version:   g.version,
+	}
+	for _, r := range db.rooms {
+		if r.archivedAt != nil || r.groupID == nil || !db.inGroup(r.groupID, &g.id) {
+			continue
+		}
+		view.RoomCount++
+		view.TotalCapacity += r.capacity
+		if r.status == models.RoomStatusOnline {
+			view.OnlineCapacity += r.capacity
+		}
+	}
+	return view
+}
+
+// checkGroupWrite enforces the constraints of the resource_groups table on g,
+// written as group id (0 for a new one)
+func (db *Database) checkGroupWrite(id int, g *models.ResourceGroup) error {
+	for _, other := range db.groups {
+		if other.id != id && other.name == g.Name {
+			return repository.ErrGroupNameTaken
+		}
+	}
+	if g.ParentID != nil && db.groups[*g.ParentID] == nil {
+		return fmt.Errorf("%w with id: %d", repository.ErrParentGroupNotFound, *g.ParentID)
+	}
+	return nil
+}
+
+// Create inserts a group. The caller checks that the parent is of an earlier kind.
+func (r *GroupRepository) Create(ctx context.Context, g *models.ResourceGroup) (*models.ResourceGroup, error) {
+	var created *models.ResourceGroup
+	err := r.db.write(ctx, func(tx *tx) error {
+		if err := tx.checkGroupWrite(0, g); err != nil {
+			return err
+		}
+		tx.lastGroupID++
+		row := &group{
+			id:        tx.lastGroupID,
+			name:      g.Name,
+			kind:      g.Kind,
+			parentID:  copyInt(g.ParentID),
+			policy:    storedPolicy(g.Policy),
+			createdAt: tx.now,
+			updatedAt: tx.now,
+			version:   1,
+		}
+		tx.groups[row.id] = row
+		tx.onRollback(func() {
+			delete(tx.groups, row.id)
+			tx.lastGroupID--
+		})
+		created = tx.groupView(row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetByID fetches a single group
+func (r *GroupRepository) GetByID(ctx context.Context, id int) (*models.ResourceGroup, error) {
+	var found *models.ResourceGroup
+	err := r.db.read(ctx, func() error {
+		g := r.db.groups[id]
+		if g == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrGroupNotFound, id)
+		}
+		found = r.db.groupView(g)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// List returns the groups of one kind, or all groups when kind is empty
+func (r *GroupRepository) List(ctx context.Context, kind string) ([]models.ResourceGroup, error) {
+	groups := []models.ResourceGroup{}
+	err := r.db.read(ctx, func() error {
+		for _, g := range r.db.groups {
+			if kind == "" || g.kind == kind {
+				groups = append(groups, *r.db.groupView(g))
+			}
+		}
+		return nil
+	})
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, err
+}
+
+// Update writes name, parent and policy of g only if the group is still at
+// version (or version is AnyVersion). The kind of a group never changes.
+func (r *GroupRepository) Update(ctx context.Context, id, version int, g *models.ResourceGroup) (*models.ResourceGroup, error) {
+	var updated *models.ResourceGroup
+	err := r.db.write(ctx, func(tx *tx) error {
+		row := tx.groups[id]
+		if row == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrGroupNotFound, id)
+		}
+		if version != repository.AnyVersion && row.version != version {
+			return fmt.Errorf("resource group %d: %w", id, repository.ErrStaleVersion)
+		}
+		if err := tx.checkGroupWrite(id, g); err != nil {
+			return err
+		}
+		tx.touchGroup(row)
+		row.name = g.Name
+		row.parentID = copyInt(g.ParentID)
+		row.policy = storedPolicy(g.Policy)
+		updated = tx.groupView(row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Delete removes an empty group. Archived rooms still pointing at it are
+// detached; active rooms and child groups must be moved away first.
+func (r *GroupRepository) Delete(ctx context.Context, id int) error {
+	return r.db.write(ctx, func(tx *tx) error {
+		row := tx.groups[id]
+		if row == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrGroupNotFound, id)
+		}
+		for _, g := range tx.groups {
+			if g.parentID != nil && *g.parentID == id {
+				return repository.ErrGroupNotEmpty
+			}
+		}
+		for _, rm := range tx.rooms {
+			if rm.groupID != nil && *rm.groupID == id && rm.archivedAt == nil {
+				return repository.ErrGroupNotEmpty
+			}
+		}
+
+		for _, rm := range tx.rooms {
+			if rm.groupID != nil && *rm.groupID == id {
+				tx.touchRoom(rm)
+				rm.groupID = nil
+			}
+		}
+		delete(tx.groups, id)
+		tx.onRollback(func() { tx.groups[id] = row })
+		return nil
+	})
+}
+
+// touchGroup saves g for rollback and stamps it as updated, as the triggers do
+func (tx *tx) touchGroup(g *group) {
+	old := *g
+	tx.onRollback(func() { *g = old })
+	g.version++
+	g.updatedAt = tx.now
+}
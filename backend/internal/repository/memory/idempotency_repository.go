@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+type idempotencyKey struct {
+	scope, key string
+}
+
+type idempotencyRecord struct {
+	models.IdempotencyRecord // StatusCode 0 while in flight
+	expiresAt                time.Time
+}
+
+type IdempotencyRepository struct {
+	db *Database
+}
+
+func NewIdempotencyRepository(db *Database) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+// Reserve claims (scope, key) for lease. It returns nil when the caller now owns
+// the key and must process the request, or the live record already holding it.
+// Expired records, including leases of requests that never completed, are taken over.
+func (r *IdempotencyRepository) Reserve(ctx context.Context, scope, key, fingerprint string, lease time.Duration) (*models.IdempotencyRecord, error) {
+	var held *models.IdempotencyRecord
+	err := r.db.write(ctx, func(tx *tx) error {
+		k := idempotencyKey{scope, key}
+		if rec := tx.keys[k]; rec != nil && !rec.expiresAt.Before(tx.now) {
+			copied := rec.IdempotencyRecord
+			copied.Body = append([]byte(nil), rec.Body...)
+			held = &copied
+			return nil
+		}
+		tx.keys[k] = &idempotencyRecord{
+			IdempotencyRecord: models.IdempotencyRecord{Fingerprint: fingerprint},
+			expiresAt:         tx.now.Add(lease),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// Complete stores the response of a reserved key and keeps it for ttl
+func (r *IdempotencyRepository) Complete(ctx context.Context, scope, key string, rec *models.IdempotencyRecord, ttl time.Duration) error {
+	return r.db.write(ctx, func(tx *tx) error {
+		held := tx.keys[idempotencyKey{scope, key}]
+		if held == nil || held.Fingerprint != rec.Fingerprint || held.StatusCode != 0 {
+			return nil
+		}
+		held.StatusCode = rec.StatusCode
+		held.ContentType = rec.ContentType
+		held.Body = append([]byte(nil), rec.Body...)
+		held.expiresAt = tx.now.Add(ttl)
+		return nil
+	})
+}
+
+// Release drops an in-flight reservation so the client may retry with the same key
+func (r *IdempotencyRepository) Release(ctx context.Context, scope, key, fingerprint string) error {
+	return r.db.write(ctx, func(tx *tx) error {
+		k := idempotencyKey{scope, key}
+		if held := tx.keys[k]; held != nil && held.Fingerprint == fingerprint && held.StatusCode == 0 {
+			delete(tx.keys, k)
+		}
+		return nil
+	})
+}
+
+// PurgeExpired deletes records past their TTL and returns how many were removed
+func (r *IdempotencyRepository) PurgeExpired(ctx context.Context) (int64, error) {
+	var purged int64
+	err := r.db.write(ctx, func(tx *tx) error {
+		for k, rec := range tx.keys {
+			if rec.expiresAt.Before(tx.now) {
+				delete(tx.keys, k)
+				purged++
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
@@ -0,0 +1,34 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/indraprhmbd/allocra/internal/repository/memory"
+	"github.com/indraprhmbd/allocra/internal/repository/storetest"
+	"github.com/indraprhmbd/allocra/internal/services"
+)
+
+var (
+	_ services.BookingStore     = (*memory.BookingRepository)(nil)
+	_ services.RoomStore        = (*memory.RoomRepository)(nil)
+	_ services.GroupStore       = (*memory.GroupRepository)(nil)
+	_ services.IdempotencyStore = (*memory.IdempotencyRepository)(nil)
+)
+
+func stores(t *testing.T) storetest.Stores {
+	db := memory.NewDatabase()
+	return storetest.Stores{
+		Bookings:    memory.NewBookingRepository(db),
+		Rooms:       memory.NewRoomRepository(db),
+		Groups:      memory.NewGroupRepository(db),
+		Idempotency: memory.NewIdempotencyRepository(db),
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, stores)
+}
+
+func TestModel(t *testing.T) {
+	storetest.Model(t, stores)
+}
@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository/utilization"
+)
+
+// GetMonthlyUsage aggregates approved bookings starting in [from, to)
+func (r *BookingRepository) GetMonthlyUsage(ctx context.Context, from, to time.Time) ([]models.MonthlyUsageReport, error) {
+	var reports []models.MonthlyUsageReport
+	err := r.StreamMonthlyUsage(ctx, from, to, func(report *models.MonthlyUsageReport) error {
+		reports = append(reports, *report)
+		return nil
+	})
+	return reports, err
+}
+
+// StreamMonthlyUsage hands each row of the monthly usage report to fn
+func (r *BookingRepository) StreamMonthlyUsage(ctx context.Context, from, to time.Time, fn func(*models.MonthlyUsageReport) error) error {
+	var reports []*models.MonthlyUsageReport
+	err := r.db.read(ctx, func() error {
+		byRoom := map[int]*models.MonthlyUsageReport{}
+		for _, b := range r.db.bookings {
+			if b.Status != "approved" || b.StartTime.Before(from) || !b.StartTime.Before(to) {
+				continue
+			}
+			report := byRoom[b.RoomID]
+			if report == nil {
+				report = &models.MonthlyUsageReport{RoomID: b.RoomID, RoomName: r.db.room(b.RoomID).name}
+				byRoom[b.RoomID] = report
+				reports = append(reports, report)
+			}
+			report.TotalBookings++
+			report.TotalHours += b.EndTime.Sub(b.StartTime).Hours()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].TotalHours > reports[j].TotalHours })
+	for _, report := range reports {
+		if err := fn(report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetGroupMonthlyUsage rolls approved bookings starting in [from, to) up to the
+// groups of kind; rooms with no ancestor of that kind are left out
+func (r *BookingRepository) GetGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time) ([]models.GroupUsageReport, error) {
+	var reports []models.GroupUsageReport
+	err := r.StreamGroupMonthlyUsage(ctx, kind, from, to, func(report *models.GroupUsageReport) error {
+		reports = append(reports, *report)
+		return nil
+	})
+	return reports, err
+}
+
+// StreamGroupMonthlyUsage hands each row of the rolled-up monthly usage report to fn
+func (r *BookingRepository) StreamGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time, fn func(*models.GroupUsageReport) error) error {
+	var reports []*models.GroupUsageReport
+	err := r.db.read(ctx, func() error {
+		byGroup := map[int]*models.GroupUsageReport{}
+		rooms := map[int]map[int]bool{}
+		for _, b := range r.db.bookings {
+			if b.Status != "approved" || b.StartTime.Before(from) || !b.StartTime.Before(to) {
+				continue
+			}
+			for id := r.db.room(b.RoomID).groupID; id != nil; {
+				g := r.db.groups[*id]
+				if g == nil {
+					break
+				}
+				if g.kind == kind {
+					report := byGroup[g.id]
+					if report == nil {
+						report = &models.GroupUsageReport{GroupID: g.id, GroupName: g.name, Kind: g.kind}
+						byGroup[g.id] = report
+						rooms[g.id] = map[int]bool{}
+						reports = append(reports, report)
+					}
+					rooms[g.id][b.RoomID] = true
+					report.Rooms = len(rooms[g.id])
+					report.TotalBookings++
+					report.TotalHours += b.EndTime.Sub(b.StartTime).Hours()
+				}
+				id = g.parentID
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].TotalHours > reports[j].TotalHours })
+	for _, report := range reports {
+		if err := fn(report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUtilization computes booked hours over available hours for each bucket of
+// q, as the Postgres report does; see package utilization
+func (r *BookingRepository) GetUtilization(ctx context.Context, q models.UtilizationQuery) ([]models.UtilizationBucket, error) {
+	var rooms []utilization.Room
+	var bookings []utilization.Booking
+	err := r.db.read(ctx, func() error {
+		for _, rm := range r.db.rooms {
+			if (q.RoomID != nil && rm.id != *q.RoomID) || (q.Type != "" && rm.roomType != q.Type) ||
+				!r.db.inGroup(rm.groupID, q.GroupID) {
+				continue
+			}
+			loc, err := r.db.zones.Load(rm.timezone)
+			if err != nil {
+				return fmt.Errorf("invalid time zone of room %d: %w", rm.id, err)
+			}
+			view := r.db.roomView(rm)
+			rooms = append(rooms, utilization.Room{
+				ID:       rm.id,
+				Online:   rm.status == models.RoomStatusOnline,
+				Location: loc,
+				OpensAt:  view.OpensAt,
+				ClosesAt: view.ClosesAt,
+			})
+			for _, b := range r.db.byRoom[rm.id] {
+				bookings = append(bookings, utilization.Booking{RoomID: b.RoomID, Status: b.Status, Start: b.StartTime, End: b.EndTime})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utilization.Compute(q, rooms, bookings)
+}
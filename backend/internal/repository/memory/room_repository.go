@@ -0,0 +1,333 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+type room struct {
+	id                   int
+	name                 string
+	capacity, quantity   int
+	roomType, status     string
+	opensAt, closesAt    *string // the room's own hours, nil when inherited
+	timezone             string
+	labels               map[string]string
+	groupID              *int
+	archivedAt           *time.Time
+	createdAt, updatedAt time.Time
+	version              int
+}
+
+type RoomRepository struct {
+	db *Database
+}
+
+func NewRoomRepository(db *Database) *RoomRepository {
+	return &RoomRepository{db: db}
+}
+
+// room returns the room with id, archived or not
+func (db *Database) room(id int) *room {
+	if id < 1 || id > len(db.rooms) {
+		return nil
+	}
+	return db.rooms[id-1]
+}
+
+// activeRoom returns the room with id unless it is archived
+func (db *Database) activeRoom(id int) *room {
+	if r := db.room(id); r != nil && r.archivedAt == nil {
+		return r
+	}
+	return nil
+}
+
+// roomView renders a room with the hours and policy it gets from its group.
+// Hours a room does not set itself come from its group, else the whole day.
+func (db *Database) roomView(r *room) models.Room {
+	p := db.effectivePolicy(r.groupID)
+	view := models.Room{
+		ID:               r.id,
+		Name:             r.name,
+		Capacity:         r.capacity,
+		Type:             r.roomType,
+		Quantity:         r.quantity,
+		Status:           r.status,
+		OpensAt:          "00:00:00",
+		ClosesAt:         "24:00:00",
+		Timezone:         r.timezone,
+		Labels:           make(map[string]string, len(r.labels)),
+		GroupID:          copyInt(r.groupID),
+		HoursInherited:   r.opensAt == nil,
+		RequiresApproval: p.RequiresApproval != nil && *p.RequiresApproval,
+		DailyQuotaHours:  copyFloat(p.DailyQuotaHours),
+		CreatedAt:        r.createdAt,
+		UpdatedAt:        r.updatedAt,
+		Version:          r.version,
+	}
+	switch {
+	case r.opensAt != nil:
+		view.OpensAt, view.ClosesAt = *r.opensAt, *r.closesAt
+	case p.OpensAt != nil:
+		view.OpensAt, view.ClosesAt = *p.OpensAt, *p.ClosesAt
+	}
+	for k, v := range r.labels {
+		view.Labels[k] = v
+	}
+	return view
+}
+
+// setRoomFields copies the writable fields of src onto r
+func setRoomFields(r *room, src *models.Room) {
+	r.name = src.Name
+	r.capacity = src.Capacity
+	r.roomType = src.Type
+	r.status = src.Status
+	r.quantity = src.Quantity
+	r.timezone = src.Timezone
+	r.groupID = copyInt(src.GroupID)
+	r.opensAt, r.closesAt = nil, nil
+	if !src.HoursInherited {
+		opens, closes := alloc.Clock(src.OpensAt), alloc.Clock(src.ClosesAt)
+		r.opensAt, r.closesAt = &opens, &closes
+	}
+	r.labels = make(map[string]string, len(src.Labels))
+	for k, v := range src.Labels {
+		r.labels[k] = v
+	}
+}
+
+// checkRoomWrite enforces the constraints of the rooms table on fields, written
+// as room id (0 for a new one): unique names among active rooms and an
+// existing group
+func (db *Database) checkRoomWrite(id int, fields *models.Room) error {
+	for _, other := range db.rooms {
+		if other.id != id && other.archivedAt == nil && other.name == fields.Name {
+			return repository.ErrRoomNameTaken
+		}
+	}
+	if fields.GroupID != nil && db.groups[*fields.GroupID] == nil {
+		return fmt.Errorf("%w with id: %d", repository.ErrGroupNotFound, *fields.GroupID)
+	}
+	return nil
+}
+
+func (r *RoomRepository) Create(ctx context.Context, fields *models.Room) (*models.Room, error) {
+	var created models.Room
+	err := r.db.write(ctx, func(tx *tx) error {
+		if err := tx.checkRoomWrite(0, fields); err != nil {
+			return err
+		}
+		row := &room{id: len(tx.rooms) + 1, createdAt: tx.now, updatedAt: tx.now, version: 1}
+		setRoomFields(row, fields)
+		tx.rooms = append(tx.rooms, row)
+		tx.onRollback(func() { tx.rooms = tx.rooms[:len(tx.rooms)-1] })
+		created = tx.roomView(row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetAll lists active rooms matching f
+func (r *RoomRepository) GetAll(ctx context.Context, f models.RoomFilter) ([]models.Room, error) {
+	rooms := []models.Room{}
+	err := r.db.read(ctx, func() error {
+		for _, rm := range r.db.rooms {
+			if rm.archivedAt == nil && r.db.inGroup(rm.groupID, f.GroupID) && f.Selector.Matches(rm.labels) {
+				rooms = append(rooms, r.db.roomView(rm))
+			}
+		}
+		return nil
+	})
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms, err
+}
+
+// FindAvailable lists active online rooms matching q that keep at least
+// q.Quantity units free throughout [q.Start, q.End), smallest capacity first,
+// with the units that stay free
+func (r *RoomRepository) FindAvailable(ctx context.Context, q models.AvailabilityQuery) ([]models.Room, error) {
+	units := alloc.Units(q.Quantity)
+	rooms := []models.Room{}
+	err := r.db.read(ctx, func() error {
+		for _, rm := range r.db.rooms {
+			if rm.archivedAt != nil || rm.status != models.RoomStatusOnline || rm.capacity < q.MinCapacity ||
+				(q.Type != "" && rm.roomType != q.Type) || !r.db.inGroup(rm.groupID, q.GroupID) || !q.Selector.Matches(rm.labels) {
+				continue
+			}
+			free := rm.quantity - r.db.peakUsage(rm.id, q.Start, q.End)
+			if free < units {
+				continue
+			}
+			view := r.db.roomView(rm)
+			view.FreeUnits = &free
+			rooms = append(rooms, view)
+		}
+		return nil
+	})
+	sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].Capacity < rooms[j].Capacity })
+	return rooms, err
+}
+
+// GetByID fetches a single room
+func (r *RoomRepository) GetByID(ctx context.Context, id int) (*models.Room, error) {
+	var found models.Room
+	err := r.db.read(ctx, func() error {
+		rm := r.db.activeRoom(id)
+		if rm == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrRoomNotFound, id)
+		}
+		found = r.db.roomView(rm)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &found, nil
+}
+
+// Update writes the mutable fields of a room only if the room is still at
+// version (or version is AnyVersion) and returns the room as written
+func (r *RoomRepository) Update(ctx context.Context, id, version int, fields *models.Room) (*models.Room, error) {
+	var updated models.Room
+	err := r.db.write(ctx, func(tx *tx) error {
+		rm := tx.activeRoom(id)
+		if rm == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrRoomNotFound, id)
+		}
+		if version != repository.AnyVersion && rm.version != version {
+			return fmt.Errorf("room %d: %w", id, repository.ErrStaleVersion)
+		}
+		if err := tx.checkRoomWrite(id, fields); err != nil {
+			return err
+		}
+		tx.touchRoom(rm)
+		setRoomFields(rm, fields)
+		updated = tx.roomView(rm)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// Archive takes a room out of service while keeping it, and its bookings, for
+// history; see repository.RoomRepository.Archive for the strategies
+func (r *RoomRepository) Archive(ctx context.Context, id int, strategy string) (*models.ArchiveResult, error) {
+	var result *models.ArchiveResult
+	err := r.db.write(ctx, func(tx *tx) error {
+		result = &models.ArchiveResult{RoomID: id, Rejected: []int{}, Reassigned: []models.Reassignment{}}
+
+		rm := tx.activeRoom(id)
+		if rm == nil {
+			return fmt.Errorf("%w with id: %d", repository.ErrRoomNotFound, id)
+		}
+
+		pending := tx.rejectUpcoming(id, "pending")
+		switch strategy {
+		case models.ArchiveStrategyReject:
+			result.Rejected = append(result.Rejected, tx.rejectUpcoming(id, "approved")...)
+		case models.ArchiveStrategyReassign:
+			var err error
+			if result.Reassigned, err = tx.reassignBookings(rm); err != nil {
+				return err
+			}
+		default:
+			upcoming := 0
+			for _, b := range tx.byRoom[id] {
+				if b.Status == "approved" && b.EndTime.After(tx.now) {
+					upcoming++
+				}
+			}
+			if upcoming > 0 {
+				return fmt.Errorf("%w: %d approved booking(s) have not ended yet", repository.ErrRoomHasUpcomingBookings, upcoming)
+			}
+		}
+		result.Rejected = append(result.Rejected, pending...)
+
+		tx.touchRoom(rm)
+		archivedAt := tx.now
+		rm.archivedAt = &archivedAt
+		rm.status = models.RoomStatusOffline
+		result.ArchivedAt = archivedAt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rejectUpcoming rejects the bookings of a room in status that have not ended,
+// along with the rest of any bundle they belong to
+func (tx *tx) rejectUpcoming(roomID int, status string) []int {
+	ids := []int{}
+	for _, b := range tx.byRoom[roomID] {
+		if b.Status == status && b.EndTime.After(tx.now) {
+			tx.setBookingStatus(b, "rejected")
+			ids = append(ids, b.ID)
+		}
+	}
+	sort.Ints(ids)
+	tx.rejectBundlesOf(ids)
+	return ids
+}
+
+// reassignBookings moves every approved booking of rm that has not ended to
+// another room with enough free units, earliest first, so later bookings see
+// the earlier moves
+func (tx *tx) reassignBookings(rm *room) ([]models.Reassignment, error) {
+	var upcoming []*models.Booking
+	for _, b := range tx.byRoom[rm.id] {
+		if b.Status == "approved" && b.EndTime.After(tx.now) {
+			upcoming = append(upcoming, b)
+		}
+	}
+	sort.Slice(upcoming, func(i, j int) bool {
+		if !upcoming[i].StartTime.Equal(upcoming[j].StartTime) {
+			return upcoming[i].StartTime.Before(upcoming[j].StartTime)
+		}
+		return upcoming[i].ID < upcoming[j].ID
+	})
+
+	moved := make([]models.Reassignment, 0, len(upcoming))
+	for _, b := range upcoming {
+		var target *room
+		for _, c := range tx.rooms {
+			if c.id == rm.id || c.archivedAt != nil || c.status != models.RoomStatusOnline ||
+				c.roomType != rm.roomType || c.capacity < rm.capacity ||
+				c.quantity-tx.peakUsage(c.id, b.StartTime, b.EndTime) < b.Quantity {
+				continue
+			}
+			if target == nil || c.capacity < target.capacity {
+				target = c
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("%w for booking %d (%s - %s)", repository.ErrNoReassignmentTarget, b.ID,
+				b.StartTime.Format(time.RFC3339), b.EndTime.Format(time.RFC3339))
+		}
+		tx.moveBooking(b, target.id)
+		moved = append(moved, models.Reassignment{BookingID: b.ID, RoomID: target.id})
+	}
+	return moved, nil
+}
+
+// touchRoom saves r for rollback and stamps it as updated, as the triggers do
+func (tx *tx) touchRoom(r *room) {
+	old := *r
+	tx.onRollback(func() { *r = old })
+	r.version++
+	r.updatedAt = tx.now
+}
@@ -2,6 +2,9 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -16,84 +19,468 @@ func NewRoomRepository(db *Database) *RoomRepository {
     return &RoomRepository{db: db}
 }
 
-func (r *RoomRepository) Create(ctx context.Context, name string, capacity int, roomType string, status string) (*models.Room, error) {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-    defer cancel()
-    
-    query := `
-        INSERT INTO rooms (name, capacity, type, status)
-        VALUES ($1, $2, $3, $4)
-        RETURNING id, name, capacity, type, status, created_at
-    `
-    
-    var room models.Room
-    err := r.db.DB.QueryRowContext(ctx, query, name, capacity, roomType, status).Scan(
+// ErrRoomNotFound is returned when no room has the requested id
+var ErrRoomNotFound = errors.New("room not found")
+
+// ErrRoomNameTaken is returned when another room already uses the name
+var ErrRoomNameTaken = errors.New("room name already in use")
+
+var (
+    // ErrRoomHasUpcomingBookings blocks archiving a room that still has commitments
+    ErrRoomHasUpcomingBookings = errors.New("room has upcoming approved bookings; retry with strategy=reject or strategy=reassign")
+    // ErrNoReassignmentTarget means no other room can take over a booking
+    ErrNoReassignmentTarget = errors.New("no free room of the same type and capacity")
+)
+
+// roomColumns is the select list read by scanRoom, over rooms r joined to the
+// group_policies gp of its group (see roomsWithPolicies). Hours a room does not
+// set itself come from its group, else the whole day.
+const roomColumns = `r.id, r.name, r.capacity, r.type, r.quantity, r.status,
+    COALESCE(r.opens_at, gp.opens_at, '00:00'), COALESCE(r.closes_at, gp.closes_at, '24:00'), r.opens_at IS NULL,
+    r.timezone, r.group_id, COALESCE(gp.requires_approval, FALSE), gp.daily_quota_hours,
+    r.labels, r.created_at, r.updated_at, r.version`
+
+// roomsWithPolicies is the FROM clause roomColumns reads
+const roomsWithPolicies = `rooms r LEFT JOIN group_policies gp ON gp.group_id = r.group_id`
+
+// inGroup is the condition that a room's group lies below the group in parameter n
+func inGroup(column string, n int) string {
+    return fmt.Sprintf("($%[2]d::int IS NULL OR %[1]s IN (SELECT descendant_id FROM group_closure WHERE ancestor_id = $%[2]d))", column, n)
+}
+
+// scanRoom reads roomColumns, followed by any extra selected columns
+func scanRoom(row rowScanner, room *models.Room, extra ...interface{}) error {
+    var labelDoc []byte
+    var groupID sql.NullInt64
+    var quota sql.NullFloat64
+    dest := []interface{}{
         &room.ID,
         &room.Name,
         &room.Capacity,
         &room.Type,
+        &room.Quantity,
         &room.Status,
+        &room.OpensAt,
+        &room.ClosesAt,
+        &room.HoursInherited,
+        &room.Timezone,
+        &groupID,
+        &room.RequiresApproval,
+        &quota,
+        &labelDoc,
         &room.CreatedAt,
-    )
+        &room.UpdatedAt,
+        &room.Version,
+    }
+    if err := row.Scan(append(dest, extra...)...); err != nil {
+        return err
+    }
+    room.GroupID = nil
+    if groupID.Valid {
+        id := int(groupID.Int64)
+        room.GroupID = &id
+    }
+    room.DailyQuotaHours = nil
+    if quota.Valid {
+        room.DailyQuotaHours = &quota.Float64
+    }
+    room.Labels = map[string]string{}
+    if err := json.Unmarshal(labelDoc, &room.Labels); err != nil {
+        return fmt.Errorf("invalid labels on room %d: %w", room.ID, err)
+    }
+    return nil
+}
+
+// labelsParam encodes a label set for a JSONB parameter; nil stores {}
+func labelsParam(set map[string]string) string {
+    if set == nil {
+        return "{}"
+    }
+    doc, _ := json.Marshal(set)
+    return string(doc)
+}
+
+// hoursParams returns the room's own operating hours, NULL when it inherits them
+func hoursParams(room *models.Room) (opens, closes sql.NullString) {
+    if room.HoursInherited {
+        return opens, closes
+    }
+    return sql.NullString{String: room.OpensAt, Valid: true}, sql.NullString{String: room.ClosesAt, Valid: true}
+}
+
+// roomWriteFailed maps the constraint violations of a room insert or update
+// to their sentinels and wraps any other error with what failed
+func roomWriteFailed(err error, groupID *int, what string) error {
+    switch {
+    case isUniqueViolation(err):
+        return ErrRoomNameTaken
+    case isForeignKeyViolation(err) && groupID != nil:
+        return fmt.Errorf("%w with id: %d", ErrGroupNotFound, *groupID)
+    }
+    return fmt.Errorf("%s: %w", what, err)
+}
+
+func (r *RoomRepository) Create(ctx context.Context, room *models.Room) (*models.Room, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    query := `
+        WITH r AS (
+            INSERT INTO rooms (name, capacity, type, status, opens_at, closes_at, labels, group_id, quantity, timezone)
+            VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $9, $10)
+            RETURNING *
+        )
+        SELECT ` + roomColumns + ` FROM r LEFT JOIN group_policies gp ON gp.group_id = r.group_id`
+    
+    opens, closes := hoursParams(room)
+    ctx, span := startSpan(ctx, "rooms.insert", query)
+    var created models.Room
+    err := scanRoom(r.db.DB.QueryRowContext(ctx, query,
+        room.Name,
+        room.Capacity,
+        room.Type,
+        room.Status,
+        opens,
+        closes,
+        labelsParam(room.Labels),
+        room.GroupID,
+        room.Quantity,
+        room.Timezone,
+    ), &created)
+    endSpan(span, err)
     
     if err != nil {
-        return nil, fmt.Errorf("failed to create room: %w", err)
+        return nil, roomWriteFailed(err, room.GroupID, "failed to create room")
     }
     
-    return &room, nil
+    return &created, nil
+}
+
+// GetAll lists active rooms matching f
+func (r *RoomRepository) GetAll(ctx context.Context, f models.RoomFilter) ([]models.Room, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    where, args := selectorClause(f.Selector, "r.labels", []interface{}{f.GroupID})
+    query := `
+        SELECT ` + roomColumns + ` FROM ` + roomsWithPolicies + `
+        WHERE r.archived_at IS NULL AND ` + inGroup("r.group_id", 1) + ` AND ` + where + `
+        ORDER BY r.name`
+    
+    return r.list(ctx, "rooms.list", query, args...)
 }
 
-func (r *RoomRepository) GetAll(ctx context.Context) ([]models.Room, error) {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// FindAvailable lists active online rooms matching q that keep at least
+// q.Quantity units free throughout [q.Start, q.End), smallest capacity first,
+// with the units that stay free. A room that is not pooled is free when no
+// approved booking overlaps the window.
+func (r *RoomRepository) FindAvailable(ctx context.Context, q models.AvailabilityQuery) ([]models.Room, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
     defer cancel()
     
-    query := `SELECT id, name, capacity, type, status, created_at FROM rooms ORDER BY name`
+    quantity := q.Quantity
+    if quantity < 1 {
+        quantity = 1
+    }
+    roomType := sql.NullString{String: q.Type, Valid: q.Type != ""}
+    args := []interface{}{q.Start, q.End, q.MinCapacity, roomType, q.GroupID, quantity}
+    where, args := selectorClause(q.Selector, "r.labels", args)
+    query := `
+        SELECT ` + roomColumns + `, r.quantity - peak_usage(r.id, $1, $2)
+        FROM ` + roomsWithPolicies + `
+        WHERE r.archived_at IS NULL
+          AND r.status = 'online'
+          AND r.capacity >= $3
+          AND r.quantity >= $6
+          AND ($4::text IS NULL OR r.type = $4)
+          AND ` + inGroup("r.group_id", 5) + `
+          AND ` + where + `
+          AND r.quantity - peak_usage(r.id, $1, $2) >= $6
+        ORDER BY r.capacity, r.id
+    `
     
-    rows, err := r.db.DB.QueryContext(ctx, query)
+    ctx, span := startSpan(ctx, "rooms.find_available", query)
+    rows, err := r.db.DB.QueryContext(ctx, query, args...)
     if err != nil {
+        endSpan(span, err)
         return nil, fmt.Errorf("failed to fetch rooms: %w", err)
     }
+    defer span.End()
     defer rows.Close()
     
-    var rooms []models.Room
+    rooms := []models.Room{}
     for rows.Next() {
         var room models.Room
-        if err := rows.Scan(&room.ID, &room.Name, &room.Capacity, &room.Type, &room.Status, &room.CreatedAt); err != nil {
+        var free int
+        if err := scanRoom(rows, &room, &free); err != nil {
             return nil, fmt.Errorf("failed to scan room: %w", err)
         }
+        room.FreeUnits = &free
         rooms = append(rooms, room)
     }
     
     return rooms, rows.Err()
 }
 
-func (r *RoomRepository) Update(ctx context.Context, id int, name string, capacity int, roomType string, status string) error {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+func (r *RoomRepository) list(ctx context.Context, spanName, query string, args ...interface{}) ([]models.Room, error) {
+    ctx, span := startSpan(ctx, spanName, query)
+    rows, err := r.db.DB.QueryContext(ctx, query, args...)
+    if err != nil {
+        endSpan(span, err)
+        return nil, fmt.Errorf("failed to fetch rooms: %w", err)
+    }
+    defer span.End()
+    defer rows.Close()
+    
+    rooms := []models.Room{}
+    for rows.Next() {
+        var room models.Room
+        if err := scanRoom(rows, &room); err != nil {
+            return nil, fmt.Errorf("failed to scan room: %w", err)
+        }
+        rooms = append(rooms, room)
+    }
+    
+    return rooms, rows.Err()
+}
+
+// GetByID fetches a single room
+func (r *RoomRepository) GetByID(ctx context.Context, id int) (*models.Room, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
     defer cancel()
     
-    query := `UPDATE rooms SET name = $1, capacity = $2, type = $3, status = $4 WHERE id = $5`
-    result, err := r.db.DB.ExecContext(ctx, query, name, capacity, roomType, status, id)
+    query := `SELECT ` + roomColumns + ` FROM ` + roomsWithPolicies + ` WHERE r.id = $1 AND r.archived_at IS NULL`
+    
+    ctx, span := startSpan(ctx, "rooms.get", query)
+    var room models.Room
+    err := scanRoom(r.db.DB.QueryRowContext(ctx, query, id), &room)
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w with id: %d", ErrRoomNotFound, id)
+    }
     if err != nil {
-        return err
+        return nil, fmt.Errorf("failed to fetch room: %w", err)
     }
     
-    rows, err := result.RowsAffected()
+    return &room, nil
+}
+
+// Update writes the mutable fields of room only if the row is still at version
+// (or version is AnyVersion) and returns the room as written
+func (r *RoomRepository) Update(ctx context.Context, id, version int, room *models.Room) (*models.Room, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+    defer cancel()
+    
+    query := `
+        WITH r AS (
+            UPDATE rooms
+            SET name = $1, capacity = $2, type = $3, status = $4, opens_at = $5, closes_at = $6,
+                labels = $9::jsonb, group_id = $10, quantity = $11, timezone = $12
+            WHERE id = $7 AND archived_at IS NULL AND ($8 = 0 OR version = $8)
+            RETURNING *
+        )
+        SELECT ` + roomColumns + ` FROM r LEFT JOIN group_policies gp ON gp.group_id = r.group_id`
+    
+    opens, closes := hoursParams(room)
+    updateCtx, span := startSpan(ctx, "rooms.update", query)
+    var updated models.Room
+    err := scanRoom(r.db.DB.QueryRowContext(updateCtx, query,
+        room.Name,
+        room.Capacity,
+        room.Type,
+        room.Status,
+        opens,
+        closes,
+        id,
+        version,
+        labelsParam(room.Labels),
+        room.GroupID,
+        room.Quantity,
+        room.Timezone,
+    ), &updated)
+    endSpan(span, err)
+    if err == sql.ErrNoRows {
+        return nil, r.updateMiss(ctx, id)
+    }
     if err != nil {
-        return err
+        return nil, roomWriteFailed(err, room.GroupID, "failed to update room")
     }
     
-    if rows == 0 {
-        return fmt.Errorf("room not found with id: %d", id)
+    return &updated, nil
+}
+
+// updateMiss explains why a versioned update matched no row
+func (r *RoomRepository) updateMiss(ctx context.Context, id int) error {
+    var exists bool
+    query := `SELECT EXISTS (SELECT 1 FROM rooms WHERE id = $1 AND archived_at IS NULL)`
+    if err := r.db.DB.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+        return fmt.Errorf("failed to check room: %w", err)
     }
-    
-    return nil
+    if !exists {
+        return fmt.Errorf("%w with id: %d", ErrRoomNotFound, id)
+    }
+    return fmt.Errorf("room %d: %w", id, ErrStaleVersion)
 }
 
-func (r *RoomRepository) Delete(ctx context.Context, id int) error {
-    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// Archive takes a room out of service while keeping it, and its bookings, for
+// history. Pending bookings that have not ended are rejected. Approved ones that
+// have not ended block archival unless strategy says what to do with them:
+// "reject" rejects them, "reassign" moves each to the smallest free online room
+// of the same type and at least the same capacity, or fails as a whole.
+func (r *RoomRepository) Archive(ctx context.Context, id int, strategy string) (*models.ArchiveResult, error) {
+    ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
     defer cancel()
     
-    query := `DELETE FROM rooms WHERE id = $1`
-    _, err := r.db.DB.ExecContext(ctx, query, id)
-    return err
+    var result *models.ArchiveResult
+    err := r.db.runInTx(ctx, "archive_room", func(tx *sql.Tx) error {
+        result = &models.ArchiveResult{RoomID: id, Rejected: []int{}, Reassigned: []models.Reassignment{}}
+        
+        var room models.Room
+        query := `SELECT ` + roomColumns + ` FROM ` + roomsWithPolicies + ` WHERE r.id = $1 AND r.archived_at IS NULL FOR UPDATE OF r`
+        lockCtx, span := startSpan(ctx, "rooms.lock", query)
+        err := scanRoom(tx.QueryRowContext(lockCtx, query, id), &room)
+        endSpan(span, err)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w with id: %d", ErrRoomNotFound, id)
+        }
+        if err != nil {
+            return fmt.Errorf("failed to lock room: %w", err)
+        }
+        
+        pending, err := r.rejectBookings(ctx, tx, id, "pending")
+        if err != nil {
+            return err
+        }
+        
+        switch strategy {
+        case models.ArchiveStrategyReject:
+            approved, err := r.rejectBookings(ctx, tx, id, "approved")
+            if err != nil {
+                return err
+            }
+            result.Rejected = append(result.Rejected, approved...)
+        case models.ArchiveStrategyReassign:
+            if result.Reassigned, err = r.reassignBookings(ctx, tx, &room); err != nil {
+                return err
+            }
+        default:
+            var upcoming int
+            query := `SELECT COUNT(*) FROM bookings WHERE room_id = $1 AND status = 'approved' AND end_time > NOW()`
+            countCtx, span := startSpan(ctx, "bookings.count_upcoming", query)
+            err := tx.QueryRowContext(countCtx, query, id).Scan(&upcoming)
+            endSpan(span, err)
+            if err != nil {
+                return fmt.Errorf("failed to count upcoming bookings: %w", err)
+            }
+            if upcoming > 0 {
+                return fmt.Errorf("%w: %d approved booking(s) have not ended yet", ErrRoomHasUpcomingBookings, upcoming)
+            }
+        }
+        result.Rejected = append(result.Rejected, pending...)
+        
+        query = `UPDATE rooms SET archived_at = NOW(), status = 'offline' WHERE id = $1 RETURNING archived_at`
+        archiveCtx, span := startSpan(ctx, "rooms.archive", query)
+        err = tx.QueryRowContext(archiveCtx, query, id).Scan(&result.ArchivedAt)
+        endSpan(span, err)
+        if err != nil {
+            return fmt.Errorf("failed to archive room: %w", err)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// rejectBookings rejects the bookings of a room in status that have not ended,
+// along with the rest of any bundle they belong to
+func (r *RoomRepository) rejectBookings(ctx context.Context, tx *sql.Tx, roomID int, status string) ([]int, error) {
+    query := `
+        UPDATE bookings SET status = 'rejected'
+        WHERE room_id = $1 AND status = $2 AND end_time > NOW()
+        RETURNING id
+    `
+    rejectCtx, span := startSpan(ctx, "bookings.reject_upcoming", query)
+    ids, err := collectIDs(tx.QueryContext(rejectCtx, query, roomID, status))
+    endSpan(span, err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to reject %s bookings: %w", status, err)
+    }
+    if err := rejectBundlesOf(ctx, tx, ids); err != nil {
+        return nil, err
+    }
+    return ids, nil
+}
+
+// reassignBookings moves every approved booking of room that has not ended to
+// another room with enough free units, earliest first, so later bookings see
+// the earlier moves
+func (r *RoomRepository) reassignBookings(ctx context.Context, tx *sql.Tx, room *models.Room) ([]models.Reassignment, error) {
+    query := `
+        SELECT id, start_time, end_time, quantity FROM bookings
+        WHERE room_id = $1 AND status = 'approved' AND end_time > NOW()
+        ORDER BY start_time, id
+    `
+    listCtx, span := startSpan(ctx, "bookings.list_upcoming", query)
+    rows, err := tx.QueryContext(listCtx, query, room.ID)
+    if err != nil {
+        endSpan(span, err)
+        return nil, fmt.Errorf("failed to list upcoming bookings: %w", err)
+    }
+    var upcoming []models.Booking
+    for rows.Next() {
+        var b models.Booking
+        if err := rows.Scan(&b.ID, &b.StartTime, &b.EndTime, &b.Quantity); err != nil {
+            rows.Close()
+            span.End()
+            return nil, err
+        }
+        upcoming = append(upcoming, b)
+    }
+    rows.Close()
+    span.End()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    
+    // The chosen room is locked like any booking target, so allocations on it
+    // queue behind the move until this transaction commits
+    pick := `
+        SELECT c.id FROM rooms c
+        WHERE c.id <> $1
+          AND c.archived_at IS NULL
+          AND c.status = 'online'
+          AND c.type = $2
+          AND c.capacity >= $3
+          AND c.quantity - peak_usage(c.id, $4, $5) >= $6
+        ORDER BY c.capacity, c.id
+        LIMIT 1
+        FOR UPDATE OF c
+    `
+    move := `UPDATE bookings SET room_id = $2 WHERE id = $1`
+    
+    moved := make([]models.Reassignment, 0, len(upcoming))
+    for _, b := range upcoming {
+        var target int
+        pickCtx, span := startSpan(ctx, "rooms.pick_reassignment", pick)
+        err := tx.QueryRowContext(pickCtx, pick, room.ID, room.Type, room.Capacity, b.StartTime, b.EndTime, b.Quantity).Scan(&target)
+        endSpan(span, err)
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("%w for booking %d (%s - %s)", ErrNoReassignmentTarget, b.ID,
+                b.StartTime.Format(time.RFC3339), b.EndTime.Format(time.RFC3339))
+        }
+        if err != nil {
+            return nil, fmt.Errorf("failed to find a room for booking %d: %w", b.ID, err)
+        }
+        
+        moveCtx, span := startSpan(ctx, "bookings.reassign", move)
+        _, err = tx.ExecContext(moveCtx, move, b.ID, target)
+        endSpan(span, err)
+        if err != nil {
+            return nil, fmt.Errorf("failed to reassign booking %d: %w", b.ID, err)
+        }
+        moved = append(moved, models.Reassignment{BookingID: b.ID, RoomID: target})
+    }
+    return moved, nil
 }
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomUpdate_StaleVersion(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    require.NoError(t, err)
+    defer db.Close()
+    
+    repo := NewRoomRepository(&Database{DB: db})
+    room := &models.Room{Name: "Lab", Capacity: 4, Quantity: 1, Type: "shared", Status: "online", OpensAt: "08:00", ClosesAt: "18:00", Timezone: "Europe/Berlin"}
+    
+    mock.ExpectQuery(regexp.QuoteMeta(`UPDATE rooms SET`)).
+        WithArgs("Lab", 4, "shared", "online", "08:00", "18:00", 7, 2, "{}", nil, 1, "Europe/Berlin").
+        WillReturnRows(sqlmock.NewRows([]string{"id"}))
+    mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM rooms WHERE id = $1 AND archived_at IS NULL)`)).
+        WithArgs(7).
+        WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+    
+    _, err = repo.Update(context.Background(), 7, 2, room)
+    assert.ErrorIs(t, err, ErrStaleVersion)
+    
+    mock.ExpectQuery(regexp.QuoteMeta(`UPDATE rooms SET`)).
+        WithArgs("Lab", 4, "shared", "online", "08:00", "18:00", 8, 2, "{}", nil, 1, "Europe/Berlin").
+        WillReturnRows(sqlmock.NewRows([]string{"id"}))
+    mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM rooms WHERE id = $1 AND archived_at IS NULL)`)).
+        WithArgs(8).
+        WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+    
+    _, err = repo.Update(context.Background(), 8, 2, room)
+    assert.ErrorIs(t, err, ErrRoomNotFound)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRoomArchive_RefusesUpcomingBookings(t *testing.T) {
+    db, mock, err := sqlmock.New()
+    require.NoError(t, err)
+    defer db.Close()
+    
+    repo := NewRoomRepository(&Database{DB: db})
+    roomRow := sqlmock.NewRows([]string{"id", "name", "capacity", "type", "quantity", "status", "opens_at", "closes_at", "hours_inherited",
+        "timezone", "group_id", "requires_approval", "daily_quota_hours", "labels", "created_at", "updated_at", "version"}).
+        AddRow(3, "Lab", 4, "shared", 1, "online", "00:00:00", "24:00:00", true, "UTC", nil, false, nil, []byte(`{"zone":"a"}`), time.Now(), time.Now(), 1)
+    
+    mock.ExpectBegin()
+    mock.ExpectQuery(regexp.QuoteMeta(`WHERE r.id = $1 AND r.archived_at IS NULL FOR UPDATE OF r`)).
+        WithArgs(3).
+        WillReturnRows(roomRow)
+    mock.ExpectQuery(regexp.QuoteMeta(`UPDATE bookings SET status = 'rejected'`)).
+        WithArgs(3, "pending").
+        WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+    mock.ExpectExec(regexp.QuoteMeta(`UPDATE booking_bundles SET status = 'rejected'`)).
+        WillReturnResult(sqlmock.NewResult(0, 0))
+    mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM bookings`)).
+        WithArgs(3).
+        WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+    mock.ExpectRollback()
+    
+    _, err = repo.Archive(context.Background(), 3, "")
+    assert.ErrorIs(t, err, ErrRoomHasUpcomingBookings)
+    assert.NoError(t, mock.ExpectationsWereMet())
+}
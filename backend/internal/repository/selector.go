@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
+)
+
+// selectorClause translates a label selector into a condition on the JSONB
+// column, appending its parameters to args. Every term is built from
+// containment (@>) and key existence (?), which the GIN index on labels serves.
+func selectorClause(sel labels.Selector, column string, args []interface{}) (string, []interface{}) {
+    if len(sel) == 0 {
+        return "TRUE", args
+    }
+    
+    contains := func(key, value string) string {
+        doc, _ := json.Marshal(map[string]string{key: value})
+        args = append(args, string(doc))
+        return fmt.Sprintf("%s @> $%d::jsonb", column, len(args))
+    }
+    anyOf := func(r labels.Requirement) string {
+        terms := make([]string, len(r.Values))
+        for i, v := range r.Values {
+            terms[i] = contains(r.Key, v)
+        }
+        return "(" + strings.Join(terms, " OR ") + ")"
+    }
+    
+    conds := make([]string, 0, len(sel))
+    for _, r := range sel {
+        switch r.Op {
+        case labels.Equals, labels.In:
+            conds = append(conds, anyOf(r))
+        case labels.NotEquals, labels.NotIn:
+            conds = append(conds, "NOT "+anyOf(r))
+        case labels.Exists, labels.DoesNotExist:
+            args = append(args, r.Key)
+            cond := fmt.Sprintf("%s ? $%d", column, len(args))
+            if r.Op == labels.DoesNotExist {
+                cond = "NOT " + cond
+            }
+            conds = append(conds, cond)
+        }
+    }
+    return strings.Join(conds, " AND "), args
+}
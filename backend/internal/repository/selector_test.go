@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
+)
+
+func TestSelectorClause(t *testing.T) {
+    sel, err := labels.Parse("zone=a,tier in (gold,silver),gpu!=true,ssd,!deprecated")
+    require.NoError(t, err)
+    
+    where, args := selectorClause(sel, "labels", []interface{}{"existing"})
+    assert.Equal(t, `(labels @> $2::jsonb) AND (labels @> $3::jsonb OR labels @> $4::jsonb) AND NOT (labels @> $5::jsonb) AND labels ? $6 AND NOT labels ? $7`, where)
+    assert.Equal(t, []interface{}{"existing", `{"zone":"a"}`, `{"tier":"gold"}`, `{"tier":"silver"}`, `{"gpu":"true"}`, "ssd", "deprecated"}, args)
+    
+    where, args = selectorClause(nil, "labels", nil)
+    assert.Equal(t, "TRUE", where)
+    assert.Empty(t, args)
+}
@@ -0,0 +1,195 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+const bundleColumns = `id, user_id, start_time, end_time, status, created_at, updated_at, version`
+
+func scanBundle(row rowScanner, b *models.Bundle) error {
+	return row.Scan(
+		&b.ID,
+		&b.UserID,
+		instant{&b.StartTime},
+		instant{&b.EndTime},
+		&b.Status,
+		instant{&b.CreatedAt},
+		instant{&b.UpdatedAt},
+		&b.Version,
+	)
+}
+
+// CreateBundle allocates one room per member over the bundle's window in a
+// single transaction, as repository.Allocation.CreateBundle describes. A
+// rejected bundle is returned with ErrBookingConflict.
+func (r *BookingRepository) CreateBundle(ctx context.Context, req *models.CreateBundleRequest) (*models.Bundle, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
+	defer cancel()
+
+	var bundle *models.Bundle
+	err := r.allocate(ctx, "create_bundle", func(a *repository.Allocation) (err error) {
+		bundle, err = a.CreateBundle(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if bundle.Status == "rejected" {
+		return bundle, repository.ErrBookingConflict
+	}
+	return bundle, nil
+}
+
+// InsertBundle stores a bundle without its members and reads it back
+func (t *allocTx) InsertBundle(ctx context.Context, req *models.CreateBundleRequest, status string) (*models.Bundle, error) {
+	query := `
+        INSERT INTO booking_bundles (user_id, start_time, end_time, status)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id`
+	insertCtx, span := startSpan(ctx, "booking_bundles.insert", query)
+	var id int
+	err := t.tx.QueryRowContext(insertCtx, query, req.UserID, micros(req.StartTime), micros(req.EndTime), status).Scan(&id)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert booking bundle: %w", err)
+	}
+	return getBundle(ctx, t.tx, id)
+}
+
+// getBundle reads a bundle with its member bookings, in insertion order, through q
+func getBundle(ctx context.Context, q queryer, id int) (*models.Bundle, error) {
+	query := `SELECT ` + bundleColumns + ` FROM booking_bundles WHERE id = $1`
+	getCtx, span := startSpan(ctx, "booking_bundles.get", query)
+	var bundle models.Bundle
+	err := scanBundle(q.QueryRowContext(getCtx, query, id), &bundle)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrBundleNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch booking bundle: %w", err)
+	}
+
+	query = `SELECT ` + bookingColumns + ` FROM bookings b WHERE b.bundle_id = $1 ORDER BY b.id`
+	listCtx, span := startSpan(ctx, "bookings.list_bundle", query)
+	rows, err := q.QueryContext(listCtx, query, id)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to fetch bundle bookings: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	bundle.Bookings = []models.Booking{}
+	for rows.Next() {
+		var b models.Booking
+		if err := scanBooking(rows, &b); err != nil {
+			return nil, fmt.Errorf("failed to scan booking: %w", err)
+		}
+		bundle.Bookings = append(bundle.Bookings, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// GetBundle fetches a bundle with its member bookings
+func (r *BookingRepository) GetBundle(ctx context.Context, id int) (*models.Bundle, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	return getBundle(ctx, r.db.DB, id)
+}
+
+// LockBundle reads a bundle with its members in the transaction
+func (t *allocTx) LockBundle(ctx context.Context, id int) (*models.Bundle, error) {
+	return getBundle(ctx, t.tx, id)
+}
+
+// SetBundleStatus moves a bundle and all its bookings to status
+func (t *allocTx) SetBundleStatus(ctx context.Context, id int, status string) error {
+	for _, query := range []string{
+		`UPDATE bookings SET status = $2 WHERE bundle_id = $1`,
+		`UPDATE booking_bundles SET status = $2 WHERE id = $1`,
+	} {
+		execCtx, span := startSpan(ctx, "booking_bundles.set_status", query)
+		_, err := t.tx.ExecContext(execCtx, query, id, status)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to mark booking bundle %s: %w", status, err)
+		}
+	}
+	return nil
+}
+
+// ApproveBundle approves a pending bundle after re-checking every member room,
+// in room id order; a conflict on any member leaves the whole bundle pending
+func (r *BookingRepository) ApproveBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Allocation)
+	defer cancel()
+
+	err := r.allocate(ctx, "approve_bundle", func(a *repository.Allocation) error {
+		return a.ApproveBundle(ctx, id, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getBundle(ctx, r.db.DB, id)
+}
+
+// RejectBundle rejects a pending bundle with all its bookings
+func (r *BookingRepository) RejectBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	err := r.allocate(ctx, "reject_bundle", func(a *repository.Allocation) error {
+		return a.RejectBundle(ctx, id, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getBundle(ctx, r.db.DB, id)
+}
+
+// rejectBundlesOf rejects every bundle one of bookingIDs belongs to, with the
+// rest of its bookings, so that no bundle is ever left partly allocated
+func rejectBundlesOf(ctx context.Context, tx *sql.Tx, bookingIDs []int) error {
+	if len(bookingIDs) == 0 {
+		return nil
+	}
+
+	query := `
+        UPDATE booking_bundles SET status = 'rejected'
+        WHERE status <> 'rejected'
+          AND id IN (SELECT bundle_id FROM bookings WHERE id IN (SELECT value FROM json_each($1)))
+        RETURNING id
+    `
+	rejectCtx, span := startSpan(ctx, "booking_bundles.cascade_reject", query)
+	bundles, err := collectIDs(tx.QueryContext(rejectCtx, query, idsParam(bookingIDs)))
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to reject bundles: %w", err)
+	}
+	if len(bundles) == 0 {
+		return nil
+	}
+
+	query = `
+        UPDATE bookings SET status = 'rejected'
+        WHERE status <> 'rejected' AND bundle_id IN (SELECT value FROM json_each($1))
+    `
+	rejectCtx, span = startSpan(ctx, "booking_bundles.cascade_reject", query)
+	_, err = tx.ExecContext(rejectCtx, query, idsParam(bundles))
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to reject bundles: %w", err)
+	}
+	return nil
+}
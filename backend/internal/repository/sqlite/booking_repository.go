@@ -0,0 +1,861 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/metrics"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+	"github.com/indraprhmbd/allocra/internal/repository/utilization"
+)
+
+type BookingRepository struct {
+	db *Database
+}
+
+func NewBookingRepository(db *Database) *BookingRepository {
+	return &BookingRepository{db: db}
+}
+
+// bookingColumns is the select list read by scanBooking, over the alias b
+const bookingColumns = `b.id, b.room_id, b.user_id, b.start_time, b.end_time, b.status, b.created_at, b.updated_at, b.version, b.quantity, b.bundle_id`
+
+// scanBooking reads bookingColumns, followed by any extra selected columns
+func scanBooking(row rowScanner, b *models.Booking, extra ...interface{}) error {
+	var bundleID sql.NullInt64
+	dest := []interface{}{
+		&b.ID,
+		&b.RoomID,
+		&b.UserID,
+		instant{&b.StartTime},
+		instant{&b.EndTime},
+		&b.Status,
+		instant{&b.CreatedAt},
+		instant{&b.UpdatedAt},
+		&b.Version,
+		&b.Quantity,
+		&bundleID,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	b.BundleID = nil
+	if bundleID.Valid {
+		id := int(bundleID.Int64)
+		b.BundleID = &id
+	}
+	return nil
+}
+
+// idsParam encodes ids as a JSON array, which queries expand with json_each
+func idsParam(ids []int) string {
+	doc, _ := json.Marshal(append([]int{}, ids...))
+	return string(doc)
+}
+
+// getBooking reads a booking through q. Statements return no row versions of
+// their own here: the touch triggers bump them after RETURNING is evaluated.
+func getBooking(ctx context.Context, q queryer, id int) (*models.Booking, error) {
+	query := `SELECT ` + bookingColumns + ` FROM bookings b WHERE b.id = $1`
+	ctx, span := startSpan(ctx, "bookings.get", query)
+	var booking models.Booking
+	err := scanBooking(q.QueryRowContext(ctx, query, id), &booking)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrBookingNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch booking: %w", err)
+	}
+	return &booking, nil
+}
+
+// GetByID fetches a single booking with its room name
+func (r *BookingRepository) GetByID(ctx context.Context, id int) (*models.Booking, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT ` + bookingColumns + `, r.name
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+        WHERE b.id = $1
+    `
+
+	ctx, span := startSpan(ctx, "bookings.get", query)
+	var booking models.Booking
+	err := scanBooking(r.db.DB.QueryRowContext(ctx, query, id), &booking, &booking.RoomName)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrBookingNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch booking: %w", err)
+	}
+
+	return &booking, nil
+}
+
+// CheckConflict reports whether quantity more units of a room can't be held
+// over [start, end) next to its approved bookings, as its Postgres namesake
+func (r *BookingRepository) CheckConflict(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time, quantity int) (bool, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.ConflictCheck)
+	defer cancel()
+
+	query := `SELECT ` + peakUsage("rooms.id", "$2", "$3") + ` + $4 > quantity FROM rooms WHERE id = $1`
+
+	ctx, span := startSpan(ctx, "bookings.conflict_check", query)
+	began := time.Now()
+	var conflict bool
+	err := tx.QueryRowContext(ctx, query, roomID, micros(start), micros(end), alloc.Units(quantity)).Scan(&conflict)
+	metrics.ConflictCheckDuration.Observe(time.Since(began).Seconds())
+	endSpan(span, err)
+
+	if err == sql.ErrNoRows {
+		return false, nil // No such room, nothing to conflict with
+	}
+	if err != nil {
+		return false, fmt.Errorf("conflict check failed: %w", err)
+	}
+
+	return conflict, nil
+}
+
+// allocTx is the AllocationTx of a SQLite write transaction. It locks
+// nothing: the writer slot the transaction holds already serializes every
+// allocation.
+type allocTx struct {
+	r  *BookingRepository
+	tx *sql.Tx
+}
+
+// allocate runs fn on the allocation rules inside a write transaction
+func (r *BookingRepository) allocate(ctx context.Context, operation string, fn func(a *repository.Allocation) error) error {
+	return r.db.write(ctx, operation, func(tx *sql.Tx) error {
+		return fn(repository.NewAllocation(&allocTx{r: r, tx: tx}))
+	})
+}
+
+func (t *allocTx) Conflicts(ctx context.Context, roomID int, start, end time.Time, units int) (bool, error) {
+	return t.r.CheckConflict(ctx, t.tx, roomID, start, end, units)
+}
+
+func (t *allocTx) Location(name string) (*time.Location, error) {
+	return t.r.db.zones.Load(name)
+}
+
+// LockRoom fails unless the room exists and is active. It stands where the
+// Postgres engine locks the room row.
+func (t *allocTx) LockRoom(ctx context.Context, roomID int) error {
+	query := "SELECT archived_at IS NULL FROM rooms WHERE id = $1"
+	ctx, span := startSpan(ctx, "rooms.check", query)
+	var active bool
+	err := t.tx.QueryRowContext(ctx, query, roomID).Scan(&active)
+	endSpan(span, err)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		return fmt.Errorf("%w with id: %d", repository.ErrRoomNotFound, roomID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read room: %w", err)
+	}
+	return nil
+}
+
+// InsertBooking inserts a booking and reads it back
+func (t *allocTx) InsertBooking(ctx context.Context, roomID int, req *models.CreateBookingRequest, status string, units int, bundleID *int) (*models.Booking, error) {
+	query := `
+        INSERT INTO bookings (room_id, user_id, start_time, end_time, status, quantity, bundle_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id`
+
+	insertCtx, span := startSpan(ctx, "bookings.insert", query)
+	var id int
+	err := t.tx.QueryRowContext(insertCtx, query,
+		roomID,
+		req.UserID,
+		micros(req.StartTime),
+		micros(req.EndTime),
+		status,
+		units,
+		bundleID,
+	).Scan(&id)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert booking: %w", err)
+	}
+	return getBooking(ctx, t.tx, id)
+}
+
+// CreateWithTransaction creates a booking within a transaction
+// Transaction boundary: conflict check + insert must be atomic
+func (r *BookingRepository) CreateWithTransaction(ctx context.Context, req *models.CreateBookingRequest) (*models.Booking, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Allocation)
+	defer cancel()
+
+	var booking *models.Booking
+	err := r.allocate(ctx, "create_booking", func(a *repository.Allocation) (err error) {
+		booking, err = a.Create(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if booking.Status == "rejected" {
+		return booking, repository.ErrBookingConflict
+	}
+
+	return booking, nil
+}
+
+// RoomPolicy reads the effective policy of a room
+func (t *allocTx) RoomPolicy(ctx context.Context, roomID int) (*repository.AllocationPolicy, error) {
+	query := `
+        SELECT r.quantity, COALESCE(gp.requires_approval, 0), gp.daily_quota_hours, gp.quota_group_id, r.timezone,
+               COALESCE(r.opens_at, gp.opens_at, '00:00:00'), COALESCE(r.closes_at, gp.closes_at, '24:00:00')
+        FROM rooms r
+        LEFT JOIN group_policies gp ON gp.group_id = r.group_id
+        WHERE r.id = $1
+    `
+	ctx, span := startSpan(ctx, "rooms.policy", query)
+	var p repository.AllocationPolicy
+	err := t.tx.QueryRowContext(ctx, query, roomID).Scan(&p.Quantity, &p.RequiresApproval, &p.QuotaHours, &p.QuotaGroupID, &p.Timezone, &p.OpensAt, &p.ClosesAt)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read room policy: %w", err)
+	}
+	return &p, nil
+}
+
+// QuotaUsage sums the user's booked hours in the quota's subtree
+func (t *allocTx) QuotaUsage(ctx context.Context, userID int, groupID int64, from, to time.Time) (float64, error) {
+	query := `
+        SELECT COALESCE(SUM(b.end_time - b.start_time), 0) / 3600000000.0
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+        WHERE b.user_id = $1
+          AND b.status IN ('approved', 'pending')
+          AND b.start_time >= $2 AND b.start_time < $3
+          AND r.group_id IN (SELECT descendant_id FROM group_closure WHERE ancestor_id = $4)
+    `
+	ctx, span := startSpan(ctx, "bookings.quota_usage", query)
+	var used float64
+	err := t.tx.QueryRowContext(ctx, query, userID, micros(from), micros(to), groupID).Scan(&used)
+	endSpan(span, err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read quota usage: %w", err)
+	}
+	return used, nil
+}
+
+// PickRoom chooses the smallest online room matching the request's selector,
+// capacity and group with enough free units. Nothing can take the room between
+// the pick and the insert while the writer slot is held, so unlike on Postgres
+// one pick is final. Rooms in exclude are never picked.
+func (t *allocTx) PickRoom(ctx context.Context, req *models.CreateBookingRequest, exclude []int) (int, error) {
+	args := []interface{}{micros(req.StartTime), micros(req.EndTime), req.MinCapacity, idsParam(exclude), req.GroupID, alloc.Units(req.Quantity)}
+	where, args := selectorClause(req.Selector, "labels", args)
+	query := `
+        SELECT id FROM rooms
+        WHERE archived_at IS NULL
+          AND status = 'online'
+          AND capacity >= $3
+          AND id NOT IN (SELECT value FROM json_each($4))
+          AND ` + inGroup("group_id", 5) + `
+          AND ` + where + `
+          AND quantity - ` + peakUsage("rooms.id", "$1", "$2") + ` >= $6
+        ORDER BY capacity, id
+        LIMIT 1
+    `
+
+	pickCtx, span := startSpan(ctx, "rooms.pick", query)
+	var roomID int
+	err := t.tx.QueryRowContext(pickCtx, query, args...).Scan(&roomID)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return 0, repository.ErrNoRoomAvailable
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to pick a room: %w", err)
+	}
+	return roomID, nil
+}
+
+// SimulateBatch books reqs by the rules of CreateWithTransaction, in order,
+// inside a single transaction that is always rolled back; see
+// repository.Allocation.Simulate for the outcomes.
+func (r *BookingRepository) SimulateBatch(ctx context.Context, reqs []models.CreateBookingRequest) ([]repository.SimulatedBooking, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Batch)
+	defer cancel()
+
+	var outcomes []repository.SimulatedBooking
+	err := r.db.simulate(ctx, "simulate_batch", func(tx *sql.Tx) (err error) {
+		outcomes, err = repository.NewAllocation(&allocTx{r: r, tx: tx}).Simulate(ctx, reqs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
+
+// LockBooking reads a booking in the transaction
+func (t *allocTx) LockBooking(ctx context.Context, bookingID int) (*models.Booking, error) {
+	return getBooking(ctx, t.tx, bookingID)
+}
+
+// SetBookingStatus writes the new status of a booking and returns the row
+func (t *allocTx) SetBookingStatus(ctx context.Context, bookingID int, status string) (*models.Booking, error) {
+	query := `UPDATE bookings SET status = $2 WHERE id = $1`
+	updateCtx, span := startSpan(ctx, "bookings.set_status", query)
+	_, err := t.tx.ExecContext(updateCtx, query, bookingID, status)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark booking %s: %w", status, err)
+	}
+	return getBooking(ctx, t.tx, bookingID)
+}
+
+// ApproveBooking updates booking status to 'approved' with conflict re-check
+func (r *BookingRepository) ApproveBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Allocation)
+	defer cancel()
+
+	var approved *models.Booking
+	err := r.allocate(ctx, "approve_booking", func(a *repository.Allocation) (err error) {
+		approved, err = a.Approve(ctx, bookingID, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approved, nil
+}
+
+// RejectBooking updates booking status to 'rejected'
+func (r *BookingRepository) RejectBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	var rejected *models.Booking
+	err := r.allocate(ctx, "reject_booking", func(a *repository.Allocation) (err error) {
+		rejected, err = a.Reject(ctx, bookingID, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejected, nil
+}
+
+// GetAll fetches all bookings across all rooms
+func (r *BookingRepository) GetAll(ctx context.Context) ([]models.Booking, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	query := `SELECT ` + bookingColumns + ` FROM bookings b ORDER BY b.start_time DESC`
+
+	ctx, span := startSpan(ctx, "bookings.list", query)
+	rows, err := r.db.DB.QueryContext(ctx, query)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to fetch all bookings: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	var bookings []models.Booking
+	for rows.Next() {
+		var booking models.Booking
+		if err := scanBooking(rows, &booking); err != nil {
+			return nil, fmt.Errorf("failed to scan booking: %w", err)
+		}
+		bookings = append(bookings, booking)
+	}
+
+	return bookings, rows.Err()
+}
+
+// GetMonthlyUsage aggregates approved bookings starting in [from, to)
+func (r *BookingRepository) GetMonthlyUsage(ctx context.Context, from, to time.Time) ([]models.MonthlyUsageReport, error) {
+	var reports []models.MonthlyUsageReport
+	err := r.StreamMonthlyUsage(ctx, from, to, func(report *models.MonthlyUsageReport) error {
+		reports = append(reports, *report)
+		return nil
+	})
+	return reports, err
+}
+
+// StreamMonthlyUsage hands each row of the monthly usage report to fn as it is scanned
+func (r *BookingRepository) StreamMonthlyUsage(ctx context.Context, from, to time.Time, fn func(*models.MonthlyUsageReport) error) error {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Report)
+	defer cancel()
+
+	query := `
+        SELECT
+            b.room_id,
+            r.name AS room_name,
+            COUNT(*) AS total_bookings,
+            SUM(b.end_time - b.start_time) / 3600000000.0 AS total_hours
+        FROM bookings b
+        JOIN rooms r ON b.room_id = r.id
+        WHERE b.status = 'approved'
+          AND b.start_time >= $1 AND b.start_time < $2
+        GROUP BY b.room_id, r.name
+        ORDER BY total_hours DESC
+    `
+
+	ctx, span := startSpan(ctx, "bookings.monthly_usage", query)
+	rows, err := r.db.DB.QueryContext(ctx, query, micros(from), micros(to))
+	if err != nil {
+		endSpan(span, err)
+		return fmt.Errorf("failed to fetch monthly usage: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	for rows.Next() {
+		var report models.MonthlyUsageReport
+		if err := rows.Scan(
+			&report.RoomID,
+			&report.RoomName,
+			&report.TotalBookings,
+			&report.TotalHours,
+		); err != nil {
+			return fmt.Errorf("failed to scan usage report: %w", err)
+		}
+		if err := fn(&report); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetGroupMonthlyUsage rolls approved bookings starting in [from, to) up to the
+// groups of kind; rooms with no ancestor of that kind are left out
+func (r *BookingRepository) GetGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time) ([]models.GroupUsageReport, error) {
+	var reports []models.GroupUsageReport
+	err := r.StreamGroupMonthlyUsage(ctx, kind, from, to, func(report *models.GroupUsageReport) error {
+		reports = append(reports, *report)
+		return nil
+	})
+	return reports, err
+}
+
+// StreamGroupMonthlyUsage hands each row of the rolled-up monthly usage report to fn
+func (r *BookingRepository) StreamGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time, fn func(*models.GroupUsageReport) error) error {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Report)
+	defer cancel()
+
+	query := `
+        SELECT
+            g.id,
+            g.name,
+            g.kind,
+            COUNT(DISTINCT b.room_id) AS rooms,
+            COUNT(*) AS total_bookings,
+            SUM(b.end_time - b.start_time) / 3600000000.0 AS total_hours
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+        JOIN group_closure c ON c.descendant_id = r.group_id
+        JOIN resource_groups g ON g.id = c.ancestor_id AND g.kind = $1
+        WHERE b.status = 'approved'
+          AND b.start_time >= $2 AND b.start_time < $3
+        GROUP BY g.id, g.name, g.kind
+        ORDER BY total_hours DESC
+    `
+
+	ctx, span := startSpan(ctx, "bookings.group_monthly_usage", query)
+	rows, err := r.db.DB.QueryContext(ctx, query, kind, micros(from), micros(to))
+	if err != nil {
+		endSpan(span, err)
+		return fmt.Errorf("failed to fetch monthly usage: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	for rows.Next() {
+		var report models.GroupUsageReport
+		if err := rows.Scan(
+			&report.GroupID,
+			&report.GroupName,
+			&report.Kind,
+			&report.Rooms,
+			&report.TotalBookings,
+			&report.TotalHours,
+		); err != nil {
+			return fmt.Errorf("failed to scan usage report: %w", err)
+		}
+		if err := fn(&report); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// StreamBookings walks bookings matching f (newest first) and hands each row to
+// fn as it is scanned, so exports never hold the full result set in memory
+func (r *BookingRepository) StreamBookings(ctx context.Context, f models.BookingFilter, fn func(*models.Booking) error) error {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Export)
+	defer cancel()
+
+	where, args := bookingFilterClause(f)
+	query := `
+        SELECT ` + bookingColumns + `, r.name
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+    ` + where + `
+        ORDER BY b.start_time DESC
+    `
+
+	ctx, span := startSpan(ctx, "bookings.stream", query)
+	rows, err := r.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return fmt.Errorf("failed to stream bookings: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	for rows.Next() {
+		var booking models.Booking
+		if err := scanBooking(rows, &booking, &booking.RoomName); err != nil {
+			return fmt.Errorf("failed to scan booking: %w", err)
+		}
+		if err := fn(&booking); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// bookingSortColumns maps the sort keys repository.IsBookingSortKey accepts to
+// their columns; b.id breaks ties so the keyset is unique
+var bookingSortColumns = map[string]string{
+	"start_time": "b.start_time",
+	"created_at": "b.created_at",
+	"id":         "b.id",
+}
+
+// List returns one page of bookings matching q.Filter using keyset pagination,
+// together with the total number of matches
+func (r *BookingRepository) List(ctx context.Context, q models.BookingListQuery) (*models.BookingPage, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	column := bookingSortColumns[q.Sort]
+	where, args := bookingFilterClause(q.Filter)
+
+	var total int
+	countQuery := `SELECT COUNT(*) FROM bookings b` + where
+	countCtx, span := startSpan(ctx, "bookings.count", countQuery)
+	err := r.db.DB.QueryRowContext(countCtx, countQuery, args...).Scan(&total)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count bookings: %w", err)
+	}
+
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	cur, err := repository.ParseBookingCursor(q)
+	if err != nil {
+		return nil, err
+	}
+	if cur != nil {
+		var keyset string
+		if column == "b.id" {
+			args = append(args, cur.ID)
+			keyset = fmt.Sprintf("b.id %s $%d", op, len(args))
+		} else {
+			args = append(args, micros(cur.Value), cur.ID)
+			keyset = fmt.Sprintf("(%s, b.id) %s ($%d, $%d)", column, op, len(args)-1, len(args))
+		}
+		if where == "" {
+			where = " WHERE " + keyset
+		} else {
+			where += " AND " + keyset
+		}
+	}
+
+	args = append(args, q.Limit+1)
+	query := `
+        SELECT ` + bookingColumns + `, r.name
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+    ` + where + fmt.Sprintf(`
+        ORDER BY %s %s, b.id %s
+        LIMIT $%d
+    `, column, dir, dir, len(args))
+
+	ctx, span = startSpan(ctx, "bookings.list", query)
+	rows, err := r.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to list bookings: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	page := &models.BookingPage{Data: []models.Booking{}, Total: total}
+	for rows.Next() {
+		var booking models.Booking
+		if err := scanBooking(rows, &booking, &booking.RoomName); err != nil {
+			return nil, fmt.Errorf("failed to scan booking: %w", err)
+		}
+		page.Data = append(page.Data, booking)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Data) > q.Limit {
+		page.Data = page.Data[:q.Limit]
+		page.NextCursor = repository.NextBookingCursor(q, &page.Data[len(page.Data)-1])
+	}
+
+	return page, nil
+}
+
+// bookingFilterClause renders f as a WHERE clause over the alias b
+func bookingFilterClause(f models.BookingFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.RoomID != nil {
+		add("b.room_id = $%d", *f.RoomID)
+	}
+	if f.UserID != nil {
+		add("b.user_id = $%d", *f.UserID)
+	}
+	if f.Status != "" {
+		add("b.status = $%d", f.Status)
+	}
+	if f.To != nil {
+		add("b.start_time < $%d", micros(*f.To))
+	}
+	if f.From != nil {
+		add("b.end_time > $%d", micros(*f.From))
+	}
+	if f.CreatedAfter != nil {
+		add("b.created_at >= $%d", micros(*f.CreatedAfter))
+	}
+	if f.CreatedBefore != nil {
+		add("b.created_at < $%d", micros(*f.CreatedBefore))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// GetUtilization computes booked hours over available hours for each bucket of
+// q. SQLite has no time zone arithmetic, so the rooms in scope and their
+// bookings over the report range are read here and bucketed by the
+// utilization package.
+func (r *BookingRepository) GetUtilization(ctx context.Context, q models.UtilizationQuery) ([]models.UtilizationBucket, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Report)
+	defer cancel()
+
+	from, to, err := utilization.Range(q)
+	if err != nil {
+		return nil, err
+	}
+
+	roomType := sql.NullString{String: q.Type, Valid: q.Type != ""}
+	scope := `($1 IS NULL OR r.id = $1) AND ($2 IS NULL OR r.type = $2) AND ` + inGroup("r.group_id", 3)
+	args := []interface{}{q.RoomID, roomType, q.GroupID}
+
+	query := `
+        SELECT r.id, r.status, r.timezone,
+               COALESCE(r.opens_at, gp.opens_at, '00:00:00'),
+               COALESCE(r.closes_at, gp.closes_at, '24:00:00')
+        FROM ` + roomsWithPolicies + `
+        WHERE ` + scope
+
+	roomsCtx, span := startSpan(ctx, "rooms.utilization_scope", query)
+	rows, err := r.db.DB.QueryContext(roomsCtx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to fetch utilization: %w", err)
+	}
+	var rooms []utilization.Room
+	for rows.Next() {
+		var rm utilization.Room
+		var status, zone string
+		if err := rows.Scan(&rm.ID, &status, &zone, &rm.OpensAt, &rm.ClosesAt); err != nil {
+			rows.Close()
+			span.End()
+			return nil, fmt.Errorf("failed to scan room: %w", err)
+		}
+		if rm.Location, err = r.db.zones.Load(zone); err != nil {
+			rows.Close()
+			span.End()
+			return nil, fmt.Errorf("invalid time zone of room %d: %w", rm.ID, err)
+		}
+		rm.Online = status == "online"
+		rooms = append(rooms, rm)
+	}
+	rows.Close()
+	span.End()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+        SELECT b.room_id, b.status, b.start_time, b.end_time
+        FROM bookings b
+        JOIN rooms r ON r.id = b.room_id
+        WHERE ` + scope + ` AND b.start_time < $4 AND b.end_time > $5
+    `
+	bookingsCtx, span := startSpan(ctx, "bookings.utilization", query)
+	rows, err = r.db.DB.QueryContext(bookingsCtx, query, append(args, micros(to), micros(from))...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to fetch utilization: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	var bookings []utilization.Booking
+	for rows.Next() {
+		var b utilization.Booking
+		if err := rows.Scan(&b.RoomID, &b.Status, instant{&b.Start}, instant{&b.End}); err != nil {
+			return nil, fmt.Errorf("failed to scan booking: %w", err)
+		}
+		bookings = append(bookings, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return utilization.Compute(q, rooms, bookings)
+}
+
+// RejectNewest rejects the most recently created approved booking overlapping b
+func (t *allocTx) RejectNewest(ctx context.Context, b *models.Booking) (int, bool, error) {
+	query := `
+        UPDATE bookings
+        SET status = 'rejected'
+        WHERE id = (
+            SELECT id FROM bookings
+            WHERE room_id = $1
+              AND status = 'approved'
+              AND start_time < $3
+              AND end_time > $2
+              AND id <> $4
+            ORDER BY created_at DESC, id DESC
+            LIMIT 1
+        )
+        RETURNING id
+    `
+	ctx, span := startSpan(ctx, "bookings.preempt_conflicts", query)
+	var id int
+	err := t.tx.QueryRowContext(ctx, query, b.RoomID, micros(b.StartTime), micros(b.EndTime), b.ID).Scan(&id)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+func (t *allocTx) RejectBundlesOf(ctx context.Context, bookingIDs []int) error {
+	return rejectBundlesOf(ctx, t.tx, bookingIDs)
+}
+
+// PreemptBooking rejects approved bookings overlapping the given one, most
+// recently created first, until it fits, and approves it. On a room that is not
+// pooled that rejects every overlapping booking.
+func (r *BookingRepository) PreemptBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
+	defer cancel()
+
+	var approved *models.Booking
+	err := r.allocate(ctx, "preempt_booking", func(a *repository.Allocation) (err error) {
+		approved, err = a.Preempt(ctx, bookingID, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approved, nil
+}
+
+// DeleteAll clears all bookings and bundles and restarts their ids, as
+// TRUNCATE ... RESTART IDENTITY does on Postgres
+func (r *BookingRepository) DeleteAll(ctx context.Context) error {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
+	defer cancel()
+
+	return r.db.write(ctx, "delete_bookings", func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM bookings`,
+			`DELETE FROM booking_bundles`,
+			`DELETE FROM sqlite_sequence WHERE name IN ('bookings', 'booking_bundles')`,
+		} {
+			execCtx, span := startSpan(ctx, "bookings.truncate", query)
+			_, err := tx.ExecContext(execCtx, query)
+			endSpan(span, err)
+			if err != nil {
+				return fmt.Errorf("failed to truncate bookings: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetSystemStats aggregates booking counters for the dashboard
+func (r *BookingRepository) GetSystemStats(ctx context.Context) (*repository.SystemStats, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	query := `
+        SELECT COUNT(*),
+               COALESCE(SUM(status = 'approved'), 0),
+               COALESCE(SUM(status = 'rejected'), 0)
+        FROM bookings
+    `
+	statsCtx, span := startSpan(ctx, "bookings.stats", query)
+	var stats repository.SystemStats
+	err := r.db.DB.QueryRowContext(statsCtx, query).Scan(&stats.TotalBookings, &stats.ActiveBookings, &stats.Conflicts)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Utilization is today's booked hours over today's available hours
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	today, err := r.GetUtilization(ctx, models.UtilizationQuery{
+		From:        dayStart,
+		To:          dayStart.AddDate(0, 0, 1),
+		Granularity: "day",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(today) > 0 {
+		stats.Utilization = today[0].Utilization
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,187 @@
+// Package sqlite implements the store interfaces on a single SQLite file, for
+// small deployments that run without Postgres. It keeps the semantics of the
+// Postgres repositories (sentinel errors, row versions, cursors) with its own
+// schema in migrations/sqlite, and runs the very same allocation rules:
+// repository.Allocation, over the statements of allocTx.
+//
+// SQLite has no row locks. Every write transaction instead holds the single
+// writer slot of the Database from BEGIN to COMMIT, so a conflict check and
+// the insert it guards can never interleave with another allocation, the way
+// FOR UPDATE on the room row serializes them on Postgres. Reads run beside
+// the writer on their own connections (WAL journal).
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/indraprhmbd/allocra/internal/metrics"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+type Database struct {
+	DB *sql.DB
+
+	// Timeouts bound each kind of operation; a zero timeout leaves the
+	// caller's context as the only limit
+	Timeouts repository.Timeouts
+
+	writer chan struct{} // the one slot write transactions take turns on
+	zones  alloc.Zones
+}
+
+// Options configures the database file
+type Options struct {
+	// BusyTimeout is how long a statement waits for another process holding
+	// the file, such as `allocra migrate`, before failing
+	BusyTimeout time.Duration
+	Timeouts    repository.Timeouts
+}
+
+// Open opens (creating if needed) the database file at path
+func Open(path string, opts Options) (*Database, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeout.Milliseconds()))
+	// Take the file's write lock at BEGIN rather than at the first write, so a
+	// transaction never has to be retried after reading stale data
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	return &Database{DB: db, Timeouts: opts.Timeouts, writer: make(chan struct{}, 1)}, nil
+}
+
+func (d *Database) Close() error {
+	return d.DB.Close()
+}
+
+// withTimeout derives the context of one operation; d <= 0 means no timeout
+func (d *Database) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// write runs fn in a transaction holding the writer slot and commits it if fn
+// succeeds. The wait for the slot is what FOR UPDATE waits for on Postgres.
+func (d *Database) write(ctx context.Context, operation string, fn func(tx *sql.Tx) error) (err error) {
+	return d.run(ctx, operation, true, fn)
+}
+
+// simulate runs fn like write but always rolls it back
+func (d *Database) simulate(ctx context.Context, operation string, fn func(tx *sql.Tx) error) (err error) {
+	return d.run(ctx, operation, false, fn)
+}
+
+func (d *Database) run(ctx context.Context, operation string, commit bool, fn func(tx *sql.Tx) error) (err error) {
+	ctx, span := startSpan(ctx, "tx."+operation, "")
+	defer func() { endSpan(span, err) }()
+
+	began := time.Now()
+	select {
+	case d.writer <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-d.writer }()
+	metrics.RoomLockWait.Observe(time.Since(began).Seconds())
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil || !commit {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// micros is how instants are stored: microseconds since the Unix epoch
+func micros(t time.Time) int64 {
+	return t.Round(time.Microsecond).UnixMicro()
+}
+
+// instant scans a stored instant, in UTC
+type instant struct {
+	t *time.Time
+}
+
+func (i instant) Scan(v interface{}) error {
+	n, ok := v.(int64)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into an instant", v)
+	}
+	*i.t = time.UnixMicro(n).UTC()
+	return nil
+}
+
+// clockParam is a nullable time of day parameter
+func clockParam(v *string) sql.NullString {
+	if v == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: alloc.Clock(*v), Valid: true}
+}
+
+// isConstraint reports whether err is a violation of the given SQLite
+// extended constraint code
+func isConstraint(err error, code int) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == code
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	return isConstraint(err, sqlite3.SQLITE_CONSTRAINT_UNIQUE)
+}
+
+// isForeignKeyViolation reports whether err is a foreign key violation
+func isForeignKeyViolation(err error) bool {
+	return isConstraint(err, sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY)
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// collectIDs reads a single integer column from every row of a query
+func collectIDs(rows *sql.Rows, err error) ([]int, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,279 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+type GroupRepository struct {
+	db *Database
+}
+
+func NewGroupRepository(db *Database) *GroupRepository {
+	return &GroupRepository{db: db}
+}
+
+// subtreeRooms is the FROM clause of the active rooms below group g
+const subtreeRooms = `group_closure c JOIN rooms r ON r.group_id = c.descendant_id AND r.archived_at IS NULL WHERE c.ancestor_id = g.id`
+
+// groupSelect reads a group with its effective policy and the capacity of the
+// active rooms in its subtree, in the order scanGroup expects
+const groupSelect = `
+    SELECT g.id, g.name, g.kind, g.parent_id,
+           g.opens_at, g.closes_at, g.requires_approval, g.daily_quota_hours,
+           gp.opens_at, gp.closes_at, gp.requires_approval, gp.daily_quota_hours,
+           (SELECT COUNT(*) FROM ` + subtreeRooms + `),
+           (SELECT COALESCE(SUM(r.capacity), 0) FROM ` + subtreeRooms + `),
+           (SELECT COALESCE(SUM(r.capacity), 0) FROM ` + subtreeRooms + ` AND r.status = 'online'),
+           g.created_at, g.updated_at, g.version
+    FROM resource_groups g
+    JOIN group_policies gp ON gp.group_id = g.id`
+
+// nullPolicy is a Policy as read from nullable columns
+type nullPolicy struct {
+	opensAt, closesAt sql.NullString
+	requiresApproval  sql.NullBool
+	dailyQuotaHours   sql.NullFloat64
+}
+
+func (p *nullPolicy) policy() models.Policy {
+	var out models.Policy
+	if p.opensAt.Valid {
+		out.OpensAt, out.ClosesAt = &p.opensAt.String, &p.closesAt.String
+	}
+	if p.requiresApproval.Valid {
+		out.RequiresApproval = &p.requiresApproval.Bool
+	}
+	if p.dailyQuotaHours.Valid {
+		out.DailyQuotaHours = &p.dailyQuotaHours.Float64
+	}
+	return out
+}
+
+func scanGroup(row rowScanner, g *models.ResourceGroup) error {
+	var parentID sql.NullInt64
+	var own, effective nullPolicy
+	err := row.Scan(
+		&g.ID,
+		&g.Name,
+		&g.Kind,
+		&parentID,
+		&own.opensAt,
+		&own.closesAt,
+		&own.requiresApproval,
+		&own.dailyQuotaHours,
+		&effective.opensAt,
+		&effective.closesAt,
+		&effective.requiresApproval,
+		&effective.dailyQuotaHours,
+		&g.RoomCount,
+		&g.TotalCapacity,
+		&g.OnlineCapacity,
+		instant{&g.CreatedAt},
+		instant{&g.UpdatedAt},
+		&g.Version,
+	)
+	if err != nil {
+		return err
+	}
+	g.ParentID = nil
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		g.ParentID = &id
+	}
+	g.Policy, g.Effective = own.policy(), effective.policy()
+	return nil
+}
+
+// groupWriteFailed maps the constraint violations of a group insert or update
+func groupWriteFailed(err error, g *models.ResourceGroup, what string) error {
+	switch {
+	case isUniqueViolation(err):
+		return repository.ErrGroupNameTaken
+	case isForeignKeyViolation(err) && g.ParentID != nil:
+		return fmt.Errorf("%w with id: %d", repository.ErrParentGroupNotFound, *g.ParentID)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// getGroup reads a single group through q
+func getGroup(ctx context.Context, q queryer, id int) (*models.ResourceGroup, error) {
+	query := groupSelect + ` WHERE g.id = $1`
+
+	ctx, span := startSpan(ctx, "resource_groups.get", query)
+	var g models.ResourceGroup
+	err := scanGroup(q.QueryRowContext(ctx, query, id), &g)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrGroupNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch resource group: %w", err)
+	}
+	return &g, nil
+}
+
+// Create inserts a group. The caller checks that the parent is of an earlier kind.
+func (r *GroupRepository) Create(ctx context.Context, g *models.ResourceGroup) (*models.ResourceGroup, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	var created *models.ResourceGroup
+	err := r.db.write(ctx, "create_resource_group", func(tx *sql.Tx) error {
+		query := `
+            INSERT INTO resource_groups (name, kind, parent_id, opens_at, closes_at, requires_approval, daily_quota_hours)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            RETURNING id
+        `
+		insertCtx, span := startSpan(ctx, "resource_groups.insert", query)
+		var id int
+		err := tx.QueryRowContext(insertCtx, query,
+			g.Name,
+			g.Kind,
+			g.ParentID,
+			clockParam(g.Policy.OpensAt),
+			clockParam(g.Policy.ClosesAt),
+			g.Policy.RequiresApproval,
+			g.Policy.DailyQuotaHours,
+		).Scan(&id)
+		endSpan(span, err)
+		if err != nil {
+			return groupWriteFailed(err, g, "failed to create resource group")
+		}
+
+		created, err = getGroup(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetByID fetches a single group
+func (r *GroupRepository) GetByID(ctx context.Context, id int) (*models.ResourceGroup, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	return getGroup(ctx, r.db.DB, id)
+}
+
+// List returns the groups of one kind, or all groups when kind is empty
+func (r *GroupRepository) List(ctx context.Context, kind string) ([]models.ResourceGroup, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	query := groupSelect + ` WHERE ($1 IS NULL OR g.kind = $1) ORDER BY g.name`
+
+	ctx, span := startSpan(ctx, "resource_groups.list", query)
+	rows, err := r.db.DB.QueryContext(ctx, query, sql.NullString{String: kind, Valid: kind != ""})
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to fetch resource groups: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	groups := []models.ResourceGroup{}
+	for rows.Next() {
+		var g models.ResourceGroup
+		if err := scanGroup(rows, &g); err != nil {
+			return nil, fmt.Errorf("failed to scan resource group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, rows.Err()
+}
+
+// Update writes name, parent and policy of g only if the row is still at
+// version (or version is AnyVersion). The kind of a group never changes.
+func (r *GroupRepository) Update(ctx context.Context, id, version int, g *models.ResourceGroup) (*models.ResourceGroup, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	var updated *models.ResourceGroup
+	err := r.db.write(ctx, "update_resource_group", func(tx *sql.Tx) error {
+		query := `
+            UPDATE resource_groups
+            SET name = $1, parent_id = $2, opens_at = $3, closes_at = $4, requires_approval = $5, daily_quota_hours = $6
+            WHERE id = $7 AND ($8 = 0 OR version = $8)
+        `
+		updateCtx, span := startSpan(ctx, "resource_groups.update", query)
+		result, err := tx.ExecContext(updateCtx, query,
+			g.Name,
+			g.ParentID,
+			clockParam(g.Policy.OpensAt),
+			clockParam(g.Policy.ClosesAt),
+			g.Policy.RequiresApproval,
+			g.Policy.DailyQuotaHours,
+			id,
+			version,
+		)
+		endSpan(span, err)
+		if err != nil {
+			return groupWriteFailed(err, g, "failed to update resource group")
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// Either gone or moved on; getGroup tells which
+			if _, err := getGroup(ctx, tx, id); err != nil {
+				return err
+			}
+			return fmt.Errorf("resource group %d: %w", id, repository.ErrStaleVersion)
+		}
+
+		updated, err = getGroup(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Delete removes an empty group. Archived rooms still pointing at it are
+// detached; active rooms and child groups must be moved away first.
+func (r *GroupRepository) Delete(ctx context.Context, id int) error {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	return r.db.write(ctx, "delete_resource_group", func(tx *sql.Tx) error {
+		query := `
+            SELECT EXISTS (SELECT 1 FROM resource_groups WHERE parent_id = $1)
+                OR EXISTS (SELECT 1 FROM rooms WHERE group_id = $1 AND archived_at IS NULL)
+            FROM resource_groups WHERE id = $1
+        `
+		checkCtx, span := startSpan(ctx, "resource_groups.check", query)
+		var inUse bool
+		err := tx.QueryRowContext(checkCtx, query, id).Scan(&inUse)
+		endSpan(span, err)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w with id: %d", repository.ErrGroupNotFound, id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read resource group: %w", err)
+		}
+		if inUse {
+			return repository.ErrGroupNotEmpty
+		}
+
+		for _, query := range []string{
+			`UPDATE rooms SET group_id = NULL WHERE group_id = $1`,
+			`DELETE FROM resource_groups WHERE id = $1`,
+		} {
+			execCtx, span := startSpan(ctx, "resource_groups.delete", query)
+			_, err := tx.ExecContext(execCtx, query, id)
+			endSpan(span, err)
+			if err != nil {
+				return fmt.Errorf("failed to delete resource group: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+type IdempotencyRepository struct {
+	db *Database
+}
+
+func NewIdempotencyRepository(db *Database) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+// Reserve claims (scope, key) for lease. It returns nil when the caller now owns
+// the key and must process the request, or the live record already holding it.
+// Expired records, including leases of requests that never completed, are taken over.
+func (r *IdempotencyRepository) Reserve(ctx context.Context, scope, key, fingerprint string, lease time.Duration) (*models.IdempotencyRecord, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	var held *models.IdempotencyRecord
+	err := r.db.write(ctx, "reserve_idempotency_key", func(tx *sql.Tx) error {
+		now := time.Now()
+		query := `
+            INSERT INTO idempotency_keys (scope, key, fingerprint, created_at, expires_at)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (scope, key) DO UPDATE
+            SET fingerprint = excluded.fingerprint,
+                status_code = NULL,
+                content_type = NULL,
+                response = NULL,
+                created_at = excluded.created_at,
+                expires_at = excluded.expires_at
+            WHERE idempotency_keys.expires_at < excluded.created_at
+            RETURNING 1
+        `
+		reserveCtx, span := startSpan(ctx, "idempotency.reserve", query)
+		var reserved int
+		err := tx.QueryRowContext(reserveCtx, query, scope, key, fingerprint, micros(now), micros(now.Add(lease))).Scan(&reserved)
+		endSpan(span, err)
+		if err == nil {
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to reserve idempotency key: %w", err)
+		}
+
+		// Nothing purges the row while this transaction holds the writer slot
+		query = `
+            SELECT fingerprint, COALESCE(status_code, 0), COALESCE(content_type, ''), response
+            FROM idempotency_keys
+            WHERE scope = $1 AND key = $2
+        `
+		getCtx, span := startSpan(ctx, "idempotency.get", query)
+		var rec models.IdempotencyRecord
+		err = tx.QueryRowContext(getCtx, query, scope, key).Scan(
+			&rec.Fingerprint,
+			&rec.StatusCode,
+			&rec.ContentType,
+			&rec.Body,
+		)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+		held = &rec
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// Complete stores the response of a reserved key and keeps it for ttl
+func (r *IdempotencyRepository) Complete(ctx context.Context, scope, key string, rec *models.IdempotencyRecord, ttl time.Duration) error {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	return r.db.write(ctx, "complete_idempotency_key", func(tx *sql.Tx) error {
+		query := `
+            UPDATE idempotency_keys
+            SET status_code = $4, content_type = $5, response = $6, expires_at = $7
+            WHERE scope = $1 AND key = $2 AND fingerprint = $3 AND status_code IS NULL
+        `
+		execCtx, span := startSpan(ctx, "idempotency.complete", query)
+		_, err := tx.ExecContext(execCtx, query, scope, key, rec.Fingerprint, rec.StatusCode, rec.ContentType, rec.Body, micros(time.Now().Add(ttl)))
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to store idempotent response: %w", err)
+		}
+		return nil
+	})
+}
+
+// Release drops an in-flight reservation so the client may retry with the same key
+func (r *IdempotencyRepository) Release(ctx context.Context, scope, key, fingerprint string) error {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	return r.db.write(ctx, "release_idempotency_key", func(tx *sql.Tx) error {
+		query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND fingerprint = $3 AND status_code IS NULL`
+		execCtx, span := startSpan(ctx, "idempotency.release", query)
+		_, err := tx.ExecContext(execCtx, query, scope, key, fingerprint)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to release idempotency key: %w", err)
+		}
+		return nil
+	})
+}
+
+// PurgeExpired deletes records past their TTL and returns how many were removed
+func (r *IdempotencyRepository) PurgeExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Batch)
+	defer cancel()
+
+	var purged int64
+	err := r.db.write(ctx, "purge_idempotency_keys", func(tx *sql.Tx) error {
+		query := `DELETE FROM idempotency_keys WHERE expires_at < $1`
+		execCtx, span := startSpan(ctx, "idempotency.purge", query)
+		result, err := tx.ExecContext(execCtx, query, micros(time.Now()))
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to purge idempotency keys: %w", err)
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+	return purged, err
+}
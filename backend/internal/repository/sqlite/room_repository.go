@@ -0,0 +1,488 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+type RoomRepository struct {
+	db *Database
+}
+
+func NewRoomRepository(db *Database) *RoomRepository {
+	return &RoomRepository{db: db}
+}
+
+// roomColumns is the select list read by scanRoom, over rooms r joined to the
+// group_policies gp of its group (see roomsWithPolicies). Hours a room does not
+// set itself come from its group, else the whole day.
+const roomColumns = `r.id, r.name, r.capacity, r.type, r.quantity, r.status,
+    COALESCE(r.opens_at, gp.opens_at, '00:00:00'), COALESCE(r.closes_at, gp.closes_at, '24:00:00'), r.opens_at IS NULL,
+    r.timezone, r.group_id, COALESCE(gp.requires_approval, 0), gp.daily_quota_hours,
+    r.labels, r.created_at, r.updated_at, r.version`
+
+// roomsWithPolicies is the FROM clause roomColumns reads
+const roomsWithPolicies = `rooms r LEFT JOIN group_policies gp ON gp.group_id = r.group_id`
+
+// inGroup is the condition that a room's group lies below the group in parameter n
+func inGroup(column string, n int) string {
+	return fmt.Sprintf("($%[2]d IS NULL OR %[1]s IN (SELECT descendant_id FROM group_closure WHERE ancestor_id = $%[2]d))", column, n)
+}
+
+// peakUsage is the most units of room that approved bookings hold at any
+// instant of [from, to), sampled as the peak_usage function of migration 014
+// explains
+func peakUsage(room, from, to string) string {
+	return fmt.Sprintf(`MAX(
+        COALESCE((
+            SELECT SUM(o.quantity) FROM bookings o
+            WHERE o.room_id = %[1]s AND o.status = 'approved'
+              AND o.start_time <= %[2]s AND o.end_time > %[2]s
+        ), 0),
+        COALESCE((
+            SELECT MAX((
+                SELECT SUM(o.quantity) FROM bookings o
+                WHERE o.room_id = %[1]s AND o.status = 'approved'
+                  AND o.start_time <= s.start_time AND o.end_time > s.start_time
+            ))
+            FROM bookings s
+            WHERE s.room_id = %[1]s AND s.status = 'approved'
+              AND s.start_time > %[2]s AND s.start_time < %[3]s
+        ), 0))`, room, from, to)
+}
+
+// scanRoom reads roomColumns, followed by any extra selected columns
+func scanRoom(row rowScanner, room *models.Room, extra ...interface{}) error {
+	var labelDoc []byte
+	var groupID sql.NullInt64
+	var quota sql.NullFloat64
+	dest := []interface{}{
+		&room.ID,
+		&room.Name,
+		&room.Capacity,
+		&room.Type,
+		&room.Quantity,
+		&room.Status,
+		&room.OpensAt,
+		&room.ClosesAt,
+		&room.HoursInherited,
+		&room.Timezone,
+		&groupID,
+		&room.RequiresApproval,
+		&quota,
+		&labelDoc,
+		instant{&room.CreatedAt},
+		instant{&room.UpdatedAt},
+		&room.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	room.GroupID = nil
+	if groupID.Valid {
+		id := int(groupID.Int64)
+		room.GroupID = &id
+	}
+	room.DailyQuotaHours = nil
+	if quota.Valid {
+		room.DailyQuotaHours = &quota.Float64
+	}
+	room.Labels = map[string]string{}
+	if err := json.Unmarshal(labelDoc, &room.Labels); err != nil {
+		return fmt.Errorf("invalid labels on room %d: %w", room.ID, err)
+	}
+	return nil
+}
+
+// labelsParam encodes a label set for the JSON column; nil stores {}
+func labelsParam(set map[string]string) string {
+	if set == nil {
+		return "{}"
+	}
+	doc, _ := json.Marshal(set)
+	return string(doc)
+}
+
+// hoursParams returns the room's own operating hours, NULL when it inherits them
+func hoursParams(room *models.Room) (opens, closes sql.NullString) {
+	if room.HoursInherited {
+		return opens, closes
+	}
+	return clockParam(&room.OpensAt), clockParam(&room.ClosesAt)
+}
+
+// roomWriteFailed maps the constraint violations of a room insert or update
+// to their sentinels and wraps any other error with what failed
+func roomWriteFailed(err error, groupID *int, what string) error {
+	switch {
+	case isUniqueViolation(err):
+		return repository.ErrRoomNameTaken
+	case isForeignKeyViolation(err) && groupID != nil:
+		return fmt.Errorf("%w with id: %d", repository.ErrGroupNotFound, *groupID)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// getRoom reads an active room through q
+func getRoom(ctx context.Context, q queryer, id int) (*models.Room, error) {
+	query := `SELECT ` + roomColumns + ` FROM ` + roomsWithPolicies + ` WHERE r.id = $1 AND r.archived_at IS NULL`
+
+	ctx, span := startSpan(ctx, "rooms.get", query)
+	var room models.Room
+	err := scanRoom(q.QueryRowContext(ctx, query, id), &room)
+	endSpan(span, err)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w with id: %d", repository.ErrRoomNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch room: %w", err)
+	}
+	return &room, nil
+}
+
+func (r *RoomRepository) Create(ctx context.Context, room *models.Room) (*models.Room, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	var created *models.Room
+	err := r.db.write(ctx, "create_room", func(tx *sql.Tx) error {
+		query := `
+            INSERT INTO rooms (name, capacity, type, status, opens_at, closes_at, labels, group_id, quantity, timezone)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+            RETURNING id`
+
+		opens, closes := hoursParams(room)
+		insertCtx, span := startSpan(ctx, "rooms.insert", query)
+		var id int
+		err := tx.QueryRowContext(insertCtx, query,
+			room.Name,
+			room.Capacity,
+			room.Type,
+			room.Status,
+			opens,
+			closes,
+			labelsParam(room.Labels),
+			room.GroupID,
+			room.Quantity,
+			room.Timezone,
+		).Scan(&id)
+		endSpan(span, err)
+		if err != nil {
+			return roomWriteFailed(err, room.GroupID, "failed to create room")
+		}
+
+		created, err = getRoom(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetAll lists active rooms matching f
+func (r *RoomRepository) GetAll(ctx context.Context, f models.RoomFilter) ([]models.Room, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	where, args := selectorClause(f.Selector, "r.labels", []interface{}{f.GroupID})
+	query := `
+        SELECT ` + roomColumns + ` FROM ` + roomsWithPolicies + `
+        WHERE r.archived_at IS NULL AND ` + inGroup("r.group_id", 1) + ` AND ` + where + `
+        ORDER BY r.name`
+
+	ctx, span := startSpan(ctx, "rooms.list", query)
+	rows, err := r.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to fetch rooms: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	rooms := []models.Room{}
+	for rows.Next() {
+		var room models.Room
+		if err := scanRoom(rows, &room); err != nil {
+			return nil, fmt.Errorf("failed to scan room: %w", err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// FindAvailable lists active online rooms matching q that keep at least
+// q.Quantity units free throughout [q.Start, q.End), smallest capacity first,
+// with the units that stay free
+func (r *RoomRepository) FindAvailable(ctx context.Context, q models.AvailabilityQuery) ([]models.Room, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	roomType := sql.NullString{String: q.Type, Valid: q.Type != ""}
+	args := []interface{}{micros(q.Start), micros(q.End), q.MinCapacity, roomType, q.GroupID, alloc.Units(q.Quantity)}
+	where, args := selectorClause(q.Selector, "r.labels", args)
+	free := `r.quantity - ` + peakUsage("r.id", "$1", "$2")
+	query := `
+        SELECT ` + roomColumns + `, ` + free + `
+        FROM ` + roomsWithPolicies + `
+        WHERE r.archived_at IS NULL
+          AND r.status = 'online'
+          AND r.capacity >= $3
+          AND r.quantity >= $6
+          AND ($4 IS NULL OR r.type = $4)
+          AND ` + inGroup("r.group_id", 5) + `
+          AND ` + where + `
+          AND ` + free + ` >= $6
+        ORDER BY r.capacity, r.id
+    `
+
+	ctx, span := startSpan(ctx, "rooms.find_available", query)
+	rows, err := r.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to fetch rooms: %w", err)
+	}
+	defer span.End()
+	defer rows.Close()
+
+	rooms := []models.Room{}
+	for rows.Next() {
+		var room models.Room
+		var free int
+		if err := scanRoom(rows, &room, &free); err != nil {
+			return nil, fmt.Errorf("failed to scan room: %w", err)
+		}
+		room.FreeUnits = &free
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// GetByID fetches a single room
+func (r *RoomRepository) GetByID(ctx context.Context, id int) (*models.Room, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	return getRoom(ctx, r.db.DB, id)
+}
+
+// Update writes the mutable fields of room only if the row is still at version
+// (or version is AnyVersion) and returns the room as written
+func (r *RoomRepository) Update(ctx context.Context, id, version int, room *models.Room) (*models.Room, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.Query)
+	defer cancel()
+
+	var updated *models.Room
+	err := r.db.write(ctx, "update_room", func(tx *sql.Tx) error {
+		query := `
+            UPDATE rooms
+            SET name = $1, capacity = $2, type = $3, status = $4, opens_at = $5, closes_at = $6,
+                labels = $9, group_id = $10, quantity = $11, timezone = $12
+            WHERE id = $7 AND archived_at IS NULL AND ($8 = 0 OR version = $8)
+        `
+
+		opens, closes := hoursParams(room)
+		updateCtx, span := startSpan(ctx, "rooms.update", query)
+		result, err := tx.ExecContext(updateCtx, query,
+			room.Name,
+			room.Capacity,
+			room.Type,
+			room.Status,
+			opens,
+			closes,
+			id,
+			version,
+			labelsParam(room.Labels),
+			room.GroupID,
+			room.Quantity,
+			room.Timezone,
+		)
+		endSpan(span, err)
+		if err != nil {
+			return roomWriteFailed(err, room.GroupID, "failed to update room")
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// Either gone or moved on; getRoom tells which
+			if _, err := getRoom(ctx, tx, id); err != nil {
+				return err
+			}
+			return fmt.Errorf("room %d: %w", id, repository.ErrStaleVersion)
+		}
+
+		updated, err = getRoom(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Archive takes a room out of service while keeping it, and its bookings, for
+// history; see repository.RoomRepository.Archive for the strategies
+func (r *RoomRepository) Archive(ctx context.Context, id int, strategy string) (*models.ArchiveResult, error) {
+	ctx, cancel := r.db.withTimeout(ctx, r.db.Timeouts.BulkWrite)
+	defer cancel()
+
+	var result *models.ArchiveResult
+	err := r.db.write(ctx, "archive_room", func(tx *sql.Tx) error {
+		result = &models.ArchiveResult{RoomID: id, Rejected: []int{}, Reassigned: []models.Reassignment{}}
+		now := time.Now().UTC().Round(time.Microsecond)
+
+		room, err := getRoom(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		pending, err := r.rejectBookings(ctx, tx, id, "pending", now)
+		if err != nil {
+			return err
+		}
+
+		switch strategy {
+		case models.ArchiveStrategyReject:
+			approved, err := r.rejectBookings(ctx, tx, id, "approved", now)
+			if err != nil {
+				return err
+			}
+			result.Rejected = append(result.Rejected, approved...)
+		case models.ArchiveStrategyReassign:
+			if result.Reassigned, err = r.reassignBookings(ctx, tx, room, now); err != nil {
+				return err
+			}
+		default:
+			var upcoming int
+			query := `SELECT COUNT(*) FROM bookings WHERE room_id = $1 AND status = 'approved' AND end_time > $2`
+			countCtx, span := startSpan(ctx, "bookings.count_upcoming", query)
+			err := tx.QueryRowContext(countCtx, query, id, micros(now)).Scan(&upcoming)
+			endSpan(span, err)
+			if err != nil {
+				return fmt.Errorf("failed to count upcoming bookings: %w", err)
+			}
+			if upcoming > 0 {
+				return fmt.Errorf("%w: %d approved booking(s) have not ended yet", repository.ErrRoomHasUpcomingBookings, upcoming)
+			}
+		}
+		result.Rejected = append(result.Rejected, pending...)
+
+		query := `UPDATE rooms SET archived_at = $2, status = 'offline' WHERE id = $1`
+		archiveCtx, span := startSpan(ctx, "rooms.archive", query)
+		_, err = tx.ExecContext(archiveCtx, query, id, micros(now))
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to archive room: %w", err)
+		}
+		result.ArchivedAt = now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rejectBookings rejects the bookings of a room in status that have not ended,
+// along with the rest of any bundle they belong to
+func (r *RoomRepository) rejectBookings(ctx context.Context, tx *sql.Tx, roomID int, status string, now time.Time) ([]int, error) {
+	query := `
+        UPDATE bookings SET status = 'rejected'
+        WHERE room_id = $1 AND status = $2 AND end_time > $3
+        RETURNING id
+    `
+	rejectCtx, span := startSpan(ctx, "bookings.reject_upcoming", query)
+	ids, err := collectIDs(tx.QueryContext(rejectCtx, query, roomID, status, micros(now)))
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reject %s bookings: %w", status, err)
+	}
+	if err := rejectBundlesOf(ctx, tx, ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// reassignBookings moves every approved booking of room that has not ended to
+// another room with enough free units, earliest first, so later bookings see
+// the earlier moves
+func (r *RoomRepository) reassignBookings(ctx context.Context, tx *sql.Tx, room *models.Room, now time.Time) ([]models.Reassignment, error) {
+	query := `
+        SELECT id, start_time, end_time, quantity FROM bookings
+        WHERE room_id = $1 AND status = 'approved' AND end_time > $2
+        ORDER BY start_time, id
+    `
+	listCtx, span := startSpan(ctx, "bookings.list_upcoming", query)
+	rows, err := tx.QueryContext(listCtx, query, room.ID, micros(now))
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("failed to list upcoming bookings: %w", err)
+	}
+	var upcoming []models.Booking
+	for rows.Next() {
+		var b models.Booking
+		if err := rows.Scan(&b.ID, instant{&b.StartTime}, instant{&b.EndTime}, &b.Quantity); err != nil {
+			rows.Close()
+			span.End()
+			return nil, err
+		}
+		upcoming = append(upcoming, b)
+	}
+	rows.Close()
+	span.End()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pick := `
+        SELECT c.id FROM rooms c
+        WHERE c.id <> $1
+          AND c.archived_at IS NULL
+          AND c.status = 'online'
+          AND c.type = $2
+          AND c.capacity >= $3
+          AND c.quantity - ` + peakUsage("c.id", "$4", "$5") + ` >= $6
+        ORDER BY c.capacity, c.id
+        LIMIT 1
+    `
+	move := `UPDATE bookings SET room_id = $2 WHERE id = $1`
+
+	moved := make([]models.Reassignment, 0, len(upcoming))
+	for _, b := range upcoming {
+		var target int
+		pickCtx, span := startSpan(ctx, "rooms.pick_reassignment", pick)
+		err := tx.QueryRowContext(pickCtx, pick, room.ID, room.Type, room.Capacity, micros(b.StartTime), micros(b.EndTime), b.Quantity).Scan(&target)
+		endSpan(span, err)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w for booking %d (%s - %s)", repository.ErrNoReassignmentTarget, b.ID,
+				b.StartTime.Format(time.RFC3339), b.EndTime.Format(time.RFC3339))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a room for booking %d: %w", b.ID, err)
+		}
+
+		moveCtx, span := startSpan(ctx, "bookings.reassign", move)
+		_, err = tx.ExecContext(moveCtx, move, b.ID, target)
+		endSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reassign booking %d: %w", b.ID, err)
+		}
+		moved = append(moved, models.Reassignment{BookingID: b.ID, RoomID: target})
+	}
+	return moved, nil
+}
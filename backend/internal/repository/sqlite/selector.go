@@ -0,0 +1,47 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
+)
+
+// selectorClause translates a label selector into a condition on the JSON
+// labels column, appending its parameters to args. Label values are always
+// strings, so json_extract compares them as text; a missing key reads as NULL.
+func selectorClause(sel labels.Selector, column string, args []interface{}) (string, []interface{}) {
+	if len(sel) == 0 {
+		return "1", args
+	}
+
+	path := func(key string) string {
+		args = append(args, `$."`+strings.ReplaceAll(key, `"`, `\"`)+`"`)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	anyOf := func(r labels.Requirement) string {
+		value := fmt.Sprintf("json_extract(%s, %s)", column, path(r.Key))
+		params := make([]string, len(r.Values))
+		for i, v := range r.Values {
+			args = append(args, v)
+			params[i] = fmt.Sprintf("$%d", len(args))
+		}
+		return fmt.Sprintf("%s IN (%s)", value, strings.Join(params, ", "))
+	}
+
+	conds := make([]string, 0, len(sel))
+	for _, r := range sel {
+		switch r.Op {
+		case labels.Equals, labels.In:
+			conds = append(conds, anyOf(r))
+		case labels.NotEquals, labels.NotIn:
+			// As NOT (labels @> ...) on Postgres, a room without the key matches
+			conds = append(conds, "NOT COALESCE("+anyOf(r)+", 0)")
+		case labels.Exists:
+			conds = append(conds, fmt.Sprintf("json_type(%s, %s) IS NOT NULL", column, path(r.Key)))
+		case labels.DoesNotExist:
+			conds = append(conds, fmt.Sprintf("json_type(%s, %s) IS NULL", column, path(r.Key)))
+		}
+	}
+	return strings.Join(conds, " AND "), args
+}
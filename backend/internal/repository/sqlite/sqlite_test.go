@@ -0,0 +1,70 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/migrate"
+	"github.com/indraprhmbd/allocra/internal/repository/sqlite"
+	"github.com/indraprhmbd/allocra/internal/repository/storetest"
+	"github.com/indraprhmbd/allocra/internal/services"
+	sqlitemigrations "github.com/indraprhmbd/allocra/migrations/sqlite"
+)
+
+var (
+	_ services.BookingStore     = (*sqlite.BookingRepository)(nil)
+	_ services.RoomStore        = (*sqlite.RoomRepository)(nil)
+	_ services.GroupStore       = (*sqlite.GroupRepository)(nil)
+	_ services.IdempotencyStore = (*sqlite.IdempotencyRepository)(nil)
+)
+
+// open migrates a new database file in the test's temporary directory. The
+// model check opens hundreds, so the migrations are applied quietly.
+func open(t *testing.T) *sqlite.Database {
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "allocra.db"), sqlite.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.NewSQLite(db.DB, sqlitemigrations.FS, func(string, ...interface{}) {})
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+	return db
+}
+
+func stores(t *testing.T) storetest.Stores {
+	db := open(t)
+	return storetest.Stores{
+		Bookings:    sqlite.NewBookingRepository(db),
+		Rooms:       sqlite.NewRoomRepository(db),
+		Groups:      sqlite.NewGroupRepository(db),
+		Idempotency: sqlite.NewIdempotencyRepository(db),
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, stores)
+}
+
+func TestModel(t *testing.T) {
+	storetest.Model(t, stores)
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	migrator, err := migrate.NewSQLite(db.DB, sqlitemigrations.FS, t.Logf)
+	require.NoError(t, err)
+
+	rolledBack, err := migrator.Down(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 2)
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	pending, err := migrator.Pending(ctx)
+	require.NoError(t, err)
+	require.Zero(t, pending)
+}
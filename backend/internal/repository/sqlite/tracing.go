@@ -0,0 +1,37 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/indraprhmbd/allocra/internal/repository/sqlite")
+
+// startSpan opens a client span for a single SQL statement (or a transaction
+// when query is empty). Callers must hand the result to endSpan.
+func startSpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	attrs := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemSqlite, semconv.DBOperationName(name)),
+	}
+	if query != "" {
+		attrs = append(attrs, trace.WithAttributes(semconv.DBQueryText(query)))
+	}
+	return tracer.Start(ctx, name, attrs...)
+}
+
+// endSpan records err on the span and ends it. sql.ErrNoRows is an expected
+// outcome for lookups and conflict checks, so it is not flagged as an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
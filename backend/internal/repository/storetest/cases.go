@@ -0,0 +1,661 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+var cases = []struct {
+	name string
+	run  func(t *testing.T, f fixture)
+}{
+	{"CreateConflictsAndUnits", testCreateConflictsAndUnits},
+	{"OverlapShapes", testOverlapShapes},
+	{"StateTransitions", testStateTransitions},
+	{"Preemption", testPreemption},
+	{"ApprovalAndPreemption", testApprovalAndPreemption},
+	{"FailedWritesAreUndone", testFailedWritesAreUndone},
+	{"ListKeysetPages", testListKeysetPages},
+	{"Groups", testGroups},
+	{"OperatingHours", testOperatingHours},
+	{"DryRunMatchesLive", testDryRunMatchesLive},
+	{"RoomsAndAvailability", testRoomsAndAvailability},
+	{"Reports", testReports},
+	{"Idempotency", testIdempotency},
+	{"ConcurrentAllocations", testConcurrentAllocations},
+	{"ConcurrentApprovals", testConcurrentApprovals},
+}
+
+func testCreateConflictsAndUnits(t *testing.T, f fixture) {
+	room := f.room(t, "Lab", models.RoomTypeShared, 1, nil)
+	pool := f.room(t, "GPUs", models.RoomTypePooled, 3, nil)
+
+	b, err := f.book(room.ID, 1, 9, 11, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "approved", b.Status)
+	assert.Equal(t, 1, b.Version)
+
+	b, err = f.book(room.ID, 2, 10, 12, 0)
+	assert.ErrorIs(t, err, repository.ErrBookingConflict)
+	assert.Equal(t, "rejected", b.Status, "conflicting requests are stored as rejected")
+
+	_, err = f.book(room.ID, 2, 11, 12, 0)
+	assert.NoError(t, err, "back-to-back bookings do not overlap")
+
+	_, err = f.book(pool.ID, 1, 9, 11, 2)
+	require.NoError(t, err)
+	_, err = f.book(pool.ID, 2, 10, 12, 1)
+	require.NoError(t, err)
+	_, err = f.book(pool.ID, 1, 10, 11, 1)
+	assert.ErrorIs(t, err, repository.ErrBookingConflict, "all three units are held from 10:00")
+	_, err = f.book(pool.ID, 1, 11, 12, 2)
+	assert.NoError(t, err, "two units free up at 11:00")
+	_, err = f.book(pool.ID, 1, 13, 14, 4)
+	assert.ErrorIs(t, err, repository.ErrInsufficientUnits)
+
+	_, err = f.book(99, 1, 9, 10, 0)
+	assert.ErrorIs(t, err, repository.ErrRoomNotFound)
+}
+
+// overlapShapes are the ways a request can sit against a booking held from
+// 10:00 to 14:00, named as the seeder names them
+var overlapShapes = []struct {
+	name     string
+	from, to int
+	overlaps bool
+}{
+	{"Exact", 10, 14, true},
+	{"TailOverlap", 8, 12, true},
+	{"HeadOverlap", 12, 16, true},
+	{"FullEnclosure", 11, 13, true},
+	{"Enclosing", 8, 16, true},
+	{"SharedStart", 10, 12, true},
+	{"SharedEnd", 12, 14, true},
+	{"AdjacentBefore", 8, 10, false},
+	{"AdjacentAfter", 14, 16, false},
+	{"Disjoint", 16, 18, false},
+}
+
+func testOverlapShapes(t *testing.T, f fixture) {
+	for _, shape := range overlapShapes {
+		t.Run(shape.name, func(t *testing.T) {
+			room := f.room(t, shape.name, models.RoomTypeShared, 1, nil)
+			_, err := f.book(room.ID, 1, 10, 14, 0)
+			require.NoError(t, err)
+
+			b, err := f.book(room.ID, 2, shape.from, shape.to, 0)
+			if shape.overlaps {
+				assert.ErrorIs(t, err, repository.ErrBookingConflict)
+				assert.Equal(t, "rejected", b.Status)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "approved", b.Status)
+			}
+
+			// A pool of two units takes one overlapping booking more, never two
+			pool := f.room(t, shape.name+" pool", models.RoomTypePooled, 2, nil)
+			_, err = f.book(pool.ID, 1, 10, 14, 0)
+			require.NoError(t, err)
+			_, err = f.book(pool.ID, 2, shape.from, shape.to, 0)
+			require.NoError(t, err)
+			_, err = f.book(pool.ID, 2, shape.from, shape.to, 0)
+			if shape.overlaps {
+				assert.ErrorIs(t, err, repository.ErrBookingConflict)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func testStateTransitions(t *testing.T, f fixture) {
+	ctx := context.Background()
+	yes := true
+	g, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "Reviewed", Kind: models.GroupKindSite, Policy: models.Policy{RequiresApproval: &yes}})
+	require.NoError(t, err)
+
+	actions := map[string]func(id, version int) (*models.Booking, error){
+		"approve": func(id, version int) (*models.Booking, error) { return f.Bookings.ApproveBooking(ctx, id, version) },
+		"reject":  func(id, version int) (*models.Booking, error) { return f.Bookings.RejectBooking(ctx, id, version) },
+		"preempt": func(id, version int) (*models.Booking, error) { return f.Bookings.PreemptBooking(ctx, id, version) },
+	}
+	tests := []struct {
+		from, action string
+		want         string // the status after the action, or empty when it fails
+		err          string
+	}{
+		{"pending", "approve", "approved", ""},
+		{"pending", "reject", "rejected", ""},
+		{"pending", "preempt", "approved", ""},
+		{"approved", "approve", "", "booking is not pending"},
+		{"approved", "reject", "", "booking is not pending"},
+		{"approved", "preempt", "approved", ""},
+		{"rejected", "approve", "", "booking is not pending"},
+		{"rejected", "reject", "", "booking is not pending"},
+		{"rejected", "preempt", "approved", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"/"+tt.action, func(t *testing.T) {
+			room := f.room(t, tt.from+" "+tt.action, models.RoomTypeShared, 1, &g.ID)
+			b, err := f.book(room.ID, 1, 9, 10, 0)
+			require.NoError(t, err)
+			if tt.from != "pending" {
+				reach := map[string]string{"approved": "approve", "rejected": "reject"}[tt.from]
+				b, err = actions[reach](b.ID, b.Version)
+				require.NoError(t, err)
+			}
+
+			got, err := actions[tt.action](b.ID, repository.AnyVersion)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				kept, err := f.Bookings.GetByID(ctx, b.ID)
+				require.NoError(t, err)
+				assert.Equal(t, b.Status, kept.Status)
+				assert.Equal(t, b.Version, kept.Version, "a refused transition changes nothing")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Status)
+		})
+	}
+
+	_, err = f.Bookings.ApproveBooking(ctx, 99, repository.AnyVersion)
+	assert.ErrorIs(t, err, repository.ErrBookingNotFound)
+	_, err = f.Bookings.PreemptBooking(ctx, 99, repository.AnyVersion)
+	assert.ErrorIs(t, err, repository.ErrBookingNotFound)
+}
+
+func testPreemption(t *testing.T, f fixture) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		quantity int
+		held     [][2]int // the units and start hour of each approved booking, oldest first, all one hour long
+		request  [2]int   // the units and start hour of the forced booking
+		rejected []int    // indexes into held of the bookings it preempts
+		err      error
+	}{
+		{name: "exclusive room", quantity: 1, held: [][2]int{{1, 9}}, request: [2]int{1, 9}, rejected: []int{0}},
+		{name: "only overlapping bookings", quantity: 1, held: [][2]int{{1, 8}, {1, 9}, {1, 10}}, request: [2]int{1, 9}, rejected: []int{1}},
+		{name: "newest first until it fits", quantity: 3, held: [][2]int{{1, 9}, {1, 9}, {1, 9}}, request: [2]int{2, 9}, rejected: []int{2, 1}},
+		{name: "one large booking frees enough", quantity: 3, held: [][2]int{{1, 9}, {2, 9}}, request: [2]int{2, 9}, rejected: []int{1}},
+		{name: "free units need no preemption", quantity: 3, held: [][2]int{{1, 9}}, request: [2]int{2, 9}},
+		{name: "more units than the room ever holds", quantity: 2, held: [][2]int{{1, 9}}, request: [2]int{3, 9}, err: repository.ErrInsufficientUnits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := f.room(t, tt.name, models.RoomTypePooled, tt.quantity, nil)
+			held := make([]*models.Booking, len(tt.held))
+			for i, h := range tt.held {
+				b, err := f.book(room.ID, 1, h[1], h[1]+1, h[0])
+				require.NoError(t, err)
+				held[i] = b
+			}
+
+			// A request that doesn't fit is stored as rejected, and forcing revives it
+			forced, err := f.book(room.ID, 2, tt.request[1], tt.request[1]+1, tt.request[0])
+			if tt.err != nil {
+				// Refused outright, so there is nothing to force
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			if forced.Status == "rejected" {
+				forced, err = f.Bookings.PreemptBooking(ctx, forced.ID, forced.Version)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, "approved", forced.Status)
+
+			rejected := map[int]bool{}
+			for _, i := range tt.rejected {
+				rejected[i] = true
+			}
+			for i, b := range held {
+				got, err := f.Bookings.GetByID(ctx, b.ID)
+				require.NoError(t, err)
+				if rejected[i] {
+					assert.Equal(t, "rejected", got.Status, "booking %d", i)
+					assert.Equal(t, b.Version+1, got.Version)
+				} else {
+					assert.Equal(t, "approved", got.Status, "booking %d", i)
+					assert.Equal(t, b.Version, got.Version)
+				}
+			}
+		})
+	}
+}
+
+func testApprovalAndPreemption(t *testing.T, f fixture) {
+	ctx := context.Background()
+	yes := true
+	g, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "Labs", Kind: models.GroupKindSite, Policy: models.Policy{RequiresApproval: &yes}})
+	require.NoError(t, err)
+	room := f.room(t, "Lab", models.RoomTypeShared, 1, &g.ID)
+	assert.True(t, room.RequiresApproval)
+
+	first, err := f.book(room.ID, 1, 9, 10, 0)
+	require.NoError(t, err)
+	second, err := f.book(room.ID, 2, 9, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", first.Status)
+	assert.Equal(t, "pending", second.Status, "pending bookings hold nothing")
+
+	_, err = f.Bookings.ApproveBooking(ctx, first.ID, first.Version+1)
+	assert.ErrorIs(t, err, repository.ErrStaleVersion)
+	approved, err := f.Bookings.ApproveBooking(ctx, first.ID, first.Version)
+	require.NoError(t, err)
+	assert.Equal(t, 2, approved.Version)
+
+	_, err = f.Bookings.ApproveBooking(ctx, second.ID, repository.AnyVersion)
+	assert.EqualError(t, err, "conflict detected, cannot approve")
+
+	forced, err := f.Bookings.PreemptBooking(ctx, second.ID, repository.AnyVersion)
+	require.NoError(t, err)
+	assert.Equal(t, "approved", forced.Status)
+	preempted, err := f.Bookings.GetByID(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "rejected", preempted.Status)
+	assert.Equal(t, "Lab", preempted.RoomName)
+
+	_, err = f.Bookings.RejectBooking(ctx, first.ID, repository.AnyVersion)
+	assert.EqualError(t, err, "booking is not pending")
+}
+
+func testFailedWritesAreUndone(t *testing.T, f fixture) {
+	ctx := context.Background()
+	quota := 2.0
+	g, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "Pool", Kind: models.GroupKindPool, Policy: models.Policy{DailyQuotaHours: &quota}})
+	require.NoError(t, err)
+	a := f.room(t, "A", models.RoomTypeShared, 1, &g.ID)
+	b := f.room(t, "B", models.RoomTypeShared, 1, &g.ID)
+
+	// The second member takes the user past the quota, so neither is kept
+	_, err = f.Bookings.CreateBundle(ctx, &models.CreateBundleRequest{
+		UserID: 1, StartTime: at(9), EndTime: at(10).Add(30 * time.Minute),
+		Members: []models.BundleMember{{RoomID: a.ID}, {RoomID: b.ID}},
+	})
+	assert.ErrorIs(t, err, repository.ErrQuotaExceeded)
+	all, err := f.Bookings.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, all)
+	_, err = f.Bookings.GetBundle(ctx, 1)
+	assert.ErrorIs(t, err, repository.ErrBundleNotFound)
+
+	// Reassignment finds no room for the booking, so the room stays as it was
+	future := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	booking, err := f.Bookings.CreateWithTransaction(ctx, &models.CreateBookingRequest{
+		RoomID: a.ID, UserID: 2, StartTime: future, EndTime: future.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	_, err = f.Bookings.CreateWithTransaction(ctx, &models.CreateBookingRequest{
+		RoomID: b.ID, UserID: 1, StartTime: future, EndTime: future.Add(time.Hour),
+	})
+	require.NoError(t, err)
+	_, err = f.Rooms.Archive(ctx, a.ID, models.ArchiveStrategyReassign)
+	assert.ErrorIs(t, err, repository.ErrNoReassignmentTarget)
+	kept, err := f.Rooms.GetByID(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, a.Version, kept.Version)
+	got, err := f.Bookings.GetByID(ctx, booking.ID)
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, got.RoomID)
+	assert.Equal(t, booking.Version, got.Version)
+}
+
+func testListKeysetPages(t *testing.T, f fixture) {
+	ctx := context.Background()
+	room := f.room(t, "Lab", models.RoomTypeShared, 1, nil)
+	for h := 0; h < 5; h++ {
+		_, err := f.book(room.ID, 1, h, h+1, 0)
+		require.NoError(t, err)
+	}
+
+	q := models.BookingListQuery{Sort: "start_time", Desc: true, Limit: 2}
+	var ids []int
+	for {
+		page, err := f.Bookings.List(ctx, q)
+		require.NoError(t, err)
+		assert.Equal(t, 5, page.Total)
+		for _, b := range page.Data {
+			ids = append(ids, b.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, ids)
+
+	q.Desc = false
+	_, err := f.Bookings.List(ctx, q)
+	assert.ErrorIs(t, err, repository.ErrInvalidCursor)
+
+	from := at(2)
+	page, err := f.Bookings.List(ctx, models.BookingListQuery{Sort: "id", Limit: 10, Filter: models.BookingFilter{From: &from}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.Total, "bookings ending by 02:00 are filtered out")
+}
+
+func testGroups(t *testing.T, f fixture) {
+	ctx := context.Background()
+	opens, closes, yes, quota := "08:00", "18:00", true, 4.0
+	site, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "HQ", Kind: models.GroupKindSite,
+		Policy: models.Policy{OpensAt: &opens, ClosesAt: &closes, RequiresApproval: &yes}})
+	require.NoError(t, err)
+	building, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "North", Kind: models.GroupKindBuilding, ParentID: &site.ID})
+	require.NoError(t, err)
+	pool, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "Desks", Kind: models.GroupKindPool, ParentID: &building.ID,
+		Policy: models.Policy{DailyQuotaHours: &quota}})
+	require.NoError(t, err)
+
+	_, err = f.Groups.Create(ctx, &models.ResourceGroup{Name: "HQ", Kind: models.GroupKindSite})
+	assert.ErrorIs(t, err, repository.ErrGroupNameTaken)
+	missing := 99
+	_, err = f.Groups.Create(ctx, &models.ResourceGroup{Name: "Orphan", Kind: models.GroupKindPool, ParentID: &missing})
+	assert.ErrorIs(t, err, repository.ErrParentGroupNotFound)
+
+	require.NotNil(t, pool.Effective.OpensAt)
+	assert.Equal(t, "08:00:00", *pool.Effective.OpensAt, "hours are inherited from the site")
+	assert.Equal(t, true, *pool.Effective.RequiresApproval)
+	assert.Nil(t, pool.Policy.OpensAt)
+
+	room := f.room(t, "Desk 1", models.RoomTypeShared, 1, &pool.ID)
+	assert.Equal(t, "08:00:00", room.OpensAt)
+	assert.Equal(t, "18:00:00", room.ClosesAt)
+	assert.True(t, room.HoursInherited)
+	assert.True(t, room.RequiresApproval)
+	require.NotNil(t, room.DailyQuotaHours)
+	assert.Equal(t, 4.0, *room.DailyQuotaHours)
+
+	got, err := f.Groups.GetByID(ctx, site.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.RoomCount)
+	assert.Equal(t, 4, got.TotalCapacity)
+	assert.Equal(t, 4, got.OnlineCapacity)
+
+	pools, err := f.Groups.List(ctx, models.GroupKindPool)
+	require.NoError(t, err)
+	require.Len(t, pools, 1)
+	assert.Equal(t, "Desks", pools[0].Name)
+
+	// Members of the subtree are found through the site
+	rooms, err := f.Rooms.GetAll(ctx, models.RoomFilter{GroupID: &site.ID})
+	require.NoError(t, err)
+	assert.Len(t, rooms, 1)
+
+	site.Name = "Headquarters"
+	_, err = f.Groups.Update(ctx, site.ID, site.Version+1, site)
+	assert.ErrorIs(t, err, repository.ErrStaleVersion)
+	renamed, err := f.Groups.Update(ctx, site.ID, site.Version, site)
+	require.NoError(t, err)
+	assert.Equal(t, "Headquarters", renamed.Name)
+	assert.Equal(t, site.Version+1, renamed.Version)
+
+	assert.ErrorIs(t, f.Groups.Delete(ctx, building.ID), repository.ErrGroupNotEmpty)
+	assert.ErrorIs(t, f.Groups.Delete(ctx, missing), repository.ErrGroupNotFound)
+}
+
+func testOperatingHours(t *testing.T, f fixture) {
+	ctx := context.Background()
+	opens, closes := "09:00", "17:00"
+	site, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "HQ", Kind: models.GroupKindSite,
+		Policy: models.Policy{OpensAt: &opens, ClosesAt: &closes}})
+	require.NoError(t, err)
+
+	// Hours run on the room's clock: 09:00 to 17:00 in Jakarta is 02:00 to 10:00 UTC
+	open, err := f.Rooms.Create(ctx, &models.Room{Name: "Lab", Capacity: 8, Type: models.RoomTypeShared, Quantity: 1,
+		Status: models.RoomStatusOnline, Timezone: "Asia/Jakarta", HoursInherited: true, GroupID: &site.ID})
+	require.NoError(t, err)
+	_, err = f.book(open.ID, 1, 2, 4, 0)
+	require.NoError(t, err)
+	_, err = f.book(open.ID, 1, 9, 11, 0)
+	assert.ErrorIs(t, err, repository.ErrOutsideOperatingHours, "closes at 10:00 UTC")
+	_, err = f.book(open.ID, 1, 1, 3, 0)
+	assert.ErrorIs(t, err, repository.ErrOutsideOperatingHours, "opens at 02:00 UTC")
+
+	// A room's own hours override its group's; auto selection passes over the
+	// smaller room because it is closed then
+	closed, err := f.Rooms.Create(ctx, &models.Room{Name: "Booth", Capacity: 2, Type: models.RoomTypeShared, Quantity: 1,
+		Status: models.RoomStatusOnline, Timezone: "Asia/Jakarta", OpensAt: "12:00", ClosesAt: "13:00", GroupID: &site.ID})
+	require.NoError(t, err)
+	picked, err := f.Bookings.CreateWithTransaction(ctx, &models.CreateBookingRequest{
+		UserID: 2, GroupID: &site.ID, StartTime: at(6), EndTime: at(7)})
+	require.NoError(t, err)
+	assert.Equal(t, open.ID, picked.RoomID)
+	picked, err = f.Bookings.CreateWithTransaction(ctx, &models.CreateBookingRequest{
+		UserID: 2, GroupID: &site.ID, StartTime: at(5), EndTime: at(6)})
+	require.NoError(t, err)
+	assert.Equal(t, closed.ID, picked.RoomID, "the smallest room open then")
+	_, err = f.Bookings.CreateWithTransaction(ctx, &models.CreateBookingRequest{
+		UserID: 2, GroupID: &site.ID, StartTime: at(12), EndTime: at(13)})
+	assert.ErrorIs(t, err, repository.ErrNoRoomAvailable)
+
+	// One closed member refuses the whole bundle
+	_, err = f.Bookings.CreateBundle(ctx, &models.CreateBundleRequest{UserID: 1, StartTime: at(7), EndTime: at(8),
+		Members: []models.BundleMember{{RoomID: open.ID}, {RoomID: closed.ID}}})
+	assert.ErrorIs(t, err, repository.ErrOutsideOperatingHours)
+
+	all, err := f.Bookings.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 3, "refused requests store nothing")
+
+	// A room that never closes takes bookings across midnight
+	always := f.room(t, "Hall", models.RoomTypeShared, 1, nil)
+	_, err = f.book(always.ID, 1, 20, 30, 0)
+	assert.NoError(t, err)
+}
+
+// testDryRunMatchesLive simulates a calendar import and then books the same
+// requests for real: every request must meet the same fate both times
+func testDryRunMatchesLive(t *testing.T, f fixture) {
+	ctx := context.Background()
+	opens, closes, quota := "09:00", "17:00", 3.0
+	site, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "HQ", Kind: models.GroupKindSite,
+		Policy: models.Policy{OpensAt: &opens, ClosesAt: &closes, DailyQuotaHours: &quota}})
+	require.NoError(t, err)
+	room := f.room(t, "Lab", models.RoomTypeShared, 1, &site.ID)
+
+	calendar := []struct {
+		from, to int
+		status   string // empty when the request is refused with err
+		err      error
+	}{
+		{9, 11, "approved", nil},
+		{10, 11, "rejected", nil},
+		{18, 19, "", repository.ErrOutsideOperatingHours},
+		{12, 14, "", repository.ErrQuotaExceeded},
+		{13, 14, "approved", nil},
+	}
+	reqs := make([]models.CreateBookingRequest, len(calendar))
+	for i, c := range calendar {
+		reqs[i] = models.CreateBookingRequest{RoomID: room.ID, UserID: 1, StartTime: at(c.from), EndTime: at(c.to)}
+	}
+
+	outcomes, err := f.Bookings.SimulateBatch(ctx, reqs)
+	require.NoError(t, err)
+	require.Len(t, outcomes, len(calendar))
+	all, err := f.Bookings.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, all, "a dry run stores nothing")
+
+	for i, c := range calendar {
+		b, err := f.Bookings.CreateWithTransaction(ctx, &reqs[i])
+		if c.err != nil {
+			assert.ErrorIs(t, outcomes[i].Err, c.err, "dry run of %d:00", c.from)
+			assert.ErrorIs(t, err, c.err, "live run of %d:00", c.from)
+			continue
+		}
+		assert.NoError(t, outcomes[i].Err, "dry run of %d:00", c.from)
+		assert.Equal(t, c.status, outcomes[i].Status, "dry run of %d:00", c.from)
+		require.NotNil(t, b, "live run of %d:00", c.from)
+		assert.Equal(t, c.status, b.Status, "live run of %d:00", c.from)
+	}
+}
+
+func testRoomsAndAvailability(t *testing.T, f fixture) {
+	ctx := context.Background()
+	create := func(name, roomType string, capacity, quantity int, set map[string]string) *models.Room {
+		t.Helper()
+		r, err := f.Rooms.Create(ctx, &models.Room{
+			Name: name, Capacity: capacity, Type: roomType, Quantity: quantity, Status: models.RoomStatusOnline,
+			Timezone: "UTC", OpensAt: "09:00", ClosesAt: "17:00", Labels: set,
+		})
+		require.NoError(t, err)
+		return r
+	}
+	small := create("Small", models.RoomTypeShared, 4, 1, map[string]string{"floor": "1", "projector": "yes"})
+	large := create("Large", models.RoomTypeShared, 10, 1, map[string]string{"floor": "2"})
+	pool := create("Laptops", models.RoomTypePooled, 1, 5, nil)
+	assert.Equal(t, "09:00:00", small.OpensAt)
+	assert.False(t, small.HoursInherited)
+
+	_, err := f.Rooms.Create(ctx, &models.Room{Name: "Small", Capacity: 1, Type: models.RoomTypeShared, Quantity: 1,
+		Status: models.RoomStatusOnline, Timezone: "UTC", HoursInherited: true})
+	assert.ErrorIs(t, err, repository.ErrRoomNameTaken)
+
+	for _, c := range []struct {
+		selector string
+		want     []int
+	}{
+		{"floor=1", []int{small.ID}},
+		{"floor!=1", []int{large.ID, pool.ID}},
+		{"floor in (1,2)", []int{small.ID, large.ID}},
+		{"!projector", []int{large.ID, pool.ID}},
+		{"projector", []int{small.ID}},
+	} {
+		sel, err := labels.Parse(c.selector)
+		require.NoError(t, err)
+		rooms, err := f.Rooms.GetAll(ctx, models.RoomFilter{Selector: sel})
+		require.NoError(t, err)
+		var got []int
+		for _, r := range rooms {
+			got = append(got, r.ID)
+		}
+		assert.ElementsMatch(t, c.want, got, c.selector)
+	}
+
+	_, err = f.book(small.ID, 1, 10, 12, 0)
+	require.NoError(t, err)
+	_, err = f.book(pool.ID, 1, 10, 12, 3)
+	require.NoError(t, err)
+	free, err := f.Rooms.FindAvailable(ctx, models.AvailabilityQuery{Start: at(11), End: at(13), Quantity: 2})
+	require.NoError(t, err)
+	require.Len(t, free, 1, "only the pool holds two units")
+	assert.Equal(t, pool.ID, free[0].ID)
+	assert.Equal(t, 2, *free[0].FreeUnits)
+	free, err = f.Rooms.FindAvailable(ctx, models.AvailabilityQuery{Start: at(11), End: at(13), MinCapacity: 2})
+	require.NoError(t, err)
+	require.Len(t, free, 1)
+	assert.Equal(t, large.ID, free[0].ID, "the small room is taken")
+
+	// Auto selection takes the smallest free room that fits
+	auto, err := f.Bookings.CreateWithTransaction(ctx, &models.CreateBookingRequest{
+		UserID: 2, StartTime: at(11), EndTime: at(12), MinCapacity: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, large.ID, auto.RoomID)
+
+	small.Capacity = 6
+	updated, err := f.Rooms.Update(ctx, small.ID, small.Version, small)
+	require.NoError(t, err)
+	assert.Equal(t, 6, updated.Capacity)
+	assert.Equal(t, small.Version+1, updated.Version)
+	_, err = f.Rooms.Update(ctx, small.ID, small.Version, small)
+	assert.ErrorIs(t, err, repository.ErrStaleVersion)
+
+	_, err = f.Rooms.Archive(ctx, small.ID, "")
+	assert.ErrorIs(t, err, repository.ErrRoomHasUpcomingBookings)
+	result, err := f.Rooms.Archive(ctx, small.ID, models.ArchiveStrategyReject)
+	require.NoError(t, err)
+	assert.Len(t, result.Rejected, 1)
+	_, err = f.Rooms.GetByID(ctx, small.ID)
+	assert.ErrorIs(t, err, repository.ErrRoomNotFound)
+	_, err = f.Rooms.Create(ctx, &models.Room{Name: "Small", Capacity: 1, Type: models.RoomTypeShared, Quantity: 1,
+		Status: models.RoomStatusOnline, Timezone: "UTC", HoursInherited: true})
+	assert.NoError(t, err, "archiving releases the name")
+
+	spare := create("Spare", models.RoomTypeShared, 12, 1, nil)
+	result, err = f.Rooms.Archive(ctx, large.ID, models.ArchiveStrategyReassign)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Reassignment{{BookingID: auto.ID, RoomID: spare.ID}}, result.Reassigned)
+}
+
+func testReports(t *testing.T, f fixture) {
+	ctx := context.Background()
+	room, err := f.Rooms.Create(ctx, &models.Room{
+		Name: "Lab", Capacity: 4, Type: models.RoomTypeShared, Quantity: 1, Status: models.RoomStatusOnline,
+		Timezone: "UTC", OpensAt: "09:00", ClosesAt: "17:00",
+	})
+	require.NoError(t, err)
+	_, err = f.book(room.ID, 1, 9, 11, 0)
+	require.NoError(t, err)
+	_, err = f.book(room.ID, 2, 10, 12, 0)
+	require.ErrorIs(t, err, repository.ErrBookingConflict)
+	_, err = f.book(room.ID, 2, 20, 22, 0)
+	require.ErrorIs(t, err, repository.ErrOutsideOperatingHours)
+	_, err = f.book(room.ID, 2, 13, 15, 0)
+	require.NoError(t, err)
+
+	buckets, err := f.Bookings.GetUtilization(ctx, models.UtilizationQuery{From: day, To: day.AddDate(0, 0, 1), Granularity: "day"})
+	require.NoError(t, err)
+	require.Len(t, buckets, 1)
+	b := buckets[0]
+	assert.True(t, b.BucketStart.Equal(day))
+	assert.InDelta(t, 8, b.AvailableHours, 1e-9)
+	assert.InDelta(t, 4, b.BookedHours, 1e-9)
+	assert.InDelta(t, 50, b.Utilization, 1e-9)
+	assert.Equal(t, 3, b.Requests)
+	assert.Equal(t, 1, b.Conflicts)
+	assert.Equal(t, 1, b.PeakConcurrency)
+
+	hours, err := f.Bookings.GetUtilization(ctx, models.UtilizationQuery{From: at(8), To: at(12), Granularity: "hour"})
+	require.NoError(t, err)
+	assert.Len(t, hours, 4)
+	_, err = f.Bookings.GetUtilization(ctx, models.UtilizationQuery{From: day, To: at(1), Granularity: "month"})
+	assert.Error(t, err)
+
+	usage, err := f.Bookings.GetMonthlyUsage(ctx, day, day.AddDate(0, 1, 0))
+	require.NoError(t, err)
+	require.Len(t, usage, 1)
+	assert.Equal(t, 2, usage[0].TotalBookings)
+	assert.InDelta(t, 4, usage[0].TotalHours, 1e-9)
+}
+
+func testIdempotency(t *testing.T, f fixture) {
+	repo := f.Idempotency
+	ctx := context.Background()
+
+	held, err := repo.Reserve(ctx, "POST /bookings", "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, held, "the first caller owns the key")
+
+	held, err = repo.Reserve(ctx, "POST /bookings", "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, held)
+	assert.Zero(t, held.StatusCode, "still in flight")
+
+	require.NoError(t, repo.Complete(ctx, "POST /bookings", "k1", &models.IdempotencyRecord{
+		Fingerprint: "fp", StatusCode: 201, ContentType: "application/json", Body: []byte(`{}`),
+	}, time.Hour))
+	held, err = repo.Reserve(ctx, "POST /bookings", "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 201, held.StatusCode)
+	assert.Equal(t, []byte(`{}`), held.Body)
+
+	// Expired leases are taken over and purged
+	_, err = repo.Reserve(ctx, "POST /bundles", "k2", "fp", -time.Second)
+	require.NoError(t, err)
+	held, err = repo.Reserve(ctx, "POST /bundles", "k2", "other", -time.Second)
+	require.NoError(t, err)
+	assert.Nil(t, held)
+	purged, err := repo.PurgeExpired(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, purged)
+}
@@ -0,0 +1,160 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+// racers is how many goroutines the concurrency cases release at once
+const racers = 300
+
+// race runs fn for every i below n on its own goroutine, all released together
+func race(n int, fn func(i int)) {
+	var ready, done sync.WaitGroup
+	start := make(chan struct{})
+	ready.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer done.Done()
+			ready.Done()
+			<-start
+			fn(i)
+		}(i)
+	}
+	ready.Wait()
+	close(start)
+	done.Wait()
+}
+
+func testConcurrentAllocations(t *testing.T, f fixture) {
+	ctx := context.Background()
+	rooms := []*models.Room{
+		f.room(t, "A", models.RoomTypeShared, 1, nil),
+		f.room(t, "B", models.RoomTypeShared, 1, nil),
+		f.room(t, "GPUs", models.RoomTypePooled, 3, nil),
+	}
+
+	// Short windows over half a day, so most requests collide with several others
+	rng := rand.New(rand.NewSource(47))
+	reqs := make([]*models.CreateBookingRequest, racers)
+	for i := range reqs {
+		room := rooms[rng.Intn(len(rooms))]
+		from := rng.Intn(12)
+		reqs[i] = &models.CreateBookingRequest{
+			RoomID: room.ID, UserID: 1 + i%2, StartTime: at(from), EndTime: at(from + 1 + rng.Intn(3)),
+			Quantity: 1 + rng.Intn(room.Quantity),
+		}
+	}
+
+	statuses := make([]string, racers)
+	race(racers, func(i int) {
+		b, err := f.Bookings.CreateWithTransaction(ctx, reqs[i])
+		if err != nil && !errors.Is(err, repository.ErrBookingConflict) {
+			t.Errorf("request %d: %v", i, err)
+			return
+		}
+		statuses[i] = b.Status
+	})
+
+	approved := 0
+	for _, s := range statuses {
+		if s == "approved" {
+			approved++
+		}
+	}
+	assert.NotZero(t, approved)
+	assert.Less(t, approved, racers, "the requests were meant to collide")
+
+	all := assertNoOverbooking(t, f, rooms)
+	assert.Len(t, all, racers, "every request is stored, approved or rejected")
+}
+
+func testConcurrentApprovals(t *testing.T, f fixture) {
+	ctx := context.Background()
+	yes := true
+	g, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "Reviewed", Kind: models.GroupKindSite, Policy: models.Policy{RequiresApproval: &yes}})
+	require.NoError(t, err)
+	rooms := []*models.Room{
+		f.room(t, "A", models.RoomTypeShared, 1, &g.ID),
+		f.room(t, "GPUs", models.RoomTypePooled, 2, &g.ID),
+	}
+
+	// Pending bookings hold nothing, so all of them are accepted and then
+	// fought over by approvals and preemptions at once
+	rng := rand.New(rand.NewSource(48))
+	pending := make([]*models.Booking, racers/3)
+	for i := range pending {
+		room := rooms[rng.Intn(len(rooms))]
+		from := rng.Intn(8)
+		b, err := f.book(room.ID, 1+i%2, from, from+1+rng.Intn(2), 1+rng.Intn(room.Quantity))
+		require.NoError(t, err)
+		require.Equal(t, "pending", b.Status)
+		pending[i] = b
+	}
+
+	race(len(pending), func(i int) {
+		var err error
+		if i%4 == 0 {
+			_, err = f.Bookings.PreemptBooking(ctx, pending[i].ID, repository.AnyVersion)
+		} else {
+			_, err = f.Bookings.ApproveBooking(ctx, pending[i].ID, repository.AnyVersion)
+		}
+		if err != nil && !errors.Is(err, repository.ErrApprovalConflict) {
+			t.Errorf("booking %d: %v", pending[i].ID, err)
+		}
+	})
+
+	assertNoOverbooking(t, f, rooms)
+}
+
+// assertNoOverbooking fails t when the approved bookings of any room hold more
+// units than it has at some instant, and returns every booking
+func assertNoOverbooking(t *testing.T, f fixture, rooms []*models.Room) []models.Booking {
+	t.Helper()
+	all, err := f.Bookings.GetAll(context.Background())
+	require.NoError(t, err)
+	for _, v := range overbookings(all, rooms) {
+		t.Error(v)
+	}
+	return all
+}
+
+// overbookings describes every instant at which the approved bookings among
+// all hold more units of one of rooms than it has
+func overbookings(all []models.Booking, rooms []*models.Room) []string {
+	var found []string
+	for _, room := range rooms {
+		var held []models.Booking
+		for _, b := range all {
+			if b.RoomID == room.ID && b.Status == "approved" {
+				held = append(held, b)
+			}
+		}
+		// Only the starts need checking, see peak_usage in migration 014
+		for _, b := range held {
+			units := 0
+			for _, o := range held {
+				if !o.StartTime.After(b.StartTime) && o.EndTime.After(b.StartTime) {
+					units += o.Quantity
+				}
+			}
+			if units > room.Quantity {
+				found = append(found, fmt.Sprintf("room %s holds %d of %d units at %s",
+					room.Name, units, room.Quantity, b.StartTime.Format(time.RFC3339)))
+			}
+		}
+	}
+	return found
+}
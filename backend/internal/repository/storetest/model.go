@@ -0,0 +1,377 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+// The model check drives a backend with random sequences of creates,
+// approvals, rejections and forced allocations and replays each against a
+// reference model that keeps every booking in a slice and decides by brute
+// force. After every step the backend has to agree with the model on the
+// outcome and on the status and version of every booking, and no room may hold
+// more approved units than it has at any instant.
+//
+// Cancelling is out of scope: the engine has no cancel operation, so no
+// sequence contains one. Once it has, it belongs in the op set, since freeing
+// units is as much a way to get the peak usage wrong as taking them.
+//
+// A failing sequence is shrunk to a minimal one before it is reported, with
+// the seed that regenerates it. By default the seeds are 1 to STORETEST_RUNS,
+// so every run of the suite checks the same sequences; raise STORETEST_RUNS to
+// explore further, and set STORETEST_SEED to replay a single seed.
+
+// modelRooms are the rooms every sequence runs on: exclusive and pooled, each
+// with and without approval
+var modelRooms = []struct {
+	name     string
+	roomType string
+	quantity int
+	approval bool
+}{
+	{"A", models.RoomTypeShared, 1, false},
+	{"B", models.RoomTypeShared, 1, true},
+	{"P", models.RoomTypePooled, 3, false},
+	{"Q", models.RoomTypePooled, 2, true},
+}
+
+type opKind int
+
+const (
+	opCreate opKind = iota
+	opApprove
+	opReject
+	opForce
+)
+
+// op is one step of a sequence. Bookings are named by creation order modulo
+// the number created so far, so a sequence stays meaningful when shrinking
+// drops some of its steps.
+type op struct {
+	kind     opKind
+	room     int // index into modelRooms
+	user     int
+	from, to int // hours of day
+	units    int
+	booking  int
+	stale    bool // pass a version the booking has not reached
+}
+
+func (o op) String() string {
+	switch o.kind {
+	case opCreate:
+		return fmt.Sprintf("create(room %s, user %d, %02d:00-%02d:00, %d units)",
+			modelRooms[o.room].name, o.user, o.from, o.to, o.units)
+	case opApprove:
+		return fmt.Sprintf("approve(#%d, stale=%t)", o.booking, o.stale)
+	case opReject:
+		return fmt.Sprintf("reject(#%d, stale=%t)", o.booking, o.stale)
+	}
+	return fmt.Sprintf("force(#%d, stale=%t)", o.booking, o.stale)
+}
+
+// generate draws a sequence of up to maxOps steps. Windows are short and
+// confined to the morning, so most bookings collide with others.
+func generate(rng *rand.Rand, maxOps int) []op {
+	ops := make([]op, 1+rng.Intn(maxOps))
+	for i := range ops {
+		o := op{booking: rng.Intn(maxOps), stale: rng.Intn(10) == 0}
+		switch n := rng.Intn(10); {
+		case n < 5 || i == 0:
+			o.kind = opCreate
+			o.room = rng.Intn(len(modelRooms))
+			o.user = 1 + rng.Intn(2)
+			o.from = rng.Intn(8)
+			o.to = o.from + 1 + rng.Intn(3)
+			// Sometimes one unit more than the room holds
+			o.units = 1 + rng.Intn(modelRooms[o.room].quantity+1)
+		case n < 7:
+			o.kind = opApprove
+		case n < 8:
+			o.kind = opReject
+		default:
+			o.kind = opForce
+		}
+		ops[i] = o
+	}
+	return ops
+}
+
+// modelBooking is the model's view of a booking
+type modelBooking struct {
+	id       int // the backend's id, learnt when it was created
+	room     int
+	from, to int
+	units    int
+	status   string
+	version  int
+}
+
+type model struct {
+	bookings []*modelBooking // in creation order
+}
+
+// held is the peak number of units b's room has approved during b's window,
+// not counting b itself
+func (m *model) held(b *modelBooking) int {
+	peak := 0
+	for h := b.from; h < b.to; h++ {
+		units := 0
+		for _, o := range m.bookings {
+			if o != b && o.room == b.room && o.status == "approved" && o.from <= h && h < o.to {
+				units += o.units
+			}
+		}
+		if units > peak {
+			peak = units
+		}
+	}
+	return peak
+}
+
+func (m *model) fits(b *modelBooking) bool {
+	return m.held(b)+b.units <= modelRooms[b.room].quantity
+}
+
+func (m *model) set(b *modelBooking, status string) {
+	b.status = status
+	b.version++
+}
+
+// outcome is what a step returned, reduced to what the model can predict
+type outcome struct {
+	status string
+	err    string
+}
+
+// errorClass names an error by the sentinel it wraps, or by its message
+func errorClass(err error) string {
+	for _, sentinel := range []error{
+		repository.ErrBookingConflict, repository.ErrInsufficientUnits, repository.ErrStaleVersion,
+		repository.ErrBookingNotFound, repository.ErrRoomNotFound,
+		repository.ErrBookingNotPending, repository.ErrApprovalConflict,
+	} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return err.Error()
+}
+
+// apply runs o against the model. The returned booking is new for a create and
+// nil when o refers to no booking.
+func (m *model) apply(o op) (outcome, *modelBooking) {
+	if o.kind == opCreate {
+		b := &modelBooking{room: o.room, from: o.from, to: o.to, units: o.units, version: 1}
+		switch {
+		case o.units > modelRooms[o.room].quantity:
+			return outcome{err: repository.ErrInsufficientUnits.Error()}, nil
+		case !m.fits(b):
+			b.status = "rejected"
+			m.bookings = append(m.bookings, b)
+			return outcome{status: b.status, err: repository.ErrBookingConflict.Error()}, b
+		case modelRooms[o.room].approval:
+			b.status = "pending"
+		default:
+			b.status = "approved"
+		}
+		m.bookings = append(m.bookings, b)
+		return outcome{status: b.status}, b
+	}
+
+	if len(m.bookings) == 0 {
+		return outcome{}, nil
+	}
+	b := m.bookings[o.booking%len(m.bookings)]
+	if o.stale {
+		return outcome{err: repository.ErrStaleVersion.Error()}, b
+	}
+	switch o.kind {
+	case opApprove, opReject:
+		if b.status != "pending" {
+			return outcome{err: repository.ErrBookingNotPending.Error()}, b
+		}
+		if o.kind == opReject {
+			m.set(b, "rejected")
+		} else if !m.fits(b) {
+			return outcome{err: repository.ErrApprovalConflict.Error()}, b
+		} else {
+			m.set(b, "approved")
+		}
+	case opForce:
+		// Approved bookings overlapping b go newest first until it fits
+		for b.status != "approved" && !m.fits(b) {
+			victim := -1
+			for i := len(m.bookings) - 1; i >= 0 && victim < 0; i-- {
+				v := m.bookings[i]
+				if v != b && v.room == b.room && v.status == "approved" && v.from < b.to && b.from < v.to {
+					victim = i
+				}
+			}
+			if victim < 0 {
+				return outcome{err: repository.ErrInsufficientUnits.Error()}, b
+			}
+			m.set(m.bookings[victim], "rejected")
+		}
+		m.set(b, "approved")
+	}
+	return outcome{status: b.status}, b
+}
+
+// replay runs ops against a fresh backend and the model side by side and
+// describes the first disagreement, or returns "" when there is none
+func replay(t *testing.T, open func(t *testing.T) Stores, ops []op) string {
+	ctx := context.Background()
+	f := fixture{Stores: open(t)}
+	yes := true
+	g, err := f.Groups.Create(ctx, &models.ResourceGroup{Name: "Reviewed", Kind: models.GroupKindSite, Policy: models.Policy{RequiresApproval: &yes}})
+	require.NoError(t, err)
+	rooms := make([]*models.Room, len(modelRooms))
+	for i, r := range modelRooms {
+		var groupID *int
+		if r.approval {
+			groupID = &g.ID
+		}
+		rooms[i] = f.room(t, r.name, r.roomType, r.quantity, groupID)
+	}
+
+	m := &model{}
+	for step, o := range ops {
+		// The version the booking has before the step, which the model moves on
+		version := 0
+		if o.kind != opCreate && len(m.bookings) > 0 {
+			version = m.bookings[o.booking%len(m.bookings)].version
+		}
+		want, b := m.apply(o)
+		if o.kind != opCreate && b == nil {
+			continue
+		}
+
+		var got *models.Booking
+		switch o.kind {
+		case opCreate:
+			got, err = f.book(rooms[o.room].ID, o.user, o.from, o.to, o.units)
+			if b != nil && got != nil {
+				b.id = got.ID
+			}
+		default:
+			if o.stale {
+				version++ // not back: zero is AnyVersion
+			}
+			switch o.kind {
+			case opApprove:
+				got, err = f.Bookings.ApproveBooking(ctx, b.id, version)
+			case opReject:
+				got, err = f.Bookings.RejectBooking(ctx, b.id, version)
+			case opForce:
+				got, err = f.Bookings.PreemptBooking(ctx, b.id, version)
+			}
+		}
+		have := outcome{}
+		if got != nil {
+			have.status = got.Status
+		}
+		if err != nil {
+			have.err = errorClass(err)
+			if o.kind != opCreate {
+				have.status = "" // failed transitions return no booking
+			}
+		}
+		if have != want {
+			return fmt.Sprintf("step %d %s: got %+v, the model expects %+v", step, o, have, want)
+		}
+
+		all, err := f.Bookings.GetAll(ctx)
+		require.NoError(t, err)
+		byID := make(map[int]models.Booking, len(all))
+		for _, x := range all {
+			byID[x.ID] = x
+		}
+		if len(byID) != len(m.bookings) {
+			return fmt.Sprintf("step %d %s: the store holds %d bookings, the model %d", step, o, len(byID), len(m.bookings))
+		}
+		for i, mb := range m.bookings {
+			x := byID[mb.id]
+			if x.Status != mb.status || x.Version != mb.version {
+				return fmt.Sprintf("step %d %s: booking #%d is %s v%d, the model has %s v%d",
+					step, o, i, x.Status, x.Version, mb.status, mb.version)
+			}
+		}
+		if found := overbookings(all, rooms); len(found) > 0 {
+			return fmt.Sprintf("step %d %s: %s", step, o, found[0])
+		}
+	}
+	return ""
+}
+
+// shrink drops ever smaller runs of steps from a failing sequence for as long
+// as what remains still fails, and returns the shortest failure found
+func shrink(ops []op, fails func([]op) string) ([]op, string) {
+	msg := fails(ops)
+	for size := len(ops) / 2; size >= 1; size /= 2 {
+		for start := 0; start+size <= len(ops); {
+			candidate := append(append([]op{}, ops[:start]...), ops[start+size:]...)
+			if m := fails(candidate); m != "" {
+				ops, msg = candidate, m
+				continue // the same start now names the next run
+			}
+			start += size
+		}
+	}
+	return ops, msg
+}
+
+// envInt reads an integer from the environment, or returns def when unset
+func envInt(t *testing.T, name string, def int64) int64 {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	require.NoError(t, err, name)
+	return n
+}
+
+// maxModelOps bounds the length of a generated sequence
+const maxModelOps = 40
+
+// Model checks the backend open returns against the reference model over
+// random sequences. open is called for every replay, shrinking included, and
+// has the same contract as for Run.
+func Model(t *testing.T, open func(t *testing.T) Stores) {
+	runs := envInt(t, "STORETEST_RUNS", 50)
+	if testing.Short() {
+		runs = 10
+	}
+	first := int64(1)
+	if os.Getenv("STORETEST_SEED") != "" {
+		first, runs = envInt(t, "STORETEST_SEED", 0), 1
+	}
+
+	for seed := first; seed < first+runs; seed++ {
+		ops := generate(rand.New(rand.NewSource(seed)), maxModelOps)
+		fails := func(ops []op) string { return replay(t, open, ops) }
+		if fails(ops) == "" {
+			continue
+		}
+
+		shrunk, msg := shrink(ops, fails)
+		steps := make([]string, len(shrunk))
+		for i, o := range shrunk {
+			steps[i] = fmt.Sprintf("  %d. %s", i, o)
+		}
+		t.Fatalf("seed %d fails after %d steps, shrunk to %d:\n%s\n%s\nreplay with STORETEST_SEED=%d",
+			seed, len(ops), len(shrunk), strings.Join(steps, "\n"), msg, seed)
+	}
+}
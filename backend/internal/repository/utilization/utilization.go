@@ -0,0 +1,206 @@
+// Package utilization computes the utilization report in Go for the storage
+// backends that cannot do it in SQL. It follows the Postgres query: buckets
+// are cut on the report zone's wall clock, operating hours are read on each
+// room's own, and bookings are clipped to the bucket, the operating window and
+// the requested range before summing.
+package utilization
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository/internal/alloc"
+)
+
+// Room is a room in the scope of a report
+type Room struct {
+	ID       int
+	Online   bool
+	Location *time.Location
+	OpensAt  string // effective operating hours, "HH:MM:SS"
+	ClosesAt string
+}
+
+// Booking is a booking of a room in scope, in any status
+type Booking struct {
+	RoomID     int
+	Status     string
+	Start, End time.Time
+}
+
+// Range is the span the buckets of q cover. Compute needs every booking of the
+// rooms in scope that overlaps it.
+func Range(q models.UtilizationQuery) (from, to time.Time, err error) {
+	switch q.Granularity {
+	case "hour", "day", "week":
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported granularity: %s", q.Granularity)
+	}
+	loc := zone(q)
+	first := truncate(wallClock(q.From, loc), q.Granularity)
+	last := truncate(wallClock(q.To.Add(-time.Microsecond), loc), q.Granularity)
+	return atZone(first, loc), atZone(step(last, q.Granularity), loc), nil
+}
+
+// Compute returns the buckets of q over rooms, which are the rooms in scope
+func Compute(q models.UtilizationQuery, rooms []Room, bookings []Booking) ([]models.UtilizationBucket, error) {
+	if _, _, err := Range(q); err != nil {
+		return nil, err
+	}
+	loc := zone(q)
+	from, to := instant(q.From), instant(q.To)
+
+	scoped := map[int]bool{}
+	var windows []window
+	for _, rm := range rooms {
+		scoped[rm.ID] = true
+		if !rm.Online {
+			continue
+		}
+		opens, closes := alloc.Offset(rm.OpensAt), alloc.Offset(rm.ClosesAt)
+		last := wallClock(to, rm.Location)
+		for d := truncate(wallClock(from, rm.Location), "day"); !d.After(last); d = d.AddDate(0, 0, 1) {
+			start, end := atZone(d.Add(opens), rm.Location), atZone(d.Add(closes), rm.Location)
+			if end.After(from) && start.Before(to) {
+				windows = append(windows, window{roomID: rm.ID, start: latest(start, from), end: earliest(end, to)})
+			}
+		}
+	}
+
+	byRoom := map[int][]Booking{}
+	var approved []Booking
+	for _, b := range bookings {
+		if b.Status == "approved" && scoped[b.RoomID] {
+			approved = append(approved, b)
+			byRoom[b.RoomID] = append(byRoom[b.RoomID], b)
+		}
+	}
+
+	var buckets []models.UtilizationBucket
+	last := wallClock(to, loc).Add(-time.Microsecond)
+	for w := truncate(wallClock(from, loc), q.Granularity); !w.After(last); w = step(w, q.Granularity) {
+		bucket := models.UtilizationBucket{BucketStart: atZone(w, loc), BucketEnd: atZone(step(w, q.Granularity), loc)}
+
+		for _, win := range windows {
+			start, end := latest(bucket.BucketStart, win.start), earliest(bucket.BucketEnd, win.end)
+			if !start.Before(end) {
+				continue
+			}
+			bucket.AvailableHours += end.Sub(start).Hours()
+			for _, b := range byRoom[win.roomID] {
+				if b.Start.Before(end) && b.End.After(start) {
+					bucket.BookedHours += earliest(end, b.End).Sub(latest(start, b.Start)).Hours()
+				}
+			}
+		}
+
+		for _, b := range bookings {
+			if scoped[b.RoomID] && !b.Start.Before(bucket.BucketStart) && b.Start.Before(bucket.BucketEnd) {
+				bucket.Requests++
+				if b.Status == "rejected" {
+					bucket.Conflicts++
+				}
+			}
+		}
+
+		// Sampled at booking starts, as in the peak_usage function of migration 014
+		for _, s := range approved {
+			if !s.Start.Before(bucket.BucketEnd) || !s.End.After(bucket.BucketStart) {
+				continue
+			}
+			at := latest(s.Start, bucket.BucketStart)
+			active := 0
+			for _, o := range approved {
+				if !o.Start.After(at) && o.End.After(at) {
+					active++
+				}
+			}
+			if active > bucket.PeakConcurrency {
+				bucket.PeakConcurrency = active
+			}
+		}
+
+		if bucket.AvailableHours > 0 {
+			bucket.Utilization = bucket.BookedHours / bucket.AvailableHours * 100
+		}
+		if bucket.Requests > 0 {
+			bucket.ConflictRate = float64(bucket.Conflicts) / float64(bucket.Requests) * 100
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func zone(q models.UtilizationQuery) *time.Location {
+	if q.Location == nil {
+		return time.UTC
+	}
+	return q.Location
+}
+
+// instant is t as a TIMESTAMPTZ column stores it: in UTC, to the microsecond
+func instant(t time.Time) time.Time {
+	return t.UTC().Round(time.Microsecond)
+}
+
+// wallClock is the wall clock reading of t in loc, as a TIMESTAMP without
+// zone: a time in UTC whose fields are those of the local time
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	l := t.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+// atZone is the instant a wall clock reading denotes in loc
+func atZone(w time.Time, loc *time.Location) time.Time {
+	return time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), w.Nanosecond(), loc).UTC()
+}
+
+// truncate is date_trunc on a wall clock reading; weeks start on Monday
+func truncate(w time.Time, granularity string) time.Time {
+	day := time.Date(w.Year(), w.Month(), w.Day(), 0, 0, 0, 0, time.UTC)
+	switch granularity {
+	case "hour":
+		return w.Truncate(time.Hour)
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// step advances a wall clock reading by one bucket
+func step(w time.Time, granularity string) time.Time {
+	switch granularity {
+	case "hour":
+		return w.Add(time.Hour)
+	case "week":
+		return w.AddDate(0, 0, 7)
+	}
+	return w.AddDate(0, 0, 1)
+}
+
+// window is one day of a room's operating hours, clipped to the report range
+type window struct {
+	roomID     int
+	start, end time.Time
+}
+
+func latest(ts ...time.Time) time.Time {
+	out := ts[0]
+	for _, t := range ts[1:] {
+		if t.After(out) {
+			out = t
+		}
+	}
+	return out
+}
+
+func earliest(ts ...time.Time) time.Time {
+	out := ts[0]
+	for _, t := range ts[1:] {
+		if t.Before(out) {
+			out = t
+		}
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/indraprhmbd/allocra/internal/metrics"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+// CreateBundle allocates every member of req over one window, all or nothing.
+// A conflict on any member returns the rejected bundle with ErrBookingConflict.
+func (s *BookingService) CreateBundle(ctx context.Context, req *models.CreateBundleRequest) (*models.Bundle, error) {
+    ctx, span := tracer.Start(ctx, "BookingService.CreateBundle", trace.WithAttributes(
+        attribute.Int("allocra.user_id", req.UserID),
+        attribute.Int("allocra.bundle_size", len(req.Members)),
+    ))
+    defer span.End()
+    
+    if err := validateBundle(req, time.Now()); err != nil {
+        metrics.AllocationAttempts.WithLabelValues(metrics.OutcomeInvalid).Inc()
+        return nil, err
+    }
+    
+    bundle, err := s.bookingRepo.CreateBundle(ctx, req)
+    outcome := metrics.OutcomeApproved
+    switch {
+    case errors.Is(err, repository.ErrBookingConflict), errors.Is(err, repository.ErrNoRoomAvailable):
+        outcome = metrics.OutcomeConflict
+    case errors.Is(err, repository.ErrQuotaExceeded):
+        outcome = metrics.OutcomeQuota
+    case errors.Is(err, repository.ErrOutsideOperatingHours):
+        outcome = metrics.OutcomeInvalid
+    case err == nil && bundle.Status == "pending":
+        outcome = metrics.OutcomePending
+    case err != nil:
+        outcome = metrics.OutcomeError
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    metrics.AllocationAttempts.WithLabelValues(outcome).Inc()
+    span.SetAttributes(attribute.String("allocra.outcome", outcome))
+    return bundle, err
+}
+
+func (s *BookingService) GetBundle(ctx context.Context, id int) (*models.Bundle, error) {
+    return s.bookingRepo.GetBundle(ctx, id)
+}
+
+func (s *BookingService) ApproveBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+    ctx, span := tracer.Start(ctx, "BookingService.ApproveBundle", trace.WithAttributes(attribute.Int("allocra.bundle_id", id)))
+    bundle, err := s.bookingRepo.ApproveBundle(ctx, id, version)
+    endSpan(span, err)
+    return bundle, err
+}
+
+func (s *BookingService) RejectBundle(ctx context.Context, id, version int) (*models.Bundle, error) {
+    ctx, span := tracer.Start(ctx, "BookingService.RejectBundle", trace.WithAttributes(attribute.Int("allocra.bundle_id", id)))
+    bundle, err := s.bookingRepo.RejectBundle(ctx, id, version)
+    endSpan(span, err)
+    return bundle, err
+}
@@ -2,62 +2,275 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
+	"github.com/indraprhmbd/allocra/internal/metrics"
 	"github.com/indraprhmbd/allocra/internal/models"
 	"github.com/indraprhmbd/allocra/internal/repository"
 )
 
+var tracer = otel.Tracer("github.com/indraprhmbd/allocra/internal/services")
+
 type BookingService struct {
-    bookingRepo *repository.BookingRepository
+    bookingRepo BookingStore
 }
 
-func NewBookingService(bookingRepo *repository.BookingRepository) *BookingService {
+func NewBookingService(bookingRepo BookingStore) *BookingService {
     return &BookingService{bookingRepo: bookingRepo}
 }
 
 func (s *BookingService) CreateBooking(ctx context.Context, req *models.CreateBookingRequest) (*models.Booking, error) {
+    ctx, span := tracer.Start(ctx, "BookingService.CreateBooking", trace.WithAttributes(
+        attribute.Int("allocra.room_id", req.RoomID),
+        attribute.Int("allocra.user_id", req.UserID),
+    ))
+    defer span.End()
+    
     if req.StartTime.After(req.EndTime) || req.StartTime.Equal(req.EndTime) {
+        metrics.AllocationAttempts.WithLabelValues(metrics.OutcomeInvalid).Inc()
         return nil, fmt.Errorf("invalid time range: start must be before end")
     }
     
     // Allow a 2-minute grace period for "immediate" bookings to account for clock drift
     if req.StartTime.Before(time.Now().Add(-2 * time.Minute)) {
+        metrics.AllocationAttempts.WithLabelValues(metrics.OutcomeInvalid).Inc()
         return nil, fmt.Errorf("cannot book in the past (beyond 2min grace period)")
     }
     
-    return s.bookingRepo.CreateWithTransaction(ctx, req)
+    if req.Quantity < 0 {
+        verr := &ValidationError{}
+        verr.Add("quantity", "must not be negative")
+        metrics.AllocationAttempts.WithLabelValues(metrics.OutcomeInvalid).Inc()
+        return nil, verr
+    }
+    
+    if req.RoomID == 0 {
+        verr := &ValidationError{}
+        sel, err := labels.Parse(req.RoomSelector)
+        switch {
+        case err != nil:
+            verr.Add("room_selector", err.Error())
+        case req.RoomSelector == "" && req.MinCapacity == 0 && req.GroupID == nil:
+            verr.Add("room_id", "is required unless room_selector, min_capacity or group_id is given")
+        case req.MinCapacity < 0:
+            verr.Add("min_capacity", "must not be negative")
+        }
+        if err := verr.Err(); err != nil {
+            metrics.AllocationAttempts.WithLabelValues(metrics.OutcomeInvalid).Inc()
+            return nil, err
+        }
+        req.Selector = sel
+    }
+    
+    booking, err := s.bookingRepo.CreateWithTransaction(ctx, req)
+    outcome := metrics.OutcomeApproved
+    switch {
+    case errors.Is(err, repository.ErrBookingConflict), errors.Is(err, repository.ErrNoRoomAvailable):
+        outcome = metrics.OutcomeConflict
+    case errors.Is(err, repository.ErrQuotaExceeded):
+        outcome = metrics.OutcomeQuota
+    case errors.Is(err, repository.ErrOutsideOperatingHours):
+        outcome = metrics.OutcomeInvalid
+    case err == nil && booking.Status == "pending":
+        outcome = metrics.OutcomePending
+    case err != nil:
+        outcome = metrics.OutcomeError
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    metrics.AllocationAttempts.WithLabelValues(outcome).Inc()
+    span.SetAttributes(attribute.String("allocra.outcome", outcome))
+    if booking != nil && req.RoomID == 0 {
+        span.SetAttributes(attribute.Int("allocra.picked_room_id", booking.RoomID))
+    }
+    return booking, err
+}
+
+func (s *BookingService) ApproveBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    ctx, span := startBookingSpan(ctx, "BookingService.ApproveBooking", bookingID)
+    booking, err := s.bookingRepo.ApproveBooking(ctx, bookingID, version)
+    endSpan(span, err)
+    return booking, err
 }
 
-func (s *BookingService) ApproveBooking(ctx context.Context, bookingID int) error {
-    return s.bookingRepo.ApproveBooking(ctx, bookingID)
+func (s *BookingService) RejectBooking(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    ctx, span := startBookingSpan(ctx, "BookingService.RejectBooking", bookingID)
+    booking, err := s.bookingRepo.RejectBooking(ctx, bookingID, version)
+    endSpan(span, err)
+    return booking, err
 }
 
-func (s *BookingService) RejectBooking(ctx context.Context, bookingID int) error {
-    return s.bookingRepo.RejectBooking(ctx, bookingID)
+func (s *BookingService) GetBooking(ctx context.Context, bookingID int) (*models.Booking, error) {
+    return s.bookingRepo.GetByID(ctx, bookingID)
 }
 
 func (s *BookingService) GetAllBookings(ctx context.Context) ([]models.Booking, error) {
     return s.bookingRepo.GetAll(ctx)
 }
 
-func (s *BookingService) GetBookingsByRoom(ctx context.Context, roomID int) ([]models.Booking, error) {
-    return s.bookingRepo.GetByRoomID(ctx, roomID)
+const (
+    defaultPageSize = 50
+    maxPageSize     = 500
+)
+
+// ErrInvalidListQuery wraps listing parameter errors that callers should see as 400s
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+func (s *BookingService) ListBookings(ctx context.Context, q models.BookingListQuery) (*models.BookingPage, error) {
+    switch {
+    case q.Limit == 0:
+        q.Limit = defaultPageSize
+    case q.Limit < 0 || q.Limit > maxPageSize:
+        return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListQuery, maxPageSize)
+    }
+    if q.Sort == "" {
+        q.Sort = "start_time"
+    }
+    if !repository.IsBookingSortKey(q.Sort) {
+        return nil, fmt.Errorf("%w: sort must be start_time, created_at or id", ErrInvalidListQuery)
+    }
+    switch q.Filter.Status {
+    case "", "pending", "approved", "rejected":
+    default:
+        return nil, fmt.Errorf("%w: status must be pending, approved or rejected", ErrInvalidListQuery)
+    }
+    if q.Filter.From != nil && q.Filter.To != nil && !q.Filter.From.Before(*q.Filter.To) {
+        return nil, fmt.Errorf("%w: from must be before to", ErrInvalidListQuery)
+    }
+    
+    page, err := s.bookingRepo.List(ctx, q)
+    if errors.Is(err, repository.ErrInvalidCursor) {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidListQuery, err)
+    }
+    return page, err
+}
+
+func (s *BookingService) StreamBookings(ctx context.Context, f models.BookingFilter, fn func(*models.Booking) error) error {
+    return s.bookingRepo.StreamBookings(ctx, f, fn)
+}
+
+// currentMonth is the calendar month under way in loc
+func currentMonth(loc *time.Location) (from, to time.Time) {
+    now := time.Now().In(loc)
+    from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+    return from, from.AddDate(0, 1, 0)
+}
+
+// StreamMonthlyReport streams usage per room over the current month of loc
+func (s *BookingService) StreamMonthlyReport(ctx context.Context, loc *time.Location, fn func(*models.MonthlyUsageReport) error) error {
+    from, to := currentMonth(loc)
+    return s.bookingRepo.StreamMonthlyUsage(ctx, from, to, fn)
+}
+
+func (s *BookingService) GetMonthlyReport(ctx context.Context, loc *time.Location) ([]models.MonthlyUsageReport, error) {
+    from, to := currentMonth(loc)
+    return s.bookingRepo.GetMonthlyUsage(ctx, from, to)
 }
 
-func (s *BookingService) GetMonthlyReport(ctx context.Context) ([]models.MonthlyUsageReport, error) {
-    return s.bookingRepo.GetMonthlyUsage(ctx)
+// StreamGroupMonthlyReport streams the monthly report rolled up to groups of kind
+func (s *BookingService) StreamGroupMonthlyReport(ctx context.Context, kind string, loc *time.Location, fn func(*models.GroupUsageReport) error) error {
+    if err := ValidateReportLevel(kind); err != nil {
+        return err
+    }
+    from, to := currentMonth(loc)
+    return s.bookingRepo.StreamGroupMonthlyUsage(ctx, kind, from, to, fn)
+}
+
+// GetGroupMonthlyReport rolls the monthly report up to the groups of kind
+func (s *BookingService) GetGroupMonthlyReport(ctx context.Context, kind string, loc *time.Location) ([]models.GroupUsageReport, error) {
+    if err := ValidateReportLevel(kind); err != nil {
+        return nil, err
+    }
+    from, to := currentMonth(loc)
+    return s.bookingRepo.GetGroupMonthlyUsage(ctx, kind, from, to)
 }
 
-func (s *BookingService) ForceAllocate(ctx context.Context, bookingID int) error {
-    return s.bookingRepo.PreemptBooking(ctx, bookingID)
+// ValidateReportLevel checks the ?level= of a rolled-up report
+func ValidateReportLevel(kind string) error {
+    if groupKindRank(kind) < 0 {
+        return fmt.Errorf("%w: level must be room, %s", ErrInvalidReportQuery, strings.Join(models.GroupKinds, ", "))
+    }
+    return nil
+}
+
+// ErrInvalidReportQuery wraps report parameter errors that callers should see as 400s
+var ErrInvalidReportQuery = errors.New("invalid report query")
+
+// maxUtilizationBuckets caps report size so a wide range at hourly granularity can't run away
+const maxUtilizationBuckets = 2000
+
+var granularityWidth = map[string]time.Duration{
+    "hour": time.Hour,
+    "day":  24 * time.Hour,
+    "week": 7 * 24 * time.Hour,
+}
+
+func (s *BookingService) GetUtilizationReport(ctx context.Context, q models.UtilizationQuery) ([]models.UtilizationBucket, error) {
+    if q.Granularity == "" {
+        q.Granularity = "day"
+    }
+    width, ok := granularityWidth[q.Granularity]
+    if !ok {
+        return nil, fmt.Errorf("%w: granularity must be hour, day or week", ErrInvalidReportQuery)
+    }
+    if !q.From.Before(q.To) {
+        return nil, fmt.Errorf("%w: from must be before to", ErrInvalidReportQuery)
+    }
+    if q.To.Sub(q.From)/width > maxUtilizationBuckets {
+        return nil, fmt.Errorf("%w: at most %d %s buckets per report", ErrInvalidReportQuery, maxUtilizationBuckets, q.Granularity)
+    }
+    
+    return s.bookingRepo.GetUtilization(ctx, q)
+}
+
+func (s *BookingService) ForceAllocate(ctx context.Context, bookingID, version int) (*models.Booking, error) {
+    ctx, span := startBookingSpan(ctx, "BookingService.ForceAllocate", bookingID)
+    booking, err := s.bookingRepo.PreemptBooking(ctx, bookingID, version)
+    endSpan(span, err)
+    return booking, err
 }
 
 func (s *BookingService) ResetAllocations(ctx context.Context) error {
     return s.bookingRepo.DeleteAll(ctx)
 }
 
-func (s *BookingService) GetSystemStats(ctx context.Context) (*repository.SystemStats, error) {
-    return s.bookingRepo.GetSystemStats(ctx)
+// SystemStatus combines booking counters with process telemetry from the metrics registry
+type SystemStatus struct {
+    *repository.SystemStats
+    *metrics.Snapshot
+}
+
+func (s *BookingService) GetSystemStats(ctx context.Context) (*SystemStatus, error) {
+    stats, err := s.bookingRepo.GetSystemStats(ctx)
+    if err != nil {
+        return nil, err
+    }
+    
+    snap, err := metrics.Collect()
+    if err != nil {
+        return nil, err
+    }
+    
+    return &SystemStatus{SystemStats: stats, Snapshot: snap}, nil
+}
+
+func startBookingSpan(ctx context.Context, name string, bookingID int) (context.Context, trace.Span) {
+    return tracer.Start(ctx, name, trace.WithAttributes(attribute.Int("allocra.booking_id", bookingID)))
+}
+
+func endSpan(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
 }
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+// GroupService manages the resource hierarchy: sites, buildings and pools
+// whose policies their rooms inherit
+type GroupService struct {
+    groupRepo GroupStore
+}
+
+func NewGroupService(groupRepo GroupStore) *GroupService {
+    return &GroupService{groupRepo: groupRepo}
+}
+
+// CreateGroup adds a group under parentID (nil for a root). The parent must be
+// of an earlier kind: a site may hold buildings and pools, a building pools.
+func (s *GroupService) CreateGroup(ctx context.Context, g *models.ResourceGroup) (*models.ResourceGroup, error) {
+    parent, err := s.parent(ctx, g.ParentID)
+    if err != nil {
+        return nil, err
+    }
+    if err := validateGroup(g, parent); err != nil {
+        return nil, err
+    }
+    
+    ctx, span := tracer.Start(ctx, "GroupService.CreateGroup")
+    created, err := s.groupRepo.Create(ctx, g)
+    endSpan(span, err)
+    return created, groupWriteError(err)
+}
+
+func (s *GroupService) GetGroup(ctx context.Context, id int) (*models.ResourceGroup, error) {
+    return s.groupRepo.GetByID(ctx, id)
+}
+
+// ListGroups lists the groups of one hierarchy level, or all of them
+func (s *GroupService) ListGroups(ctx context.Context, kind string) ([]models.ResourceGroup, error) {
+    if kind != "" && groupKindRank(kind) < 0 {
+        verr := &ValidationError{}
+        verr.Add("kind", "must be one of "+strings.Join(models.GroupKinds, ", "))
+        return nil, verr
+    }
+    return s.groupRepo.List(ctx, kind)
+}
+
+// PatchGroup applies a merge patch to the group at version; see PatchRoom
+func (s *GroupService) PatchGroup(ctx context.Context, id, version int, patch models.GroupPatch) (*models.ResourceGroup, error) {
+    ctx, span := tracer.Start(ctx, "GroupService.PatchGroup", trace.WithAttributes(attribute.Int("allocra.group_id", id)))
+    g, err := s.patchGroup(ctx, id, version, patch)
+    endSpan(span, err)
+    return g, err
+}
+
+func (s *GroupService) patchGroup(ctx context.Context, id, version int, patch models.GroupPatch) (*models.ResourceGroup, error) {
+    g, err := s.groupRepo.GetByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if version != repository.AnyVersion && g.Version != version {
+        return nil, fmt.Errorf("resource group %d: %w", id, repository.ErrStaleVersion)
+    }
+    
+    applyGroupPatch(g, patch)
+    parent, err := s.parent(ctx, g.ParentID)
+    if err != nil {
+        return nil, err
+    }
+    if err := validateGroup(g, parent); err != nil {
+        return nil, err
+    }
+    
+    updated, err := s.groupRepo.Update(ctx, id, g.Version, g)
+    return updated, groupWriteError(err)
+}
+
+func applyGroupPatch(g *models.ResourceGroup, patch models.GroupPatch) {
+    if patch.Name != nil { g.Name = *patch.Name }
+    
+    if patch.ClearParent {
+        g.ParentID = nil
+    } else if patch.ParentID != nil {
+        g.ParentID = patch.ParentID
+    }
+    
+    p := &g.Policy
+    if patch.InheritHours {
+        p.OpensAt, p.ClosesAt = nil, nil
+    }
+    if patch.OpensAt != nil { p.OpensAt = patch.OpensAt }
+    if patch.ClosesAt != nil { p.ClosesAt = patch.ClosesAt }
+    if patch.InheritApproval {
+        p.RequiresApproval = nil
+    } else if patch.RequiresApproval != nil {
+        p.RequiresApproval = patch.RequiresApproval
+    }
+    if patch.InheritQuota {
+        p.DailyQuotaHours = nil
+    } else if patch.DailyQuotaHours != nil {
+        p.DailyQuotaHours = patch.DailyQuotaHours
+    }
+}
+
+// DeleteGroup removes a group that no longer holds child groups or active rooms
+func (s *GroupService) DeleteGroup(ctx context.Context, id int) error {
+    ctx, span := tracer.Start(ctx, "GroupService.DeleteGroup", trace.WithAttributes(attribute.Int("allocra.group_id", id)))
+    err := s.groupRepo.Delete(ctx, id)
+    endSpan(span, err)
+    return err
+}
+
+// parent loads the parent a group is being placed under, reporting a missing
+// one against parent_id
+func (s *GroupService) parent(ctx context.Context, parentID *int) (*models.ResourceGroup, error) {
+    if parentID == nil {
+        return nil, nil
+    }
+    parent, err := s.groupRepo.GetByID(ctx, *parentID)
+    if errors.Is(err, repository.ErrGroupNotFound) {
+        verr := &ValidationError{}
+        verr.Add("parent_id", "does not exist")
+        return nil, verr
+    }
+    return parent, err
+}
+
+// groupWriteError reports the constraint violations of a group write as field errors
+func groupWriteError(err error) error {
+    verr := &ValidationError{}
+    switch {
+    case errors.Is(err, repository.ErrGroupNameTaken):
+        verr.Add("name", "is already in use by another group")
+    case errors.Is(err, repository.ErrParentGroupNotFound):
+        verr.Add("parent_id", "does not exist")
+    default:
+        return err
+    }
+    return verr
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+const (
+    // DefaultIdempotencyTTL is how long a completed response is replayed
+    DefaultIdempotencyTTL = 24 * time.Hour
+
+    // idempotencyLease bounds how long an in-flight request holds its key;
+    // a crashed request frees the key once the lease runs out
+    idempotencyLease = 5 * time.Minute
+)
+
+var (
+    // ErrIdempotencyKeyReused means the key was first used with a different request
+    ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+    // ErrIdempotencyInProgress means the original request with this key has not finished yet
+    ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+type IdempotencyService struct {
+    repo IdempotencyStore
+    ttl  time.Duration
+}
+
+func NewIdempotencyService(repo IdempotencyStore, ttl time.Duration) *IdempotencyService {
+    if ttl <= 0 {
+        ttl = DefaultIdempotencyTTL
+    }
+    return &IdempotencyService{repo: repo, ttl: ttl}
+}
+
+// Begin claims key for a request with the given fingerprint. A nil record means
+// the caller must process the request and then call Complete or Abort; otherwise
+// the record holds the original response to replay.
+func (s *IdempotencyService) Begin(ctx context.Context, scope, key, fingerprint string) (*models.IdempotencyRecord, error) {
+    rec, err := s.repo.Reserve(ctx, scope, key, fingerprint, idempotencyLease)
+    if err != nil || rec == nil {
+        return nil, err
+    }
+    if rec.Fingerprint != fingerprint {
+        return nil, ErrIdempotencyKeyReused
+    }
+    if rec.StatusCode == 0 {
+        return nil, ErrIdempotencyInProgress
+    }
+    return rec, nil
+}
+
+// Complete stores the response for replay until the TTL runs out
+func (s *IdempotencyService) Complete(ctx context.Context, scope, key string, rec *models.IdempotencyRecord) error {
+    return s.repo.Complete(ctx, scope, key, rec, s.ttl)
+}
+
+// Abort frees the key after a failure that should not be replayed
+func (s *IdempotencyService) Abort(ctx context.Context, scope, key, fingerprint string) error {
+    return s.repo.Release(ctx, scope, key, fingerprint)
+}
+
+// PurgeLoop deletes expired keys every interval until ctx is cancelled
+func (s *IdempotencyService) PurgeLoop(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if n, err := s.repo.PurgeExpired(ctx); err != nil {
+                log.Printf("Idempotency purge failed: %v", err)
+            } else if n > 0 {
+                log.Printf("Purged %d expired idempotency keys", n)
+            }
+        }
+    }
+}
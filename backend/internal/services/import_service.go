@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/ical"
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+// maxImportOccurrences bounds a single import after recurrence expansion
+const maxImportOccurrences = 1000
+
+// ErrInvalidCalendar wraps parse and expansion failures of an uploaded calendar
+var ErrInvalidCalendar = errors.New("invalid calendar")
+
+// ImportCalendar expands the VEVENTs in r and feeds each future occurrence to the
+// allocation engine in start-time order. With req.DryRun the same decisions are
+// simulated in a rolled-back transaction and nothing is persisted.
+func (s *BookingService) ImportCalendar(ctx context.Context, req models.ImportRequest, r io.Reader) (*models.ImportReport, error) {
+    ctx, span := tracer.Start(ctx, "BookingService.ImportCalendar")
+    defer span.End()
+    
+    occurrences, err := ical.Expand(r, req.Horizon, maxImportOccurrences, req.Location)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidCalendar, err)
+    }
+    
+    report := &models.ImportReport{RoomID: req.RoomID, DryRun: req.DryRun, Total: len(occurrences)}
+    
+    var pending []models.CreateBookingRequest
+    var pendingIdx []int
+    cutoff := time.Now().Add(-2 * time.Minute)
+    for _, occ := range occurrences {
+        event := models.ImportedEvent{
+            UID:       occ.UID,
+            Summary:   occ.Summary,
+            StartTime: occ.Start,
+            EndTime:   occ.End,
+        }
+        if occ.Start.Before(cutoff) {
+            event.Status = "skipped"
+            event.Reason = "starts in the past"
+        } else {
+            pending = append(pending, models.CreateBookingRequest{
+                RoomID:    req.RoomID,
+                UserID:    req.UserID,
+                StartTime: occ.Start,
+                EndTime:   occ.End,
+            })
+            pendingIdx = append(pendingIdx, len(report.Events))
+        }
+        report.Events = append(report.Events, event)
+    }
+    
+    if req.DryRun {
+        outcomes, err := s.bookingRepo.SimulateBatch(ctx, pending)
+        if err != nil {
+            return nil, err
+        }
+        for i, outcome := range outcomes {
+            event := &report.Events[pendingIdx[i]]
+            switch {
+            case outcome.Err != nil:
+                event.Status = "error"
+                event.Reason = outcome.Err.Error()
+            case outcome.Status == "rejected":
+                event.Status = "conflict"
+            default:
+                event.Status = outcome.Status
+            }
+        }
+    } else {
+        for i := range pending {
+            event := &report.Events[pendingIdx[i]]
+            booking, err := s.CreateBooking(ctx, &pending[i])
+            switch {
+            case errors.Is(err, repository.ErrBookingConflict):
+                event.Status = "conflict"
+                event.BookingID = booking.ID
+            case err != nil:
+                event.Status = "error"
+                event.Reason = err.Error()
+            default:
+                // Approved, or pending where the room's group requires approval
+                event.Status = booking.Status
+                event.BookingID = booking.ID
+            }
+        }
+    }
+    
+    for _, e := range report.Events {
+        switch e.Status {
+        case "approved":
+            report.Approved++
+        case "pending":
+            report.Pending++
+        case "conflict":
+            report.Conflicts++
+        case "skipped":
+            report.Skipped++
+        case "error":
+            report.Errors++
+        }
+    }
+    
+    return report, nil
+}
@@ -2,42 +2,235 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
 	"github.com/indraprhmbd/allocra/internal/models"
 	"github.com/indraprhmbd/allocra/internal/repository"
 )
 
 type RoomService struct {
-    roomRepo *repository.RoomRepository
+    roomRepo RoomStore
 }
 
-func NewRoomService(roomRepo *repository.RoomRepository) *RoomService {
+func NewRoomService(roomRepo RoomStore) *RoomService {
     return &RoomService{roomRepo: roomRepo}
 }
 
-func (s *RoomService) CreateRoom(ctx context.Context, name string, capacity int, roomType string, status string) (*models.Room, error) {
-    if capacity <= 0 {
-        return nil, fmt.Errorf("capacity must be positive")
+// CreateRoom registers a room, optionally inside a resource group whose
+// operating hours it inherits. An omitted type defaults to shared, an omitted
+// status to online and an omitted quantity to 1; any other type or status must
+// be one of the enumerated ones, and only pooled rooms hold more than one unit.
+func (s *RoomService) CreateRoom(ctx context.Context, name string, capacity, quantity int, roomType string, status string, timezone string, labelSet map[string]string, groupID *int) (*models.Room, error) {
+    if roomType == "" { roomType = models.RoomTypeShared }
+    if status == "" { status = models.RoomStatusOnline }
+    if quantity == 0 { quantity = 1 }
+    if timezone == "" { timezone = "UTC" }
+    
+    room := &models.Room{
+        Name:           name,
+        Capacity:       capacity,
+        Quantity:       quantity,
+        Type:           roomType,
+        Status:         status,
+        Timezone:       timezone,
+        HoursInherited: true,
+        Labels:         labelSet,
+        GroupID:        groupID,
+    }
+    if err := validateRoom(room); err != nil {
+        return nil, err
     }
     
-    if roomType == "" { roomType = "shared" }
-    if status == "" { status = "online" }
+    ctx, span := tracer.Start(ctx, "RoomService.CreateRoom")
+    created, err := s.roomRepo.Create(ctx, room)
+    endSpan(span, err)
+    return created, roomWriteError(err)
+}
+
+// GetAllRooms lists active rooms, narrowed by an optional label selector and
+// to the subtree of groupID when it is set
+func (s *RoomService) GetAllRooms(ctx context.Context, selector string, groupID *int) ([]models.Room, error) {
+    sel, err := parseSelector("selector", selector)
+    if err != nil {
+        return nil, err
+    }
+    return s.roomRepo.GetAll(ctx, models.RoomFilter{Selector: sel, GroupID: groupID})
+}
+
+// FindAvailableRooms lists rooms free for the whole of [q.Start, q.End)
+func (s *RoomService) FindAvailableRooms(ctx context.Context, q models.AvailabilityQuery, selector string) ([]models.Room, error) {
+    verr := &ValidationError{}
+    if q.Start.IsZero() {
+        verr.Add("start", "is required")
+    }
+    if q.End.IsZero() {
+        verr.Add("end", "is required")
+    } else if !q.End.After(q.Start) {
+        verr.Add("end", "must be after start")
+    }
+    if q.MinCapacity < 0 {
+        verr.Add("min_capacity", "must not be negative")
+    }
+    if q.Quantity < 0 {
+        verr.Add("quantity", "must not be negative")
+    }
+    if q.Type != "" && !oneOf(q.Type, models.RoomTypes) {
+        verr.Add("type", "must be one of "+strings.Join(models.RoomTypes, ", "))
+    }
+    sel, err := labels.Parse(selector)
+    if err != nil {
+        verr.Add("selector", err.Error())
+    }
+    if err := verr.Err(); err != nil {
+        return nil, err
+    }
     
-    return s.roomRepo.Create(ctx, name, capacity, roomType, status)
+    q.Selector = sel
+    return s.roomRepo.FindAvailable(ctx, q)
 }
 
-func (s *RoomService) GetAllRooms(ctx context.Context) ([]models.Room, error) {
-    return s.roomRepo.GetAll(ctx)
+func (s *RoomService) GetRoom(ctx context.Context, id int) (*models.Room, error) {
+    return s.roomRepo.GetByID(ctx, id)
 }
 
-func (s *RoomService) UpdateRoom(ctx context.Context, id int, name string, capacity int, roomType string, status string) error {
-    if capacity <= 0 {
-        return fmt.Errorf("capacity must be positive")
+// UpdateRoom replaces the descriptive fields of a room (PUT semantics; an
+// omitted quantity is 1); operating hours are kept, and labels and group too
+// unless given
+func (s *RoomService) UpdateRoom(ctx context.Context, id, version int, name string, capacity, quantity int, roomType string, status string, timezone string, labelSet map[string]string, groupID *int) (*models.Room, error) {
+    if quantity == 0 { quantity = 1 }
+    patch := models.RoomPatch{
+        Name:     &name,
+        Capacity: &capacity,
+        Quantity: &quantity,
+        Type:     &roomType,
+        Status:   &status,
+        GroupID:  groupID,
+    }
+    // Labels and the time zone are replaced when given and kept otherwise
+    if timezone != "" {
+        patch.Timezone = &timezone
+    }
+    if labelSet != nil {
+        patch.ClearLabels = true
+        patch.Labels = make(map[string]*string, len(labelSet))
+        for k, v := range labelSet {
+            v := v
+            patch.Labels[k] = &v
+        }
     }
-    return s.roomRepo.Update(ctx, id, name, capacity, roomType, status)
+    return s.PatchRoom(ctx, id, version, patch)
 }
 
-func (s *RoomService) DeleteRoom(ctx context.Context, id int) error {
-    return s.roomRepo.Delete(ctx, id)
+// PatchRoom applies a merge patch to the room at version. The whole resulting
+// room is validated, and the write is conditioned on the version that was read,
+// so a concurrent update between read and write still yields ErrStaleVersion.
+func (s *RoomService) PatchRoom(ctx context.Context, id, version int, patch models.RoomPatch) (*models.Room, error) {
+    ctx, span := tracer.Start(ctx, "RoomService.PatchRoom", trace.WithAttributes(attribute.Int("allocra.room_id", id)))
+    room, err := s.patchRoom(ctx, id, version, patch)
+    endSpan(span, err)
+    return room, err
+}
+
+func (s *RoomService) patchRoom(ctx context.Context, id, version int, patch models.RoomPatch) (*models.Room, error) {
+    room, err := s.roomRepo.GetByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if version != repository.AnyVersion && room.Version != version {
+        return nil, fmt.Errorf("room %d: %w", id, repository.ErrStaleVersion)
+    }
+    
+    applyRoomPatch(room, patch)
+    if err := validateRoom(room); err != nil {
+        return nil, err
+    }
+    
+    updated, err := s.roomRepo.Update(ctx, id, room.Version, room)
+    return updated, roomWriteError(err)
+}
+
+func applyRoomPatch(room *models.Room, patch models.RoomPatch) {
+    if patch.Name != nil { room.Name = *patch.Name }
+    if patch.Capacity != nil { room.Capacity = *patch.Capacity }
+    if patch.Quantity != nil { room.Quantity = *patch.Quantity }
+    if patch.Type != nil { room.Type = *patch.Type }
+    if patch.Status != nil { room.Status = *patch.Status }
+    if patch.Timezone != nil { room.Timezone = *patch.Timezone }
+    
+    // Setting either bound gives the room hours of its own, starting from the
+    // ones it inherited
+    if patch.InheritHours {
+        room.HoursInherited = true
+    } else if patch.OpensAt != nil || patch.ClosesAt != nil {
+        room.HoursInherited = false
+    }
+    if patch.OpensAt != nil { room.OpensAt = *patch.OpensAt }
+    if patch.ClosesAt != nil { room.ClosesAt = *patch.ClosesAt }
+    
+    if patch.ClearGroup {
+        room.GroupID = nil
+    } else if patch.GroupID != nil {
+        room.GroupID = patch.GroupID
+    }
+    
+    if patch.ClearLabels || room.Labels == nil {
+        room.Labels = map[string]string{}
+    }
+    for k, v := range patch.Labels {
+        if v == nil {
+            delete(room.Labels, k)
+        } else {
+            room.Labels[k] = *v
+        }
+    }
+}
+
+// parseSelector parses a label selector, reporting syntax errors against field
+func parseSelector(field, selector string) (labels.Selector, error) {
+    sel, err := labels.Parse(selector)
+    if err != nil {
+        verr := &ValidationError{}
+        verr.Add(field, err.Error())
+        return nil, verr
+    }
+    return sel, nil
+}
+
+// roomWriteError reports the constraint violations of a room write as field errors
+func roomWriteError(err error) error {
+    verr := &ValidationError{}
+    switch {
+    case errors.Is(err, repository.ErrRoomNameTaken):
+        verr.Add("name", "is already in use by another room")
+    case errors.Is(err, repository.ErrGroupNotFound):
+        verr.Add("group_id", "does not exist")
+    default:
+        return err
+    }
+    return verr
+}
+
+// ArchiveRoom decommissions a room; see RoomRepository.Archive for strategy
+func (s *RoomService) ArchiveRoom(ctx context.Context, id int, strategy string) (*models.ArchiveResult, error) {
+    switch strategy {
+    case "", models.ArchiveStrategyReject, models.ArchiveStrategyReassign:
+    default:
+        verr := &ValidationError{}
+        verr.Add("strategy", "must be reject or reassign")
+        return nil, verr
+    }
+    
+    ctx, span := tracer.Start(ctx, "RoomService.ArchiveRoom", trace.WithAttributes(
+        attribute.Int("allocra.room_id", id),
+        attribute.String("allocra.archive_strategy", strategy),
+    ))
+    result, err := s.roomRepo.Archive(ctx, id, strategy)
+    endSpan(span, err)
+    return result, err
 }
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+	"github.com/indraprhmbd/allocra/internal/repository"
+)
+
+// The stores are the storage the services run on. The Postgres implementations
+// live in package repository, the SQLite ones in repository/sqlite and an
+// in-memory one in repository/memory. Every implementation returns the
+// repository sentinel errors (ErrBookingConflict, ErrRoomNotFound,
+// ErrStaleVersion...) and makes each call atomic, with allocation decisions on
+// a room serialized as under its row lock.
+
+// BookingStore persists bookings and bundles and runs the allocation engine
+type BookingStore interface {
+    CreateWithTransaction(ctx context.Context, req *models.CreateBookingRequest) (*models.Booking, error)
+    SimulateBatch(ctx context.Context, reqs []models.CreateBookingRequest) ([]repository.SimulatedBooking, error)
+    ApproveBooking(ctx context.Context, bookingID, version int) (*models.Booking, error)
+    RejectBooking(ctx context.Context, bookingID, version int) (*models.Booking, error)
+    PreemptBooking(ctx context.Context, bookingID, version int) (*models.Booking, error)
+    GetByID(ctx context.Context, id int) (*models.Booking, error)
+    GetAll(ctx context.Context) ([]models.Booking, error)
+    List(ctx context.Context, q models.BookingListQuery) (*models.BookingPage, error)
+    StreamBookings(ctx context.Context, f models.BookingFilter, fn func(*models.Booking) error) error
+    DeleteAll(ctx context.Context) error
+
+    CreateBundle(ctx context.Context, req *models.CreateBundleRequest) (*models.Bundle, error)
+    GetBundle(ctx context.Context, id int) (*models.Bundle, error)
+    ApproveBundle(ctx context.Context, id, version int) (*models.Bundle, error)
+    RejectBundle(ctx context.Context, id, version int) (*models.Bundle, error)
+
+    GetMonthlyUsage(ctx context.Context, from, to time.Time) ([]models.MonthlyUsageReport, error)
+    StreamMonthlyUsage(ctx context.Context, from, to time.Time, fn func(*models.MonthlyUsageReport) error) error
+    GetGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time) ([]models.GroupUsageReport, error)
+    StreamGroupMonthlyUsage(ctx context.Context, kind string, from, to time.Time, fn func(*models.GroupUsageReport) error) error
+    GetUtilization(ctx context.Context, q models.UtilizationQuery) ([]models.UtilizationBucket, error)
+    GetSystemStats(ctx context.Context) (*repository.SystemStats, error)
+}
+
+// RoomStore persists rooms
+type RoomStore interface {
+    Create(ctx context.Context, room *models.Room) (*models.Room, error)
+    GetByID(ctx context.Context, id int) (*models.Room, error)
+    GetAll(ctx context.Context, f models.RoomFilter) ([]models.Room, error)
+    FindAvailable(ctx context.Context, q models.AvailabilityQuery) ([]models.Room, error)
+    Update(ctx context.Context, id, version int, room *models.Room) (*models.Room, error)
+    Archive(ctx context.Context, id int, strategy string) (*models.ArchiveResult, error)
+}
+
+// GroupStore persists the resource hierarchy
+type GroupStore interface {
+    Create(ctx context.Context, g *models.ResourceGroup) (*models.ResourceGroup, error)
+    GetByID(ctx context.Context, id int) (*models.ResourceGroup, error)
+    List(ctx context.Context, kind string) ([]models.ResourceGroup, error)
+    Update(ctx context.Context, id, version int, g *models.ResourceGroup) (*models.ResourceGroup, error)
+    Delete(ctx context.Context, id int) error
+}
+
+// IdempotencyStore holds Idempotency-Key reservations and stored responses
+type IdempotencyStore interface {
+    Reserve(ctx context.Context, scope, key, fingerprint string, lease time.Duration) (*models.IdempotencyRecord, error)
+    Complete(ctx context.Context, scope, key string, rec *models.IdempotencyRecord, ttl time.Duration) error
+    Release(ctx context.Context, scope, key, fingerprint string) error
+    PurgeExpired(ctx context.Context) (int64, error)
+}
+
+var (
+    _ BookingStore     = (*repository.BookingRepository)(nil)
+    _ RoomStore        = (*repository.RoomRepository)(nil)
+    _ GroupStore       = (*repository.GroupRepository)(nil)
+    _ IdempotencyStore = (*repository.IdempotencyRepository)(nil)
+)
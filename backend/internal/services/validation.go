@@ -0,0 +1,234 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/indraprhmbd/allocra/internal/labels"
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+// ValidationError reports invalid input per field. Keys are JSON field names.
+type ValidationError struct {
+    Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+    keys := make([]string, 0, len(e.Fields))
+    for k := range e.Fields {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    
+    parts := make([]string, len(keys))
+    for i, k := range keys {
+        parts[i] = k + ": " + e.Fields[k]
+    }
+    return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// Add records the first problem found with field
+func (e *ValidationError) Add(field, message string) {
+    if e.Fields == nil {
+        e.Fields = make(map[string]string)
+    }
+    if _, ok := e.Fields[field]; !ok {
+        e.Fields[field] = message
+    }
+}
+
+// Err returns e when any field failed, so callers can return it unconditionally
+func (e *ValidationError) Err() error {
+    if len(e.Fields) == 0 {
+        return nil
+    }
+    return e
+}
+
+// validateRoom checks a complete room before it is written
+func validateRoom(room *models.Room) error {
+    verr := &ValidationError{}
+    
+    switch name := strings.TrimSpace(room.Name); {
+    case name == "":
+        verr.Add("name", "is required")
+    case utf8.RuneCountInString(name) > 255:
+        verr.Add("name", "must be at most 255 characters")
+    }
+    if room.Capacity <= 0 {
+        verr.Add("capacity", "must be a positive integer")
+    }
+    if !oneOf(room.Type, models.RoomTypes) {
+        verr.Add("type", "must be one of "+strings.Join(models.RoomTypes, ", "))
+    }
+    switch {
+    case room.Type == models.RoomTypePooled && room.Quantity <= 0:
+        verr.Add("quantity", "must be a positive integer")
+    case room.Type != models.RoomTypePooled && room.Quantity > 1:
+        verr.Add("quantity", "must be 1 unless type is pooled")
+    }
+    if !oneOf(room.Status, models.RoomStatuses) {
+        verr.Add("status", "must be one of "+strings.Join(models.RoomStatuses, ", "))
+    }
+    if _, err := LoadTimezone(room.Timezone); err != nil {
+        verr.Add("timezone", err.Error())
+    }
+    
+    if err := labels.Validate(room.Labels); err != nil {
+        verr.Add("labels", err.Error())
+    }
+    
+    if !room.HoursInherited {
+        validateHours(verr, "", room.OpensAt, room.ClosesAt)
+    }
+    
+    return verr.Err()
+}
+
+// LoadTimezone resolves an IANA zone name such as "Europe/Berlin". "Local" is
+// refused: it names whatever zone the server runs in, which clients cannot know.
+func LoadTimezone(name string) (*time.Location, error) {
+    if name == "" || name == "Local" {
+        return nil, fmt.Errorf("must be an IANA time zone such as UTC or Europe/Berlin")
+    }
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        return nil, fmt.Errorf("unknown time zone %q", name)
+    }
+    return loc, nil
+}
+
+// validateHours checks an operating window, reporting under prefix+"opens_at"
+// and prefix+"closes_at"
+func validateHours(verr *ValidationError, prefix, opensAt, closesAt string) {
+    opens, err := clockSeconds(opensAt)
+    if err != nil || opens >= 24*3600 {
+        verr.Add(prefix+"opens_at", "must be a time of day between 00:00 and 23:59:59")
+    }
+    closes, err := clockSeconds(closesAt)
+    if err != nil {
+        verr.Add(prefix+"closes_at", "must be a time of day between 00:00 and 24:00")
+    }
+    if verr.Fields[prefix+"opens_at"] == "" && verr.Fields[prefix+"closes_at"] == "" && closes <= opens {
+        verr.Add(prefix+"closes_at", "must be after opens_at")
+    }
+}
+
+// groupKindRank orders group kinds from the top of the hierarchy down
+func groupKindRank(kind string) int {
+    for i, k := range models.GroupKinds {
+        if k == kind {
+            return i
+        }
+    }
+    return -1
+}
+
+// validateGroup checks a complete group before it is written; parent is the
+// group's parent as stored, or nil for a root group
+func validateGroup(g *models.ResourceGroup, parent *models.ResourceGroup) error {
+    verr := &ValidationError{}
+    
+    switch name := strings.TrimSpace(g.Name); {
+    case name == "":
+        verr.Add("name", "is required")
+    case utf8.RuneCountInString(name) > 255:
+        verr.Add("name", "must be at most 255 characters")
+    }
+    if groupKindRank(g.Kind) < 0 {
+        verr.Add("kind", "must be one of "+strings.Join(models.GroupKinds, ", "))
+    } else if parent != nil && groupKindRank(parent.Kind) >= groupKindRank(g.Kind) {
+        verr.Add("parent_id", fmt.Sprintf("a %s cannot be placed under a %s", g.Kind, parent.Kind))
+    }
+    
+    p := g.Policy
+    switch {
+    case p.OpensAt == nil && p.ClosesAt == nil:
+    case p.OpensAt == nil || p.ClosesAt == nil:
+        verr.Add("policy.opens_at", "must be set together with closes_at")
+    default:
+        validateHours(verr, "policy.", *p.OpensAt, *p.ClosesAt)
+    }
+    if p.DailyQuotaHours != nil && (*p.DailyQuotaHours <= 0 || *p.DailyQuotaHours > 24) {
+        verr.Add("policy.daily_quota_hours", "must be more than 0 and at most 24")
+    }
+    
+    return verr.Err()
+}
+
+// maxBundleMembers bounds how many rooms one bundle may lock at once
+const maxBundleMembers = 20
+
+// validateBundle checks a bundle request and parses its members' selectors.
+// Member problems are reported as members[i].<field>.
+func validateBundle(req *models.CreateBundleRequest, now time.Time) error {
+    verr := &ValidationError{}
+    
+    if !req.StartTime.Before(req.EndTime) {
+        verr.Add("end_time", "must be after start_time")
+    }
+    // The same 2-minute grace period for clock drift as single bookings
+    if req.StartTime.Before(now.Add(-2 * time.Minute)) {
+        verr.Add("start_time", "cannot be in the past (beyond 2min grace period)")
+    }
+    switch {
+    case len(req.Members) == 0:
+        verr.Add("members", "must name at least one resource")
+    case len(req.Members) > maxBundleMembers:
+        verr.Add("members", fmt.Sprintf("must name at most %d resources", maxBundleMembers))
+    }
+    
+    seen := make(map[int]bool)
+    for i := range req.Members {
+        m := &req.Members[i]
+        field := fmt.Sprintf("members[%d].", i)
+        if m.Quantity < 0 {
+            verr.Add(field+"quantity", "must not be negative")
+        }
+        if m.RoomID != 0 {
+            if seen[m.RoomID] {
+                verr.Add(field+"room_id", "is already in the bundle")
+            }
+            seen[m.RoomID] = true
+            continue
+        }
+        
+        sel, err := labels.Parse(m.RoomSelector)
+        switch {
+        case err != nil:
+            verr.Add(field+"room_selector", err.Error())
+        case m.RoomSelector == "" && m.MinCapacity == 0 && m.GroupID == nil:
+            verr.Add(field+"room_id", "is required unless room_selector, min_capacity or group_id is given")
+        case m.MinCapacity < 0:
+            verr.Add(field+"min_capacity", "must not be negative")
+        }
+        m.Selector = sel
+    }
+    
+    return verr.Err()
+}
+
+func oneOf(v string, allowed []string) bool {
+    for _, a := range allowed {
+        if v == a {
+            return true
+        }
+    }
+    return false
+}
+
+// clockSeconds parses "HH:MM" or "HH:MM:SS", allowing 24:00 as end of day
+func clockSeconds(v string) (int, error) {
+    var h, m, s int
+    n, _ := fmt.Sscanf(v, "%d:%d:%d", &h, &m, &s)
+    if n < 2 || (n == 2 && len(v) != 5) || (n == 3 && len(v) != 8) {
+        return 0, fmt.Errorf("malformed time %q", v)
+    }
+    if h < 0 || m < 0 || m > 59 || s < 0 || s > 59 || h > 24 || (h == 24 && m+s > 0) {
+        return 0, fmt.Errorf("time out of range %q", v)
+    }
+    return h*3600 + m*60 + s, nil
+}
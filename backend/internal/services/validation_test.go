@@ -0,0 +1,126 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/indraprhmbd/allocra/internal/models"
+)
+
+func TestValidateRoom(t *testing.T) {
+	valid := models.Room{Name: "Lab", Capacity: 4, Type: "shared", Status: "online", OpensAt: "08:00:00", ClosesAt: "24:00:00", Timezone: "Asia/Jakarta"}
+	assert.NoError(t, validateRoom(&valid))
+
+	cases := []struct {
+		name   string
+		mutate func(*models.Room)
+		field  string
+	}{
+		{"blank name", func(r *models.Room) { r.Name = "  " }, "name"},
+		{"zero capacity", func(r *models.Room) { r.Capacity = 0 }, "capacity"},
+		{"unknown type", func(r *models.Room) { r.Type = "private" }, "type"},
+		{"unknown status", func(r *models.Room) { r.Status = "" }, "status"},
+		{"pool without units", func(r *models.Room) { r.Type = "pooled" }, "quantity"},
+		{"units on a shared room", func(r *models.Room) { r.Quantity = 3 }, "quantity"},
+		{"unknown time zone", func(r *models.Room) { r.Timezone = "Mars/Olympus_Mons" }, "timezone"},
+		{"server time zone", func(r *models.Room) { r.Timezone = "Local" }, "timezone"},
+		{"malformed opening", func(r *models.Room) { r.OpensAt = "8am" }, "opens_at"},
+		{"opens at midnight end", func(r *models.Room) { r.OpensAt = "24:00" }, "opens_at"},
+		{"closing past end of day", func(r *models.Room) { r.ClosesAt = "24:30" }, "closes_at"},
+		{"closes before opening", func(r *models.Room) { r.ClosesAt = "07:00" }, "closes_at"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			room := valid
+			tc.mutate(&room)
+
+			var verr *ValidationError
+			require.ErrorAs(t, validateRoom(&room), &verr)
+			assert.Contains(t, verr.Fields, tc.field)
+			assert.Len(t, verr.Fields, 1)
+		})
+	}
+}
+
+func TestValidateRoom_InheritedHoursAreNotChecked(t *testing.T) {
+	room := models.Room{Name: "Node", Capacity: 1, Type: "shared", Status: "online", Timezone: "UTC", HoursInherited: true}
+	assert.NoError(t, validateRoom(&room))
+}
+
+func TestValidateGroup(t *testing.T) {
+	site := &models.ResourceGroup{ID: 1, Name: "HQ", Kind: "site"}
+	building := &models.ResourceGroup{ID: 2, Name: "North", Kind: "building", ParentID: &site.ID}
+	assert.NoError(t, validateGroup(site, nil))
+	assert.NoError(t, validateGroup(building, site))
+
+	opens, closes, early := "08:00", "18:00", "07:00"
+	quota, tooMuch := 4.0, 25.0
+	pool := &models.ResourceGroup{Name: "GPU", Kind: "pool", Policy: models.Policy{OpensAt: &opens, ClosesAt: &closes, DailyQuotaHours: &quota}}
+	assert.NoError(t, validateGroup(pool, building))
+
+	cases := []struct {
+		name   string
+		group  models.ResourceGroup
+		parent *models.ResourceGroup
+		field  string
+	}{
+		{"unknown kind", models.ResourceGroup{Name: "X", Kind: "rack"}, nil, "kind"},
+		{"site under a building", models.ResourceGroup{Name: "X", Kind: "site"}, building, "parent_id"},
+		{"pool under a pool", models.ResourceGroup{Name: "X", Kind: "pool"}, pool, "parent_id"},
+		{"half an operating window", models.ResourceGroup{Name: "X", Kind: "pool", Policy: models.Policy{OpensAt: &opens}}, nil, "policy.opens_at"},
+		{"closes before opening", models.ResourceGroup{Name: "X", Kind: "pool", Policy: models.Policy{OpensAt: &opens, ClosesAt: &early}}, nil, "policy.closes_at"},
+		{"quota over a day", models.ResourceGroup{Name: "X", Kind: "pool", Policy: models.Policy{DailyQuotaHours: &tooMuch}}, nil, "policy.daily_quota_hours"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var verr *ValidationError
+			require.ErrorAs(t, validateGroup(&tc.group, tc.parent), &verr)
+			assert.Contains(t, verr.Fields, tc.field)
+			assert.Len(t, verr.Fields, 1)
+		})
+	}
+}
+
+func TestValidateBundle(t *testing.T) {
+	now := time.Now()
+	valid := func() models.CreateBundleRequest {
+		return models.CreateBundleRequest{
+			UserID:    1,
+			StartTime: now.Add(time.Hour),
+			EndTime:   now.Add(2 * time.Hour),
+			Members:   []models.BundleMember{{RoomID: 3}, {RoomSelector: "kind=projector"}},
+		}
+	}
+	req := valid()
+	require.NoError(t, validateBundle(&req, now))
+	assert.NotNil(t, req.Members[1].Selector)
+
+	cases := []struct {
+		name   string
+		mutate func(*models.CreateBundleRequest)
+		field  string
+	}{
+		{"empty window", func(r *models.CreateBundleRequest) { r.EndTime = r.StartTime }, "end_time"},
+		{"in the past", func(r *models.CreateBundleRequest) {
+			r.StartTime, r.EndTime = now.Add(-time.Hour), now
+		}, "start_time"},
+		{"no members", func(r *models.CreateBundleRequest) { r.Members = nil }, "members"},
+		{"room twice", func(r *models.CreateBundleRequest) { r.Members[1] = models.BundleMember{RoomID: 3} }, "members[1].room_id"},
+		{"member without criteria", func(r *models.CreateBundleRequest) { r.Members[1] = models.BundleMember{} }, "members[1].room_id"},
+		{"bad selector", func(r *models.CreateBundleRequest) { r.Members[1].RoomSelector = "kind in (" }, "members[1].room_selector"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := valid()
+			tc.mutate(&req)
+
+			var verr *ValidationError
+			require.ErrorAs(t, validateBundle(&req, now), &verr)
+			assert.Contains(t, verr.Fields, tc.field)
+			assert.Len(t, verr.Fields, 1)
+		})
+	}
+}
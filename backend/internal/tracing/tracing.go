@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supported values for Options.Exporter
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+const instrumentationName = "github.com/indraprhmbd/allocra/internal/tracing"
+
+// Options selects where spans are exported
+type Options struct {
+	Exporter    string    // "otlp", "stdout" or "none"
+	ServiceName string
+	Writer      io.Writer // stdout exporter destination, defaults to os.Stdout
+}
+
+// Setup installs the global tracer provider and the W3C trace-context propagator.
+// The OTLP exporter is configured through the standard OTEL_EXPORTER_OTLP_* variables.
+// The returned function flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w := opts.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (want otlp, stdout or none)", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", opts.Exporter, err)
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = "allocra-api"
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware continues the trace from an incoming traceparent header and opens
+// the server span. Handlers must pass c.UserContext() downstream to keep the chain.
+func Middleware() fiber.Handler {
+	tracer := otel.Tracer(instrumentationName)
+
+	return func(c *fiber.Ctx) error {
+		carrier := propagation.MapCarrier{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			carrier.Set(strings.ToLower(string(key)), string(value))
+		})
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+
+		ctx, span := tracer.Start(ctx, c.Method(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Method()),
+				semconv.URLPath(c.Path()),
+			),
+		)
+		defer span.End()
+
+		c.SetUserContext(ctx)
+		err := c.Next()
+
+		// The route template is only known once the router has matched
+		route := c.Route().Path
+		status := c.Response().StatusCode()
+		span.SetName(c.Method() + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, "")
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestMiddleware_PropagatesTraceparent(t *testing.T) {
+	var out bytes.Buffer
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterStdout, Writer: &out})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(Middleware())
+	app.Get("/api/rooms/:id", func(c *fiber.Ctx) error {
+		_, span := otel.Tracer("test").Start(c.UserContext(), "child")
+		span.End()
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/api/rooms/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	require.NoError(t, shutdown(context.Background()))
+
+	exported := out.String()
+	assert.Contains(t, exported, "4bf92f3577b34da6a3ce929d0e0e4736", "spans should join the caller's trace")
+	assert.Contains(t, exported, "00f067aa0ba902b7", "server span should be parented to the caller's span")
+	assert.Contains(t, exported, `"Name":"GET /api/rooms/:id"`)
+	assert.Contains(t, exported, `"Name":"child"`)
+}
+
+func TestSetup_RejectsUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Options{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,6 @@
+-- Bookings of the seeded users go with them (ON DELETE CASCADE)
+DELETE FROM users WHERE email IN ('admin@allocra.local', 'user@allocra.local');
+DELETE FROM bookings WHERE room_id IN (
+    SELECT id FROM rooms WHERE name IN ('Meeting Room A', 'Meeting Room B', 'Conference Hall')
+);
+DELETE FROM rooms WHERE name IN ('Meeting Room A', 'Meeting Room B', 'Conference Hall');
@@ -0,0 +1,2 @@
+ALTER TABLE rooms DROP COLUMN status;
+ALTER TABLE rooms DROP COLUMN type;
@@ -0,0 +1,4 @@
+DROP INDEX idx_bookings_status_time;
+ALTER TABLE rooms DROP CONSTRAINT valid_operating_hours;
+ALTER TABLE rooms DROP COLUMN closes_at;
+ALTER TABLE rooms DROP COLUMN opens_at;
@@ -0,0 +1,7 @@
+-- Migration: Operating hours per room, used as the denominator of utilization reports
+ALTER TABLE rooms ADD COLUMN opens_at TIME NOT NULL DEFAULT '00:00';
+ALTER TABLE rooms ADD COLUMN closes_at TIME NOT NULL DEFAULT '24:00';
+ALTER TABLE rooms ADD CONSTRAINT valid_operating_hours CHECK (closes_at > opens_at);
+
+-- Supports the per-bucket booked-hours scan of utilization reports
+CREATE INDEX idx_bookings_status_time ON bookings(status, start_time, end_time);
@@ -0,0 +1,2 @@
+DROP INDEX idx_bookings_created_id;
+DROP INDEX idx_bookings_start_id;
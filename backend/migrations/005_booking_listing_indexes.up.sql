@@ -0,0 +1,3 @@
+-- Keyset pagination indexes for GET /api/bookings (sort column + id tie-breaker)
+CREATE INDEX idx_bookings_start_id ON bookings(start_time, id);
+CREATE INDEX idx_bookings_created_id ON bookings(created_at, id);
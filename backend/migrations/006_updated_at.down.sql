@@ -0,0 +1,6 @@
+DROP TRIGGER bookings_set_updated_at ON bookings;
+DROP TRIGGER rooms_set_updated_at ON rooms;
+DROP FUNCTION set_updated_at();
+
+ALTER TABLE bookings DROP COLUMN updated_at;
+ALTER TABLE rooms DROP COLUMN updated_at;
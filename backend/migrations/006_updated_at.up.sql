@@ -0,0 +1,19 @@
+-- Migration: Track last modification of rooms and bookings for ETag / Last-Modified
+ALTER TABLE rooms ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE bookings ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE rooms SET updated_at = created_at;
+UPDATE bookings SET updated_at = created_at;
+
+-- Bumped on every UPDATE so writers cannot forget to touch it
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = clock_timestamp();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER rooms_set_updated_at BEFORE UPDATE ON rooms
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER bookings_set_updated_at BEFORE UPDATE ON bookings
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
@@ -0,0 +1,6 @@
+DROP TRIGGER bookings_bump_version ON bookings;
+DROP TRIGGER rooms_bump_version ON rooms;
+DROP FUNCTION bump_row_version();
+
+ALTER TABLE bookings DROP COLUMN version;
+ALTER TABLE rooms DROP COLUMN version;
@@ -0,0 +1,17 @@
+-- Migration: Row versions for optimistic concurrency control on updates
+ALTER TABLE rooms ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE bookings ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Every UPDATE moves the row to the next version, including status changes
+-- made by the allocation engine, so a client's If-Match can never be reused
+CREATE OR REPLACE FUNCTION bump_row_version() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER rooms_bump_version BEFORE UPDATE ON rooms
+    FOR EACH ROW EXECUTE FUNCTION bump_row_version();
+CREATE TRIGGER bookings_bump_version BEFORE UPDATE ON bookings
+    FOR EACH ROW EXECUTE FUNCTION bump_row_version();
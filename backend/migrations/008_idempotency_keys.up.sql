@@ -0,0 +1,16 @@
+-- Migration: Idempotency keys for retried allocation requests
+-- A row is a lease while status_code is NULL (request in flight) and a stored
+-- response afterwards; expires_at covers both, so crashed requests free their key
+CREATE TABLE idempotency_keys (
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    content_type TEXT,
+    response BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
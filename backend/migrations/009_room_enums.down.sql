@@ -0,0 +1,4 @@
+ALTER TABLE rooms DROP CONSTRAINT valid_room_status;
+ALTER TABLE rooms DROP CONSTRAINT valid_room_type;
+ALTER TABLE rooms ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE rooms ALTER COLUMN type DROP NOT NULL;
@@ -0,0 +1,8 @@
+-- Migration: Enumerate room types and statuses
+UPDATE rooms SET type = 'shared' WHERE type IS NULL OR type NOT IN ('shared', 'exclusive');
+UPDATE rooms SET status = 'offline' WHERE status IS NULL OR status NOT IN ('online', 'maintenance', 'offline');
+
+ALTER TABLE rooms ALTER COLUMN type SET NOT NULL;
+ALTER TABLE rooms ALTER COLUMN status SET NOT NULL;
+ALTER TABLE rooms ADD CONSTRAINT valid_room_type CHECK (type IN ('shared', 'exclusive'));
+ALTER TABLE rooms ADD CONSTRAINT valid_room_status CHECK (status IN ('online', 'maintenance', 'offline'));
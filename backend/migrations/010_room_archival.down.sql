@@ -0,0 +1,13 @@
+-- Before archival a removed room took its bookings with it, and names were
+-- unique across all rooms, so archived rooms cannot survive the rollback
+DELETE FROM bookings WHERE room_id IN (SELECT id FROM rooms WHERE archived_at IS NOT NULL);
+DELETE FROM rooms WHERE archived_at IS NOT NULL;
+
+DROP INDEX rooms_active_name_key;
+ALTER TABLE rooms ADD CONSTRAINT rooms_name_key UNIQUE (name);
+
+ALTER TABLE bookings DROP CONSTRAINT bookings_room_id_fkey;
+ALTER TABLE bookings ADD CONSTRAINT bookings_room_id_fkey
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE;
+
+ALTER TABLE rooms DROP COLUMN archived_at;
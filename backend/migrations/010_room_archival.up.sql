@@ -0,0 +1,11 @@
+-- Migration: Archive rooms instead of deleting them, so booking history survives
+ALTER TABLE rooms ADD COLUMN archived_at TIMESTAMP;
+
+-- Bookings must never disappear with their room again
+ALTER TABLE bookings DROP CONSTRAINT bookings_room_id_fkey;
+ALTER TABLE bookings ADD CONSTRAINT bookings_room_id_fkey
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE RESTRICT;
+
+-- An archived room releases its name for reuse
+ALTER TABLE rooms DROP CONSTRAINT rooms_name_key;
+CREATE UNIQUE INDEX rooms_active_name_key ON rooms(name) WHERE archived_at IS NULL;
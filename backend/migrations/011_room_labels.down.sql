@@ -0,0 +1,3 @@
+DROP INDEX idx_rooms_labels;
+ALTER TABLE rooms DROP CONSTRAINT labels_is_object;
+ALTER TABLE rooms DROP COLUMN labels;
@@ -0,0 +1,6 @@
+-- Migration: Free-form key/value labels on rooms for selector queries
+ALTER TABLE rooms ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE rooms ADD CONSTRAINT labels_is_object CHECK (jsonb_typeof(labels) = 'object');
+
+-- jsonb_ops supports both @> (key=value, in) and ? (key exists) in selectors
+CREATE INDEX idx_rooms_labels ON rooms USING GIN (labels);
@@ -0,0 +1,17 @@
+-- Rooms keep the operating hours they had in effect, as their own
+UPDATE rooms r SET opens_at = gp.opens_at, closes_at = gp.closes_at
+FROM group_policies gp
+WHERE gp.group_id = r.group_id AND r.opens_at IS NULL AND gp.opens_at IS NOT NULL;
+UPDATE rooms SET opens_at = '00:00', closes_at = '24:00' WHERE opens_at IS NULL;
+
+DROP VIEW group_policies;
+DROP VIEW group_closure;
+
+ALTER TABLE rooms DROP CONSTRAINT room_hours_pair;
+ALTER TABLE rooms ALTER COLUMN opens_at SET DEFAULT '00:00';
+ALTER TABLE rooms ALTER COLUMN opens_at SET NOT NULL;
+ALTER TABLE rooms ALTER COLUMN closes_at SET DEFAULT '24:00';
+ALTER TABLE rooms ALTER COLUMN closes_at SET NOT NULL;
+
+ALTER TABLE rooms DROP COLUMN group_id;
+DROP TABLE resource_groups;
@@ -0,0 +1,79 @@
+-- Migration: Resource hierarchy. Sites contain buildings, buildings contain pools,
+-- and rooms (the allocatable nodes) belong to a group at any level. Policy columns
+-- left NULL are inherited from the nearest ancestor that sets them.
+CREATE TABLE resource_groups (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('site', 'building', 'pool')),
+    parent_id INTEGER REFERENCES resource_groups(id),
+    opens_at TIME,
+    closes_at TIME,
+    requires_approval BOOLEAN,
+    daily_quota_hours NUMERIC(6, 2) CHECK (daily_quota_hours > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+    -- Operating hours are inherited as a pair so a window is never half from each level
+    CONSTRAINT group_hours_pair CHECK ((opens_at IS NULL) = (closes_at IS NULL)),
+    CONSTRAINT group_valid_operating_hours CHECK (closes_at > opens_at)
+);
+
+CREATE INDEX idx_resource_groups_parent ON resource_groups(parent_id);
+
+CREATE TRIGGER resource_groups_set_updated_at BEFORE UPDATE ON resource_groups
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER resource_groups_bump_version BEFORE UPDATE ON resource_groups
+    FOR EACH ROW EXECUTE FUNCTION bump_row_version();
+
+ALTER TABLE rooms ADD COLUMN group_id INTEGER REFERENCES resource_groups(id);
+CREATE INDEX idx_rooms_group ON rooms(group_id);
+
+-- A room's own operating hours become an override; NULL inherits from its group.
+-- Rooms still on the old all-day default inherit from now on.
+ALTER TABLE rooms ALTER COLUMN opens_at DROP NOT NULL;
+ALTER TABLE rooms ALTER COLUMN opens_at DROP DEFAULT;
+ALTER TABLE rooms ALTER COLUMN closes_at DROP NOT NULL;
+ALTER TABLE rooms ALTER COLUMN closes_at DROP DEFAULT;
+UPDATE rooms SET opens_at = NULL, closes_at = NULL WHERE opens_at = '00:00' AND closes_at = '24:00';
+ALTER TABLE rooms ADD CONSTRAINT room_hours_pair CHECK ((opens_at IS NULL) = (closes_at IS NULL));
+
+-- Every group paired with itself and each of its descendants
+CREATE VIEW group_closure AS
+WITH RECURSIVE closure (ancestor_id, descendant_id, depth) AS (
+    SELECT id, id, 0 FROM resource_groups
+    UNION ALL
+    SELECT c.ancestor_id, g.id, c.depth + 1
+    FROM closure c
+    JOIN resource_groups g ON g.parent_id = c.descendant_id
+)
+SELECT ancestor_id, descendant_id, depth FROM closure;
+
+-- Effective policy of every group: each setting comes from the nearest group on
+-- the path to the root that sets it. quota_group_id is the group whose subtree
+-- the daily quota is counted over.
+CREATE VIEW group_policies AS
+SELECT g.id AS group_id,
+       h.opens_at,
+       h.closes_at,
+       a.requires_approval,
+       q.daily_quota_hours,
+       q.group_id AS quota_group_id
+FROM resource_groups g
+LEFT JOIN LATERAL (
+    SELECT p.opens_at, p.closes_at
+    FROM group_closure c JOIN resource_groups p ON p.id = c.ancestor_id
+    WHERE c.descendant_id = g.id AND p.opens_at IS NOT NULL
+    ORDER BY c.depth LIMIT 1
+) h ON TRUE
+LEFT JOIN LATERAL (
+    SELECT p.requires_approval
+    FROM group_closure c JOIN resource_groups p ON p.id = c.ancestor_id
+    WHERE c.descendant_id = g.id AND p.requires_approval IS NOT NULL
+    ORDER BY c.depth LIMIT 1
+) a ON TRUE
+LEFT JOIN LATERAL (
+    SELECT p.id AS group_id, p.daily_quota_hours
+    FROM group_closure c JOIN resource_groups p ON p.id = c.ancestor_id
+    WHERE c.descendant_id = g.id AND p.daily_quota_hours IS NOT NULL
+    ORDER BY c.depth LIMIT 1
+) q ON TRUE;
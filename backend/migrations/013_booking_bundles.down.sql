@@ -0,0 +1,2 @@
+ALTER TABLE bookings DROP COLUMN bundle_id;
+DROP TABLE booking_bundles;
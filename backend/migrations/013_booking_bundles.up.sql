@@ -0,0 +1,21 @@
+-- Migration: Bundles of bookings allocated all-or-nothing over one window
+CREATE TABLE booking_bundles (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    start_time TIMESTAMP NOT NULL,
+    end_time TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT valid_bundle_time_range CHECK (end_time > start_time)
+);
+
+CREATE TRIGGER booking_bundles_set_updated_at BEFORE UPDATE ON booking_bundles
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER booking_bundles_bump_version BEFORE UPDATE ON booking_bundles
+    FOR EACH ROW EXECUTE FUNCTION bump_row_version();
+
+-- Member bookings share the bundle's window and status
+ALTER TABLE bookings ADD COLUMN bundle_id INTEGER REFERENCES booking_bundles(id) ON DELETE CASCADE;
+CREATE INDEX idx_bookings_bundle ON bookings(bundle_id) WHERE bundle_id IS NOT NULL;
@@ -0,0 +1,10 @@
+DROP FUNCTION peak_usage(INTEGER, TIMESTAMP, TIMESTAMP);
+
+ALTER TABLE bookings DROP COLUMN quantity;
+
+-- A pool becomes a single shared room; allocations already approved are kept
+UPDATE rooms SET type = 'shared' WHERE type = 'pooled';
+ALTER TABLE rooms DROP CONSTRAINT room_quantity;
+ALTER TABLE rooms DROP COLUMN quantity;
+ALTER TABLE rooms DROP CONSTRAINT valid_room_type;
+ALTER TABLE rooms ADD CONSTRAINT valid_room_type CHECK (type IN ('shared', 'exclusive'));
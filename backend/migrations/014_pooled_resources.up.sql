@@ -0,0 +1,27 @@
+-- Migration: Pooled resources. A pooled room holds a quantity of fungible units
+-- (licenses, GPUs, parking spots) and each booking takes a count of them; other
+-- rooms are a single unit, so any overlap conflicts as before.
+ALTER TABLE rooms DROP CONSTRAINT valid_room_type;
+ALTER TABLE rooms ADD CONSTRAINT valid_room_type CHECK (type IN ('shared', 'exclusive', 'pooled'));
+ALTER TABLE rooms ADD COLUMN quantity INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE rooms ADD CONSTRAINT room_quantity CHECK (quantity > 0 AND (type = 'pooled' OR quantity = 1));
+
+ALTER TABLE bookings ADD COLUMN quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0);
+
+-- peak_usage is the most units of a room that approved bookings hold at any
+-- instant of [from, to). Usage only rises where a booking starts, so it is
+-- enough to sample the window start and every start inside the window.
+CREATE FUNCTION peak_usage(room INTEGER, from_time TIMESTAMP, to_time TIMESTAMP) RETURNS INTEGER AS $$
+    SELECT COALESCE(MAX((
+        SELECT SUM(o.quantity) FROM bookings o
+        WHERE o.room_id = room AND o.status = 'approved'
+          AND o.start_time <= t.at AND o.end_time > t.at
+    )), 0)::INTEGER
+    FROM (
+        SELECT from_time AS at
+        UNION
+        SELECT b.start_time FROM bookings b
+        WHERE b.room_id = room AND b.status = 'approved'
+          AND b.start_time > from_time AND b.start_time < to_time
+    ) t
+$$ LANGUAGE SQL STABLE;
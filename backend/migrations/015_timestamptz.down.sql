@@ -0,0 +1,47 @@
+-- Back to wall-clock Asia/Jakarta times, as the server used to run
+ALTER TABLE rooms DROP COLUMN timezone;
+
+DROP FUNCTION peak_usage(INTEGER, TIMESTAMPTZ, TIMESTAMPTZ);
+
+ALTER TABLE users
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE rooms
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN archived_at TYPE TIMESTAMP USING archived_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE bookings
+    ALTER COLUMN start_time TYPE TIMESTAMP USING start_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN end_time TYPE TIMESTAMP USING end_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE idempotency_keys
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE resource_groups
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE booking_bundles
+    ALTER COLUMN start_time TYPE TIMESTAMP USING start_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN end_time TYPE TIMESTAMP USING end_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'Asia/Jakarta';
+
+CREATE FUNCTION peak_usage(room INTEGER, from_time TIMESTAMP, to_time TIMESTAMP) RETURNS INTEGER AS $$
+    SELECT COALESCE(MAX((
+        SELECT SUM(o.quantity) FROM bookings o
+        WHERE o.room_id = room AND o.status = 'approved'
+          AND o.start_time <= t.at AND o.end_time > t.at
+    )), 0)::INTEGER
+    FROM (
+        SELECT from_time AS at
+        UNION
+        SELECT b.start_time FROM bookings b
+        WHERE b.room_id = room AND b.status = 'approved'
+          AND b.start_time > from_time AND b.start_time < to_time
+    ) t
+$$ LANGUAGE SQL STABLE;
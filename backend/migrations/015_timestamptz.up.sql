@@ -0,0 +1,53 @@
+-- Migration: Timezone-aware timestamps. Every instant is stored as TIMESTAMPTZ and
+-- the API talks to the database in UTC. Until now the server ran in Asia/Jakarta
+-- and both the application and the database stamped wall-clock Jakarta times, so
+-- existing values are read back in that zone.
+ALTER TABLE users
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE rooms
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN archived_at TYPE TIMESTAMPTZ USING archived_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE bookings
+    ALTER COLUMN start_time TYPE TIMESTAMPTZ USING start_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN end_time TYPE TIMESTAMPTZ USING end_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE idempotency_keys
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE resource_groups
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE 'Asia/Jakarta';
+
+ALTER TABLE booking_bundles
+    ALTER COLUMN start_time TYPE TIMESTAMPTZ USING start_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN end_time TYPE TIMESTAMPTZ USING end_time AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'Asia/Jakarta',
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE 'Asia/Jakarta';
+
+-- Same body as before, over instants instead of wall-clock times
+DROP FUNCTION peak_usage(INTEGER, TIMESTAMP, TIMESTAMP);
+CREATE FUNCTION peak_usage(room INTEGER, from_time TIMESTAMPTZ, to_time TIMESTAMPTZ) RETURNS INTEGER AS $$
+    SELECT COALESCE(MAX((
+        SELECT SUM(o.quantity) FROM bookings o
+        WHERE o.room_id = room AND o.status = 'approved'
+          AND o.start_time <= t.at AND o.end_time > t.at
+    )), 0)::INTEGER
+    FROM (
+        SELECT from_time AS at
+        UNION
+        SELECT b.start_time FROM bookings b
+        WHERE b.room_id = room AND b.status = 'approved'
+          AND b.start_time > from_time AND b.start_time < to_time
+    ) t
+$$ LANGUAGE SQL STABLE;
+
+-- Operating hours and daily quotas are wall-clock rules, read in the room's own
+-- IANA zone. Existing rooms keep the zone they were configured in.
+ALTER TABLE rooms ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+UPDATE rooms SET timezone = 'Asia/Jakarta';
@@ -0,0 +1,4 @@
+DELETE FROM bookings WHERE room_id IN (
+    SELECT id FROM rooms WHERE name IN ('NODE-AX-06', 'NODE-AX-07', 'NODE-AX-08', 'NODE-AX-09', 'NODE-AX-10')
+);
+DELETE FROM rooms WHERE name IN ('NODE-AX-06', 'NODE-AX-07', 'NODE-AX-08', 'NODE-AX-09', 'NODE-AX-10');
@@ -0,0 +1,22 @@
+-- Add more sample rooms (nodes). Shipped as a second 003 that the old runner
+-- never applied; names already taken are skipped.
+WITH added AS (
+    INSERT INTO rooms (name, capacity) VALUES
+    ('NODE-AX-06', 128),
+    ('NODE-AX-07', 256),
+    ('NODE-AX-08', 64),
+    ('NODE-AX-09', 512),
+    ('NODE-AX-10', 1024)
+    ON CONFLICT (name) WHERE archived_at IS NULL DO NOTHING
+    RETURNING id
+)
+-- Add some sample allocations for testing, on the new rooms only
+INSERT INTO bookings (room_id, user_id, start_time, end_time, status)
+SELECT 
+    id, 
+    1, 
+    NOW() + (interval '1 hour' * (id % 5)), 
+    NOW() + (interval '1 hour' * (id % 5)) + interval '2 hours',
+    'approved'
+FROM added
+WHERE EXISTS (SELECT 1 FROM users WHERE id = 1);
@@ -0,0 +1,8 @@
+// Package migrations bundles the Postgres schema into the binary. Each version
+// is a pair of NNN_name.up.sql and NNN_name.down.sql scripts; see internal/migrate.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
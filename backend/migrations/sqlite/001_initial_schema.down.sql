@@ -0,0 +1,8 @@
+DROP VIEW group_policies;
+DROP VIEW group_closure;
+DROP TABLE idempotency_keys;
+DROP TABLE bookings;
+DROP TABLE booking_bundles;
+DROP TABLE rooms;
+DROP TABLE resource_groups;
+DROP TABLE users;
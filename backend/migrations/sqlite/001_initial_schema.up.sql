@@ -0,0 +1,173 @@
+-- The Postgres schema as of its migration 016, in SQLite's types. Instants are
+-- INTEGER microseconds since the Unix epoch, UTC; ids are never reused.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL CHECK (role IN ('admin', 'user')),
+    created_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER))
+);
+
+-- Sites contain buildings, buildings contain pools. Policy columns left NULL
+-- are inherited from the nearest ancestor that sets them.
+CREATE TABLE resource_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    kind TEXT NOT NULL CHECK (kind IN ('site', 'building', 'pool')),
+    parent_id INTEGER REFERENCES resource_groups(id),
+    opens_at TEXT,
+    closes_at TEXT,
+    requires_approval INTEGER CHECK (requires_approval IN (0, 1)),
+    daily_quota_hours REAL CHECK (daily_quota_hours > 0),
+    created_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    updated_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT group_hours_pair CHECK ((opens_at IS NULL) = (closes_at IS NULL)),
+    CONSTRAINT group_valid_operating_hours CHECK (closes_at > opens_at)
+);
+
+CREATE INDEX idx_resource_groups_parent ON resource_groups(parent_id);
+
+CREATE TABLE rooms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    capacity INTEGER NOT NULL CHECK (capacity > 0),
+    type TEXT NOT NULL DEFAULT 'shared' CHECK (type IN ('shared', 'exclusive', 'pooled')),
+    status TEXT NOT NULL DEFAULT 'online' CHECK (status IN ('online', 'maintenance', 'offline')),
+    quantity INTEGER NOT NULL DEFAULT 1,
+    -- The room's own operating hours; NULL inherits them from its group
+    opens_at TEXT,
+    closes_at TEXT,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    labels TEXT NOT NULL DEFAULT '{}' CHECK (json_type(labels) = 'object'),
+    group_id INTEGER REFERENCES resource_groups(id),
+    archived_at INTEGER,
+    created_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    updated_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT room_quantity CHECK (quantity > 0 AND (type = 'pooled' OR quantity = 1)),
+    CONSTRAINT room_hours_pair CHECK ((opens_at IS NULL) = (closes_at IS NULL)),
+    CONSTRAINT valid_operating_hours CHECK (closes_at > opens_at)
+);
+
+-- An archived room releases its name for reuse
+CREATE UNIQUE INDEX rooms_active_name_key ON rooms(name) WHERE archived_at IS NULL;
+CREATE INDEX idx_rooms_group ON rooms(group_id);
+
+-- Bundles of bookings allocated all-or-nothing over one window
+CREATE TABLE booking_bundles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    start_time INTEGER NOT NULL,
+    end_time INTEGER NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    updated_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT valid_bundle_time_range CHECK (end_time > start_time)
+);
+
+CREATE TABLE bookings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE RESTRICT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    start_time INTEGER NOT NULL,
+    end_time INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    bundle_id INTEGER REFERENCES booking_bundles(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    updated_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT valid_time_range CHECK (end_time > start_time)
+);
+
+CREATE INDEX idx_bookings_room_time ON bookings(room_id, start_time, end_time);
+CREATE INDEX idx_bookings_status_time ON bookings(status, start_time, end_time);
+CREATE INDEX idx_bookings_user_id ON bookings(user_id);
+CREATE INDEX idx_bookings_start_id ON bookings(start_time, id);
+CREATE INDEX idx_bookings_created_id ON bookings(created_at, id);
+CREATE INDEX idx_bookings_bundle ON bookings(bundle_id) WHERE bundle_id IS NOT NULL;
+
+-- Every UPDATE moves the row to the next version and stamps it, so writers
+-- cannot forget to and a client's If-Match can never be reused. Triggers are
+-- not recursive, so the inner UPDATE does not fire them again.
+CREATE TRIGGER resource_groups_touch AFTER UPDATE ON resource_groups FOR EACH ROW
+BEGIN
+    UPDATE resource_groups
+    SET version = OLD.version + 1, updated_at = CAST(unixepoch('subsec') * 1000000 AS INTEGER)
+    WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER rooms_touch AFTER UPDATE ON rooms FOR EACH ROW
+BEGIN
+    UPDATE rooms
+    SET version = OLD.version + 1, updated_at = CAST(unixepoch('subsec') * 1000000 AS INTEGER)
+    WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER booking_bundles_touch AFTER UPDATE ON booking_bundles FOR EACH ROW
+BEGIN
+    UPDATE booking_bundles
+    SET version = OLD.version + 1, updated_at = CAST(unixepoch('subsec') * 1000000 AS INTEGER)
+    WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER bookings_touch AFTER UPDATE ON bookings FOR EACH ROW
+BEGIN
+    UPDATE bookings
+    SET version = OLD.version + 1, updated_at = CAST(unixepoch('subsec') * 1000000 AS INTEGER)
+    WHERE id = NEW.id;
+END;
+
+-- A row is a lease while status_code is NULL (request in flight) and a stored
+-- response afterwards; expires_at covers both
+CREATE TABLE idempotency_keys (
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    content_type TEXT,
+    response BLOB,
+    created_at INTEGER NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000000 AS INTEGER)),
+    expires_at INTEGER NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+
+-- Every group paired with itself and each of its descendants
+CREATE VIEW group_closure AS
+WITH RECURSIVE closure (ancestor_id, descendant_id, depth) AS (
+    SELECT id, id, 0 FROM resource_groups
+    UNION ALL
+    SELECT c.ancestor_id, g.id, c.depth + 1
+    FROM closure c
+    JOIN resource_groups g ON g.parent_id = c.descendant_id
+)
+SELECT ancestor_id, descendant_id, depth FROM closure;
+
+-- Effective policy of every group: each setting comes from the nearest group on
+-- the path to the root that sets it. quota_group_id is the group whose subtree
+-- the daily quota is counted over.
+CREATE VIEW group_policies AS
+SELECT g.id AS group_id,
+       h.opens_at,
+       h.closes_at,
+       (SELECT p.requires_approval
+        FROM group_closure c JOIN resource_groups p ON p.id = c.ancestor_id
+        WHERE c.descendant_id = g.id AND p.requires_approval IS NOT NULL
+        ORDER BY c.depth LIMIT 1) AS requires_approval,
+       q.daily_quota_hours,
+       q.id AS quota_group_id
+FROM resource_groups g
+LEFT JOIN resource_groups h ON h.id = (
+    SELECT p.id
+    FROM group_closure c JOIN resource_groups p ON p.id = c.ancestor_id
+    WHERE c.descendant_id = g.id AND p.opens_at IS NOT NULL
+    ORDER BY c.depth LIMIT 1)
+LEFT JOIN resource_groups q ON q.id = (
+    SELECT p.id
+    FROM group_closure c JOIN resource_groups p ON p.id = c.ancestor_id
+    WHERE c.descendant_id = g.id AND p.daily_quota_hours IS NOT NULL
+    ORDER BY c.depth LIMIT 1);
@@ -0,0 +1,2 @@
+-- Bookings of the seeded users go with them (ON DELETE CASCADE)
+DELETE FROM users WHERE email IN ('admin@allocra.local', 'user@allocra.local');
@@ -0,0 +1,5 @@
+-- The users of the Postgres seed, which bookings refer to. Rooms are left to
+-- the deployment.
+INSERT INTO users (name, email, role) VALUES
+('Admin User', 'admin@allocra.local', 'admin'),
+('Test User', 'user@allocra.local', 'user');
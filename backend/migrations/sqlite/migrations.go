@@ -0,0 +1,10 @@
+// Package sqlite bundles the SQLite schema into the binary. It mirrors the
+// Postgres schema in the parent directory as it stands today, in SQLite's
+// types: instants are INTEGER microseconds since the Unix epoch, times of day
+// are 'HH:MM:SS' text and labels are JSON text. See internal/migrate.
+package sqlite
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
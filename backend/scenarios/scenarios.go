@@ -0,0 +1,12 @@
+// Package scenarios bundles the seeder's scenario files into the binary. Each
+// file declares rooms, groups and a timeline of allocation operations with the
+// outcome expected of every step; see cmd/seeder.
+package scenarios
+
+import "embed"
+
+// FS holds the directory as a whole, so that a scenario dropped in is picked
+// up whether it is written in YAML or JSON
+//
+//go:embed *
+var FS embed.FS
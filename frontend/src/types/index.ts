@@ -2,10 +2,15 @@ export interface Resource {
   id: number;
   name: string;
   capacity: number;
-  type: "exclusive" | "shared";
+  type: "exclusive" | "shared" | "pooled";
+  quantity: number;
+  timezone: string;
   usage: number;
   status: "online" | "offline" | "maintenance";
   created_at: string;
+  version: number;
+  labels: Record<string, string>;
+  group_id: number | null;
 }
 
 export interface AllocationRequest {